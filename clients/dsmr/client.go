@@ -0,0 +1,250 @@
+// Package dsmr reads DSMR 4/5 P1 telegrams from a smart meter, either from a
+// serial P1 cable or from a TCP stream such as ser2net or a Slimmemeter-style
+// gateway.
+package dsmr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+const (
+	// DefaultBaud is the P1 port speed of DSMR 4 and 5 meters (8N1).
+	// DSMR 2.2/3 meters use 9600 baud 7E1.
+	DefaultBaud = 115200
+
+	// DSMR 5 meters send a telegram every second, DSMR 4 meters every ten.
+	staleAfter  = 30 * time.Second
+	dialTimeout = 5 * time.Second
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+)
+
+// Client reads P1 telegrams in the background and serves the latest one.
+// It implements the service MeterReader interface.
+type Client struct {
+	source string
+	baud   int
+
+	mu          sync.Mutex
+	latest      *Telegram
+	received    time.Time
+	crcFailures int
+	nowFunc     func() time.Time
+
+	runMu   sync.Mutex
+	stopRun context.CancelFunc
+	runDone chan struct{}
+}
+
+// New creates a DSMR client for source, which is either a serial device path
+// (e.g. /dev/ttyUSB0) or a TCP address (host:port or tcp://host:port).
+// If source is empty, the client is disabled.
+func New(source string, baud int) *Client {
+	if baud <= 0 {
+		baud = DefaultBaud
+	}
+	return &Client{
+		source:  source,
+		baud:    baud,
+		nowFunc: time.Now,
+	}
+}
+
+// Enabled returns true if a P1 source is configured.
+func (c *Client) Enabled() bool {
+	return c.source != ""
+}
+
+// Start begins reading telegrams in the background, reconnecting on failure.
+// It is a no-op if the client is disabled or already started.
+func (c *Client) Start() {
+	if !c.Enabled() {
+		return
+	}
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if c.stopRun != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopRun = cancel
+	c.runDone = make(chan struct{})
+	go func() {
+		defer close(c.runDone)
+		c.run(ctx)
+	}()
+}
+
+// Close stops the background reader.
+func (c *Client) Close() error {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if c.stopRun == nil {
+		return nil
+	}
+	c.stopRun()
+	<-c.runDone
+	c.stopRun = nil
+	return nil
+}
+
+// Latest returns the most recent valid telegram.
+func (c *Client) Latest() (*Telegram, error) {
+	if !c.Enabled() {
+		return nil, errors.New("dsmr P1 meter not configured")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latest == nil {
+		return nil, errors.New("no P1 telegram received yet")
+	}
+	if age := c.nowFunc().Sub(c.received); age > staleAfter {
+		return nil, fmt.Errorf("last P1 telegram is %s old", age.Round(time.Second))
+	}
+	t := *c.latest
+	return &t, nil
+}
+
+// GetActivePowerW returns the current net grid power in watts from the latest telegram.
+// Positive values mean importing from grid, negative values mean exporting (solar surplus).
+func (c *Client) GetActivePowerW() (float64, error) {
+	t, err := c.Latest()
+	if err != nil {
+		return 0, err
+	}
+	return t.ActivePowerW(), nil
+}
+
+// GetEnergyRegisters returns the import and export registers of the latest
+// telegram in kWh, summed over both tariffs.
+func (c *Client) GetEnergyRegisters() (importKWh, exportKWh float64, err error) {
+	t, err := c.Latest()
+	if err != nil {
+		return 0, 0, err
+	}
+	return t.TotalEnergyDeliveredKWh(), t.TotalEnergyReturnedKWh(), nil
+}
+
+// GetPhases returns the per-phase power, current and voltage from the latest telegram.
+// Single-phase meters report L1 only.
+func (c *Client) GetPhases() ([]grid.Phase, error) {
+	t, err := c.Latest()
+	if err != nil {
+		return nil, err
+	}
+	return t.GridPhases(), nil
+}
+
+// run keeps a connection to the P1 source open until ctx is cancelled.
+func (c *Client) run(ctx context.Context) {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := c.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		slog.Warn("DSMR P1 stream interrupted", "source", c.source, "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// stream reads telegrams from one connection until it fails or goes silent.
+func (c *Client) stream(ctx context.Context) error {
+	conn, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock the read when ctx is cancelled or no telegram arrives in time.
+	stopOnCancel := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopOnCancel()
+	silent := time.AfterFunc(staleAfter, func() { conn.Close() })
+	defer silent.Stop()
+
+	slog.Info("DSMR P1 stream connected", "source", c.source)
+	return c.readFrom(conn, func() { silent.Reset(staleAfter) })
+}
+
+// readFrom consumes telegrams from r until it returns an error.
+// onTelegram is called after each valid telegram.
+func (c *Client) readFrom(r io.Reader, onTelegram func()) error {
+	br := bufio.NewReaderSize(r, maxTelegramSize)
+	for {
+		raw, err := ReadTelegram(br)
+		if err != nil {
+			return err
+		}
+		t, err := Parse(raw)
+		if err != nil {
+			c.mu.Lock()
+			if errors.Is(err, ErrCRCMismatch) {
+				c.crcFailures++
+			}
+			c.mu.Unlock()
+			slog.Debug("discarding invalid P1 telegram", "error", err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.latest = t
+		c.received = c.nowFunc()
+		c.mu.Unlock()
+		if onTelegram != nil {
+			onTelegram()
+		}
+	}
+}
+
+// CRCFailures returns the number of telegrams discarded for a CRC mismatch.
+func (c *Client) CRCFailures() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.crcFailures
+}
+
+// open connects to the configured TCP address or serial device.
+func (c *Client) open(ctx context.Context) (io.ReadCloser, error) {
+	if addr, ok := tcpAddress(c.source); ok {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+	return openSerial(c.source, c.baud)
+}
+
+// tcpAddress reports whether source is a TCP address rather than a device path.
+func tcpAddress(source string) (string, bool) {
+	if addr, ok := strings.CutPrefix(source, "tcp://"); ok {
+		return addr, true
+	}
+	if strings.HasPrefix(source, "/") {
+		return "", false
+	}
+	_, _, err := net.SplitHostPort(source)
+	return source, err == nil
+}
@@ -0,0 +1,111 @@
+package dsmr
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFrom_ServesLatestValidTelegram(t *testing.T) {
+	good := withCRC(dsmr5Body)
+	newer := withCRC(strings.Replace(dsmr5Body, "1-0:1.7.0(00.000*kW)\r\n1-0:2.7.0(01.250*kW)", "1-0:1.7.0(00.800*kW)\r\n1-0:2.7.0(00.000*kW)", 1))
+	corrupted := strings.Replace(newer, "00.800*kW", "09.800*kW", 1)
+
+	client := New("/dev/ttyUSB0", 0)
+	if err := client.readFrom(strings.NewReader(good+newer+corrupted), nil); err != io.EOF {
+		t.Fatalf("readFrom() error = %v, want EOF", err)
+	}
+
+	power, err := client.GetActivePowerW()
+	if err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	if power != 800 {
+		t.Errorf("GetActivePowerW() = %v, want 800 from the last valid telegram", power)
+	}
+	if client.CRCFailures() != 1 {
+		t.Errorf("CRCFailures() = %d, want 1", client.CRCFailures())
+	}
+}
+
+func TestGetActivePowerW_StaleTelegram(t *testing.T) {
+	client := New("meter.local:2001", 0)
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	client.nowFunc = func() time.Time { return now }
+
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil before any telegram")
+	}
+
+	client.readFrom(strings.NewReader(withCRC(dsmr5Body)), nil)
+	now = now.Add(staleAfter + time.Second)
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil for stale telegram")
+	}
+}
+
+func TestDisabledClient(t *testing.T) {
+	client := New("", 0)
+	if client.Enabled() {
+		t.Error("Enabled() = true for empty source")
+	}
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil for disabled client")
+	}
+	client.Start()
+	client.Close()
+}
+
+func TestTCPAddress(t *testing.T) {
+	tests := []struct {
+		source string
+		addr   string
+		tcp    bool
+	}{
+		{"/dev/ttyUSB0", "", false},
+		{"tcp://192.168.1.20:2001", "192.168.1.20:2001", true},
+		{"slimmelezer.local:23", "slimmelezer.local:23", true},
+		{"COM3", "", false},
+	}
+	for _, tt := range tests {
+		addr, tcp := tcpAddress(tt.source)
+		if tcp != tt.tcp || (tcp && addr != tt.addr) {
+			t.Errorf("tcpAddress(%q) = %q, %v; want %q, %v", tt.source, addr, tcp, tt.addr, tt.tcp)
+		}
+	}
+}
+
+func TestStart_StreamsFromTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(withCRC(dsmr5Body)))
+		time.Sleep(time.Second)
+	}()
+
+	client := New(ln.Addr().String(), 0)
+	client.Start()
+	defer client.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if power, err := client.GetActivePowerW(); err == nil {
+			if power != -1250 {
+				t.Errorf("GetActivePowerW() = %v, want -1250", power)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no telegram received over TCP")
+}
@@ -0,0 +1,67 @@
+package dsmr
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openSerial opens a P1 serial device in raw mode. 115200 baud is configured
+// as 8N1 (DSMR 4/5) and 9600 baud as 7E1 (DSMR 2.2/3).
+func openSerial(path string, baud int) (io.ReadCloser, error) {
+	var speed uint32
+	var frame uint32
+	switch baud {
+	case 115200:
+		speed, frame = unix.B115200, unix.CS8
+	case 9600:
+		speed, frame = unix.B9600, unix.CS7|unix.PARENB
+	default:
+		return nil, fmt.Errorf("unsupported P1 baud rate %d (want 115200 or 9600)", baud)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	// Configure through SyscallConn so the file stays in the runtime poller
+	// and Close unblocks a pending Read.
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("configure %s: %w", path, err)
+	}
+	var termErr error
+	err = raw.Control(func(fd uintptr) {
+		t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			termErr = err
+			return
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+		if frame&unix.PARENB != 0 {
+			t.Iflag |= unix.ISTRIP // 7E1: strip the parity bit
+		} else {
+			t.Iflag &^= unix.ISTRIP
+		}
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD
+		t.Cflag |= frame | speed | unix.CREAD | unix.CLOCAL
+		t.Ispeed, t.Ospeed = speed, speed
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		termErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err == nil {
+		err = termErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("configure %s: %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package dsmr
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// openSerial opens a P1 serial device. Outside Linux the port settings are not
+// changed; configure them beforehand (e.g. stty -f /dev/cu.usbserial 115200 raw).
+func openSerial(path string, _ int) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,256 @@
+package dsmr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+// maxTelegramSize bounds a single telegram; DSMR 5 telegrams with long text
+// messages and M-Bus channels stay well below this.
+const maxTelegramSize = 16 * 1024
+
+// ErrCRCMismatch is returned for a telegram whose CRC16 does not match its contents.
+var ErrCRCMismatch = errors.New("dsmr: telegram CRC mismatch")
+
+// Phase holds the per-phase instantaneous values of a telegram.
+type Phase struct {
+	PowerDeliveredW float64 // 1-0:21.7.0 / 41.7.0 / 61.7.0
+	PowerReturnedW  float64 // 1-0:22.7.0 / 42.7.0 / 62.7.0
+	CurrentA        float64 // 1-0:31.7.0 / 51.7.0 / 71.7.0
+	VoltageV        float64 // 1-0:32.7.0 / 52.7.0 / 72.7.0
+}
+
+// ActivePowerW returns the net power on the phase: positive imports, negative exports.
+func (p Phase) ActivePowerW() float64 {
+	return p.PowerDeliveredW - p.PowerReturnedW
+}
+
+// Telegram is a parsed DSMR P1 telegram.
+type Telegram struct {
+	Header    string    // identification line without the leading "/"
+	Version   string    // 1-3:0.2.8 (or 0-0:96.1.4 on Belgian meters)
+	Timestamp time.Time // 0-0:1.0.0
+
+	PowerDeliveredW float64 // 1-0:1.7.0
+	PowerReturnedW  float64 // 1-0:2.7.0
+
+	// Energy registers per tariff (index 0 = tariff 1, index 1 = tariff 2).
+	EnergyDeliveredKWh [2]float64 // 1-0:1.8.1 / 1-0:1.8.2
+	EnergyReturnedKWh  [2]float64 // 1-0:2.8.1 / 1-0:2.8.2
+
+	Phases    [3]Phase
+	PhaseSeen [3]bool // phases present in the telegram (single-phase meters only report L1)
+}
+
+// ActivePowerW returns the net grid power: positive imports, negative exports.
+func (t *Telegram) ActivePowerW() float64 {
+	return t.PowerDeliveredW - t.PowerReturnedW
+}
+
+// GridPhases returns the phases present in the telegram. Telegrams without
+// per-phase values yield a single phase carrying the total power.
+func (t *Telegram) GridPhases() []grid.Phase {
+	var phases []grid.Phase
+	for i, p := range t.Phases {
+		if t.PhaseSeen[i] {
+			phases = append(phases, grid.Phase{PowerW: p.ActivePowerW(), CurrentA: p.CurrentA, VoltageV: p.VoltageV})
+		}
+	}
+	if len(phases) == 0 {
+		phases = append(phases, grid.Phase{PowerW: t.ActivePowerW()})
+	}
+	return phases
+}
+
+// TotalEnergyDeliveredKWh returns the import register summed over both tariffs.
+func (t *Telegram) TotalEnergyDeliveredKWh() float64 {
+	return t.EnergyDeliveredKWh[0] + t.EnergyDeliveredKWh[1]
+}
+
+// TotalEnergyReturnedKWh returns the export register summed over both tariffs.
+func (t *Telegram) TotalEnergyReturnedKWh() float64 {
+	return t.EnergyReturnedKWh[0] + t.EnergyReturnedKWh[1]
+}
+
+// ReadTelegram reads the next complete telegram from r, skipping any bytes
+// before its "/" header line. The returned bytes run from "/" up to and
+// including the "!CRC" trailer line.
+func ReadTelegram(r *bufio.Reader) ([]byte, error) {
+	var buf []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(buf) == 0 {
+			// Resynchronise on the header; a partial telegram is discarded.
+			if i := bytes.IndexByte(line, '/'); i >= 0 {
+				buf = append(buf, line[i:]...)
+			}
+		} else {
+			buf = append(buf, line...)
+			if len(line) > 0 && line[0] == '!' && bytes.HasSuffix(line, []byte("\n")) {
+				return buf, nil
+			}
+		}
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(buf) > maxTelegramSize {
+			return nil, fmt.Errorf("dsmr: telegram exceeds %d bytes", maxTelegramSize)
+		}
+	}
+}
+
+// Parse validates and parses a raw telegram as returned by ReadTelegram.
+// DSMR 4 and 5 telegrams carry a CRC16 after the "!" which must match;
+// DSMR 2.2/3 telegrams end with a bare "!" and are accepted without a check.
+func Parse(raw []byte) (*Telegram, error) {
+	end := bytes.LastIndexByte(raw, '!')
+	if len(raw) == 0 || raw[0] != '/' || end < 0 {
+		return nil, errors.New("dsmr: not a telegram")
+	}
+	if crcHex := strings.TrimSpace(string(raw[end+1:])); crcHex != "" {
+		want, err := strconv.ParseUint(crcHex, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("dsmr: invalid CRC %q", crcHex)
+		}
+		if got := crc16(raw[:end+1]); got != uint16(want) {
+			return nil, fmt.Errorf("%w: got %04X, telegram says %04X", ErrCRCMismatch, got, want)
+		}
+	}
+
+	t := &Telegram{}
+	lines := strings.Split(string(raw[:end]), "\n")
+	t.Header = strings.TrimSpace(strings.TrimPrefix(lines[0], "/"))
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		obis, rest, ok := strings.Cut(line, "(")
+		if !ok {
+			continue
+		}
+		value, _, _ := strings.Cut(rest, ")")
+		if err := t.set(obis, value); err != nil {
+			return nil, fmt.Errorf("dsmr: %s: %w", obis, err)
+		}
+	}
+	return t, nil
+}
+
+// set assigns a single OBIS value. Unknown codes are ignored.
+func (t *Telegram) set(obis, value string) error {
+	switch obis {
+	case "1-3:0.2.8", "0-0:96.1.4":
+		t.Version = value
+		return nil
+	case "0-0:1.0.0":
+		ts, err := parseTimestamp(value)
+		if err != nil {
+			return err
+		}
+		t.Timestamp = ts
+		return nil
+	}
+
+	var target *float64
+	scale := 1.0
+	phase := -1
+	switch obis {
+	case "1-0:1.7.0":
+		target, scale = &t.PowerDeliveredW, 1000
+	case "1-0:2.7.0":
+		target, scale = &t.PowerReturnedW, 1000
+	case "1-0:1.8.1":
+		target = &t.EnergyDeliveredKWh[0]
+	case "1-0:1.8.2":
+		target = &t.EnergyDeliveredKWh[1]
+	case "1-0:2.8.1":
+		target = &t.EnergyReturnedKWh[0]
+	case "1-0:2.8.2":
+		target = &t.EnergyReturnedKWh[1]
+	case "1-0:21.7.0", "1-0:41.7.0", "1-0:61.7.0":
+		phase = phaseIndex(obis, 21)
+		target, scale = &t.Phases[phase].PowerDeliveredW, 1000
+	case "1-0:22.7.0", "1-0:42.7.0", "1-0:62.7.0":
+		phase = phaseIndex(obis, 22)
+		target, scale = &t.Phases[phase].PowerReturnedW, 1000
+	case "1-0:31.7.0", "1-0:51.7.0", "1-0:71.7.0":
+		phase = phaseIndex(obis, 31)
+		target = &t.Phases[phase].CurrentA
+	case "1-0:32.7.0", "1-0:52.7.0", "1-0:72.7.0":
+		phase = phaseIndex(obis, 32)
+		target = &t.Phases[phase].VoltageV
+	default:
+		return nil
+	}
+
+	v, err := parseNumber(value)
+	if err != nil {
+		return err
+	}
+	*target = v * scale
+	if phase >= 0 {
+		t.PhaseSeen[phase] = true
+	}
+	return nil
+}
+
+// phaseIndex maps the OBIS group C of a per-phase code (e.g. 41 for L2 power
+// delivered) onto 0..2, given the L1 code.
+func phaseIndex(obis string, l1 int) int {
+	c, _ := strconv.Atoi(strings.TrimPrefix(strings.SplitN(obis, ".", 2)[0], "1-0:"))
+	return (c - l1) / 20
+}
+
+// parseNumber parses a value like "01234.567*kWh" or "002*A", dropping the unit.
+func parseNumber(value string) (float64, error) {
+	number, _, _ := strings.Cut(value, "*")
+	return strconv.ParseFloat(number, 64)
+}
+
+// Dutch meters report local time as YYMMDDhhmmss followed by S (summer, CEST)
+// or W (winter, CET).
+var (
+	cet  = time.FixedZone("CET", 1*60*60)
+	cest = time.FixedZone("CEST", 2*60*60)
+)
+
+func parseTimestamp(value string) (time.Time, error) {
+	if len(value) != 13 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	loc := cet
+	switch value[12] {
+	case 'S':
+		loc = cest
+	case 'W':
+	default:
+		return time.Time{}, fmt.Errorf("invalid timestamp DST flag %q", value)
+	}
+	return time.ParseInLocation("060102150405", value[:12], loc)
+}
+
+// crc16 computes the CRC16/ARC checksum used by DSMR 4 and 5 (polynomial 0x8005,
+// reflected, initial value 0) over the telegram from "/" through "!".
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
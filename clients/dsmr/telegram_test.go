@@ -0,0 +1,141 @@
+package dsmr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCRC terminates a telegram body (from "/" up to but excluding "!") with its CRC trailer.
+func withCRC(body string) string {
+	return fmt.Sprintf("%s!%04X\r\n", body, crc16([]byte(body+"!")))
+}
+
+// dsmr5Body is a recorded three-phase DSMR 5.0 telegram with a PV system exporting on L2.
+const dsmr5Body = "/ISK5\\2M550T-1012\r\n" +
+	"\r\n" +
+	"1-3:0.2.8(50)\r\n" +
+	"0-0:1.0.0(240615134512S)\r\n" +
+	"0-0:96.1.1(4530303434303037313331363530363138)\r\n" +
+	"1-0:1.8.1(001234.567*kWh)\r\n" +
+	"1-0:1.8.2(002345.678*kWh)\r\n" +
+	"1-0:2.8.1(000123.456*kWh)\r\n" +
+	"1-0:2.8.2(000234.567*kWh)\r\n" +
+	"0-0:96.14.0(0002)\r\n" +
+	"1-0:1.7.0(00.000*kW)\r\n" +
+	"1-0:2.7.0(01.250*kW)\r\n" +
+	"0-0:96.7.21(00010)\r\n" +
+	"1-0:99.97.0(1)(0-0:96.7.19)(230101120000W)(0000000240*s)\r\n" +
+	"1-0:32.7.0(230.1*V)\r\n" +
+	"1-0:52.7.0(232.4*V)\r\n" +
+	"1-0:72.7.0(229.8*V)\r\n" +
+	"1-0:31.7.0(002*A)\r\n" +
+	"1-0:51.7.0(010*A)\r\n" +
+	"1-0:71.7.0(001*A)\r\n" +
+	"1-0:21.7.0(00.450*kW)\r\n" +
+	"1-0:41.7.0(00.000*kW)\r\n" +
+	"1-0:61.7.0(00.200*kW)\r\n" +
+	"1-0:22.7.0(00.000*kW)\r\n" +
+	"1-0:42.7.0(01.900*kW)\r\n" +
+	"1-0:62.7.0(00.000*kW)\r\n" +
+	"0-1:24.1.0(003)\r\n" +
+	"0-1:24.2.1(240615134500S)(01234.567*m3)\r\n"
+
+func TestCRC16_CheckValue(t *testing.T) {
+	// Standard CRC-16/ARC check value.
+	if got := crc16([]byte("123456789")); got != 0xBB3D {
+		t.Errorf("crc16(123456789) = %04X, want BB3D", got)
+	}
+}
+
+func TestParse_DSMR5(t *testing.T) {
+	tg, err := Parse([]byte(withCRC(dsmr5Body)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if tg.Header != `ISK5\2M550T-1012` || tg.Version != "50" {
+		t.Errorf("header = %q version = %q", tg.Header, tg.Version)
+	}
+	wantTime := time.Date(2024, 6, 15, 11, 45, 12, 0, time.UTC)
+	if !tg.Timestamp.Equal(wantTime) {
+		t.Errorf("Timestamp = %v, want %v", tg.Timestamp, wantTime)
+	}
+	if tg.ActivePowerW() != -1250 {
+		t.Errorf("ActivePowerW() = %v, want -1250 (exporting)", tg.ActivePowerW())
+	}
+	if tg.TotalEnergyDeliveredKWh() != 1234.567+2345.678 || tg.EnergyReturnedKWh[1] != 234.567 {
+		t.Errorf("energy delivered = %v, returned = %v", tg.EnergyDeliveredKWh, tg.EnergyReturnedKWh)
+	}
+
+	wantPhases := [3]Phase{
+		{PowerDeliveredW: 450, CurrentA: 2, VoltageV: 230.1},
+		{PowerReturnedW: 1900, CurrentA: 10, VoltageV: 232.4},
+		{PowerDeliveredW: 200, CurrentA: 1, VoltageV: 229.8},
+	}
+	if tg.Phases != wantPhases {
+		t.Errorf("Phases = %+v, want %+v", tg.Phases, wantPhases)
+	}
+	if tg.PhaseSeen != [3]bool{true, true, true} {
+		t.Errorf("PhaseSeen = %v, want all phases", tg.PhaseSeen)
+	}
+	if tg.Phases[1].ActivePowerW() != -1900 {
+		t.Errorf("L2 ActivePowerW() = %v, want -1900", tg.Phases[1].ActivePowerW())
+	}
+	if gp := tg.GridPhases(); len(gp) != 3 || gp[1].PowerW != -1900 || gp[1].CurrentA != 10 {
+		t.Errorf("GridPhases() = %+v, want L2 at -1900 W / 10 A", gp)
+	}
+}
+
+func TestParse_CRCMismatch(t *testing.T) {
+	raw := withCRC(dsmr5Body)
+	corrupted := strings.Replace(raw, "01.250*kW", "01.260*kW", 1)
+
+	_, err := Parse([]byte(corrupted))
+	if !errors.Is(err, ErrCRCMismatch) {
+		t.Errorf("Parse() error = %v, want ErrCRCMismatch", err)
+	}
+}
+
+func TestParse_DSMR3WithoutCRC(t *testing.T) {
+	raw := "/KFM5KAIFA-METER\r\n\r\n1-0:1.7.0(0000.35*kW)\r\n1-0:2.7.0(0000.00*kW)\r\n!\r\n"
+
+	tg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if tg.ActivePowerW() != 350 {
+		t.Errorf("ActivePowerW() = %v, want 350", tg.ActivePowerW())
+	}
+	if tg.PhaseSeen != [3]bool{} {
+		t.Errorf("PhaseSeen = %v, want none", tg.PhaseSeen)
+	}
+	if gp := tg.GridPhases(); len(gp) != 1 || gp[0].PowerW != 350 {
+		t.Errorf("GridPhases() = %+v, want one phase at 350 W", gp)
+	}
+}
+
+func TestReadTelegram_ResynchronisesAndDetectsTruncation(t *testing.T) {
+	first := withCRC(dsmr5Body)
+	second := withCRC(strings.Replace(dsmr5Body, "01.250*kW", "00.100*kW", 1))
+	// Stream starts mid-telegram and ends mid-telegram.
+	stream := "0-0:96.14.0(0001)\r\n!1A2B\r\n" + first + second + second[:200]
+
+	r := bufio.NewReader(strings.NewReader(stream))
+	for i, want := range []string{first, second} {
+		got, err := ReadTelegram(r)
+		if err != nil {
+			t.Fatalf("telegram %d: ReadTelegram() error = %v", i, err)
+		}
+		if string(got) != want {
+			t.Errorf("telegram %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, err := ReadTelegram(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadTelegram() error = %v, want ErrUnexpectedEOF for truncated telegram", err)
+	}
+}
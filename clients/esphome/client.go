@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/foae/marstek-energy-trading/clients/marstek"
@@ -25,33 +26,35 @@ const (
 	espHomeControlPublicationInterval = 15 * time.Second
 	controlConfirmationTimeout        = espHomeControlPublicationInterval + 5*time.Second
 	controlConfirmationInterval       = 500 * time.Millisecond
-
-	// ESPHome sensor/entity paths (URL-encoded where needed)
-	sensorSOC              = "/sensor/Battery%20State%20Of%20Charge"
-	sensorTemperature      = "/sensor/Internal%20Temperature"
-	sensorRemainingCap     = "/sensor/Battery%20Remaining%20Capacity"
-	sensorTotalEnergy      = "/sensor/Battery%20Total%20Energy"
-	sensorBatteryPower     = "/sensor/Battery%20Power"
-	textSensorDeviceName   = "/text_sensor/Device%20Name"
-	textSensorEspIP        = "/text_sensor/Esp%20ip"
-	numberChargepower      = "/number/Forcible%20Charge%20Power"
-	numberDischargePower   = "/number/Forcible%20Discharge%20Power"
-	selectRS485ControlMode = "/select/RS485%20Control%20Mode"
-	// Note: Unicode division slash (U+2044) in "Charge⁄Discharge"
-	selectForceMode = "/select/Forcible%20Charge%E2%81%84Discharge"
 )
 
+// errEntityNotMapped is returned when reading an optional entity the profile does not map.
+var errEntityNotMapped = errors.New("entity not mapped")
+
 // Client is an ESPHome HTTP client for battery control.
 // It implements the service.BatteryController interface.
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	minSOC     int // Minimum SOC percentage for discharge flag
+	urlMu        sync.Mutex
+	baseURL      string
+	httpClient   *http.Client
+	streamClient *http.Client // no timeout: /events is a long-lived response
+	minSOC       int          // Minimum SOC percentage for discharge flag
+	entities     EntityMap
+
+	events     *eventCache
+	eventsMu   sync.Mutex
+	stopEvents context.CancelFunc
+	eventsDone chan struct{}
 }
 
-// New creates a new ESPHome client.
+// New creates a new ESPHome client using the default entity profile.
 // minSOC is the minimum SOC percentage (e.g., 11 for 11%).
 func New(baseURL string, minSOC int) *Client {
+	return NewWithEntities(baseURL, minSOC, DefaultEntityMap())
+}
+
+// NewWithEntities creates a new ESPHome client for a device whose YAML uses the given entity map.
+func NewWithEntities(baseURL string, minSOC int, entities EntityMap) *Client {
 	// Remove trailing slash if present
 	baseURL = strings.TrimRight(baseURL, "/")
 	if minSOC <= 0 {
@@ -63,32 +66,94 @@ func New(baseURL string, minSOC int) *Client {
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
+		streamClient: &http.Client{},
+		entities:     entities,
+		events:       newEventCache(),
 	}
 }
 
-// Connect verifies connectivity to the ESPHome device.
-// For HTTP this is a simple health check - actual connection is per-request.
+// URL returns the device base URL.
+func (c *Client) URL() string {
+	c.urlMu.Lock()
+	defer c.urlMu.Unlock()
+	return c.baseURL
+}
+
+// SetURL points the client at a new device address, e.g. after the device got a
+// new DHCP lease. A running /events subscription is restarted against it.
+func (c *Client) SetURL(baseURL string) {
+	c.urlMu.Lock()
+	c.baseURL = strings.TrimRight(baseURL, "/")
+	c.urlMu.Unlock()
+
+	c.eventsMu.Lock()
+	subscribed := c.stopEvents != nil
+	c.eventsMu.Unlock()
+	if subscribed {
+		c.Close()
+		c.Subscribe()
+	}
+}
+
+// Connect verifies connectivity to the ESPHome device, checks that every mapped
+// entity exists and subscribes to its /events stream. Reads and control
+// confirmations are served from the live event cache while the stream is
+// healthy and fall back to per-entity polling otherwise.
 func (c *Client) Connect() error {
-	_, err := c.getTextSensor(textSensorDeviceName)
+	_, err := c.getTextSensor(entityPath(c.entities.DeviceName))
+	if err != nil {
+		return fmt.Errorf("connect to ESPHome: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	missingOptional, err := c.checkEntities(ctx)
 	if err != nil {
 		return fmt.Errorf("connect to ESPHome: %w", err)
 	}
+	if len(missingOptional) > 0 {
+		slog.Warn("ESPHome device is missing optional entities", "missing", missingOptional)
+	}
+	c.Subscribe()
 	return nil
 }
 
-// Close is a no-op for HTTP (stateless protocol).
+// Subscribe starts the background /events subscription if it is not already running.
+func (c *Client) Subscribe() {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	if c.stopEvents != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopEvents = cancel
+	c.eventsDone = make(chan struct{})
+	go func() {
+		defer close(c.eventsDone)
+		c.runEvents(ctx)
+	}()
+}
+
+// Close stops the /events subscription. Control requests are stateless HTTP.
 func (c *Client) Close() error {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	if c.stopEvents == nil {
+		return nil
+	}
+	c.stopEvents()
+	<-c.eventsDone
+	c.stopEvents = nil
 	return nil
 }
 
 // Discover returns device information from ESPHome.
 func (c *Client) Discover() (*marstek.DeviceInfo, error) {
-	deviceName, err := c.getTextSensor(textSensorDeviceName)
+	deviceName, err := c.getTextSensor(entityPath(c.entities.DeviceName))
 	if err != nil {
 		return nil, fmt.Errorf("get device name: %w", err)
 	}
 
-	ip, err := c.getTextSensor(textSensorEspIP)
+	ip, err := c.getTextSensor(entityPath(c.entities.IP))
 	if err != nil {
 		// IP is optional, don't fail
 		ip = ""
@@ -107,19 +172,19 @@ func (c *Client) GetBatteryStatus() (*marstek.BatteryStatus, error) {
 
 // GetBatteryStatusContext returns battery status with cancellation support.
 func (c *Client) GetBatteryStatusContext(ctx context.Context) (*marstek.BatteryStatus, error) {
-	soc, err := c.getSensorFloatContext(ctx, sensorSOC)
+	soc, err := c.getSensorFloatContext(ctx, entityPath(c.entities.SOC))
 	if err != nil {
 		return nil, fmt.Errorf("get SOC: %w", err)
 	}
 
 	// Temperature is optional - don't fail if unavailable
-	temp, _ := c.getSensorFloatContext(ctx, sensorTemperature)
+	temp, _ := c.getSensorFloatContext(ctx, entityPath(c.entities.Temperature))
 
 	// Capacity is optional
-	capacity, _ := c.getSensorFloatContext(ctx, sensorRemainingCap)
+	capacity, _ := c.getSensorFloatContext(ctx, entityPath(c.entities.RemainingCapacity))
 
 	// Total energy for rated capacity
-	ratedCapacity, _ := c.getSensorFloatContext(ctx, sensorTotalEnergy)
+	ratedCapacity, _ := c.getSensorFloatContext(ctx, entityPath(c.entities.TotalEnergy))
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
@@ -133,19 +198,19 @@ func (c *Client) GetBatteryStatusContext(ctx context.Context) (*marstek.BatteryS
 		ChargingFlag:  socInt < 100,
 		DischargFlag:  socInt > c.minSOC,
 		Temperature:   temp,
-		Capacity:      capacity * 1000, // kWh to Wh
-		RatedCapacity: ratedCapacity * 1000,
+		Capacity:      c.entities.energyToWh(capacity),
+		RatedCapacity: c.entities.energyToWh(ratedCapacity),
 	}, nil
 }
 
 // GetESStatus returns the energy system status.
 func (c *Client) GetESStatus(ctx context.Context) (*marstek.ESStatus, error) {
-	soc, err := c.getSensorFloatContext(ctx, sensorSOC)
+	soc, err := c.getSensorFloatContext(ctx, entityPath(c.entities.SOC))
 	if err != nil {
 		return nil, fmt.Errorf("get SOC: %w", err)
 	}
 
-	power, err := c.getSensorFloatContext(ctx, sensorBatteryPower)
+	power, err := c.getSensorFloatContext(ctx, entityPath(c.entities.BatteryPower))
 	if err != nil {
 		return nil, fmt.Errorf("get battery power: %w", err)
 	}
@@ -158,7 +223,57 @@ func (c *Client) GetESStatus(ctx context.Context) (*marstek.ESStatus, error) {
 
 // GetBatteryPower returns the signed battery power: positive charging, negative discharging.
 func (c *Client) GetBatteryPower(ctx context.Context) (float64, error) {
-	return c.getSensorFloatContext(ctx, sensorBatteryPower)
+	return c.getSensorFloatContext(ctx, entityPath(c.entities.BatteryPower))
+}
+
+// GetEnergyCounters returns the battery's lifetime charge and discharge
+// energy counters in Wh.
+func (c *Client) GetEnergyCounters(ctx context.Context) (chargedWh, dischargedWh float64, err error) {
+	if c.entities.ChargeEnergy == "" || c.entities.DischargeEnergy == "" {
+		return 0, 0, fmt.Errorf("energy counters not mapped")
+	}
+	charged, err := c.getSensorFloatContext(ctx, entityPath(c.entities.ChargeEnergy))
+	if err != nil {
+		return 0, 0, fmt.Errorf("get charge energy: %w", err)
+	}
+	discharged, err := c.getSensorFloatContext(ctx, entityPath(c.entities.DischargeEnergy))
+	if err != nil {
+		return 0, 0, fmt.Errorf("get discharge energy: %w", err)
+	}
+	return c.entities.energyToWh(charged), c.entities.energyToWh(discharged), nil
+}
+
+// GetControlStatus reads the RS485 control and forcible mode selects. The battery
+// is under forced control only while RS485 control is enabled and the forcible
+// mode is charge or discharge.
+func (c *Client) GetControlStatus(ctx context.Context) (*marstek.ControlStatus, error) {
+	control, err := c.getControlValue(ctx, entityPath(c.entities.ControlMode))
+	if err != nil {
+		return nil, fmt.Errorf("get RS485 control mode: %w", err)
+	}
+	force, err := c.getControlValue(ctx, entityPath(c.entities.ForceMode))
+	if err != nil {
+		return nil, fmt.Errorf("get forcible mode: %w", err)
+	}
+	power, err := c.GetBatteryPower(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get battery power: %w", err)
+	}
+
+	status := &marstek.ControlStatus{
+		Mode:         control + "/" + force,
+		Direction:    marstek.ControlIdle,
+		BatteryPower: power,
+	}
+	if control == c.entities.ControlEnable {
+		switch force {
+		case c.entities.ForceCharge:
+			status.Direction = marstek.ControlCharge
+		case c.entities.ForceDischarge:
+			status.Direction = marstek.ControlDischarge
+		}
+	}
+	return status, nil
 }
 
 // Charge starts charging at the specified power (watts).
@@ -170,17 +285,17 @@ func (c *Client) Charge(powerW int, _ int) error {
 // ChargeContext starts charging and allows cancellation while ESPHome applies each control write.
 func (c *Client) ChargeContext(ctx context.Context, powerW int, _ int) error {
 	// Enable RS485 control mode first
-	if err := c.setSelectConfirmed(ctx, selectRS485ControlMode, "enable"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ControlMode), c.entities.ControlEnable); err != nil {
 		return fmt.Errorf("enable RS485 control mode: %w", err)
 	}
 
 	// Then set charge power
-	if err := c.setNumberConfirmed(ctx, numberChargepower, float64(powerW)); err != nil {
+	if err := c.setNumberConfirmed(ctx, entityPath(c.entities.ChargePower), float64(powerW)); err != nil {
 		return fmt.Errorf("set charge power: %w", err)
 	}
 
 	// Finally activate charge mode
-	if err := c.setSelectConfirmed(ctx, selectForceMode, "charge"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ForceMode), c.entities.ForceCharge); err != nil {
 		return fmt.Errorf("set charge mode: %w", err)
 	}
 
@@ -196,17 +311,17 @@ func (c *Client) Discharge(powerW int, _ int) error {
 // DischargeContext starts discharging and allows cancellation while ESPHome applies each control write.
 func (c *Client) DischargeContext(ctx context.Context, powerW int, _ int) error {
 	// Enable RS485 control mode first
-	if err := c.setSelectConfirmed(ctx, selectRS485ControlMode, "enable"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ControlMode), c.entities.ControlEnable); err != nil {
 		return fmt.Errorf("enable RS485 control mode: %w", err)
 	}
 
 	// Then set discharge power
-	if err := c.setNumberConfirmed(ctx, numberDischargePower, float64(powerW)); err != nil {
+	if err := c.setNumberConfirmed(ctx, entityPath(c.entities.DischargePower), float64(powerW)); err != nil {
 		return fmt.Errorf("set discharge power: %w", err)
 	}
 
 	// Finally activate discharge mode
-	if err := c.setSelectConfirmed(ctx, selectForceMode, "discharge"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ForceMode), c.entities.ForceDischarge); err != nil {
 		return fmt.Errorf("set discharge mode: %w", err)
 	}
 
@@ -243,16 +358,16 @@ func (c *Client) Idle() error {
 // IdleContext stops forced operation and allows cancellation during control confirmation.
 func (c *Client) IdleContext(ctx context.Context) error {
 	var enableErr error
-	if err := c.setSelectConfirmed(ctx, selectRS485ControlMode, "enable"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ControlMode), c.entities.ControlEnable); err != nil {
 		enableErr = fmt.Errorf("enable RS485 control mode: %w", err)
 	}
 
-	if err := c.setSelectConfirmed(ctx, selectForceMode, "stop"); err != nil {
+	if err := c.setSelectConfirmed(ctx, entityPath(c.entities.ForceMode), c.entities.ForceStop); err != nil {
 		return errors.Join(enableErr, fmt.Errorf("stop forcible mode: %w", err))
 	}
 
 	// Once stop is confirmed the battery is physically safe; disabling RS485 is cleanup.
-	disableErr := c.setSelectConfirmed(ctx, selectRS485ControlMode, "disable")
+	disableErr := c.setSelectConfirmed(ctx, entityPath(c.entities.ControlMode), c.entities.ControlDisable)
 	if enableErr != nil || disableErr != nil {
 		slog.Warn("battery stopped but RS485 control cleanup was incomplete",
 			"error", errors.Join(enableErr, disableErr))
@@ -282,7 +397,15 @@ type controlResponse struct {
 }
 
 func (c *Client) getSensorFloatContext(ctx context.Context, path string) (float64, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if path == "" {
+		return 0, errEntityNotMapped
+	}
+	if cached, ok := c.events.lookup(path); ok {
+		if value, err := strconv.ParseFloat(cached, 64); err == nil {
+			return value, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL()+path, nil)
 	if err != nil {
 		return 0, fmt.Errorf("create GET %s request: %w", path, err)
 	}
@@ -307,7 +430,10 @@ func (c *Client) getSensorFloatContext(ctx context.Context, path string) (float6
 
 // getTextSensor retrieves a text sensor value.
 func (c *Client) getTextSensor(path string) (string, error) {
-	resp, err := c.httpClient.Get(c.baseURL + path)
+	if path == "" {
+		return "", errEntityNotMapped
+	}
+	resp, err := c.httpClient.Get(c.URL() + path)
 	if err != nil {
 		return "", fmt.Errorf("GET %s: %w", path, err)
 	}
@@ -332,7 +458,7 @@ func (c *Client) getTextSensor(path string) (string, error) {
 
 // setNumber sets a number entity value via POST.
 func (c *Client) setNumber(ctx context.Context, path string, value float64) error {
-	endpoint := fmt.Sprintf("%s%s/set?value=%v", c.baseURL, path, value)
+	endpoint := fmt.Sprintf("%s%s/set?value=%v", c.URL(), path, value)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, http.NoBody)
 	if err != nil {
 		return fmt.Errorf("create POST %s request: %w", path, err)
@@ -353,7 +479,7 @@ func (c *Client) setNumber(ctx context.Context, path string, value float64) erro
 
 // setSelect sets a select entity option via POST.
 func (c *Client) setSelect(ctx context.Context, path string, option string) error {
-	endpoint := fmt.Sprintf("%s%s/set?option=%s", c.baseURL, path, url.QueryEscape(option))
+	endpoint := fmt.Sprintf("%s%s/set?option=%s", c.URL(), path, url.QueryEscape(option))
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, http.NoBody)
 	if err != nil {
 		return fmt.Errorf("create POST %s request: %w", path, err)
@@ -388,6 +514,7 @@ func (c *Client) setNumberConfirmed(ctx context.Context, path string, value floa
 	var lastValue string
 	var lastErr error
 	for {
+		changed := c.events.wait()
 		actual, err := c.getControlValue(confirmationCtx, path)
 		if err == nil {
 			lastValue = actual
@@ -407,6 +534,7 @@ func (c *Client) setNumberConfirmed(ctx context.Context, path string, value floa
 				return fmt.Errorf("confirm value %v: %w", value, lastErr)
 			}
 			return fmt.Errorf("confirm value %v: still %q", value, lastValue)
+		case <-changed:
 		case <-time.After(controlConfirmationInterval):
 		}
 	}
@@ -418,6 +546,7 @@ func (c *Client) waitForControlValue(ctx context.Context, path string, expected
 	var lastValue string
 	var lastErr error
 	for {
+		changed := c.events.wait()
 		actual, err := c.getControlValue(confirmationCtx, path)
 		if err == nil {
 			lastValue = actual
@@ -436,13 +565,17 @@ func (c *Client) waitForControlValue(ctx context.Context, path string, expected
 				return fmt.Errorf("confirm option %q: %w", expected, lastErr)
 			}
 			return fmt.Errorf("confirm option %q: still %q", expected, lastValue)
+		case <-changed:
 		case <-time.After(controlConfirmationInterval):
 		}
 	}
 }
 
 func (c *Client) getControlValue(ctx context.Context, path string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if cached, ok := c.events.lookup(path); ok {
+		return cached, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL()+path, nil)
 	if err != nil {
 		return "", fmt.Errorf("create GET %s request: %w", path, err)
 	}
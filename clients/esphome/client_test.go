@@ -46,23 +46,56 @@ func newControlTestServer(t *testing.T, failOption string) (*httptest.Server, *[
 }
 
 func TestConnect_Success(t *testing.T) {
+	required := map[string]bool{}
+	for _, entity := range []string{"soc", "battery_power", "device_name", "charge_power", "discharge_power", "control_mode", "force_mode"} {
+		required["/"+DefaultEntityMap().fields()[entity].value] = true
+	}
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/text_sensor/Device%20Name" || r.URL.Path == "/text_sensor/Device Name" {
 			w.Header().Set("Content-Type", "application/json")
 			w.Write([]byte(`{"id":"text_sensor-device_name","state":"Marstek Venus E"}`))
 			return
 		}
+		if required[r.URL.Path] {
+			w.Write([]byte(`{"value":0}`))
+			return
+		}
 		http.NotFound(w, r)
 	}))
 	defer server.Close()
 
 	client := New(server.URL, 11)
+	defer client.Close()
 	err := client.Connect()
 	if err != nil {
 		t.Errorf("Connect() error = %v, want nil", err)
 	}
 }
 
+func TestConnect_ReportsMissingEntities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sensor/Battery Power" || strings.HasPrefix(r.URL.Path, "/sensor/Battery State") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"value":"Marstek Venus E"}`))
+	}))
+	defer server.Close()
+
+	err := New(server.URL, 11).Connect()
+	if err == nil {
+		t.Fatal("Connect() error = nil, want missing entity error")
+	}
+	for _, want := range []string{"sensor/Battery Power (battery_power, HTTP 404)", "sensor/Battery State Of Charge (soc, HTTP 404)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Connect() error = %v, want it to name %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "Forcible") {
+		t.Errorf("Connect() error = %v, want only the missing entities", err)
+	}
+}
+
 func TestConnect_Failure(t *testing.T) {
 	// Connect to a non-existent server
 	client := New("http://127.0.0.1:1", 11)
@@ -531,6 +564,30 @@ func TestGetESStatus(t *testing.T) {
 	}
 }
 
+func TestGetEnergyCounters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(r.URL.Path, "Total Charging Energy"):
+			w.Write([]byte(`{"id":"sensor-charged","value":152.25,"state":"152.25 kWh"}`))
+		case strings.Contains(r.URL.Path, "Total Discharging Energy"):
+			w.Write([]byte(`{"id":"sensor-discharged","value":131.5,"state":"131.5 kWh"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := New(server.URL, 11)
+	charged, discharged, err := client.GetEnergyCounters(context.Background())
+	if err != nil {
+		t.Fatalf("GetEnergyCounters() error = %v", err)
+	}
+	if charged != 152250 || discharged != 131500 {
+		t.Errorf("GetEnergyCounters() = %v, %v Wh; want 152250, 131500", charged, discharged)
+	}
+}
+
 func TestGetESStatus_RequiresBatteryPower(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "State Of Charge") {
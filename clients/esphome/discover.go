@@ -0,0 +1,141 @@
+package esphome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	mdnsService = "_esphomelib._tcp"
+	mdnsDomain  = "local."
+	mdnsTimeout = 3 * time.Second
+)
+
+// ErrNoMarstekEntities is returned by DetectProfile for ESPHome devices (or
+// other HTTP servers) that do not expose the entities of any built-in profile.
+var ErrNoMarstekEntities = errors.New("no Marstek entities found")
+
+// DiscoveryResult contains the details of a discovered ESPHome device.
+type DiscoveryResult struct {
+	URL      string // web server URL; mDNS advertises the native API port, the REST API is on port 80
+	Name     string // friendly name, or the node name when none is set
+	MAC      string
+	Hostname string
+	Method   string // "mdns"
+}
+
+// Browse returns every ESPHome device that answers over mDNS within the browse timeout.
+func Browse(ctx context.Context) ([]DiscoveryResult, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create mDNS resolver: %w", err)
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	done := make(chan []DiscoveryResult, 1)
+
+	ctx, cancel := context.WithTimeout(ctx, mdnsTimeout)
+	defer cancel()
+
+	go func() {
+		var results []DiscoveryResult
+		for entry := range entries {
+			if r := resultFromEntry(entry); r != nil {
+				results = append(results, *r)
+			}
+		}
+		done <- results
+	}()
+
+	if err := resolver.Browse(ctx, mdnsService, mdnsDomain, entries); err != nil {
+		return nil, fmt.Errorf("mDNS browse: %w", err)
+	}
+
+	<-ctx.Done()
+	return <-done, nil
+}
+
+// resultFromEntry returns the discovery result for an ESPHome mDNS entry, or nil if it has no address.
+func resultFromEntry(entry *zeroconf.ServiceEntry) *DiscoveryResult {
+	txt := parseTXTRecords(entry.Text)
+
+	var host string
+	if len(entry.AddrIPv4) > 0 {
+		host = entry.AddrIPv4[0].String()
+	} else if len(entry.AddrIPv6) > 0 {
+		host = "[" + entry.AddrIPv6[0].String() + "]"
+	} else {
+		slog.Debug("skipping ESPHome device with no addresses", "hostname", entry.HostName)
+		return nil
+	}
+
+	name := txt["friendly_name"]
+	if name == "" {
+		name = entry.Instance
+	}
+	return &DiscoveryResult{
+		URL:      "http://" + host,
+		Name:     name,
+		MAC:      txt["mac"],
+		Hostname: entry.HostName,
+		Method:   "mdns",
+	}
+}
+
+// DetectProfile returns the built-in entity profile whose entities the ESPHome
+// device at baseURL exposes, trying the default profile first. It returns
+// ErrNoMarstekEntities when no profile matches.
+func DetectProfile(ctx context.Context, client *http.Client, baseURL string) (string, error) {
+	names := Profiles()
+	slices.SortStableFunc(names, func(a, b string) int {
+		switch {
+		case a == DefaultProfile:
+			return -1
+		case b == DefaultProfile:
+			return 1
+		}
+		return 0
+	})
+
+	for _, name := range names {
+		c := &Client{
+			baseURL:    strings.TrimRight(baseURL, "/"),
+			httpClient: client,
+			entities:   profiles[name],
+		}
+		// One request rules out most devices before checking every entity.
+		status, err := c.entityStatus(ctx, entityPath(c.entities.SOC))
+		if err != nil {
+			return "", err
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		if _, err := c.checkEntities(ctx); err != nil {
+			slog.Debug("ESPHome device partially matches profile", "url", baseURL, "profile", name, "error", err)
+			continue
+		}
+		return name, nil
+	}
+	return "", ErrNoMarstekEntities
+}
+
+// parseTXTRecords converts mDNS TXT record entries (key=value) into a map.
+func parseTXTRecords(records []string) map[string]string {
+	m := make(map[string]string, len(records))
+	for _, r := range records {
+		k, v, ok := strings.Cut(r, "=")
+		if ok {
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,81 @@
+package esphome
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// profileServer serves every required entity of the named profile.
+func profileServer(t *testing.T, profile string) *httptest.Server {
+	t.Helper()
+	exposed := map[string]bool{}
+	for _, entity := range profiles[profile].fields() {
+		if entity.required {
+			exposed["/"+entity.value] = true
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !exposed[r.URL.Path] {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"value":1,"state":"1"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDetectProfile(t *testing.T) {
+	for _, profile := range Profiles() {
+		t.Run(profile, func(t *testing.T) {
+			server := profileServer(t, profile)
+			got, err := DetectProfile(context.Background(), server.Client(), server.URL)
+			if err != nil {
+				t.Fatalf("DetectProfile() error = %v", err)
+			}
+			if got != profile {
+				t.Errorf("DetectProfile() = %q, want %q", got, profile)
+			}
+		})
+	}
+}
+
+func TestDetectProfile_NoMarstekEntities(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := DetectProfile(context.Background(), server.Client(), server.URL)
+	if !errors.Is(err, ErrNoMarstekEntities) {
+		t.Errorf("DetectProfile() error = %v, want ErrNoMarstekEntities", err)
+	}
+}
+
+func TestResultFromEntry(t *testing.T) {
+	entry := zeroconf.NewServiceEntry("marstek-bridge", mdnsService, mdnsDomain)
+	entry.HostName = "marstek-bridge.local."
+	entry.Port = 6053
+	entry.AddrIPv4 = []net.IP{net.IPv4(10, 0, 0, 30)}
+	entry.Text = []string{"friendly_name=Marstek Venus", "mac=a0b1c2d3e4f5", "version=2024.12.0"}
+
+	r := resultFromEntry(entry)
+	if r == nil {
+		t.Fatal("resultFromEntry() = nil")
+	}
+	if r.URL != "http://10.0.0.30" {
+		t.Errorf("URL = %q, want the web server on port 80", r.URL)
+	}
+	if r.Name != "Marstek Venus" || r.MAC != "a0b1c2d3e4f5" {
+		t.Errorf("result = %+v", r)
+	}
+
+	entry.AddrIPv4 = nil
+	if r := resultFromEntry(entry); r != nil {
+		t.Errorf("resultFromEntry() without addresses = %+v, want nil", r)
+	}
+}
@@ -0,0 +1,235 @@
+package esphome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the entity profile used when none is configured.
+const DefaultProfile = "default"
+
+// EntityMap maps the battery functions the trader needs onto ESPHome entities.
+// Entities are written as "domain/Name", e.g. "sensor/Battery Power", using the
+// entity name exactly as it appears in the ESPHome YAML.
+type EntityMap struct {
+	SOC               string `json:"soc"`
+	BatteryPower      string `json:"battery_power"`
+	Temperature       string `json:"temperature,omitempty"`        // optional
+	RemainingCapacity string `json:"remaining_capacity,omitempty"` // optional
+	TotalEnergy       string `json:"total_energy,omitempty"`       // optional
+	ChargeEnergy      string `json:"charge_energy,omitempty"`      // optional lifetime charge counter
+	DischargeEnergy   string `json:"discharge_energy,omitempty"`   // optional lifetime discharge counter
+	DeviceName        string `json:"device_name"`
+	IP                string `json:"ip,omitempty"` // optional
+	ChargePower       string `json:"charge_power"`
+	DischargePower    string `json:"discharge_power"`
+	ControlMode       string `json:"control_mode"`
+	ForceMode         string `json:"force_mode"`
+
+	// EnergyUnit is the unit of the capacity and energy counter sensors: "kWh" or "Wh".
+	EnergyUnit string `json:"energy_unit"`
+
+	// Select option labels.
+	ControlEnable  string `json:"control_enable"`
+	ControlDisable string `json:"control_disable"`
+	ForceCharge    string `json:"force_charge"`
+	ForceDischarge string `json:"force_discharge"`
+	ForceStop      string `json:"force_stop"`
+}
+
+// profiles are the built-in entity maps. Other YAMLs are mapped by overriding
+// the default profile with an ESPHOME_ENTITY_MAP file.
+var profiles = map[string]EntityMap{
+	// The Modbus YAML this project was developed against. Note the Unicode
+	// division slash (U+2044) in "Charge⁄Discharge": ESPHome cannot route a
+	// plain "/" in an entity name.
+	DefaultProfile: {
+		SOC:               "sensor/Battery State Of Charge",
+		BatteryPower:      "sensor/Battery Power",
+		Temperature:       "sensor/Internal Temperature",
+		RemainingCapacity: "sensor/Battery Remaining Capacity",
+		TotalEnergy:       "sensor/Battery Total Energy",
+		ChargeEnergy:      "sensor/Total Charging Energy",
+		DischargeEnergy:   "sensor/Total Discharging Energy",
+		DeviceName:        "text_sensor/Device Name",
+		IP:                "text_sensor/Esp ip",
+		ChargePower:       "number/Forcible Charge Power",
+		DischargePower:    "number/Forcible Discharge Power",
+		ControlMode:       "select/RS485 Control Mode",
+		ForceMode:         "select/Forcible Charge⁄Discharge",
+		EnergyUnit:        "kWh",
+		ControlEnable:     "enable",
+		ControlDisable:    "disable",
+		ForceCharge:       "charge",
+		ForceDischarge:    "discharge",
+		ForceStop:         "stop",
+	},
+}
+
+// Profiles returns the names of the built-in entity profiles.
+func Profiles() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultEntityMap returns the entity map of the default profile.
+func DefaultEntityMap() EntityMap {
+	return profiles[DefaultProfile]
+}
+
+// LoadEntityMap returns the named built-in profile, with any fields present in
+// the optional JSON file at path overriding it.
+func LoadEntityMap(profile, path string) (EntityMap, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	m, ok := profiles[profile]
+	if !ok {
+		return EntityMap{}, fmt.Errorf("unknown ESPHome profile %q (available: %s)", profile, strings.Join(Profiles(), ", "))
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return EntityMap{}, fmt.Errorf("read entity map: %w", err)
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return EntityMap{}, fmt.Errorf("parse entity map %s: %w", path, err)
+		}
+	}
+	if err := m.validate(); err != nil {
+		return EntityMap{}, err
+	}
+	return m, nil
+}
+
+// validate checks that the map is syntactically complete.
+func (m EntityMap) validate() error {
+	var errs []error
+	for field, entity := range m.fields() {
+		if entity.value == "" {
+			if entity.required {
+				errs = append(errs, fmt.Errorf("entity %s is not mapped", field))
+			}
+			continue
+		}
+		domain, name, ok := strings.Cut(entity.value, "/")
+		if !ok || name == "" || domain != entity.domain {
+			errs = append(errs, fmt.Errorf("entity %s = %q, want %q", field, entity.value, entity.domain+"/<name>"))
+		}
+	}
+	if m.EnergyUnit != "kWh" && m.EnergyUnit != "Wh" {
+		errs = append(errs, fmt.Errorf("energy_unit = %q, want kWh or Wh", m.EnergyUnit))
+	}
+	for field, option := range map[string]string{
+		"control_enable":  m.ControlEnable,
+		"control_disable": m.ControlDisable,
+		"force_charge":    m.ForceCharge,
+		"force_discharge": m.ForceDischarge,
+		"force_stop":      m.ForceStop,
+	} {
+		if option == "" {
+			errs = append(errs, fmt.Errorf("select option %s is not mapped", field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type mappedEntity struct {
+	value    string
+	domain   string
+	required bool
+}
+
+// fields lists every mapped entity keyed by its JSON field name.
+func (m EntityMap) fields() map[string]mappedEntity {
+	return map[string]mappedEntity{
+		"soc":                {m.SOC, "sensor", true},
+		"battery_power":      {m.BatteryPower, "sensor", true},
+		"temperature":        {m.Temperature, "sensor", false},
+		"remaining_capacity": {m.RemainingCapacity, "sensor", false},
+		"total_energy":       {m.TotalEnergy, "sensor", false},
+		"charge_energy":      {m.ChargeEnergy, "sensor", false},
+		"discharge_energy":   {m.DischargeEnergy, "sensor", false},
+		"device_name":        {m.DeviceName, "text_sensor", true},
+		"ip":                 {m.IP, "text_sensor", false},
+		"charge_power":       {m.ChargePower, "number", true},
+		"discharge_power":    {m.DischargePower, "number", true},
+		"control_mode":       {m.ControlMode, "select", true},
+		"force_mode":         {m.ForceMode, "select", true},
+	}
+}
+
+// energyToWh converts a capacity sensor reading to Wh.
+func (m EntityMap) energyToWh(v float64) float64 {
+	if m.EnergyUnit == "Wh" {
+		return v
+	}
+	return v * 1000
+}
+
+// entityPath converts "domain/Name" into the URL path of the ESPHome REST API.
+// An empty entity maps to an empty path.
+func entityPath(entity string) string {
+	domain, name, ok := strings.Cut(entity, "/")
+	if !ok {
+		return ""
+	}
+	return "/" + domain + "/" + url.PathEscape(name)
+}
+
+// checkEntities verifies that every mapped entity exists on the device.
+// Missing required entities are returned as an error; missing optional ones are returned separately.
+func (c *Client) checkEntities(ctx context.Context) (missingOptional []string, err error) {
+	var missingRequired []string
+	for field, entity := range c.entities.fields() {
+		if entity.value == "" {
+			continue
+		}
+		status, reqErr := c.entityStatus(ctx, entityPath(entity.value))
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		if status == http.StatusOK {
+			continue
+		}
+		desc := fmt.Sprintf("%s (%s, HTTP %d)", entity.value, field, status)
+		if entity.required {
+			missingRequired = append(missingRequired, desc)
+		} else {
+			missingOptional = append(missingOptional, desc)
+		}
+	}
+	slices.Sort(missingRequired)
+	slices.Sort(missingOptional)
+	if len(missingRequired) > 0 {
+		return missingOptional, fmt.Errorf("ESPHome device is missing mapped entities: %s; check ESPHOME_PROFILE or ESPHOME_ENTITY_MAP against your YAML",
+			strings.Join(missingRequired, ", "))
+	}
+	return missingOptional, nil
+}
+
+// entityStatus returns the HTTP status of GET path.
+func (c *Client) entityStatus(ctx context.Context, path string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL()+path, nil)
+	if err != nil {
+		return 0, fmt.Errorf("create GET %s request: %w", path, err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("GET %s: %w", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,139 @@
+package esphome
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEntityMap_BuiltInProfiles(t *testing.T) {
+	for _, name := range Profiles() {
+		if _, err := LoadEntityMap(name, ""); err != nil {
+			t.Errorf("LoadEntityMap(%q) error = %v", name, err)
+		}
+	}
+	m, err := LoadEntityMap("", "")
+	if err != nil {
+		t.Fatalf("LoadEntityMap(\"\") error = %v", err)
+	}
+	if m != DefaultEntityMap() {
+		t.Error("empty profile should select the default profile")
+	}
+}
+
+func TestLoadEntityMap_UnknownProfile(t *testing.T) {
+	_, err := LoadEntityMap("nope", "")
+	if err == nil || !strings.Contains(err.Error(), "available: default") {
+		t.Fatalf("LoadEntityMap() error = %v, want unknown profile listing available profiles", err)
+	}
+}
+
+func TestLoadEntityMap_FileOverridesProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entities.json")
+	data := `{"soc": "sensor/SoC", "force_mode": "select/Forcible Charge-Discharge", "energy_unit": "Wh", "force_stop": "Idle"}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadEntityMap(DefaultProfile, path)
+	if err != nil {
+		t.Fatalf("LoadEntityMap() error = %v", err)
+	}
+	if m.SOC != "sensor/SoC" || m.ForceMode != "select/Forcible Charge-Discharge" || m.EnergyUnit != "Wh" || m.ForceStop != "Idle" {
+		t.Errorf("overrides not applied: %+v", m)
+	}
+	if m.BatteryPower != DefaultEntityMap().BatteryPower {
+		t.Errorf("BatteryPower = %q, want profile value kept", m.BatteryPower)
+	}
+}
+
+func TestLoadEntityMap_RejectsInvalidMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entities.json")
+	data := `{"soc": "Battery SOC", "charge_power": "", "energy_unit": "MWh"}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadEntityMap(DefaultProfile, path)
+	if err == nil {
+		t.Fatal("LoadEntityMap() error = nil, want validation error")
+	}
+	for _, want := range []string{`entity soc = "Battery SOC"`, "entity charge_power is not mapped", `energy_unit = "MWh"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+// writeEntityMap writes an entity map override file and returns its path.
+func writeEntityMap(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "entities.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetBatteryStatus_WhEntityMap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sensor/Battery SOC":
+			w.Write([]byte(`{"value":40}`))
+		case "/sensor/Battery Remaining Capacity":
+			w.Write([]byte(`{"value":2048}`))
+		case "/sensor/Battery Total Energy":
+			w.Write([]byte(`{"value":5120}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	entities, err := LoadEntityMap(DefaultProfile, writeEntityMap(t, `{"soc": "sensor/Battery SOC", "energy_unit": "Wh"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := NewWithEntities(server.URL, 11, entities).GetBatteryStatus()
+	if err != nil {
+		t.Fatalf("GetBatteryStatus() error = %v", err)
+	}
+	if status.SOC != 40 || status.Capacity != 2048 || status.RatedCapacity != 5120 {
+		t.Errorf("status = %+v, want SOC 40, capacity 2048 Wh, rated 5120 Wh", status)
+	}
+}
+
+func TestChargeUsesMappedOptionLabels(t *testing.T) {
+	values := map[string]string{}
+	var posts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity := strings.TrimSuffix(r.URL.Path, "/set")
+		if r.Method == http.MethodPost {
+			posts = append(posts, r.URL.String())
+			values[entity] = r.URL.Query().Get("option") + r.URL.Query().Get("value")
+			return
+		}
+		w.Write([]byte(`{"value":"` + values[entity] + `"}`))
+	}))
+	defer server.Close()
+
+	entities, err := LoadEntityMap(DefaultProfile, writeEntityMap(t, `{
+		"charge_power": "number/Force Charge Power",
+		"force_mode": "select/Force Mode",
+		"control_enable": "Enable",
+		"force_charge": "Charge"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewWithEntities(server.URL, 11, entities).Charge(1000, 0); err != nil {
+		t.Fatalf("Charge() error = %v", err)
+	}
+	if len(posts) != 3 || !strings.Contains(posts[0], "option=Enable") ||
+		!strings.Contains(posts[1], "Force%20Charge%20Power") || !strings.Contains(posts[2], "/select/Force%20Mode/set?option=Charge") {
+		t.Errorf("posts = %v, want the mapped entities and options", posts)
+	}
+}
@@ -0,0 +1,270 @@
+package esphome
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventsPath = "/events"
+
+	// ESPHome's web server pings every event-stream client roughly every 10 seconds,
+	// so three missed pings mean the stream is dead even if TCP has not noticed yet.
+	eventStreamStaleAfter    = 30 * time.Second
+	eventStreamMinBackoff    = time.Second
+	eventStreamMaxBackoff    = 30 * time.Second
+	eventStreamMaxLineLength = 64 * 1024
+)
+
+// eventState is the JSON payload of an ESPHome "state" event.
+type eventState struct {
+	ID     string          `json:"id"`
+	NameID string          `json:"name_id"`
+	Name   string          `json:"name"`
+	State  string          `json:"state"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// eventCache holds the live entity states received from the /events stream.
+type eventCache struct {
+	mu        sync.Mutex
+	states    map[string]string // latest value per entity key
+	connected bool
+	lastEvent time.Time
+	changed   chan struct{} // closed and replaced on every state update
+	nowFunc   func() time.Time
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		states:  make(map[string]string),
+		changed: make(chan struct{}),
+		nowFunc: time.Now,
+	}
+}
+
+// liveLocked reports whether the stream is connected and has delivered an event recently.
+// Caller must hold e.mu.
+func (e *eventCache) liveLocked() bool {
+	return e.connected && e.nowFunc().Sub(e.lastEvent) < eventStreamStaleAfter
+}
+
+// lookup returns the cached value for an entity path if the stream is live.
+func (e *eventCache) lookup(path string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.liveLocked() {
+		return "", false
+	}
+	for _, key := range entityKeys(path) {
+		if value, ok := e.states[key]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// wait returns a channel that is closed on the next state update.
+func (e *eventCache) wait() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.changed
+}
+
+func (e *eventCache) setConnected(connected bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connected = connected
+	if connected {
+		e.lastEvent = e.nowFunc()
+		return
+	}
+	// A reconnect replays every entity, so drop states that may be outdated.
+	clear(e.states)
+	e.broadcastLocked()
+}
+
+func (e *eventCache) touch() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastEvent = e.nowFunc()
+}
+
+func (e *eventCache) update(ev eventState) {
+	value := ev.State
+	if len(ev.Value) > 0 {
+		value = rawValueString(ev.Value)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastEvent = e.nowFunc()
+	if ev.ID != "" {
+		e.states[ev.ID] = value
+	}
+	if ev.NameID != "" {
+		e.states[ev.NameID] = value
+	}
+	if domain, _, ok := strings.Cut(ev.ID, "-"); ok && ev.Name != "" {
+		e.states[domain+"/"+ev.Name] = value
+	}
+	e.broadcastLocked()
+}
+
+// broadcastLocked wakes every waiter. Caller must hold e.mu.
+func (e *eventCache) broadcastLocked() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// entityKeys returns the cache keys an entity path may be published under:
+// the "domain/Name" form used by newer ESPHome releases and the legacy
+// "domain-object_id" form.
+func entityKeys(path string) []string {
+	domain, escapedName, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok {
+		return nil
+	}
+	name, err := url.PathUnescape(escapedName)
+	if err != nil {
+		name = escapedName
+	}
+	return []string{domain + "/" + name, domain + "-" + objectID(name)}
+}
+
+// objectID mirrors ESPHome's str_sanitize(str_snake_case(name)), which works on
+// bytes: every byte outside [a-z0-9_-] becomes an underscore.
+func objectID(name string) string {
+	b := []byte(strings.ToLower(name))
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// rawValueString renders an ESPHome "value" field, which is a string for selects
+// and text sensors and a number for sensors and numbers.
+func rawValueString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// runEvents keeps the /events subscription open until ctx is cancelled,
+// reconnecting with exponential backoff.
+func (c *Client) runEvents(ctx context.Context) {
+	backoff := eventStreamMinBackoff
+	for {
+		start := time.Now()
+		err := c.streamEvents(ctx)
+		c.events.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > eventStreamMaxBackoff {
+			backoff = eventStreamMinBackoff
+		}
+		slog.Warn("ESPHome event stream disconnected, falling back to polling", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, eventStreamMaxBackoff)
+	}
+}
+
+// streamEvents reads one /events connection until it fails or goes stale.
+func (c *Client) streamEvents(ctx context.Context) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, c.URL()+eventsPath, nil)
+	if err != nil {
+		return fmt.Errorf("create GET %s request: %w", eventsPath, err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", eventsPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: status %d: %s", eventsPath, resp.StatusCode, string(body))
+	}
+
+	c.events.setConnected(true)
+	slog.Info("ESPHome event stream connected", "url", c.URL()+eventsPath)
+
+	// Cancel the request when no event (including pings) arrives in time.
+	stale := time.AfterFunc(eventStreamStaleAfter, cancel)
+	defer stale.Stop()
+
+	return readEvents(resp.Body, func(event, data string) {
+		stale.Reset(eventStreamStaleAfter)
+		c.events.touch()
+		if event != "state" {
+			return
+		}
+		var ev eventState
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			slog.Debug("ignoring malformed ESPHome state event", "error", err)
+			return
+		}
+		c.events.update(ev)
+	})
+}
+
+// readEvents parses a server-sent events stream and calls dispatch for each event.
+func readEvents(r io.Reader, dispatch func(event, data string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), eventStreamMaxLineLength)
+
+	var event string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() > 0 || event != "" {
+				dispatch(cmp.Or(event, "message"), strings.TrimSuffix(data.String(), "\n"))
+			}
+			event = ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,237 @@
+package esphome
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadEvents_ParsesStateAndPingEvents(t *testing.T) {
+	stream := ": keepalive\n" +
+		"event: ping\n" +
+		"data: {\"title\":\"marstek\"}\n\n" +
+		"id: 17\n" +
+		"event: state\n" +
+		"data: {\"id\":\"sensor-battery_power\",\"value\":1500,\"state\":\"1500 W\"}\n\n" +
+		"data: no event name\n\n"
+
+	var got []string
+	err := readEvents(strings.NewReader(stream), func(event, data string) {
+		got = append(got, event+"|"+data)
+	})
+	if err == nil {
+		t.Fatal("readEvents() error = nil, want EOF at end of stream")
+	}
+	want := []string{
+		`ping|{"title":"marstek"}`,
+		`state|{"id":"sensor-battery_power","value":1500,"state":"1500 W"}`,
+		`message|no event name`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEntityKeys(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{entityPath(DefaultEntityMap().SOC), []string{"sensor/Battery State Of Charge", "sensor-battery_state_of_charge"}},
+		{entityPath(DefaultEntityMap().ForceMode), []string{"select/Forcible Charge⁄Discharge", "select-forcible_charge___discharge"}},
+	}
+	for _, tt := range tests {
+		got := entityKeys(tt.path)
+		if len(got) != 2 || got[0] != tt.want[0] || got[1] != tt.want[1] {
+			t.Errorf("entityKeys(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEventCache_StaleStreamFallsBackToPolling(t *testing.T) {
+	cache := newEventCache()
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	cache.nowFunc = func() time.Time { return now }
+
+	cache.setConnected(true)
+	cache.update(eventState{ID: "sensor-battery_power", Value: []byte("750")})
+	if v, ok := cache.lookup(entityPath(DefaultEntityMap().BatteryPower)); !ok || v != "750" {
+		t.Fatalf("lookup() = %q, %v; want 750, true", v, ok)
+	}
+
+	now = now.Add(eventStreamStaleAfter)
+	if _, ok := cache.lookup(entityPath(DefaultEntityMap().BatteryPower)); ok {
+		t.Error("lookup() served a value from a stale stream")
+	}
+
+	cache.setConnected(false)
+	cache.setConnected(true)
+	if _, ok := cache.lookup(entityPath(DefaultEntityMap().BatteryPower)); ok {
+		t.Error("lookup() served a value cached before the reconnect")
+	}
+}
+
+// eventServer is a fake ESPHome web server that publishes entity states over /events.
+type eventServer struct {
+	mu      sync.Mutex
+	values  map[string]string // legacy entity id -> value
+	polls   int
+	streams []chan string
+}
+
+func stateEvent(id, value string) string {
+	return fmt.Sprintf("event: state\ndata: {\"id\":%q,\"value\":%q}\n\n", id, value)
+}
+
+func (s *eventServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == eventsPath {
+		flusher := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		ch := make(chan string, 16)
+		s.mu.Lock()
+		for id, value := range s.values {
+			fmt.Fprint(w, stateEvent(id, value))
+		}
+		s.streams = append(s.streams, ch)
+		s.mu.Unlock()
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case line := <-ch:
+				fmt.Fprint(w, line)
+				flusher.Flush()
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == http.MethodPost {
+		domain, name, _ := strings.Cut(strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/set"), "/"), "/")
+		value := r.URL.Query().Get("option")
+		if value == "" {
+			value = r.URL.Query().Get("value")
+		}
+		// The device applies the write and publishes the new state on the stream.
+		id := domain + "-" + objectID(name)
+		s.values[id] = value
+		for _, ch := range s.streams {
+			ch <- stateEvent(id, value)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	s.polls++
+	http.NotFound(w, r)
+}
+
+func (s *eventServer) pollCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polls
+}
+
+func waitForLiveStream(t *testing.T, c *Client) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := c.events.lookup(entityPath(c.entities.SOC)); ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("event stream did not deliver initial states")
+}
+
+func TestGetESStatus_ServedFromEventStream(t *testing.T) {
+	srv := &eventServer{values: map[string]string{
+		"sensor-battery_state_of_charge": "64",
+		"sensor-battery_power":           "-1200",
+	}}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	client := New(server.URL, 11)
+	client.Subscribe()
+	defer client.Close()
+	waitForLiveStream(t, client)
+
+	status, err := client.GetESStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetESStatus() error = %v", err)
+	}
+	if status.BatterySOC != 64 || status.BatteryPower != -1200 {
+		t.Errorf("status = %+v, want SOC 64 and power -1200", status)
+	}
+	if polls := srv.pollCount(); polls != 0 {
+		t.Errorf("sensor polls = %d, want 0 while the event stream is live", polls)
+	}
+}
+
+func TestChargeConfirmedByStateEvents(t *testing.T) {
+	srv := &eventServer{values: map[string]string{
+		"sensor-battery_state_of_charge":     "50",
+		"select-rs485_control_mode":          "disable",
+		"select-forcible_charge___discharge": "stop",
+		"number-forcible_charge_power":       "0",
+	}}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	client := New(server.URL, 11)
+	client.Subscribe()
+	defer client.Close()
+	waitForLiveStream(t, client)
+
+	start := time.Now()
+	if err := client.ChargeContext(context.Background(), 1800, 0); err != nil {
+		t.Fatalf("ChargeContext() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 3*controlConfirmationInterval {
+		t.Errorf("confirmation took %s, want event-driven confirmation faster than polling", elapsed)
+	}
+	if polls := srv.pollCount(); polls != 0 {
+		t.Errorf("control polls = %d, want 0 while the event stream is live", polls)
+	}
+}
+
+func TestSetURL_ResubscribesToNewAddress(t *testing.T) {
+	old := httptest.NewServer(&eventServer{values: map[string]string{"sensor-battery_state_of_charge": "50", "sensor-battery_power": "100"}})
+	defer old.Close()
+	moved := httptest.NewServer(&eventServer{values: map[string]string{"sensor-battery_state_of_charge": "50", "sensor-battery_power": "-700"}})
+	defer moved.Close()
+
+	client := New(old.URL, 11)
+	client.Subscribe()
+	defer client.Close()
+	waitForLiveStream(t, client)
+
+	client.SetURL(moved.URL)
+	if client.URL() != moved.URL {
+		t.Fatalf("URL() = %q, want %q", client.URL(), moved.URL)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		power, err := client.GetBatteryPower(context.Background())
+		if err == nil && power == -700 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetBatteryPower() = %v, %v, want -700 from the new address", power, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,28 @@
+// Package grid holds the per-phase meter reading shared by the grid meter clients.
+package grid
+
+import "math"
+
+// NominalVoltageV is assumed when a meter does not report phase voltage.
+const NominalVoltageV = 230.0
+
+// Phase is the instantaneous reading of one grid phase at the meter.
+type Phase struct {
+	PowerW   float64 `json:"power_w"`             // Positive imports, negative exports
+	CurrentA float64 `json:"current_a,omitempty"` // RMS current, 0 if not reported
+	VoltageV float64 `json:"voltage_v,omitempty"` // RMS voltage, 0 if not reported
+}
+
+// Voltage returns the measured voltage, or NominalVoltageV if not reported.
+func (p Phase) Voltage() float64 {
+	if p.VoltageV > 0 {
+		return p.VoltageV
+	}
+	return NominalVoltageV
+}
+
+// Current returns the RMS current. Meters that only report power (or report
+// whole amps, like DSMR) get the larger of the reported and power-derived current.
+func (p Phase) Current() float64 {
+	return max(p.CurrentA, math.Abs(p.PowerW)/p.Voltage())
+}
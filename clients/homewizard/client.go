@@ -6,7 +6,10 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
 )
 
 const defaultTimeout = 5 * time.Second
@@ -21,12 +24,52 @@ type DeviceInfo struct {
 }
 
 // dataResponse represents the /api/v1/data response from a HomeWizard P1 meter.
+// Per-phase fields are absent on single-phase connections.
 type dataResponse struct {
-	ActivePowerW float64 `json:"active_power_w"`
+	ActivePowerW     float64  `json:"active_power_w"`
+	ActivePowerL1W   *float64 `json:"active_power_l1_w"`
+	ActivePowerL2W   *float64 `json:"active_power_l2_w"`
+	ActivePowerL3W   *float64 `json:"active_power_l3_w"`
+	ActiveCurrentL1A *float64 `json:"active_current_l1_a"`
+	ActiveCurrentL2A *float64 `json:"active_current_l2_a"`
+	ActiveCurrentL3A *float64 `json:"active_current_l3_a"`
+	ActiveVoltageL1V *float64 `json:"active_voltage_l1_v"`
+	ActiveVoltageL2V *float64 `json:"active_voltage_l2_v"`
+	ActiveVoltageL3V *float64 `json:"active_voltage_l3_v"`
+	TotalImportKWh   *float64 `json:"total_power_import_kwh"`
+	TotalExportKWh   *float64 `json:"total_power_export_kwh"`
+}
+
+// phases returns the reported phases, in order L1..L3.
+func (d *dataResponse) phases() []grid.Phase {
+	powers := []*float64{d.ActivePowerL1W, d.ActivePowerL2W, d.ActivePowerL3W}
+	currents := []*float64{d.ActiveCurrentL1A, d.ActiveCurrentL2A, d.ActiveCurrentL3A}
+	voltages := []*float64{d.ActiveVoltageL1V, d.ActiveVoltageL2V, d.ActiveVoltageL3V}
+
+	var phases []grid.Phase
+	for i := range powers {
+		if powers[i] == nil && currents[i] == nil {
+			continue
+		}
+		phases = append(phases, grid.Phase{PowerW: deref(powers[i]), CurrentA: deref(currents[i]), VoltageV: deref(voltages[i])})
+	}
+	// Older firmware only reports totals: treat the connection as single-phase.
+	if len(phases) == 0 {
+		phases = append(phases, grid.Phase{PowerW: d.ActivePowerW})
+	}
+	return phases
+}
+
+func deref(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
 }
 
 // Client is a HomeWizard P1 meter HTTP client.
 type Client struct {
+	mu         sync.Mutex
 	baseURL    string
 	httpClient *http.Client
 	enabled    bool
@@ -50,13 +93,27 @@ func (c *Client) Enabled() bool {
 	return c.enabled
 }
 
+// URL returns the meter's base URL.
+func (c *Client) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.baseURL
+}
+
+// SetURL points the client at a new meter address, e.g. after the meter got a new DHCP lease.
+func (c *Client) SetURL(baseURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseURL = strings.TrimRight(baseURL, "/")
+}
+
 // GetDeviceInfo fetches device info from the P1 meter (connectivity check).
 func (c *Client) GetDeviceInfo() (*DeviceInfo, error) {
 	if !c.enabled {
 		return nil, fmt.Errorf("homewizard P1 meter not configured")
 	}
 
-	resp, err := c.httpClient.Get(c.baseURL + "/api")
+	resp, err := c.httpClient.Get(c.URL() + "/api")
 	if err != nil {
 		return nil, fmt.Errorf("GET /api: %w", err)
 	}
@@ -78,25 +135,67 @@ func (c *Client) GetDeviceInfo() (*DeviceInfo, error) {
 // GetActivePowerW returns the current active power in watts from the P1 meter.
 // Positive values mean importing from grid, negative values mean exporting (solar surplus).
 func (c *Client) GetActivePowerW() (float64, error) {
+	data, err := c.getData()
+	if err != nil {
+		return 0, err
+	}
+	return data.ActivePowerW, nil
+}
+
+// GetPVPowerW returns the solar production in watts, for a kWh meter on the
+// inverter circuit. The meter sees the inverter's output as export (negative
+// power); the inverter's standby draw at night reads as zero production.
+func (c *Client) GetPVPowerW() (float64, error) {
+	power, err := c.GetActivePowerW()
+	if err != nil {
+		return 0, err
+	}
+	return max(-power, 0), nil
+}
+
+// GetPhases returns the per-phase power, current and voltage from the P1 meter.
+func (c *Client) GetPhases() ([]grid.Phase, error) {
+	data, err := c.getData()
+	if err != nil {
+		return nil, err
+	}
+	return data.phases(), nil
+}
+
+// GetEnergyRegisters returns the meter's cumulative import and export
+// registers in kWh, summed over tariffs.
+func (c *Client) GetEnergyRegisters() (importKWh, exportKWh float64, err error) {
+	data, err := c.getData()
+	if err != nil {
+		return 0, 0, err
+	}
+	if data.TotalImportKWh == nil || data.TotalExportKWh == nil {
+		return 0, 0, fmt.Errorf("meter does not report energy registers")
+	}
+	return *data.TotalImportKWh, *data.TotalExportKWh, nil
+}
+
+// getData fetches GET /api/v1/data.
+func (c *Client) getData() (*dataResponse, error) {
 	if !c.enabled {
-		return 0, fmt.Errorf("homewizard P1 meter not configured")
+		return nil, fmt.Errorf("homewizard P1 meter not configured")
 	}
 
-	resp, err := c.httpClient.Get(c.baseURL + "/api/v1/data")
+	resp, err := c.httpClient.Get(c.URL() + "/api/v1/data")
 	if err != nil {
-		return 0, fmt.Errorf("GET /api/v1/data: %w", err)
+		return nil, fmt.Errorf("GET /api/v1/data: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("GET /api/v1/data: status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("GET /api/v1/data: status %d: %s", resp.StatusCode, string(body))
 	}
 
 	var data dataResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0, fmt.Errorf("decode data response: %w", err)
+		return nil, fmt.Errorf("decode data response: %w", err)
 	}
 
-	return data.ActivePowerW, nil
+	return &data, nil
 }
@@ -48,6 +48,28 @@ func TestGetActivePowerW_Export(t *testing.T) {
 	}
 }
 
+func TestGetPVPowerW(t *testing.T) {
+	for _, tt := range []struct {
+		body string
+		want float64
+	}{
+		{`{"active_power_w": -1520.4}`, 1520.4},
+		{`{"active_power_w": 3.1}`, 0},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tt.body))
+		}))
+		power, err := New(server.URL).GetPVPowerW()
+		server.Close()
+		if err != nil {
+			t.Fatalf("GetPVPowerW() error = %v", err)
+		}
+		if power != tt.want {
+			t.Errorf("GetPVPowerW() with %s = %v, want %v", tt.body, power, tt.want)
+		}
+	}
+}
+
 func TestGetActivePowerW_ServerError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -193,3 +215,59 @@ func TestGetActivePowerW_ConnectionRefused(t *testing.T) {
 		t.Error("GetActivePowerW() error = nil, want error for connection refused")
 	}
 }
+
+func TestGetPhases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active_power_w": 2100, "active_power_l1_w": 1800, "active_power_l2_w": 0, "active_power_l3_w": 300,
+			"active_current_l1_a": 8, "active_current_l2_a": 0, "active_current_l3_a": 1.3,
+			"active_voltage_l1_v": 229.1, "active_voltage_l2_v": 230.2, "active_voltage_l3_v": 231.0}`))
+	}))
+	defer server.Close()
+
+	phases, err := New(server.URL).GetPhases()
+	if err != nil {
+		t.Fatalf("GetPhases() error = %v", err)
+	}
+	if len(phases) != 3 {
+		t.Fatalf("got %d phases, want 3", len(phases))
+	}
+	if phases[0].PowerW != 1800 || phases[0].CurrentA != 8 || phases[2].VoltageV != 231.0 {
+		t.Errorf("phases = %+v", phases)
+	}
+}
+
+func TestGetPhases_SinglePhase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active_power_w": -450, "active_power_l1_w": -450, "active_current_l1_a": 2}`))
+	}))
+	defer server.Close()
+
+	phases, err := New(server.URL).GetPhases()
+	if err != nil {
+		t.Fatalf("GetPhases() error = %v", err)
+	}
+	if len(phases) != 1 || phases[0].PowerW != -450 {
+		t.Errorf("phases = %+v, want single L1 phase at -450 W", phases)
+	}
+}
+
+func TestSetURL(t *testing.T) {
+	moved := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"active_power_w": 120}`))
+	}))
+	defer moved.Close()
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	client := New(gone.URL)
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Fatal("GetActivePowerW() error = nil at the old address")
+	}
+	client.SetURL(moved.URL + "/")
+	if client.URL() != moved.URL {
+		t.Errorf("URL() = %q, want %q", client.URL(), moved.URL)
+	}
+	if power, err := client.GetActivePowerW(); err != nil || power != 120 {
+		t.Errorf("GetActivePowerW() = %v, %v after SetURL, want 120", power, err)
+	}
+}
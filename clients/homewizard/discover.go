@@ -25,29 +25,45 @@ const (
 	scanWorkers        = 64
 )
 
-// subnets to scan when mDNS fails (typical home networks).
-var scanSubnets = []string{"192.168.1", "192.168.0"}
+// energyMeterTypes are the product types of HomeWizard devices that measure electricity.
+var energyMeterTypes = map[string]bool{
+	"HWE-P1":      true, // P1 meter
+	"HWE-KWH1":    true, // kWh meter, 1 phase
+	"HWE-KWH3":    true, // kWh meter, 3 phase
+	"SDM230-wifi": true, // kWh meter, 1 phase (first generation)
+	"SDM630-wifi": true, // kWh meter, 3 phase (first generation)
+}
+
+// IsEnergyMeter reports whether a HomeWizard product type is a P1 or kWh meter.
+func IsEnergyMeter(productType string) bool {
+	return energyMeterTypes[productType]
+}
 
 // DiscoveryResult contains the details of a discovered HomeWizard device.
 type DiscoveryResult struct {
-	URL      string
-	Serial   string
-	Hostname string
-	Method   string // "mdns" or "http_scan"
+	URL         string
+	ProductType string // e.g. HWE-P1, HWE-KWH3
+	Serial      string
+	Hostname    string
+	Method      string // "mdns" or "http_scan"
 }
 
 // Discover attempts to find a HomeWizard P1 meter on the local network.
-// It tries mDNS first, then falls back to an HTTP scan of common subnets.
-func Discover(ctx context.Context) (*DiscoveryResult, error) {
+// It tries mDNS first, then falls back to an HTTP scan of hosts (IPs or
+// host:port); without hosts only mDNS is tried.
+func Discover(ctx context.Context, hosts []string) (*DiscoveryResult, error) {
 	// Try mDNS first
 	result, err := discoverMDNS(ctx)
 	if err == nil {
 		return result, nil
 	}
+	if len(hosts) == 0 {
+		return nil, err
+	}
 	slog.Info("mDNS discovery failed, falling back to HTTP scan", "mdns_error", err)
 
 	// Fall back to HTTP scan
-	result, err = discoverHTTPScan(ctx)
+	result, err = discoverHTTPScan(ctx, hosts)
 	if err != nil {
 		return nil, fmt.Errorf("all discovery methods failed: %w", err)
 	}
@@ -69,42 +85,12 @@ func discoverMDNS(ctx context.Context) (*DiscoveryResult, error) {
 
 	go func() {
 		for entry := range entries {
-			txt := parseTXTRecords(entry.Text)
-			l := slog.With(
-				"hostname", entry.HostName,
-				"addr", entry.AddrIPv4,
-				"port", entry.Port,
-				"product_type", txt["product_type"],
-				"api_enabled", txt["api_enabled"],
-				"serial", txt["serial"],
-			)
-
-			if txt["product_type"] != "HWE-P1" {
-				l.Debug("skipping non-P1 HomeWizard device")
+			r := resultFromEntry(entry)
+			if r == nil || r.ProductType != "HWE-P1" {
 				continue
 			}
-			if txt["api_enabled"] != "1" {
-				l.Debug("skipping P1 device with API disabled")
-				continue
-			}
-
-			var host string
-			if len(entry.AddrIPv4) > 0 {
-				host = entry.AddrIPv4[0].String()
-			} else if len(entry.AddrIPv6) > 0 {
-				host = "[" + entry.AddrIPv6[0].String() + "]"
-			} else {
-				l.Debug("skipping P1 device with no addresses")
-				continue
-			}
-
 			select {
-			case result <- &DiscoveryResult{
-				URL:      fmt.Sprintf("http://%s:%d", host, entry.Port),
-				Serial:   txt["serial"],
-				Hostname: entry.HostName,
-				Method:   "mdns",
-			}:
+			case result <- r:
 				cancel()
 			default:
 			}
@@ -126,9 +112,82 @@ func discoverMDNS(ctx context.Context) (*DiscoveryResult, error) {
 	}
 }
 
-// discoverHTTPScan scans common LAN subnets for a HomeWizard P1 meter by
-// probing GET /api on each IP. It uses a worker pool for concurrency.
-func discoverHTTPScan(ctx context.Context) (*DiscoveryResult, error) {
+// Browse returns every HomeWizard P1 and kWh meter with the local API enabled
+// that answers over mDNS within the browse timeout.
+func Browse(ctx context.Context) ([]DiscoveryResult, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create mDNS resolver: %w", err)
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	done := make(chan []DiscoveryResult, 1)
+
+	ctx, cancel := context.WithTimeout(ctx, mdnsTimeout)
+	defer cancel()
+
+	go func() {
+		var results []DiscoveryResult
+		for entry := range entries {
+			if r := resultFromEntry(entry); r != nil {
+				results = append(results, *r)
+			}
+		}
+		done <- results
+	}()
+
+	if err := resolver.Browse(ctx, mdnsService, mdnsDomain, entries); err != nil {
+		return nil, fmt.Errorf("mDNS browse: %w", err)
+	}
+
+	<-ctx.Done()
+	return <-done, nil
+}
+
+// resultFromEntry returns the discovery result for an mDNS entry of an energy
+// meter with the local API enabled, or nil for other devices.
+func resultFromEntry(entry *zeroconf.ServiceEntry) *DiscoveryResult {
+	txt := parseTXTRecords(entry.Text)
+	l := slog.With(
+		"hostname", entry.HostName,
+		"addr", entry.AddrIPv4,
+		"port", entry.Port,
+		"product_type", txt["product_type"],
+		"api_enabled", txt["api_enabled"],
+		"serial", txt["serial"],
+	)
+
+	if !IsEnergyMeter(txt["product_type"]) {
+		l.Debug("skipping non-meter HomeWizard device")
+		return nil
+	}
+	if txt["api_enabled"] != "1" {
+		l.Debug("skipping HomeWizard meter with API disabled")
+		return nil
+	}
+
+	var host string
+	if len(entry.AddrIPv4) > 0 {
+		host = entry.AddrIPv4[0].String()
+	} else if len(entry.AddrIPv6) > 0 {
+		host = "[" + entry.AddrIPv6[0].String() + "]"
+	} else {
+		l.Debug("skipping HomeWizard meter with no addresses")
+		return nil
+	}
+
+	return &DiscoveryResult{
+		URL:         fmt.Sprintf("http://%s:%d", host, entry.Port),
+		ProductType: txt["product_type"],
+		Serial:      txt["serial"],
+		Hostname:    entry.HostName,
+		Method:      "mdns",
+	}
+}
+
+// discoverHTTPScan scans hosts for a HomeWizard P1 meter by probing GET /api
+// on each. It uses a worker pool for concurrency.
+func discoverHTTPScan(ctx context.Context, hosts []string) (*DiscoveryResult, error) {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
@@ -142,19 +201,11 @@ func discoverHTTPScan(ctx context.Context) (*DiscoveryResult, error) {
 		Timeout: scanReadTimeout,
 	}
 
-	// Collect all IPs to scan
-	var ips []string
-	for _, subnet := range scanSubnets {
-		for i := 1; i < 255; i++ {
-			ips = append(ips, fmt.Sprintf("%s.%d", subnet, i))
-		}
-	}
-
-	slog.Info("starting HTTP scan for HomeWizard P1", "subnets", scanSubnets, "total_ips", len(ips))
+	slog.Info("starting HTTP scan for HomeWizard P1", "total_ips", len(hosts))
 
 	// Fan out to workers
-	ipCh := make(chan string, len(ips))
-	for _, ip := range ips {
+	ipCh := make(chan string, len(hosts))
+	for _, ip := range hosts {
 		ipCh <- ip
 	}
 	close(ipCh)
@@ -194,29 +245,43 @@ func discoverHTTPScan(ctx context.Context) (*DiscoveryResult, error) {
 	if r, ok := <-resultCh; ok {
 		return r, nil
 	}
-	return nil, fmt.Errorf("no HomeWizard P1 meter found via HTTP scan on %v", scanSubnets)
+	return nil, fmt.Errorf("no HomeWizard P1 meter found via HTTP scan of %d hosts", len(hosts))
 }
 
-// probeP1 checks a single IP for a HomeWizard P1 device by calling GET /api.
-func probeP1(ctx context.Context, client *http.Client, ip string) *DiscoveryResult {
-	url := "http://" + ip + "/api"
+// Probe checks a single host (IP or host:port) for a HomeWizard energy meter by
+// calling GET /api. It returns an error for unreachable hosts, other HTTP
+// servers and HomeWizard devices that are not P1 or kWh meters.
+func Probe(ctx context.Context, client *http.Client, host string) (*DeviceInfo, error) {
+	url := "http://" + host + "/api"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
 	}
 
 	var info DeviceInfo
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", url, err)
+	}
+	if !IsEnergyMeter(info.ProductType) {
+		return nil, fmt.Errorf("%s: not a HomeWizard energy meter (product type %q)", host, info.ProductType)
+	}
+	return &info, nil
+}
+
+// probeP1 checks a single IP for a HomeWizard P1 device by calling GET /api.
+func probeP1(ctx context.Context, client *http.Client, ip string) *DiscoveryResult {
+	info, err := Probe(ctx, client, ip)
+	if err != nil {
 		return nil
 	}
 
@@ -227,10 +292,11 @@ func probeP1(ctx context.Context, client *http.Client, ip string) *DiscoveryResu
 
 	slog.Info("found HomeWizard P1 via HTTP scan", "ip", ip, "serial", info.Serial, "product", info.ProductName)
 	return &DiscoveryResult{
-		URL:      "http://" + ip,
-		Serial:   info.Serial,
-		Hostname: info.ProductName,
-		Method:   "http_scan",
+		URL:         "http://" + ip,
+		ProductType: info.ProductType,
+		Serial:      info.Serial,
+		Hostname:    info.ProductName,
+		Method:      "http_scan",
 	}
 }
 
@@ -310,7 +310,7 @@ func TestDiscover_PrefersMDNS(t *testing.T) {
 
 	time.Sleep(200 * time.Millisecond)
 
-	result, err := Discover(context.Background())
+	result, err := Discover(context.Background(), nil)
 	if err != nil {
 		t.Fatalf("Discover() error: %v", err)
 	}
@@ -322,3 +322,29 @@ func TestDiscover_PrefersMDNS(t *testing.T) {
 		t.Errorf("serial: got %q, want %q", result.Serial, "mdnsp1serial")
 	}
 }
+
+func TestProbe_AcceptsKWhMeter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(DeviceInfo{ProductType: "HWE-KWH3", Serial: "kwh3serial"})
+	}))
+	defer srv.Close()
+
+	info, err := Probe(context.Background(), srv.Client(), srv.URL[len("http://"):])
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	if info.ProductType != "HWE-KWH3" || info.Serial != "kwh3serial" {
+		t.Errorf("Probe() = %+v", info)
+	}
+}
+
+func TestProbe_RejectsNonMeterDevice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(DeviceInfo{ProductType: "HWE-SKT", Serial: "socketserial"})
+	}))
+	defer srv.Close()
+
+	if _, err := Probe(context.Background(), srv.Client(), srv.URL[len("http://"):]); err == nil {
+		t.Error("Probe() error = nil, want error for an energy socket")
+	}
+}
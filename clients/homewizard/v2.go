@@ -0,0 +1,641 @@
+package homewizard
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+const (
+	v2APIVersion   = "2"
+	v2StateFile    = "homewizard-v2.json"
+	v2PairInterval = 2 * time.Second
+
+	// Measurements are pushed on every meter telegram (1 s for DSMR 5, 10 s for DSMR 4).
+	v2StaleAfter  = 30 * time.Second
+	v2MinBackoff  = time.Second
+	v2MaxBackoff  = 30 * time.Second
+	v2WriteWindow = 5 * time.Second
+)
+
+// ErrPressButton is returned while pairing until the button on the device is pressed.
+var ErrPressButton = errors.New("press the button on the HomeWizard device to pair")
+
+// Measurement is the v2 /api/measurement payload (and websocket "measurement" data).
+type Measurement struct {
+	PowerW          float64 `json:"power_w"`
+	PowerL1W        float64 `json:"power_l1_w"`
+	PowerL2W        float64 `json:"power_l2_w"`
+	PowerL3W        float64 `json:"power_l3_w"`
+	CurrentL1A      float64 `json:"current_l1_a"`
+	CurrentL2A      float64 `json:"current_l2_a"`
+	CurrentL3A      float64 `json:"current_l3_a"`
+	VoltageL1V      float64 `json:"voltage_l1_v"`
+	VoltageL2V      float64 `json:"voltage_l2_v"`
+	VoltageL3V      float64 `json:"voltage_l3_v"`
+	EnergyImportKWh float64 `json:"energy_import_kwh"`
+	EnergyExportKWh float64 `json:"energy_export_kwh"`
+	Timestamp       string  `json:"timestamp"`
+}
+
+// V2Trust selects how the device certificate is verified. The device presents
+// a certificate issued by HomeWizard's CA to appliance/<product>/<serial>,
+// which does not match its IP address.
+type V2Trust struct {
+	RootCAs *x509.CertPool // HomeWizard's CA (see LoadCAFile)
+	Serial  string         // device serial the certificate must be issued to
+
+	// PinOnFirstUse trusts the first certificate the device presents and
+	// rejects any other afterwards. Only used without RootCAs.
+	PinOnFirstUse bool
+}
+
+// LoadCAFile reads PEM certificates, such as HomeWizard's published CA, into a pool.
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read homewizard CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("homewizard CA %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// v2State is persisted in DATA_DIR so the device only has to be paired once.
+type v2State struct {
+	Host       string `json:"host"`
+	Token      string `json:"token"`
+	CertSHA256 string `json:"cert_sha256,omitempty"` // pinned device certificate (PinOnFirstUse)
+}
+
+// wsMessage is the envelope of every v2 websocket message.
+type wsMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// V2Client is a HomeWizard local API v2 client. It pairs with the device using
+// the button-press flow, verifies the device certificate against HomeWizard's
+// CA (or a pin, see V2Trust), and keeps the latest measurement from the
+// websocket push subscription, falling back to HTTPS polling while the
+// websocket is down.
+type V2Client struct {
+	userName  string
+	statePath string
+	trust     V2Trust
+	http      *http.Client
+	tlsConfig *tls.Config
+
+	mu          sync.Mutex
+	host        string // host[:port]
+	state       v2State
+	latest      *Measurement
+	received    time.Time
+	wsConnected bool
+	nowFunc     func() time.Time
+
+	runMu   sync.Mutex
+	stopRun context.CancelFunc
+	runDone chan struct{}
+}
+
+// NewV2 creates a v2 client for the device at baseURL (scheme optional, HTTPS is always used).
+// userName identifies this application on the device; dataDir stores the token and,
+// when pinning, the certificate pin. If baseURL is empty, the client is disabled.
+func NewV2(baseURL, dataDir, userName string, trust V2Trust) *V2Client {
+	host := strings.TrimRight(baseURL, "/")
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+
+	c := &V2Client{
+		host:     host,
+		userName: "local/" + strings.TrimPrefix(userName, "local/"),
+		trust:    trust,
+		nowFunc:  time.Now,
+	}
+	if dataDir != "" {
+		c.statePath = filepath.Join(dataDir, v2StateFile)
+	}
+	c.tlsConfig = &tls.Config{
+		// The device certificate does not match the device's IP address, so
+		// the default verification is replaced by verifyConnection.
+		InsecureSkipVerify: true,
+		VerifyConnection:   c.verifyConnection,
+	}
+	c.http = &http.Client{
+		Timeout:   defaultTimeout,
+		Transport: &http.Transport{TLSClientConfig: c.tlsConfig},
+	}
+	return c
+}
+
+// Enabled returns true if the meter is configured.
+func (c *V2Client) Enabled() bool {
+	return c.currentHost() != ""
+}
+
+// URL returns the device's base URL.
+func (c *V2Client) URL() string {
+	return "https://" + c.currentHost()
+}
+
+// SetURL points the client at a new device address, e.g. after the device got a
+// new DHCP lease. The token and certificate pin stay valid for the same device;
+// the websocket reconnects to the new address once the old connection fails.
+func (c *V2Client) SetURL(baseURL string) {
+	host := strings.TrimRight(baseURL, "/")
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.host = host
+	if c.state.Token == "" {
+		return
+	}
+	c.state.Host = host
+	if err := c.saveStateLocked(); err != nil {
+		slog.Warn("failed to save HomeWizard v2 state", "error", err)
+	}
+}
+
+func (c *V2Client) currentHost() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.host
+}
+
+// Paired returns true if a token is available.
+func (c *V2Client) Paired() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Token != ""
+}
+
+// LoadState reads the persisted token and certificate pin.
+func (c *V2Client) LoadState() error {
+	if c.statePath == "" {
+		return nil // No persistence configured
+	}
+	data, err := os.ReadFile(c.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read homewizard v2 state: %w", err)
+	}
+
+	var state v2State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("unmarshal homewizard v2 state: %w", err)
+	}
+	if host := c.currentHost(); state.Host != host {
+		slog.Warn("HomeWizard v2 token was paired with a different address; the device certificate is still verified",
+			"paired_host", state.Host, "host", host, "state_file", c.statePath)
+	}
+
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+	return nil
+}
+
+// saveStateLocked persists the token and pin atomically. Caller must hold c.mu.
+func (c *V2Client) saveStateLocked() error {
+	if c.statePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.statePath), 0755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal homewizard v2 state: %w", err)
+	}
+	tmpPath := c.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.statePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("rename homewizard v2 state: %w", err)
+	}
+	return nil
+}
+
+// verifyConnection checks the device certificate against the configured trust.
+func (c *V2Client) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("homewizard: device presented no certificate")
+	}
+	switch {
+	case c.trust.RootCAs != nil:
+		return c.verifyChain(cs.PeerCertificates)
+	case c.trust.PinOnFirstUse:
+		return c.verifyPin(cs)
+	default:
+		return errors.New("homewizard: no CA configured to verify the device certificate")
+	}
+}
+
+// verifyChain requires a certificate chain to HomeWizard's CA, issued to the
+// configured device serial.
+func (c *V2Client) verifyChain(certs []*x509.Certificate) error {
+	if c.trust.Serial == "" {
+		return errors.New("homewizard: device serial unknown, cannot verify the device certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.trust.RootCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("homewizard: verify device certificate: %w", err)
+	}
+	if !issuedToSerial(leaf, c.trust.Serial) {
+		return fmt.Errorf("homewizard: device certificate %q is not issued to serial %s", leaf.Subject.CommonName, c.trust.Serial)
+	}
+	return nil
+}
+
+// issuedToSerial reports whether cert names appliance/<product>/<serial>.
+func issuedToSerial(cert *x509.Certificate, serial string) bool {
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		parts := strings.Split(name, "/")
+		if len(parts) == 3 && parts[0] == "appliance" && strings.EqualFold(parts[2], serial) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPin trusts the device certificate on first use and rejects any other
+// certificate afterwards.
+func (c *V2Client) verifyPin(cs tls.ConnectionState) error {
+	sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state.CertSHA256 {
+	case fingerprint:
+		return nil
+	case "":
+		c.state.CertSHA256 = fingerprint
+		slog.Info("pinned HomeWizard device certificate", "host", c.host, "sha256", fingerprint,
+			"subject", cs.PeerCertificates[0].Subject.CommonName)
+		return nil
+	default:
+		return fmt.Errorf("homewizard: device certificate %s does not match pinned %s (remove %s to re-pair)",
+			fingerprint, c.state.CertSHA256, c.statePath)
+	}
+}
+
+// Pair requests a token from the device. It returns ErrPressButton until the
+// button on the device has been pressed (the device accepts pairing for 30 s after that).
+func (c *V2Client) Pair(ctx context.Context) error {
+	body, _ := json.Marshal(map[string]string{"name": c.userName})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL()+"/api/user", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create pair request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Version", v2APIVersion)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST /api/user: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusForbidden {
+		return ErrPressButton
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST /api/user: status %d: %s", resp.StatusCode, string(msg))
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode pair response: %w", err)
+	}
+	if result.Token == "" {
+		return errors.New("POST /api/user: empty token")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Host = c.host
+	c.state.Token = result.Token
+	return c.saveStateLocked()
+}
+
+// get performs an authenticated GET and decodes the JSON response into v.
+func (c *V2Client) get(ctx context.Context, path string, v any) error {
+	c.mu.Lock()
+	token := c.state.Token
+	c.mu.Unlock()
+	if token == "" {
+		return errors.New("homewizard v2 meter not paired yet; press the button on the device")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL()+path, nil)
+	if err != nil {
+		return fmt.Errorf("create GET %s request: %w", path, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Api-Version", v2APIVersion)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: status %d: %s", path, resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s response: %w", path, err)
+	}
+	return nil
+}
+
+// GetDeviceInfo fetches device info (connectivity and token check).
+func (c *V2Client) GetDeviceInfo() (*DeviceInfo, error) {
+	if !c.Enabled() {
+		return nil, fmt.Errorf("homewizard P1 meter not configured")
+	}
+	var info DeviceInfo
+	if err := c.get(context.Background(), "/api", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetMeasurement returns the latest pushed measurement, or polls /api/measurement
+// when the websocket is not delivering.
+func (c *V2Client) GetMeasurement(ctx context.Context) (*Measurement, error) {
+	if !c.Enabled() {
+		return nil, fmt.Errorf("homewizard P1 meter not configured")
+	}
+
+	c.mu.Lock()
+	if c.wsConnected && c.latest != nil && c.nowFunc().Sub(c.received) < v2StaleAfter {
+		m := *c.latest
+		c.mu.Unlock()
+		return &m, nil
+	}
+	c.mu.Unlock()
+
+	var m Measurement
+	if err := c.get(ctx, "/api/measurement", &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetActivePowerW returns the current active power in watts.
+// Positive values mean importing from grid, negative values mean exporting (solar surplus).
+func (c *V2Client) GetActivePowerW() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	m, err := c.GetMeasurement(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return m.PowerW, nil
+}
+
+// GetEnergyRegisters returns the meter's cumulative import and export
+// registers in kWh, summed over tariffs.
+func (c *V2Client) GetEnergyRegisters() (importKWh, exportKWh float64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	m, err := c.GetMeasurement(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return m.EnergyImportKWh, m.EnergyExportKWh, nil
+}
+
+// GetPhases returns the per-phase power, current and voltage.
+func (c *V2Client) GetPhases() ([]grid.Phase, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	m, err := c.GetMeasurement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m.phases(), nil
+}
+
+// phases returns L1 and every further phase the meter reports (fields of
+// phases that are not connected are omitted by the device).
+func (m *Measurement) phases() []grid.Phase {
+	all := []grid.Phase{
+		{PowerW: m.PowerL1W, CurrentA: m.CurrentL1A, VoltageV: m.VoltageL1V},
+		{PowerW: m.PowerL2W, CurrentA: m.CurrentL2A, VoltageV: m.VoltageL2V},
+		{PowerW: m.PowerL3W, CurrentA: m.CurrentL3A, VoltageV: m.VoltageL3V},
+	}
+	phases := all[:1]
+	for _, p := range all[1:] {
+		if p != (grid.Phase{}) {
+			phases = append(phases, p)
+		}
+	}
+	if len(phases) == 1 && phases[0] == (grid.Phase{}) {
+		phases[0].PowerW = m.PowerW
+	}
+	return phases
+}
+
+// Start pairs with the device if needed and subscribes to measurement pushes
+// in the background. It is a no-op if the client is disabled or already started.
+func (c *V2Client) Start() {
+	if !c.Enabled() {
+		return
+	}
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if c.stopRun != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopRun = cancel
+	c.runDone = make(chan struct{})
+	go func() {
+		defer close(c.runDone)
+		if err := c.pairUntilDone(ctx); err != nil {
+			return
+		}
+		c.runWebsocket(ctx)
+	}()
+}
+
+// Close stops the background subscription.
+func (c *V2Client) Close() error {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	if c.stopRun == nil {
+		return nil
+	}
+	c.stopRun()
+	<-c.runDone
+	c.stopRun = nil
+	return nil
+}
+
+// pairUntilDone retries pairing until a token is obtained or ctx is cancelled.
+func (c *V2Client) pairUntilDone(ctx context.Context) error {
+	warned := false
+	for !c.Paired() {
+		err := c.Pair(ctx)
+		switch {
+		case err == nil:
+			slog.Info("paired with HomeWizard device", "host", c.currentHost(), "state_file", c.statePath)
+			return nil
+		case errors.Is(err, ErrPressButton):
+			if !warned {
+				slog.Warn("HomeWizard v2 pairing: press the button on the P1 meter", "host", c.currentHost())
+				warned = true
+			}
+		default:
+			slog.Warn("HomeWizard v2 pairing failed", "host", c.currentHost(), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(v2PairInterval):
+		}
+	}
+	return nil
+}
+
+// runWebsocket keeps the measurement subscription open until ctx is cancelled.
+func (c *V2Client) runWebsocket(ctx context.Context) {
+	backoff := v2MinBackoff
+	for {
+		start := time.Now()
+		err := c.subscribe(ctx)
+		c.setWSConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > v2MaxBackoff {
+			backoff = v2MinBackoff
+		}
+		slog.Warn("HomeWizard websocket disconnected, falling back to polling", "error", err, "retry_in", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, v2MaxBackoff)
+	}
+}
+
+func (c *V2Client) setWSConnected(connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wsConnected = connected
+}
+
+// subscribe runs one websocket session: authorize, subscribe to measurements
+// and store every push until the connection fails or goes silent.
+func (c *V2Client) subscribe(ctx context.Context) error {
+	host := c.currentHost()
+	config, err := websocket.NewConfig("wss://"+host+"/api/ws", "https://"+host+"/")
+	if err != nil {
+		return fmt.Errorf("websocket config: %w", err)
+	}
+	config.TlsConfig = c.tlsConfig
+	dialCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	ws, err := config.DialContext(dialCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("dial websocket: %w", err)
+	}
+	defer ws.Close()
+	stopOnCancel := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stopOnCancel()
+
+	c.mu.Lock()
+	token := c.state.Token
+	c.mu.Unlock()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(v2StaleAfter))
+		var msg wsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return fmt.Errorf("read websocket: %w", err)
+		}
+
+		switch msg.Type {
+		case "authorization_requested":
+			if err := c.send(ws, wsMessage{Type: "authorization", Data: jsonString(token)}); err != nil {
+				return err
+			}
+		case "authorized":
+			if err := c.send(ws, wsMessage{Type: "subscribe", Data: jsonString("measurement")}); err != nil {
+				return err
+			}
+			slog.Info("HomeWizard websocket subscribed", "host", host)
+		case "measurement":
+			var m Measurement
+			if err := json.Unmarshal(msg.Data, &m); err != nil {
+				slog.Debug("ignoring malformed HomeWizard measurement", "error", err)
+				continue
+			}
+			c.mu.Lock()
+			c.latest = &m
+			c.received = c.nowFunc()
+			c.wsConnected = true
+			c.mu.Unlock()
+		case "error":
+			return fmt.Errorf("websocket error: %s", string(msg.Data))
+		}
+	}
+}
+
+func (c *V2Client) send(ws *websocket.Conn, msg wsMessage) error {
+	ws.SetWriteDeadline(time.Now().Add(v2WriteWindow))
+	if err := websocket.JSON.Send(ws, msg); err != nil {
+		return fmt.Errorf("send websocket %s: %w", msg.Type, err)
+	}
+	return nil
+}
+
+func jsonString(s string) json.RawMessage {
+	data, _ := json.Marshal(s)
+	return data
+}
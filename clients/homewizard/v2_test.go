@@ -0,0 +1,327 @@
+package homewizard
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// pinTrust trusts the test server's certificate on first use.
+var pinTrust = V2Trust{PinOnFirstUse: true}
+
+// newV2Server starts a fake HomeWizard v2 device. pressesNeeded pairing attempts
+// are rejected before a token is issued.
+func newV2Server(t *testing.T, pressesNeeded int32, ws websocket.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(newV2Mux(pressesNeeded, ws))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newV2Mux(pressesNeeded int32, ws websocket.Handler) *http.ServeMux {
+	var attempts atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Version") != "2" {
+			http.Error(w, "missing api version", http.StatusBadRequest)
+			return
+		}
+		if attempts.Add(1) <= pressesNeeded {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"user:press-button"}`))
+			return
+		}
+		w.Write([]byte(`{"token":"ABCDEF0123456789","name":"local/test"}`))
+	})
+	mux.HandleFunc("GET /api/measurement", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ABCDEF0123456789" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"power_w": 450, "power_l1_w": 150, "energy_import_kwh": 1234.5}`))
+	})
+	if ws != nil {
+		mux.Handle("/api/ws", ws)
+	}
+	return mux
+}
+
+// newDeviceServer starts a fake paired device whose certificate is issued by a
+// test CA to appliance/p1dongle/<serial>, like a real HomeWizard device.
+func newDeviceServer(t *testing.T, serial string) (*httptest.Server, *x509.CertPool) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test HomeWizard CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "appliance/p1dongle/" + serial},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(newV2Mux(0, nil))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return server, roots
+}
+
+func TestV2_VerifiesDeviceCertificateAgainstCA(t *testing.T) {
+	server, roots := newDeviceServer(t, "5c2fafabcdef")
+	tests := []struct {
+		name    string
+		trust   V2Trust
+		wantErr string
+	}{
+		{"issued to the device serial", V2Trust{RootCAs: roots, Serial: "5C2FAFABCDEF"}, ""},
+		{"issued to another serial", V2Trust{RootCAs: roots, Serial: "5c2faf000000"}, "not issued to serial"},
+		{"serial unknown", V2Trust{RootCAs: roots}, "serial unknown"},
+		{"other CA", V2Trust{RootCAs: x509.NewCertPool(), Serial: "5c2fafabcdef"}, "verify device certificate"},
+		{"no trust configured", V2Trust{}, "no CA configured"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewV2(server.URL, "", "energy-trader", tt.trust)
+			client.state.Token = "ABCDEF0123456789"
+			power, err := client.GetActivePowerW()
+			if tt.wantErr == "" {
+				if err != nil || power != 450 {
+					t.Errorf("GetActivePowerW() = %v, %v; want 450", power, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GetActivePowerW() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestV2_CAVerificationDoesNotPin(t *testing.T) {
+	server, roots := newDeviceServer(t, "5c2fafabcdef")
+	client := NewV2(server.URL, "", "energy-trader", V2Trust{RootCAs: roots, Serial: "5c2fafabcdef", PinOnFirstUse: true})
+	client.state.Token = "ABCDEF0123456789"
+	if _, err := client.GetActivePowerW(); err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	if client.state.CertSHA256 != "" {
+		t.Errorf("certificate pinned as %s, want the CA to be used instead", client.state.CertSHA256)
+	}
+}
+
+func TestLoadCAFile(t *testing.T) {
+	server, _ := newDeviceServer(t, "5c2fafabcdef")
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	cert := server.Certificate()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCAFile(path); err != nil {
+		t.Errorf("LoadCAFile() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCAFile(path); err == nil {
+		t.Error("LoadCAFile() error = nil for a file without certificates")
+	}
+}
+
+func TestV2_PairPersistsTokenAndPin(t *testing.T) {
+	server := newV2Server(t, 1, nil)
+	dir := t.TempDir()
+	client := NewV2(server.URL, dir, "energy-trader", pinTrust)
+
+	if err := client.Pair(context.Background()); !errors.Is(err, ErrPressButton) {
+		t.Fatalf("first Pair() error = %v, want ErrPressButton", err)
+	}
+	if err := client.Pair(context.Background()); err != nil {
+		t.Fatalf("second Pair() error = %v", err)
+	}
+
+	reloaded := NewV2(server.URL, dir, "energy-trader", pinTrust)
+	if err := reloaded.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if !reloaded.Paired() {
+		t.Fatal("Paired() = false after reload, want persisted token")
+	}
+	if reloaded.state.CertSHA256 == "" {
+		t.Error("certificate pin not persisted")
+	}
+	power, err := reloaded.GetActivePowerW()
+	if err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	if power != 450 {
+		t.Errorf("GetActivePowerW() = %v, want 450", power)
+	}
+}
+
+func TestV2_RejectsChangedCertificate(t *testing.T) {
+	server := newV2Server(t, 0, nil)
+	dir := t.TempDir()
+	state := `{"host":"` + strings.TrimPrefix(server.URL, "https://") + `","token":"ABCDEF0123456789","cert_sha256":"00ff"}`
+	if err := os.WriteFile(filepath.Join(dir, v2StateFile), []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewV2(server.URL, dir, "energy-trader", pinTrust)
+	if err := client.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	_, err := client.GetActivePowerW()
+	if err == nil || !strings.Contains(err.Error(), "does not match pinned") {
+		t.Errorf("GetActivePowerW() error = %v, want pin mismatch", err)
+	}
+}
+
+func TestV2_NotPaired(t *testing.T) {
+	server := newV2Server(t, 0, nil)
+	client := NewV2(server.URL, "", "energy-trader", pinTrust)
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want not paired error")
+	}
+}
+
+func TestV2_WebsocketPush(t *testing.T) {
+	var authorized atomic.Bool
+	ws := websocket.Handler(func(conn *websocket.Conn) {
+		websocket.JSON.Send(conn, wsMessage{Type: "authorization_requested", Data: jsonString("2.0.0")})
+		var msg wsMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil || msg.Type != "authorization" || string(msg.Data) != `"ABCDEF0123456789"` {
+			websocket.JSON.Send(conn, wsMessage{Type: "error", Data: jsonString("user:unauthorized")})
+			return
+		}
+		authorized.Store(true)
+		websocket.JSON.Send(conn, wsMessage{Type: "authorized"})
+		if err := websocket.JSON.Receive(conn, &msg); err != nil || msg.Type != "subscribe" {
+			return
+		}
+		data, _ := json.Marshal(Measurement{PowerW: -1200, PowerL2W: -1200})
+		websocket.JSON.Send(conn, wsMessage{Type: "measurement", Data: data})
+		// Keep the connection open until the client closes it.
+		conn.Read(make([]byte, 1))
+	})
+	server := newV2Server(t, 0, ws)
+
+	client := NewV2(server.URL, "", "energy-trader", pinTrust)
+	client.state.Token = "ABCDEF0123456789"
+	client.Start()
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.mu.Lock()
+		pushed := client.wsConnected
+		client.mu.Unlock()
+		if pushed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no measurement pushed (authorized=%v)", authorized.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The pushed value wins over the polled one (450 W).
+	power, err := client.GetActivePowerW()
+	if err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	if power != -1200 {
+		t.Errorf("GetActivePowerW() = %v, want -1200 from websocket push", power)
+	}
+
+	// Stale pushes fall back to polling.
+	client.mu.Lock()
+	client.nowFunc = func() time.Time { return time.Now().Add(time.Minute) }
+	client.mu.Unlock()
+	if power, err := client.GetActivePowerW(); err != nil || power != 450 {
+		t.Errorf("GetActivePowerW() = %v, %v; want 450 from polling", power, err)
+	}
+}
+
+func TestV2_Disabled(t *testing.T) {
+	client := NewV2("", "", "energy-trader", pinTrust)
+	if client.Enabled() {
+		t.Error("Enabled() = true, want false for empty URL")
+	}
+	client.Start()
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestV2_SetURLKeepsPairing(t *testing.T) {
+	dir := t.TempDir()
+	c := NewV2("http://10.0.0.12", dir, "energy-trader", pinTrust)
+	c.mu.Lock()
+	c.state = v2State{Host: "10.0.0.12", Token: "token", CertSHA256: "pin"}
+	c.mu.Unlock()
+
+	c.SetURL("http://10.0.0.57/")
+	if c.URL() != "https://10.0.0.57" {
+		t.Errorf("URL() = %q, want https://10.0.0.57", c.URL())
+	}
+
+	reloaded := NewV2("10.0.0.57", dir, "energy-trader", pinTrust)
+	if err := reloaded.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.state != (v2State{Host: "10.0.0.57", Token: "token", CertSHA256: "pin"}) {
+		t.Errorf("persisted state = %+v, want the new host with token and pin kept", reloaded.state)
+	}
+}
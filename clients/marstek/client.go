@@ -13,6 +13,10 @@ import (
 const (
 	defaultPort    = 30000
 	defaultTimeout = 5 * time.Second
+
+	// activePowerThresholdW is the battery power below which the battery is
+	// treated as not moving energy, as in the trading service.
+	activePowerThresholdW = 50.0
 )
 
 // Client is a Marstek battery UDP client.
@@ -21,6 +25,14 @@ type Client struct {
 	conn      *net.UDPConn
 	requestID atomic.Int64
 	mu        sync.Mutex // protects UDP operations
+
+	// waiting counts callers blocked in lockContext. Background polls
+	// (sendBackground) only take the socket while it is zero.
+	waiting atomic.Int32
+
+	// passivePower is the power of the last accepted passive mode command
+	// (positive discharges), 0 after switching back to auto mode.
+	passivePower atomic.Int64
 }
 
 // New creates a new Marstek client.
@@ -31,8 +43,12 @@ func New(addr string) *Client {
 }
 
 // Connect establishes the UDP connection.
-// The client must bind to port 30000 as the source port.
+// The client must bind to port 30000 as the source port. Connecting a client
+// that is already connected (e.g. shared with the meter readers) is a no-op.
 func (c *Client) Connect() error {
+	if c.conn != nil {
+		return nil
+	}
 	// Parse the target address
 	raddr, err := net.ResolveUDPAddr("udp", c.addr)
 	if err != nil {
@@ -126,6 +142,23 @@ type ESMode struct {
 	BatterySOC   int     `json:"bat_soc"`       // Battery SOC (%)
 }
 
+// ControlDirection is the direction the battery is being forced in by an external controller.
+type ControlDirection string
+
+const (
+	ControlIdle      ControlDirection = "idle" // not under forced control
+	ControlCharge    ControlDirection = "charge"
+	ControlDischarge ControlDirection = "discharge"
+)
+
+// ControlStatus describes how the battery is actually being controlled right now,
+// independent of what the trader last commanded.
+type ControlStatus struct {
+	Mode         string           // backend-reported mode, e.g. "Passive" or "enable/charge"
+	Direction    ControlDirection // forced direction, ControlIdle when the battery runs on its own
+	BatteryPower float64          // measured battery power (W): positive charging, negative discharging
+}
+
 // send sends a request and waits for response with matching ID.
 func (c *Client) send(method string, params interface{}) (*response, error) {
 	return c.sendContext(context.Background(), method, params)
@@ -136,7 +169,21 @@ func (c *Client) sendContext(ctx context.Context, method string, params interfac
 		return nil, err
 	}
 	defer c.mu.Unlock()
+	return c.exchangeLocked(ctx, method, params)
+}
+
+// sendBackground is sendContext for low-priority polling (such as meter reads):
+// it yields the socket to any control or status call that is waiting for it.
+func (c *Client) sendBackground(ctx context.Context, method string, params interface{}) (*response, error) {
+	if err := c.lockBackground(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	return c.exchangeLocked(ctx, method, params)
+}
 
+// exchangeLocked sends one request and waits for its response. Caller must hold c.mu.
+func (c *Client) exchangeLocked(ctx context.Context, method string, params interface{}) (*response, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
@@ -224,10 +271,29 @@ func (c *Client) sendContext(ctx context.Context, method string, params interfac
 }
 
 func (c *Client) lockContext(ctx context.Context) error {
+	if c.mu.TryLock() {
+		return nil
+	}
+	c.waiting.Add(1)
+	defer c.waiting.Add(-1)
 	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
 		if c.mu.TryLock() {
 			return nil
 		}
+	}
+}
+
+// lockBackground acquires c.mu only when no lockContext caller is waiting.
+func (c *Client) lockBackground(ctx context.Context) error {
+	for {
+		if c.waiting.Load() == 0 && c.mu.TryLock() {
+			return nil
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -317,6 +383,16 @@ func (c *Client) GetESStatus(ctx context.Context) (*ESStatus, error) {
 	return &status, nil
 }
 
+// GetEnergyCounters returns the lifetime grid input (charge) and output
+// (discharge) energy counters in Wh.
+func (c *Client) GetEnergyCounters(ctx context.Context) (chargedWh, dischargedWh float64, err error) {
+	status, err := c.GetESStatus(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return status.TotalGridInputEnergy, status.TotalGridOutputEnergy, nil
+}
+
 // GetBatteryPower returns the signed battery power from energy-system status.
 func (c *Client) GetBatteryPower(ctx context.Context) (float64, error) {
 	status, err := c.GetESStatus(ctx)
@@ -328,8 +404,13 @@ func (c *Client) GetBatteryPower(ctx context.Context) (float64, error) {
 
 // GetESMode gets the current operating mode.
 func (c *Client) GetESMode() (*ESMode, error) {
+	return c.GetESModeContext(context.Background())
+}
+
+// GetESModeContext gets the current operating mode with cancellation support.
+func (c *Client) GetESModeContext(ctx context.Context) (*ESMode, error) {
 	params := map[string]int{"id": 0}
-	resp, err := c.send("ES.GetMode", params)
+	resp, err := c.sendContext(ctx, "ES.GetMode", params)
 	if err != nil {
 		return nil, err
 	}
@@ -342,6 +423,37 @@ func (c *Client) GetESMode() (*ESMode, error) {
 	return &mode, nil
 }
 
+// GetControlStatus reports the actual operating mode. Passive mode is forced
+// control; its direction follows the measured battery power. Below
+// activePowerThresholdW (a session tapering off, sensor noise) the direction
+// of the last passive command is reported instead.
+func (c *Client) GetControlStatus(ctx context.Context) (*ControlStatus, error) {
+	mode, err := c.GetESModeContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get mode: %w", err)
+	}
+	power, err := c.GetBatteryPower(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get battery power: %w", err)
+	}
+
+	status := &ControlStatus{Mode: mode.Mode, Direction: ControlIdle, BatteryPower: power}
+	if mode.Mode == "Passive" {
+		commanded := c.passivePower.Load()
+		switch {
+		case power >= activePowerThresholdW:
+			status.Direction = ControlCharge
+		case power <= -activePowerThresholdW:
+			status.Direction = ControlDischarge
+		case commanded < 0:
+			status.Direction = ControlCharge
+		case commanded > 0:
+			status.Direction = ControlDischarge
+		}
+	}
+	return status, nil
+}
+
 // SetPassiveMode sets the battery to passive mode with specified power.
 // Positive power = discharge, negative power = charge.
 // cdTime is the countdown in seconds before reverting to previous mode.
@@ -379,6 +491,7 @@ func (c *Client) SetPassiveModeContext(ctx context.Context, power int, cdTime in
 		return fmt.Errorf("set mode failed")
 	}
 
+	c.passivePower.Store(int64(power))
 	return nil
 }
 
@@ -416,6 +529,7 @@ func (c *Client) SetAutoModeContext(ctx context.Context) error {
 		return fmt.Errorf("set mode failed")
 	}
 
+	c.passivePower.Store(0)
 	return nil
 }
 
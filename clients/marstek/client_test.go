@@ -3,6 +3,7 @@ package marstek
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -31,3 +32,47 @@ func TestSendContext_CancelledBeforeLock(t *testing.T) {
 		t.Fatalf("sendContext() error = %v, want context canceled", err)
 	}
 }
+
+func TestGetControlStatus_PassiveDeadband(t *testing.T) {
+	var batteryPower atomic.Int64
+	client, _ := fakeDevice(t, func(method string) any {
+		switch method {
+		case "ES.GetMode":
+			return ESMode{Mode: "Passive"}
+		case "ES.GetStatus":
+			return ESStatus{BatteryPower: float64(batteryPower.Load())}
+		case "ES.SetMode":
+			return map[string]any{"id": 0, "set_result": true}
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		command func() error
+		power   int64
+		want    ControlDirection
+	}{
+		{"charging above threshold", func() error { return client.SetPassiveModeContext(ctx, -800, 60) }, 790, ControlCharge},
+		{"discharging above threshold", func() error { return client.SetPassiveModeContext(ctx, 800, 60) }, -790, ControlDischarge},
+		{"noise while charge commanded", func() error { return client.SetPassiveModeContext(ctx, -800, 60) }, -12, ControlCharge},
+		{"noise while discharge commanded", func() error { return client.SetPassiveModeContext(ctx, 800, 60) }, 12, ControlDischarge},
+		{"noise after auto mode", func() error { return client.SetAutoModeContext(ctx) }, 12, ControlIdle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.command(); err != nil {
+				t.Fatal(err)
+			}
+			batteryPower.Store(tt.power)
+			status, err := client.GetControlStatus(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Direction != tt.want {
+				t.Errorf("Direction = %v, want %v", status.Direction, tt.want)
+			}
+		})
+	}
+}
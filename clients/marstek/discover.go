@@ -0,0 +1,103 @@
+package marstek
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// broadcastWait is how long Broadcast collects replies when ctx has no deadline.
+const broadcastWait = 3 * time.Second
+
+// Broadcast sends Marstek.GetDevice to every address (host:port, usually a
+// subnet broadcast address on port 30000) and returns each device that replies
+// before ctx is done. It binds local port 30000, as the protocol requires, so it
+// fails while another client (such as a running trader) holds the port.
+func Broadcast(ctx context.Context, addrs []string) ([]DeviceInfo, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: defaultPort})
+	if err != nil {
+		return nil, fmt.Errorf("bind to port %d: %w", defaultPort, err)
+	}
+	defer conn.Close()
+	return collectDevices(ctx, conn, addrs)
+}
+
+// collectDevices sends one Marstek.GetDevice request to each address on conn
+// and gathers the replies until ctx is done or broadcastWait has passed.
+func collectDevices(ctx context.Context, conn *net.UDPConn, addrs []string) ([]DeviceInfo, error) {
+	id := time.Now().UnixMilli()
+	data, err := json.Marshal(request{ID: id, Method: "Marstek.GetDevice", Params: map[string]string{"ble_mac": "0"}})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	var sent int
+	var sendErr error
+	for _, addr := range addrs {
+		raddr, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			sendErr = fmt.Errorf("resolve address %s: %w", addr, err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(data, raddr); err != nil {
+			sendErr = fmt.Errorf("send to %s: %w", addr, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		if sendErr == nil {
+			sendErr = errors.New("no broadcast addresses")
+		}
+		return nil, sendErr
+	}
+
+	deadline := time.Now().Add(broadcastWait)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("set read deadline: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var devices []DeviceInfo
+	seen := make(map[string]bool)
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends the collection window.
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return devices, nil
+			}
+			return devices, fmt.Errorf("read response: %w", err)
+		}
+
+		var resp response
+		if err := json.Unmarshal(buf[:n], &resp); err != nil || resp.ID != id || resp.Src == "" || resp.Error != nil {
+			continue // other traffic, our own echoed broadcast, or an error reply
+		}
+		var info DeviceInfo
+		if err := json.Unmarshal(resp.Result, &info); err != nil {
+			continue
+		}
+		if info.IP == "" {
+			info.IP = from.IP.String()
+		}
+		key := info.WiFiMAC
+		if key == "" {
+			key = info.IP
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		devices = append(devices, info)
+	}
+}
@@ -0,0 +1,54 @@
+package marstek
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCollectDevices(t *testing.T) {
+	device, requests := fakeDevice(t, func(method string) any {
+		if method != "Marstek.GetDevice" {
+			return nil
+		}
+		return DeviceInfo{Device: "VenusE", Version: 155, WiFiMAC: "aabbccddeeff", WiFiName: "home"}
+	})
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	// The same device reached twice is reported once.
+	devices, err := collectDevices(ctx, conn, []string{device.addr, device.addr})
+	if err != nil {
+		t.Fatalf("collectDevices() error = %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("got %d devices, want 1: %+v", len(devices), devices)
+	}
+	if devices[0].Device != "VenusE" || devices[0].WiFiMAC != "aabbccddeeff" {
+		t.Errorf("device = %+v", devices[0])
+	}
+	if devices[0].IP != "127.0.0.1" {
+		t.Errorf("IP = %q, want the reply's source address 127.0.0.1", devices[0].IP)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestCollectDevices_NoAddresses(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := collectDevices(context.Background(), conn, nil); err == nil {
+		t.Error("collectDevices() error = nil, want error without addresses")
+	}
+}
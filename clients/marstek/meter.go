@@ -0,0 +1,133 @@
+package marstek
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+const (
+	// Meter reads use a short timeout so a slow or lost reply never holds the
+	// shared socket for the full defaultTimeout while a control call is waiting.
+	meterTimeout = time.Second
+	// A reading younger than meterCacheTTL is served without another request.
+	meterCacheTTL = 2 * time.Second
+	// When a read fails, a reading younger than meterStaleAfter is still served.
+	meterStaleAfter = 15 * time.Second
+)
+
+// EMStatus contains the built-in CT meter readings (EM.GetStatus).
+// Powers are measured at the grid connection: positive imports, negative exports.
+type EMStatus struct {
+	ID         int     `json:"id"`
+	CTState    int     `json:"ct_state"` // 0 = not connected, 1 = connected
+	APower     float64 `json:"a_power"`
+	BPower     float64 `json:"b_power"`
+	CPower     float64 `json:"c_power"`
+	TotalPower float64 `json:"total_power"`
+}
+
+// GetEMStatus gets the CT meter status. It is a background poll: it waits for
+// the socket only while no control or status call is queued for it.
+func (c *Client) GetEMStatus(ctx context.Context) (*EMStatus, error) {
+	params := map[string]int{"id": 0}
+	resp, err := c.sendBackground(ctx, "EM.GetStatus", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var status EMStatus
+	if err := json.Unmarshal(resp.Result, &status); err != nil {
+		return nil, fmt.Errorf("unmarshal EM status: %w", err)
+	}
+
+	return &status, nil
+}
+
+// Meter reads grid power from the battery's Marstek CT kit. It shares the
+// Client's UDP socket with battery control and implements the service
+// MeterReader interface.
+type Meter struct {
+	client *Client
+
+	mu      sync.Mutex
+	last    *EMStatus
+	fetched time.Time
+	nowFunc func() time.Time
+}
+
+// NewMeter creates a CT meter reader on top of an existing (connected) client.
+func NewMeter(client *Client) *Meter {
+	return &Meter{
+		client:  client,
+		nowFunc: time.Now,
+	}
+}
+
+// Enabled returns true if the battery address is configured.
+func (m *Meter) Enabled() bool {
+	return m.client != nil && m.client.addr != ""
+}
+
+// GetStatus returns the latest CT readings. Readings are cached briefly to keep
+// socket traffic low, and a recent reading is served when the socket is busy.
+func (m *Meter) GetStatus() (*EMStatus, error) {
+	if !m.Enabled() {
+		return nil, errors.New("marstek CT meter not configured")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.nowFunc()
+	if m.last != nil && now.Sub(m.fetched) < meterCacheTTL {
+		status := *m.last
+		return &status, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), meterTimeout)
+	defer cancel()
+	status, err := m.client.GetEMStatus(ctx)
+	if err == nil && status.CTState != 1 {
+		err = errors.New("marstek CT not connected")
+	}
+	if err != nil {
+		if m.last != nil && now.Sub(m.fetched) < meterStaleAfter {
+			slog.Debug("marstek CT read failed, serving last reading", "error", err, "age", now.Sub(m.fetched))
+			status := *m.last
+			return &status, nil
+		}
+		return nil, err
+	}
+
+	m.last = status
+	m.fetched = now
+	result := *status
+	return &result, nil
+}
+
+// GetActivePowerW returns the current net grid power in watts.
+// Positive values mean importing from grid, negative values mean exporting (solar surplus).
+func (m *Meter) GetActivePowerW() (float64, error) {
+	status, err := m.GetStatus()
+	if err != nil {
+		return 0, err
+	}
+	return status.TotalPower, nil
+}
+
+// GetPhases returns the per-phase CT power. The CT kit does not report current
+// or voltage.
+func (m *Meter) GetPhases() ([]grid.Phase, error) {
+	status, err := m.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	return []grid.Phase{{PowerW: status.APower}, {PowerW: status.BPower}, {PowerW: status.CPower}}, nil
+}
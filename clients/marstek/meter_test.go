@@ -0,0 +1,195 @@
+package marstek
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDevice answers JSON-RPC requests over UDP on localhost with reply(method).
+// A nil result from reply is sent as an RPC error.
+func fakeDevice(t *testing.T, reply func(method string) any) (*Client, *atomic.Int32) {
+	t.Helper()
+	device, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { device.Close() })
+
+	var requests atomic.Int32
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, from, err := device.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req request
+			if err := json.Unmarshal(buf[:n], &req); err != nil {
+				continue
+			}
+			requests.Add(1)
+			resp := map[string]any{"id": req.ID, "src": "VenusE-test"}
+			if result := reply(req.Method); result != nil {
+				resp["result"] = result
+			} else {
+				resp["error"] = rpcError{Code: -32603, Message: "internal error"}
+			}
+			data, _ := json.Marshal(resp)
+			device.WriteToUDP(data, from)
+		}
+	}()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := New(device.LocalAddr().String())
+	client.conn = conn
+	return client, &requests
+}
+
+func TestMeter_ReadsAndCachesCTPower(t *testing.T) {
+	client, requests := fakeDevice(t, func(method string) any {
+		if method != "EM.GetStatus" {
+			return nil
+		}
+		return EMStatus{CTState: 1, APower: -900, BPower: 50, CPower: 0, TotalPower: -850}
+	})
+	meter := NewMeter(client)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	meter.nowFunc = func() time.Time { return now }
+
+	power, err := meter.GetActivePowerW()
+	if err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	if power != -850 {
+		t.Errorf("GetActivePowerW() = %v, want -850", power)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := meter.GetActivePowerW(); err != nil {
+		t.Fatalf("cached GetActivePowerW() error = %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("device received %d requests, want 1 (second read cached)", got)
+	}
+}
+
+func TestMeter_CTNotConnected(t *testing.T) {
+	client, _ := fakeDevice(t, func(string) any { return EMStatus{CTState: 0} })
+	if _, err := NewMeter(client).GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error when CT is not connected")
+	}
+}
+
+func TestMeter_ServesRecentReadingOnFailure(t *testing.T) {
+	var failing atomic.Bool
+	client, _ := fakeDevice(t, func(string) any {
+		if failing.Load() {
+			return nil
+		}
+		return EMStatus{CTState: 1, TotalPower: 300}
+	})
+	meter := NewMeter(client)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	meter.nowFunc = func() time.Time { return now }
+
+	if _, err := meter.GetActivePowerW(); err != nil {
+		t.Fatalf("GetActivePowerW() error = %v", err)
+	}
+	failing.Store(true)
+
+	now = now.Add(5 * time.Second)
+	if power, err := meter.GetActivePowerW(); err != nil || power != 300 {
+		t.Errorf("GetActivePowerW() = %v, %v; want last reading 300", power, err)
+	}
+	now = now.Add(20 * time.Second)
+	if _, err := meter.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error once the last reading is stale")
+	}
+}
+
+func TestMeter_Disabled(t *testing.T) {
+	if NewMeter(New("")).Enabled() {
+		t.Error("Enabled() = true, want false without address")
+	}
+	if _, err := NewMeter(nil).GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error when disabled")
+	}
+}
+
+func TestLockBackground_YieldsToWaitingControlCall(t *testing.T) {
+	client := New("192.0.2.1:30000")
+	client.mu.Lock()
+
+	var (
+		orderMu sync.Mutex
+		order   []string
+		wg      sync.WaitGroup
+	)
+	record := func(name string) {
+		orderMu.Lock()
+		order = append(order, name)
+		orderMu.Unlock()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := client.lockContext(context.Background()); err != nil {
+			t.Errorf("lockContext() error = %v", err)
+			return
+		}
+		record("control")
+		time.Sleep(30 * time.Millisecond)
+		client.mu.Unlock()
+	}()
+	for client.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := client.lockBackground(context.Background()); err != nil {
+			t.Errorf("lockBackground() error = %v", err)
+			return
+		}
+		record("background")
+		client.mu.Unlock()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	client.mu.Unlock()
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != "control" {
+		t.Errorf("lock order = %v, want control before background", order)
+	}
+}
+
+func TestPVMeter_ReadsSolarPower(t *testing.T) {
+	client, _ := fakeDevice(t, func(method string) any {
+		if method != "PV.GetStatus" {
+			return nil
+		}
+		return PVStatus{PVPower: 1450, PVVoltage: 38.2, PVCurrent: 38}
+	})
+	power, err := NewPVMeter(client).GetPVPowerW()
+	if err != nil {
+		t.Fatalf("GetPVPowerW() error = %v", err)
+	}
+	if power != 1450 {
+		t.Errorf("GetPVPowerW() = %v, want 1450", power)
+	}
+	if _, err := NewPVMeter(nil).GetPVPowerW(); err == nil {
+		t.Error("GetPVPowerW() error = nil, want error when disabled")
+	}
+}
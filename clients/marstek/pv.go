@@ -0,0 +1,64 @@
+package marstek
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PVStatus contains the battery's solar input readings (PV.GetStatus), on
+// models with built-in MPPT inputs such as the Venus D.
+type PVStatus struct {
+	ID        int     `json:"id"`
+	PVPower   float64 `json:"pv_power"`   // Solar power (W)
+	PVVoltage float64 `json:"pv_voltage"` // Solar voltage (V)
+	PVCurrent float64 `json:"pv_current"` // Solar current (A)
+}
+
+// GetPVStatus gets the solar input status. Like GetEMStatus it is a background
+// poll that yields the socket to control and status calls.
+func (c *Client) GetPVStatus(ctx context.Context) (*PVStatus, error) {
+	params := map[string]int{"id": 0}
+	resp, err := c.sendBackground(ctx, "PV.GetStatus", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var status PVStatus
+	if err := json.Unmarshal(resp.Result, &status); err != nil {
+		return nil, fmt.Errorf("unmarshal PV status: %w", err)
+	}
+
+	return &status, nil
+}
+
+// PVMeter reads solar production from the battery's PV inputs. It shares the
+// Client's UDP socket and implements the service PVReader interface.
+type PVMeter struct {
+	client *Client
+}
+
+// NewPVMeter creates a PV reader on top of an existing (connected) client.
+func NewPVMeter(client *Client) *PVMeter {
+	return &PVMeter{client: client}
+}
+
+// Enabled returns true if the battery address is configured.
+func (m *PVMeter) Enabled() bool {
+	return m.client != nil && m.client.addr != ""
+}
+
+// GetPVPowerW returns the current solar production in watts.
+func (m *PVMeter) GetPVPowerW() (float64, error) {
+	if !m.Enabled() {
+		return 0, errors.New("marstek PV input not configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), meterTimeout)
+	defer cancel()
+	status, err := m.client.GetPVStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return max(status.PVPower, 0), nil
+}
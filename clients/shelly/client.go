@@ -0,0 +1,211 @@
+// Package shelly reads grid power from Shelly Pro 3EM and Shelly EM energy
+// meters over their local Gen2+ RPC HTTP API.
+package shelly
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// errNoHandler is returned by rpc when the device does not implement a method,
+// which is how a single-meter (EM1) device answers EM.GetStatus.
+var errNoHandler = errors.New("shelly: RPC method not supported")
+
+// DeviceInfo contains basic Shelly device information (Shelly.GetDeviceInfo).
+type DeviceInfo struct {
+	Name     string `json:"name"`
+	ID       string `json:"id"`
+	MAC      string `json:"mac"`
+	Model    string `json:"model"`
+	Gen      int    `json:"gen"`
+	Firmware string `json:"ver"`
+	App      string `json:"app"`
+	Profile  string `json:"profile"`
+}
+
+// Phase holds the readings of one phase (Pro 3EM) or one channel (EM1).
+type Phase struct {
+	ActivePowerW float64
+	CurrentA     float64
+	VoltageV     float64
+}
+
+// Status is a combined meter reading.
+type Status struct {
+	ActivePowerW float64 // Sum over phases: positive imports, negative exports
+	Phases       []Phase
+}
+
+// emStatus is the EM.GetStatus response of a three-phase meter (Pro 3EM "triphase" profile).
+type emStatus struct {
+	ACurrent      float64 `json:"a_current"`
+	AVoltage      float64 `json:"a_voltage"`
+	AActPower     float64 `json:"a_act_power"`
+	BCurrent      float64 `json:"b_current"`
+	BVoltage      float64 `json:"b_voltage"`
+	BActPower     float64 `json:"b_act_power"`
+	CCurrent      float64 `json:"c_current"`
+	CVoltage      float64 `json:"c_voltage"`
+	CActPower     float64 `json:"c_act_power"`
+	TotalActPower float64 `json:"total_act_power"`
+}
+
+// em1Status is the EM1.GetStatus response of a single channel (Shelly EM, Pro EM,
+// or a Pro 3EM in "monophase" profile).
+type em1Status struct {
+	Current  float64 `json:"current"`
+	Voltage  float64 `json:"voltage"`
+	ActPower float64 `json:"act_power"`
+}
+
+// Client is a Shelly energy meter RPC client. It implements the service MeterReader interface.
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	enabled     bool
+	em1Channels []int
+
+	mu         sync.Mutex
+	singlePath bool // device answered EM.GetStatus with "no handler"; use EM1 channels
+}
+
+// New creates a new Shelly meter client. em1Channels selects the EM1 channel ids
+// that measure the grid connection on single-meter devices (default: channel 0);
+// three-phase devices always report all phases.
+// If baseURL is empty, the client is disabled and all methods return gracefully.
+func New(baseURL string, em1Channels []int) *Client {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if len(em1Channels) == 0 {
+		em1Channels = []int{0}
+	}
+	return &Client{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: defaultTimeout,
+		},
+		enabled:     baseURL != "",
+		em1Channels: em1Channels,
+	}
+}
+
+// Enabled returns true if the meter is configured.
+func (c *Client) Enabled() bool {
+	return c.enabled
+}
+
+// GetDeviceInfo fetches device info (connectivity check).
+func (c *Client) GetDeviceInfo() (*DeviceInfo, error) {
+	if !c.enabled {
+		return nil, fmt.Errorf("shelly meter not configured")
+	}
+	var info DeviceInfo
+	if err := c.rpc("Shelly.GetDeviceInfo", "", &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetStatus returns total and per-phase active power. Three-phase devices are
+// read with EM.GetStatus; devices without an EM component fall back to
+// EM1.GetStatus on the configured channels.
+func (c *Client) GetStatus() (*Status, error) {
+	if !c.enabled {
+		return nil, fmt.Errorf("shelly meter not configured")
+	}
+
+	c.mu.Lock()
+	single := c.singlePath
+	c.mu.Unlock()
+
+	if !single {
+		var em emStatus
+		err := c.rpc("EM.GetStatus", "id=0", &em)
+		if err == nil {
+			return &Status{
+				ActivePowerW: em.TotalActPower,
+				Phases: []Phase{
+					{ActivePowerW: em.AActPower, CurrentA: em.ACurrent, VoltageV: em.AVoltage},
+					{ActivePowerW: em.BActPower, CurrentA: em.BCurrent, VoltageV: em.BVoltage},
+					{ActivePowerW: em.CActPower, CurrentA: em.CCurrent, VoltageV: em.CVoltage},
+				},
+			}, nil
+		}
+		if !errors.Is(err, errNoHandler) {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.singlePath = true
+		c.mu.Unlock()
+	}
+
+	status := &Status{}
+	for _, id := range c.em1Channels {
+		var em1 em1Status
+		if err := c.rpc("EM1.GetStatus", fmt.Sprintf("id=%d", id), &em1); err != nil {
+			return nil, err
+		}
+		status.ActivePowerW += em1.ActPower
+		status.Phases = append(status.Phases, Phase{ActivePowerW: em1.ActPower, CurrentA: em1.Current, VoltageV: em1.Voltage})
+	}
+	return status, nil
+}
+
+// GetActivePowerW returns the current net active power in watts.
+// Positive values mean importing from grid, negative values mean exporting (solar surplus).
+func (c *Client) GetActivePowerW() (float64, error) {
+	status, err := c.GetStatus()
+	if err != nil {
+		return 0, err
+	}
+	return status.ActivePowerW, nil
+}
+
+// GetPhases returns the per-phase (or per-channel) power, current and voltage.
+func (c *Client) GetPhases() ([]grid.Phase, error) {
+	status, err := c.GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	phases := make([]grid.Phase, len(status.Phases))
+	for i, p := range status.Phases {
+		phases[i] = grid.Phase{PowerW: p.ActivePowerW, CurrentA: p.CurrentA, VoltageV: p.VoltageV}
+	}
+	return phases, nil
+}
+
+// rpc calls GET /rpc/<method>?<query> and decodes the JSON result into v.
+func (c *Client) rpc(method, query string, v any) error {
+	url := c.baseURL + "/rpc/" + method
+	if query != "" {
+		url += "?" + query
+	}
+
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", method, errNoHandler)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: status %d: %s", method, resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	return nil
+}
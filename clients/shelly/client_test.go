@@ -0,0 +1,148 @@
+package shelly
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestGetStatus_Pro3EM(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rpc/EM.GetStatus" && r.URL.Query().Get("id") == "0" {
+			w.Write([]byte(`{"id":0,"a_current":2.1,"a_voltage":231.2,"a_act_power":480.5,
+				"b_current":0.5,"b_voltage":229.8,"b_act_power":-120.0,
+				"c_current":1.0,"c_voltage":230.1,"c_act_power":200.0,"total_act_power":560.5}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	client := New(server.URL, nil)
+	status, err := client.GetStatus()
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if status.ActivePowerW != 560.5 {
+		t.Errorf("ActivePowerW = %v, want 560.5", status.ActivePowerW)
+	}
+	if len(status.Phases) != 3 {
+		t.Fatalf("got %d phases, want 3", len(status.Phases))
+	}
+	if status.Phases[1].ActivePowerW != -120 || status.Phases[0].VoltageV != 231.2 {
+		t.Errorf("phases = %+v", status.Phases)
+	}
+}
+
+func TestGetStatus_EM1FallbackSumsChannels(t *testing.T) {
+	var emCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rpc/EM.GetStatus":
+			emCalls++
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":404,"message":"No handler for EM.GetStatus"}`))
+		case "/rpc/EM1.GetStatus":
+			switch r.URL.Query().Get("id") {
+			case "0":
+				w.Write([]byte(`{"id":0,"current":3.2,"voltage":230.0,"act_power":-700.0}`))
+			case "1":
+				w.Write([]byte(`{"id":1,"current":1.0,"voltage":230.0,"act_power":150.0}`))
+			default:
+				http.Error(w, `{"code":-105,"message":"Argument 'id', value 2 not found!"}`, http.StatusInternalServerError)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := New(server.URL, []int{0, 1})
+	for range 2 {
+		power, err := client.GetActivePowerW()
+		if err != nil {
+			t.Fatalf("GetActivePowerW() error = %v", err)
+		}
+		if power != -550 {
+			t.Errorf("GetActivePowerW() = %v, want -550", power)
+		}
+	}
+	if emCalls != 1 {
+		t.Errorf("EM.GetStatus called %d times, want 1 (remembered as unsupported)", emCalls)
+	}
+
+	missing := New(server.URL, []int{2})
+	if _, err := missing.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error for missing channel")
+	}
+}
+
+func TestGetStatus_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := New(server.URL, nil)
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error for 500 response")
+	}
+}
+
+func TestGetActivePowerW_Disabled(t *testing.T) {
+	client := New("", nil)
+	if client.Enabled() {
+		t.Error("Enabled() = true, want false for empty URL")
+	}
+	if _, err := client.GetActivePowerW(); err == nil {
+		t.Error("GetActivePowerW() error = nil, want error when disabled")
+	}
+}
+
+func TestGetDeviceInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rpc/Shelly.GetDeviceInfo" {
+			w.Write([]byte(`{"name":null,"id":"shellypro3em-0123456789ab","mac":"0123456789AB","model":"SPEM-003CEBEU","gen":2,"ver":"1.3.3","app":"Pro3EM","profile":"triphase"}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	info, err := New(server.URL+"/", nil).GetDeviceInfo()
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+	if info.App != "Pro3EM" || info.Gen != 2 || info.Profile != "triphase" {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestMeterFromEntry(t *testing.T) {
+	entry := func(txt ...string) *zeroconf.ServiceEntry {
+		e := zeroconf.NewServiceEntry("shellypro3em-0123456789ab", mdnsService, mdnsDomain)
+		e.HostName = "shellypro3em-0123456789ab.local."
+		e.Port = 80
+		e.AddrIPv4 = []net.IP{net.ParseIP("192.168.1.42")}
+		e.Text = txt
+		return e
+	}
+
+	r := meterFromEntry(entry("gen=2", "app=Pro3EM", "ver=1.3.3"))
+	if r == nil {
+		t.Fatal("meterFromEntry() = nil, want Pro 3EM")
+	}
+	if r.URL != "http://192.168.1.42:80" || r.ID != "shellypro3em-0123456789ab" || r.Method != "mdns" {
+		t.Errorf("result = %+v", r)
+	}
+
+	if r := meterFromEntry(entry("gen=2", "app=Plus1PM")); r != nil {
+		t.Errorf("meterFromEntry(Plus1PM) = %+v, want nil", r)
+	}
+	if r := meterFromEntry(entry("app=EM")); r != nil {
+		t.Errorf("meterFromEntry(gen 1) = %+v, want nil", r)
+	}
+}
@@ -0,0 +1,126 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	mdnsService = "_shelly._tcp"
+	mdnsDomain  = "local."
+	mdnsTimeout = 3 * time.Second
+)
+
+// DiscoveryResult contains the details of a discovered Shelly energy meter.
+type DiscoveryResult struct {
+	URL      string
+	ID       string // e.g. shellypro3em-0123456789ab
+	App      string // e.g. Pro3EM, EMG3
+	Hostname string
+	Method   string // "mdns"
+}
+
+// Discover attempts to find a Shelly energy meter on the local network via mDNS.
+func Discover(ctx context.Context) (*DiscoveryResult, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create mDNS resolver: %w", err)
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	result := make(chan *DiscoveryResult, 1)
+
+	ctx, cancel := context.WithTimeout(ctx, mdnsTimeout)
+	defer cancel()
+
+	go func() {
+		for entry := range entries {
+			r := meterFromEntry(entry)
+			if r == nil {
+				continue
+			}
+			select {
+			case result <- r:
+				cancel()
+			default:
+			}
+			return
+		}
+	}()
+
+	if err := resolver.Browse(ctx, mdnsService, mdnsDomain, entries); err != nil {
+		return nil, fmt.Errorf("mDNS browse: %w", err)
+	}
+
+	<-ctx.Done()
+
+	select {
+	case r := <-result:
+		return r, nil
+	default:
+		return nil, fmt.Errorf("no Shelly energy meter found via mDNS (timeout %s)", mdnsTimeout)
+	}
+}
+
+// meterFromEntry returns the discovery result for an mDNS entry of a Gen2+
+// energy meter, or nil for other Shelly devices.
+func meterFromEntry(entry *zeroconf.ServiceEntry) *DiscoveryResult {
+	txt := parseTXTRecords(entry.Text)
+	l := slog.With(
+		"hostname", entry.HostName,
+		"addr", entry.AddrIPv4,
+		"port", entry.Port,
+		"app", txt["app"],
+		"gen", txt["gen"],
+	)
+
+	if txt["gen"] == "" || txt["gen"] == "1" {
+		l.Debug("skipping Shelly device without RPC API")
+		return nil
+	}
+	if !isMeterApp(txt["app"]) {
+		l.Debug("skipping non-meter Shelly device")
+		return nil
+	}
+
+	var host string
+	if len(entry.AddrIPv4) > 0 {
+		host = entry.AddrIPv4[0].String()
+	} else if len(entry.AddrIPv6) > 0 {
+		host = "[" + entry.AddrIPv6[0].String() + "]"
+	} else {
+		l.Debug("skipping Shelly meter with no addresses")
+		return nil
+	}
+
+	return &DiscoveryResult{
+		URL:      fmt.Sprintf("http://%s:%d", host, entry.Port),
+		ID:       entry.Instance,
+		App:      txt["app"],
+		Hostname: entry.HostName,
+		Method:   "mdns",
+	}
+}
+
+// isMeterApp reports whether a Shelly application name is an energy meter
+// (Pro3EM, ProEM, EMG3, 3EMG3, EMMini...).
+func isMeterApp(app string) bool {
+	return strings.Contains(app, "EM")
+}
+
+// parseTXTRecords converts mDNS TXT record entries (key=value) into a map.
+func parseTXTRecords(records []string) map[string]string {
+	m := make(map[string]string, len(records))
+	for _, r := range records {
+		k, v, ok := strings.Cut(r, "=")
+		if ok {
+			m[k] = v
+		}
+	}
+	return m
+}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -105,12 +107,35 @@ func (c *Client) SendTradeEnd(ctx context.Context, action string, energyKWh floa
 	return c.SendMessage(ctx, text)
 }
 
+// SendOverride sends a notification when a manual override starts. powerW is
+// 0 for actions without a power.
+func (c *Client) SendOverride(ctx context.Context, action string, powerW int, until time.Time) error {
+	text := fmt.Sprintf("🕹 <b>Manual override: %s</b>\nUntil: %s", action, until.Format("15:04"))
+	if powerW > 0 {
+		text += fmt.Sprintf("\nPower: %d W", powerW)
+	}
+	return c.SendMessage(ctx, text)
+}
+
+// SendOverrideEnd sends a notification when a manual override ends.
+func (c *Client) SendOverrideEnd(ctx context.Context, action, reason string) error {
+	text := fmt.Sprintf("▶️ <b>Manual override ended: %s</b>\n%s\nAutomatic trading resumed", action, reason)
+	return c.SendMessage(ctx, text)
+}
+
 // SendError sends an error notification.
 func (c *Client) SendError(ctx context.Context, errMsg string) error {
 	text := fmt.Sprintf("⚠️ <b>Error</b>\n%s", errMsg)
 	return c.SendMessage(ctx, text)
 }
 
+// SendAddressChange announces that a device was found at a new address and the client was switched to it.
+func (c *Client) SendAddressChange(ctx context.Context, device, oldURL, newURL string) error {
+	text := fmt.Sprintf("🔄 <b>%s moved</b>\nOld: %s\nNew: %s",
+		html.EscapeString(device), html.EscapeString(oldURL), html.EscapeString(newURL))
+	return c.SendMessage(ctx, text)
+}
+
 // DailySummaryData contains all data for the daily summary notification.
 type DailySummaryData struct {
 	Date              time.Time
@@ -126,6 +151,20 @@ type DailySummaryData struct {
 	MinChargePrice    float64
 	MaxDischargePrice float64
 	TotalPnLEUR       float64 // cumulative P&L
+	Cycles            []CycleSummary
+}
+
+// CycleSummary compares a planned trade cycle with its execution.
+type CycleSummary struct {
+	ChargeStart    time.Time
+	DischargeStart time.Time
+	PlannedProfit  float64  // EUR/kWh
+	RealizedProfit *float64 // EUR/kWh, nil unless both legs traded
+	ChargedKWh     float64
+	DischargedKWh  float64
+	PnLEUR         float64
+	MissedSlots    int
+	MissReasons    []string // distinct reasons, in slot order
 }
 
 // SendDailySummary sends a daily P&L summary (simple version for backward compatibility).
@@ -209,10 +248,33 @@ func (c *Client) SendDailySummaryFull(ctx context.Context, data DailySummaryData
 			totalSign, data.TotalPnLEUR,
 		)
 	}
+	text += formatCycles(data.Cycles)
 
 	return c.SendMessage(ctx, text)
 }
 
+// formatCycles renders the planned-vs-realized block of the daily summary, or "" without planned cycles.
+func formatCycles(cycles []CycleSummary) string {
+	if len(cycles) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\n🔁 <b>Planned cycles</b>")
+	for _, c := range cycles {
+		realized := "n/a"
+		if c.RealizedProfit != nil {
+			realized = fmt.Sprintf("%+.4f", *c.RealizedProfit)
+		}
+		fmt.Fprintf(&b, "\n%s→%s: plan %+.4f, realized %s EUR/kWh\n   %.2f kWh in, %.2f kWh out, P&L %+.4f EUR",
+			c.ChargeStart.Format("15:04"), c.DischargeStart.Format("15:04"),
+			c.PlannedProfit, realized, c.ChargedKWh, c.DischargedKWh, c.PnLEUR)
+		if c.MissedSlots > 0 {
+			fmt.Fprintf(&b, "\n   %d missed slots (%s)", c.MissedSlots, strings.Join(c.MissReasons, ", "))
+		}
+	}
+	return b.String()
+}
+
 // SendStartup sends a startup notification.
 func (c *Client) SendStartup(ctx context.Context, serviceName string) error {
 	text := fmt.Sprintf("🚀 <b>%s started</b>", serviceName)
@@ -227,8 +289,20 @@ type StatusData struct {
 	BatteryPowerW    float64
 	CurrentPrice     float64
 	NextAction       string
-	TodayPnL         float64
-	TotalPnL         float64
+	TodayPnL         float64 // realized
+	TotalPnL         float64 // realized
+
+	// Energy in the battery by the trade ledger
+	InventoryKWh  float64
+	AvgCostEUR    float64  // cost basis per stored kWh
+	UnrealizedPnL *float64 // nil without a current price
+
+	// Energy flow, set when a grid meter is available
+	FlowAvailable      bool
+	PVPowerW           *float64 // nil without a PV meter
+	GridPowerW         float64  // positive imports, negative exports
+	HouseLoadW         float64
+	SelfSufficiencyPct float64
 }
 
 // SendStatus sends the current status.
@@ -256,20 +330,116 @@ func (c *Client) SendStatus(ctx context.Context, data StatusData) error {
 			"<b>Battery power:</b> %s\n"+
 			"<b>Price:</b> %.4f EUR/kWh\n"+
 			"<b>Next:</b> %s\n\n"+
+			"%s"+
 			"<b>Today P&L:</b> %.4f EUR\n"+
-			"<b>Total P&L:</b> %.4f EUR",
+			"<b>Total P&L:</b> %.4f EUR"+
+			"%s",
 		stateEmoji,
 		data.State,
 		batterySOC,
 		batteryPower,
 		data.CurrentPrice,
 		data.NextAction,
+		formatEnergyFlow(data),
 		data.TodayPnL,
 		data.TotalPnL,
+		formatInventory(data),
 	)
 	return c.SendMessage(ctx, text)
 }
 
+// formatInventory renders the stored energy line of the status message, or "" when the battery holds none.
+func formatInventory(data StatusData) string {
+	if data.InventoryKWh <= 0 {
+		return ""
+	}
+	line := fmt.Sprintf("\n<b>Stored:</b> %.2f kWh @ %.4f EUR/kWh", data.InventoryKWh, data.AvgCostEUR)
+	if data.UnrealizedPnL != nil {
+		line += fmt.Sprintf(" (unrealized %.4f EUR)", *data.UnrealizedPnL)
+	}
+	return line
+}
+
+// formatEnergyFlow renders the energy flow block of the status message, or "" without a grid meter.
+func formatEnergyFlow(data StatusData) string {
+	if !data.FlowAvailable {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<b>Energy flow</b>\n")
+	if data.PVPowerW != nil {
+		fmt.Fprintf(&b, "☀️ Solar: %.0f W\n", *data.PVPowerW)
+	}
+	if data.GridPowerW < 0 {
+		fmt.Fprintf(&b, "🔌 Grid: exporting %.0f W\n", -data.GridPowerW)
+	} else {
+		fmt.Fprintf(&b, "🔌 Grid: importing %.0f W\n", data.GridPowerW)
+	}
+	fmt.Fprintf(&b, "🏠 House: %.0f W\n", data.HouseLoadW)
+	fmt.Fprintf(&b, "<b>Self-sufficiency:</b> %.0f%%\n\n", data.SelfSufficiencyPct)
+	return b.String()
+}
+
+// CommandLogEntry is a single battery command for the /log command.
+type CommandLogEntry struct {
+	Time           time.Time
+	Command        string
+	Reason         string
+	PowerW         int
+	StateBefore    string
+	StateAfter     string
+	Err            string
+	LatencyMS      int64
+	MeasuredPowerW *float64
+	VerifyErr      string
+}
+
+// commandLogMaxErrLen keeps /log replies under Telegram's 4096 character limit.
+const commandLogMaxErrLen = 120
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// SendCommandLog sends the most recent battery commands, newest first.
+func (c *Client) SendCommandLog(ctx context.Context, entries []CommandLogEntry) error {
+	if len(entries) == 0 {
+		return c.SendMessage(ctx, "📜 <b>Command Log</b>\n\nNo battery commands recorded.")
+	}
+
+	text := fmt.Sprintf("📜 <b>Command Log</b> (last %d)\n", len(entries))
+	for _, e := range entries {
+		result := "✅"
+		if e.Err != "" || e.VerifyErr != "" {
+			result = "❌"
+		}
+		command := e.Command
+		if e.Reason != "" {
+			command += " (" + e.Reason + ")"
+		}
+		text += fmt.Sprintf(
+			"\n%s <b>%s</b> %s %d W\n   %s → %s, %d ms",
+			result, e.Time.Format("02 Jan 15:04:05"), html.EscapeString(command), e.PowerW,
+			e.StateBefore, e.StateAfter, e.LatencyMS,
+		)
+		if e.MeasuredPowerW != nil {
+			text += fmt.Sprintf(", measured %.0f W", *e.MeasuredPowerW)
+		}
+		for _, errMsg := range []string{e.Err, e.VerifyErr} {
+			if errMsg != "" {
+				text += "\n   <i>" + html.EscapeString(truncate(errMsg, commandLogMaxErrLen)) + "</i>"
+			}
+		}
+		text += "\n"
+	}
+	return c.SendMessage(ctx, text)
+}
+
 // Update represents a Telegram update.
 type Update struct {
 	UpdateID int64   `json:"update_id"`
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/internal/config"
+	"github.com/foae/marstek-energy-trading/internal/discovery"
+)
+
+// runDiscover implements "trader discover": it scans the network for meters and
+// batteries and prints what it finds as .env lines. Progress and warnings go to
+// stderr so the output can be appended to .env directly. It returns the exit code.
+func runDiscover(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ContinueOnError)
+	subnetsFlag := fs.String("subnets", strings.Join(cfg.DiscoverySubnets, ","),
+		"comma-separated IPv4 CIDRs to scan (default DISCOVERY_SUBNETS, else the host's interface subnets)")
+	timeout := fs.Duration("timeout", time.Minute, "maximum scan duration")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel(cfg.LogLevel)})))
+
+	var cidrs []string
+	if *subnetsFlag != "" {
+		cidrs = strings.Split(*subnetsFlag, ",")
+	}
+	subnets, err := discovery.Subnets(cidrs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "discover: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Scanning %s (%d hosts) with mDNS, HTTP probes and a Marstek UDP broadcast...\n",
+		formatSubnets(subnets), len(discovery.Hosts(subnets)))
+	devices, err := discovery.Scan(ctx, subnets)
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", line)
+		}
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "No devices found.")
+		return 1
+	}
+
+	fmt.Printf("# Found %d device(s)\n", len(devices))
+	for _, d := range devices {
+		fmt.Printf("\n# %s\n", d.Summary())
+		for _, line := range d.ConfigLines() {
+			fmt.Println(line)
+		}
+	}
+	return 0
+}
+
+func formatSubnets(subnets []netip.Prefix) string {
+	s := make([]string, len(subnets))
+	for i, subnet := range subnets {
+		s[i] = subnet.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/foae/marstek-energy-trading/internal/config"
+	"github.com/foae/marstek-energy-trading/service"
+)
+
+// runExport implements "trader export trades|daily": it reads the trade
+// journal in DATA_DIR and writes trades or summaries as CSV or JSON. It never
+// modifies the journal, not even a final line the service is still appending,
+// so it is safe while the service runs. It returns the exit code.
+func runExport(cfg *config.Config, args []string) int {
+	if len(args) == 0 || (args[0] != "trades" && args[0] != "daily") {
+		fmt.Fprintln(os.Stderr, "usage: trader export trades|daily [flags]")
+		return 2
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet("export "+kind, flag.ContinueOnError)
+	from := fs.String("from", "", "first day included (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day included (YYYY-MM-DD)")
+	actions := fs.String("action", "", "trades: comma-separated actions (charge, discharge, solar_charge)")
+	period := fs.String("period", "day", "daily: aggregation period (day, month, year)")
+	format := fs.String("format", "csv", "output format (csv, json)")
+	output := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "export: format must be csv or json")
+		return 2
+	}
+	q, err := service.ParseExportQuery(*from, *to, *actions, *period)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+
+	recorder := service.NewRecorder(cfg.DataDir, cfg.BatteryEfficiency, cfg.Location())
+	recorder.SetCostBasis(service.CostBasis(cfg.PnLCostBasis), service.SolarValuation(cfg.SolarValuation))
+	if err := recorder.LoadTradesReadOnly(); err != nil {
+		fmt.Fprintf(os.Stderr, "export: load trades: %v\n", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch {
+	case kind == "trades" && *format == "csv":
+		err = service.WriteTradesCSV(w, recorder.ExportTrades(q))
+	case kind == "trades":
+		err = writeIndentedJSON(w, recorder.ExportTrades(q))
+	case *format == "csv":
+		err = service.WriteSummariesCSV(w, recorder.ExportSummaries(q))
+	default:
+		err = writeIndentedJSON(w, recorder.ExportSummaries(q))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/foae/marstek-energy-trading/internal/config"
+	"github.com/foae/marstek-energy-trading/service"
+)
+
+// runImportUsage implements "trader import-usage FILE": it reads a supplier's
+// hourly or 15-minute usage export (CSV) into DATA_DIR for reconciliation.
+// Intervals imported before are kept. It returns the exit code.
+func runImportUsage(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("import-usage", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: trader import-usage FILE.csv")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-usage: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	intervals, err := service.ParseUsageCSV(f, cfg.Location())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-usage: %v\n", err)
+		return 1
+	}
+
+	added, err := service.NewMeterDataStore(cfg.DataDir).ImportUsage(intervals)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-usage: %v\n", err)
+		return 1
+	}
+	first, last := intervals[0], intervals[len(intervals)-1]
+	fmt.Printf("Read %d intervals from %s to %s, imported %d new\n",
+		len(intervals), first.Start.Format("2006-01-02 15:04"), last.End.Format("2006-01-02 15:04"), added)
+	return 0
+}
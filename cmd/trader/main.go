@@ -4,6 +4,8 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
 	"sync"
@@ -12,12 +14,16 @@ import (
 
 	"github.com/joho/godotenv"
 
+	"github.com/foae/marstek-energy-trading/clients/dsmr"
 	"github.com/foae/marstek-energy-trading/clients/esphome"
 	"github.com/foae/marstek-energy-trading/clients/homewizard"
+	"github.com/foae/marstek-energy-trading/clients/marstek"
 	"github.com/foae/marstek-energy-trading/clients/nordpool"
+	"github.com/foae/marstek-energy-trading/clients/shelly"
 	"github.com/foae/marstek-energy-trading/clients/telegram"
 	"github.com/foae/marstek-energy-trading/handler"
 	"github.com/foae/marstek-energy-trading/internal/config"
+	"github.com/foae/marstek-energy-trading/internal/discovery"
 	"github.com/foae/marstek-energy-trading/service"
 )
 
@@ -32,17 +38,23 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Setup structured logging
-	logLevel := slog.LevelInfo
-	switch cfg.LogLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "discover":
+			os.Exit(runDiscover(cfg, os.Args[2:]))
+		case "export":
+			os.Exit(runExport(cfg, os.Args[2:]))
+		case "import-usage":
+			os.Exit(runImportUsage(cfg, os.Args[2:]))
+		default:
+			slog.Error("unknown command", "command", os.Args[1], "available", "discover, export, import-usage")
+			os.Exit(2)
+		}
 	}
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+
+	// Setup structured logging
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel(cfg.LogLevel)}))
 	slog.SetDefault(logger)
 
 	slog.Info("starting energy trader",
@@ -56,48 +68,184 @@ func main() {
 	// Initialize clients with configured timezone
 	nordpoolClient := nordpool.NewWithLocation(cfg.NordPoolArea, cfg.NordPoolCurrency, cfg.Location())
 	minSOC := int(cfg.BatteryMinSOC * 100)
-	esphomeClient := esphome.New(cfg.ESPHomeURL, minSOC)
-	defer esphomeClient.Close()
-	slog.Info("using ESPHome battery backend", "url", cfg.ESPHomeURL, "min_soc", minSOC)
-	p1URL := cfg.HomeWizardP1URL
-	if p1URL == "" {
-		if discovered, err := homewizard.Discover(context.Background()); err != nil {
-			slog.Info("HomeWizard P1 not discovered, meter disabled", "error", err)
-		} else {
-			p1URL = discovered.URL
-			slog.Info("HomeWizard P1 auto-discovered",
-				"url", p1URL,
-				"serial", discovered.Serial,
-				"hostname", discovered.Hostname,
-				"method", discovered.Method,
-			)
-		}
+	entities, err := esphome.LoadEntityMap(cfg.ESPHomeProfile, cfg.ESPHomeEntityMap)
+	if err != nil {
+		slog.Error("failed to load ESPHome entity map", "error", err)
+		os.Exit(1)
 	}
-	p1Client := homewizard.New(p1URL)
-	if p1Client.Enabled() {
-		if info, err := p1Client.GetDeviceInfo(); err != nil {
-			slog.Warn("HomeWizard P1 meter unreachable at startup, will retry during operation", "url", p1URL, "error", err)
-		} else {
-			slog.Info("HomeWizard P1 meter enabled", "url", p1URL, "product", info.ProductName, "serial", info.Serial, "firmware", info.Firmware)
-		}
-	} else {
-		slog.Info("HomeWizard P1 meter disabled (no URL configured)")
+	var esphomeClient *esphome.Client
+	if cfg.BatteryBackend != "marstek" {
+		esphomeClient = esphome.NewWithEntities(cfg.ESPHomeURL, minSOC, entities)
+		defer esphomeClient.Close()
+		slog.Info("using ESPHome battery backend",
+			"url", cfg.ESPHomeURL,
+			"min_soc", minSOC,
+			"profile", cfg.ESPHomeProfile,
+			"entity_map", cfg.ESPHomeEntityMap,
+		)
 	}
-
 	telegramClient := telegram.New(cfg.TelegramBotToken, cfg.TelegramChatID)
 
 	if telegramClient.Enabled() {
 		slog.Info("telegram notifications enabled")
 	}
 
+	// Rediscovers the meter and battery by serial, MAC or host name when their address changes
+	subnets := discoverySubnets(cfg.DiscoverySubnets)
+	supervisor := discovery.NewSupervisor(subnets, time.Duration(cfg.RediscoveryIntervalS)*time.Second, telegramClient)
+	if host := hostOf(cfg.ESPHomeURL); esphomeClient != nil && isIP(host) {
+		supervisor.Watch(discovery.Endpoint{
+			Name:   "ESPHome battery bridge",
+			Kind:   discovery.KindESPHome,
+			Client: esphomeClient,
+			Check: func(ctx context.Context) (discovery.Identity, error) {
+				_, err := esphomeClient.Discover()
+				return discovery.Identity{}, err
+			},
+		})
+	}
+
+	meterBackend := cfg.MeterBackend
+	if meterBackend == "" || meterBackend == "auto" {
+		meterBackend = "homewizard"
+		if cfg.DSMRSource != "" {
+			meterBackend = "dsmr"
+		}
+	}
+	// Battery control, the CT meter and the PV reader share one socket: the Marstek API
+	// requires source port 30000. Meter and PV reads yield it to battery control calls.
+	var marstekClient *marstek.Client
+	if cfg.BatteryBackend == "marstek" || meterBackend == "marstek" || cfg.PVMeterBackend == "marstek" {
+		marstekClient = marstek.New(cfg.BatteryUDPAddr)
+		if err := marstekClient.Connect(); err != nil {
+			slog.Error("failed to open Marstek UDP socket", "addr", cfg.BatteryUDPAddr, "error", err)
+			os.Exit(1)
+		}
+		defer marstekClient.Close()
+	}
+	var battery service.BatteryController = esphomeClient
+	if cfg.BatteryBackend == "marstek" {
+		battery = marstekClient
+		slog.Info("using Marstek UDP battery backend", "addr", cfg.BatteryUDPAddr)
+	}
+
+	var meter service.MeterReader
+	switch {
+	case meterBackend == "dsmr":
+		dsmrClient := dsmr.New(cfg.DSMRSource, cfg.DSMRBaud)
+		dsmrClient.Start()
+		defer dsmrClient.Close()
+		meter = dsmrClient
+		slog.Info("DSMR P1 meter enabled", "source", cfg.DSMRSource, "baud", cfg.DSMRBaud)
+	case meterBackend == "marstek":
+		// With BATTERY_BACKEND=marstek, CT reads yield the socket to battery control calls.
+		meter = marstek.NewMeter(marstekClient)
+		slog.Info("Marstek CT meter enabled", "addr", cfg.BatteryUDPAddr)
+	case meterBackend == "shelly":
+		meter = newShellyMeter(cfg.ShellyURL, cfg.ShellyEM1Channels)
+	case cfg.HomeWizardAPI == "v2":
+		trust := homewizard.V2Trust{Serial: cfg.HomeWizardSerial, PinOnFirstUse: cfg.HomeWizardPinTLS}
+		if cfg.HomeWizardCAFile != "" {
+			roots, err := homewizard.LoadCAFile(cfg.HomeWizardCAFile)
+			if err != nil {
+				slog.Error("failed to load HomeWizard CA", "file", cfg.HomeWizardCAFile, "error", err)
+				os.Exit(1)
+			}
+			trust.RootCAs = roots
+		}
+		p1Client := newHomeWizardV2Meter(cfg.HomeWizardP1URL, subnets, cfg.DataDir, cfg.ServiceName, trust)
+		p1Client.Start()
+		defer p1Client.Close()
+		meter = p1Client
+		if p1Client.Enabled() {
+			supervisor.Watch(discovery.Endpoint{
+				Name:   "HomeWizard P1 meter",
+				Kind:   discovery.KindHomeWizard,
+				Client: p1Client,
+				Check: func(ctx context.Context) (discovery.Identity, error) {
+					if !p1Client.Paired() {
+						return discovery.Identity{}, nil // waiting for the button press, not unreachable
+					}
+					info, err := p1Client.GetDeviceInfo()
+					if err != nil {
+						return discovery.Identity{}, err
+					}
+					return discovery.Identity{Serial: info.Serial}, nil
+				},
+			})
+		}
+	default:
+		p1Client := newHomeWizardMeter(cfg.HomeWizardP1URL, subnets)
+		meter = p1Client
+		if p1Client.Enabled() {
+			supervisor.Watch(discovery.Endpoint{
+				Name:   "HomeWizard P1 meter",
+				Kind:   discovery.KindHomeWizard,
+				Client: p1Client,
+				Check: func(ctx context.Context) (discovery.Identity, error) {
+					info, err := p1Client.GetDeviceInfo()
+					if err != nil {
+						return discovery.Identity{}, err
+					}
+					return discovery.Identity{Serial: info.Serial}, nil
+				},
+			})
+		}
+	}
+
+	// Optional PV production meter for the house load and energy flow breakdown
+	var pvMeter service.PVReader
+	switch cfg.PVMeterBackend {
+	case "homewizard":
+		pvClient := newHomeWizardPVMeter(cfg.PVMeterURL)
+		pvMeter = pvClient
+		supervisor.Watch(discovery.Endpoint{
+			Name:   "HomeWizard PV meter",
+			Kind:   discovery.KindHomeWizard,
+			Client: pvClient,
+			Check: func(ctx context.Context) (discovery.Identity, error) {
+				info, err := pvClient.GetDeviceInfo()
+				if err != nil {
+					return discovery.Identity{}, err
+				}
+				return discovery.Identity{Serial: info.Serial}, nil
+			},
+		})
+	case "marstek":
+		pvMeter = marstek.NewPVMeter(marstekClient)
+		slog.Info("Marstek PV input enabled", "addr", cfg.BatteryUDPAddr)
+	}
+
 	// Initialize recorder with configured timezone
 	recorder := service.NewRecorder(cfg.DataDir, cfg.BatteryEfficiency, cfg.Location())
+	recorder.SetCostBasis(service.CostBasis(cfg.PnLCostBasis), service.SolarValuation(cfg.SolarValuation))
+
+	// Battery command audit journal
+	commands := service.NewCommandJournal(cfg.DataDir, cfg.CommandRetentionDays, cfg.Location())
+
+	// Control loop telemetry for after-the-fact diagnosis
+	var telemetry *service.TelemetryStore
+	if cfg.TelemetryRetentionDays > 0 {
+		telemetry = service.NewTelemetryStore(cfg.DataDir, cfg.TelemetryRetentionDays, cfg.Location())
+	}
+
+	// Activated trading plans and missed slots for planned-vs-realized reports
+	cycles := service.NewCycleJournal(cfg.DataDir)
+	sessions := service.NewSessionStore(cfg.DataDir)
+	meterData := service.NewMeterDataStore(cfg.DataDir)
 
 	// Initialize trading service
-	tradingSvc := service.New(cfg, nordpoolClient, esphomeClient, p1Client, telegramClient, recorder)
+	tradingSvc := service.New(cfg, nordpoolClient, battery, meter, telegramClient, recorder, commands, telemetry, cycles, sessions, meterData)
+	tradingSvc.SetPVMeter(pvMeter)
 
 	// Setup HTTP handler
 	h := handler.New(tradingSvc)
+	if cfg.ControlEnabled() {
+		h.EnableControl(handler.ControlAuth{Tokens: cfg.ControlTokens, User: cfg.ControlUser, Password: cfg.ControlPassword})
+		if !cfg.TLSEnabled() {
+			slog.Warn("control API is enabled without TLS, credentials are sent in plain text")
+		}
+	}
 	router := h.NewRouter()
 
 	server := &http.Server{
@@ -107,6 +255,7 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	server.RegisterOnShutdown(h.Close)
 
 	// WaitGroup for graceful shutdown
 	var wg sync.WaitGroup
@@ -115,8 +264,14 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		slog.Info("HTTP server listening", "addr", cfg.HTTPListenAddr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("HTTP server listening", "addr", cfg.HTTPListenAddr, "tls", cfg.TLSEnabled(), "control_api", cfg.ControlEnabled())
+		var err error
+		if cfg.TLSEnabled() {
+			err = server.ListenAndServeTLS(cfg.HTTPTLSCert, cfg.HTTPTLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("HTTP server error", "error", err)
 			os.Exit(1)
 		}
@@ -136,6 +291,13 @@ func main() {
 		}
 	}()
 
+	// Start device supervisor in background
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		supervisor.Run(ctx)
+	}()
+
 	// Wait for shutdown signal
 	<-ctx.Done()
 	slog.Info("shutting down...")
@@ -153,3 +315,145 @@ func main() {
 
 	slog.Info("shutdown complete")
 }
+
+// logLevel maps LOG_LEVEL onto a slog level (default info).
+func logLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// discoverHomeWizardURL returns configuredURL, or the auto-discovered P1 meter URL and serial when it is empty.
+// The HTTP scan fallback covers the discovery subnets.
+func discoverHomeWizardURL(configuredURL string, subnets []netip.Prefix) (p1URL, serial string) {
+	p1URL = configuredURL
+	if p1URL == "" {
+		if discovered, err := homewizard.Discover(context.Background(), discovery.Hosts(subnets)); err != nil {
+			slog.Info("HomeWizard P1 not discovered, meter disabled", "error", err)
+		} else {
+			p1URL, serial = discovered.URL, discovered.Serial
+			slog.Info("HomeWizard P1 auto-discovered",
+				"url", p1URL,
+				"serial", discovered.Serial,
+				"hostname", discovered.Hostname,
+				"method", discovered.Method,
+			)
+		}
+	}
+	return p1URL, serial
+}
+
+// discoverySubnets returns the subnets to scan for devices: DISCOVERY_SUBNETS, or the host's interface subnets.
+func discoverySubnets(cidrs []string) []netip.Prefix {
+	subnets, err := discovery.Subnets(cidrs)
+	if err != nil {
+		slog.Warn("no subnets to scan for devices", "error", err)
+		return nil
+	}
+	return subnets
+}
+
+// hostOf returns the host name or IP of a URL.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// isIP reports whether host is an IP address rather than a name DNS keeps up to date.
+func isIP(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// newHomeWizardMeter returns the HomeWizard P1 client, auto-discovering it when no URL is configured.
+func newHomeWizardMeter(configuredURL string, subnets []netip.Prefix) *homewizard.Client {
+	p1URL, _ := discoverHomeWizardURL(configuredURL, subnets)
+	p1Client := homewizard.New(p1URL)
+	if p1Client.Enabled() {
+		if info, err := p1Client.GetDeviceInfo(); err != nil {
+			slog.Warn("HomeWizard P1 meter unreachable at startup, will retry during operation", "url", p1URL, "error", err)
+		} else {
+			slog.Info("HomeWizard P1 meter enabled", "url", p1URL, "product", info.ProductName, "serial", info.Serial, "firmware", info.Firmware)
+		}
+	} else {
+		slog.Info("HomeWizard P1 meter disabled (no URL configured)")
+	}
+	return p1Client
+}
+
+// newHomeWizardV2Meter returns the HomeWizard local API v2 client. The device certificate
+// must be issued to the configured or discovered serial. The pairing token (and a pinned
+// certificate) are loaded from dataDir; pairing itself happens in the background.
+func newHomeWizardV2Meter(configuredURL string, subnets []netip.Prefix, dataDir, serviceName string, trust homewizard.V2Trust) *homewizard.V2Client {
+	p1URL, serial := discoverHomeWizardURL(configuredURL, subnets)
+	if trust.Serial == "" {
+		trust.Serial = serial
+	}
+	p1Client := homewizard.NewV2(p1URL, dataDir, serviceName, trust)
+	if !p1Client.Enabled() {
+		slog.Info("HomeWizard P1 meter disabled (no URL configured)")
+		return p1Client
+	}
+	if err := p1Client.LoadState(); err != nil {
+		slog.Warn("failed to load HomeWizard v2 pairing state", "error", err)
+	}
+	if !p1Client.Paired() {
+		slog.Warn("HomeWizard v2 meter not paired yet: press the button on the P1 meter", "url", p1URL)
+		return p1Client
+	}
+	if info, err := p1Client.GetDeviceInfo(); err != nil {
+		slog.Warn("HomeWizard P1 meter unreachable at startup, will retry during operation", "url", p1URL, "error", err)
+	} else {
+		slog.Info("HomeWizard P1 meter enabled (API v2)", "url", p1URL, "product", info.ProductName, "serial", info.Serial, "firmware", info.Firmware)
+	}
+	return p1Client
+}
+
+// newHomeWizardPVMeter returns the HomeWizard kWh meter client measuring the inverter circuit.
+func newHomeWizardPVMeter(meterURL string) *homewizard.Client {
+	pvClient := homewizard.New(meterURL)
+	if info, err := pvClient.GetDeviceInfo(); err != nil {
+		slog.Warn("HomeWizard PV meter unreachable at startup, will retry during operation", "url", meterURL, "error", err)
+	} else {
+		slog.Info("HomeWizard PV meter enabled", "url", meterURL, "product", info.ProductName, "serial", info.Serial, "firmware", info.Firmware)
+	}
+	return pvClient
+}
+
+// newShellyMeter returns the Shelly energy meter client, auto-discovering it when no URL is configured.
+func newShellyMeter(configuredURL string, em1Channels []int) *shelly.Client {
+	meterURL := configuredURL
+	if meterURL == "" {
+		if discovered, err := shelly.Discover(context.Background()); err != nil {
+			slog.Info("Shelly energy meter not discovered, meter disabled", "error", err)
+		} else {
+			meterURL = discovered.URL
+			slog.Info("Shelly energy meter auto-discovered",
+				"url", meterURL,
+				"id", discovered.ID,
+				"app", discovered.App,
+				"hostname", discovered.Hostname,
+			)
+		}
+	}
+	meterClient := shelly.New(meterURL, em1Channels)
+	if meterClient.Enabled() {
+		if info, err := meterClient.GetDeviceInfo(); err != nil {
+			slog.Warn("Shelly energy meter unreachable at startup, will retry during operation", "url", meterURL, "error", err)
+		} else {
+			slog.Info("Shelly energy meter enabled", "url", meterURL, "model", info.Model, "app", info.App, "profile", info.Profile, "firmware", info.Firmware)
+		}
+	} else {
+		slog.Info("Shelly energy meter disabled (no URL configured)")
+	}
+	return meterClient
+}
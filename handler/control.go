@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/foae/marstek-energy-trading/service"
+)
+
+const (
+	// controlWriteTimeout covers a command and the battery's start verification.
+	controlWriteTimeout = time.Minute
+	controlMaxBodyBytes = 4 << 10
+)
+
+// ControlAuth holds the credentials accepted by the control API.
+type ControlAuth struct {
+	Tokens   []string // bearer tokens
+	User     string   // HTTP basic auth, with Password
+	Password string
+}
+
+// EnableControl registers the control API with the given credentials when
+// NewRouter is called. Without credentials the API stays disabled.
+func (h *Handler) EnableControl(auth ControlAuth) {
+	for _, token := range auth.Tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			h.control.Tokens = append(h.control.Tokens, token)
+		}
+	}
+	h.control.User, h.control.Password = auth.User, auth.Password
+	h.controlEnabled = len(h.control.Tokens) > 0 || h.control.User != ""
+}
+
+// controlRoutes registers the control API when it is enabled.
+func (h *Handler) controlRoutes(r chi.Router) {
+	if !h.controlEnabled {
+		return
+	}
+	r.Route("/control", func(r chi.Router) {
+		r.Use(h.requireControlAuth)
+		r.Get("/", h.controlStatusHandler)
+		r.Post("/{action}", h.controlHandler)
+	})
+}
+
+// requireControlAuth accepts a configured bearer token or basic auth credentials.
+func (h *Handler) requireControlAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.controlAuthorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Warn("unauthorized control request", "remote", r.RemoteAddr, "path", r.URL.Path)
+		if h.control.User != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="energy-trader"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="energy-trader"`)
+		}
+		h.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	})
+}
+
+func (h *Handler) controlAuthorized(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		for _, want := range h.control.Tokens {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(want)) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+	if user, password, ok := r.BasicAuth(); ok && h.control.User != "" {
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(h.control.User)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.control.Password)) == 1
+		return userOK && passwordOK
+	}
+	return false
+}
+
+// controlRequest is the optional JSON body of a control command.
+type controlRequest struct {
+	PowerW          int `json:"power_w"`          // 0 = configured power
+	DurationMinutes int `json:"duration_minutes"` // 0 = one hour
+}
+
+// controlStatusHandler returns the state and the active manual override.
+func (h *Handler) controlStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+	h.writeJSON(w, http.StatusOK, service.ControlResult{State: h.svc.State(), Override: h.svc.GetOverride()})
+}
+
+// controlHandler carries out a manual command: charge, discharge, idle, pause
+// or resume.
+func (h *Handler) controlHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	var body controlRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, controlMaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid JSON body: %v", err)})
+		return
+	}
+
+	// Starting a session waits for the battery to confirm it
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(controlWriteTimeout)); err != nil {
+		slog.Debug("failed to extend control response deadline", "error", err)
+	}
+
+	req := service.ControlRequest{
+		Action:   service.ControlAction(chi.URLParam(r, "action")),
+		PowerW:   body.PowerW,
+		Duration: time.Duration(body.DurationMinutes) * time.Minute,
+	}
+	slog.Info("control request", "action", req.Action, "power_w", req.PowerW, "duration", req.Duration, "remote", r.RemoteAddr)
+	result, err := h.svc.Control(r.Context(), req)
+	switch {
+	case err == nil:
+		h.writeJSON(w, http.StatusOK, result)
+	case errors.Is(err, service.ErrInvalidControl):
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrControlRejected):
+		h.writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		h.writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+}
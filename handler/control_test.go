@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testControlToken = "0123456789abcdef"
+
+// serveControl sends a charge command through the router. Without a service,
+// a request that passes authentication is answered with 503.
+func serveControl(t *testing.T, h *Handler, authorize func(*http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/control/charge", nil)
+	if authorize != nil {
+		authorize(req)
+	}
+	w := httptest.NewRecorder()
+	h.NewRouter().ServeHTTP(w, req)
+	return w
+}
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+func basic(user, password string) func(*http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth(user, password) }
+}
+
+func TestControlAuth(t *testing.T) {
+	tokenOnly := New(nil)
+	tokenOnly.EnableControl(ControlAuth{Tokens: []string{testControlToken, " second-token-0123 "}})
+	basicOnly := New(nil)
+	basicOnly.EnableControl(ControlAuth{User: "admin", Password: "s3cret-password"})
+
+	tests := []struct {
+		name      string
+		h         *Handler
+		authorize func(*http.Request)
+		want      int
+	}{
+		{"valid bearer token", tokenOnly, bearer(testControlToken), http.StatusServiceUnavailable},
+		{"second bearer token, trimmed", tokenOnly, bearer("second-token-0123"), http.StatusServiceUnavailable},
+		{"invalid bearer token", tokenOnly, bearer("0123456789abcdeX"), http.StatusUnauthorized},
+		{"token prefix", tokenOnly, bearer(testControlToken[:8]), http.StatusUnauthorized},
+		{"valid basic auth", basicOnly, basic("admin", "s3cret-password"), http.StatusServiceUnavailable},
+		{"wrong password", basicOnly, basic("admin", "wrong"), http.StatusUnauthorized},
+		{"wrong user", basicOnly, basic("root", "s3cret-password"), http.StatusUnauthorized},
+		{"bearer token when only basic auth is configured", basicOnly, bearer("s3cret-password"), http.StatusUnauthorized},
+		{"basic auth when only tokens are configured", tokenOnly, basic("admin", testControlToken), http.StatusUnauthorized},
+		{"no credentials", tokenOnly, nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveControl(t, tt.h, tt.authorize).Code; got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlAuth_ChallengesMissingCredentials(t *testing.T) {
+	tokenOnly := New(nil)
+	tokenOnly.EnableControl(ControlAuth{Tokens: []string{testControlToken}})
+	w := serveControl(t, tokenOnly, nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="energy-trader"` {
+		t.Errorf("response = %d with WWW-Authenticate %q, want 401 with a bearer challenge", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	basicOnly := New(nil)
+	basicOnly.EnableControl(ControlAuth{User: "admin", Password: "s3cret-password"})
+	w = serveControl(t, basicOnly, nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="energy-trader"` {
+		t.Errorf("response = %d with WWW-Authenticate %q, want 401 with a basic challenge", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestControlRoutes_NotRegisteredWhenDisabled(t *testing.T) {
+	disabled := New(nil)
+	disabled.EnableControl(ControlAuth{Tokens: []string{" "}}) // blank tokens do not enable it
+
+	for _, path := range []string{"/control", "/control/charge"} {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set("Authorization", "Bearer "+testControlToken)
+			w := httptest.NewRecorder()
+			disabled.NewRouter().ServeHTTP(w, req)
+			if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s = %d, want the route not registered", method, path, w.Code)
+			}
+		}
+	}
+}
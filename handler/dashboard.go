@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dashboardLiveInterval is how often the dashboard stream sends the live status.
+const dashboardLiveInterval = 5 * time.Second
+
+//go:embed web
+var webFiles embed.FS
+
+// dashboardRoutes serves the embedded dashboard under /dashboard/ and its
+// live status stream.
+func (h *Handler) dashboardRoutes(r chi.Router) {
+	web, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // the embedded directory always exists
+	}
+
+	r.Get("/", http.RedirectHandler("/dashboard/", http.StatusFound).ServeHTTP)
+	r.Get("/dashboard", http.RedirectHandler("/dashboard/", http.StatusMovedPermanently).ServeHTTP)
+	r.Get("/dashboard/config", h.dashboardConfigHandler)
+	r.Get("/dashboard/live", h.dashboardLiveHandler)
+	r.Get("/dashboard/*", http.StripPrefix("/dashboard/", http.FileServerFS(web)).ServeHTTP)
+}
+
+// dashboardConfigHandler tells the dashboard which features are available.
+func (h *Handler) dashboardConfigHandler(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string]any{"control_enabled": h.controlEnabled})
+}
+
+// dashboardLiveHandler streams the live status as server-sent "status" events
+// until the client disconnects or the server shuts down.
+func (h *Handler) dashboardLiveHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+	if !h.startSSE(w) {
+		return
+	}
+
+	ticker := time.NewTicker(dashboardLiveInterval)
+	defer ticker.Stop()
+	for {
+		if err := writeSSE(w, "status", h.svc.GetLiveStatus()); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// startSSE writes the headers of a server-sent event stream and lifts the
+// server's write timeout for it. It reports false when the stream cannot be
+// used; if nothing was sent yet, it answers with a JSON error itself.
+func (h *Handler) startSSE(w http.ResponseWriter) bool {
+	rc := http.NewResponseController(w)
+	if !canFlush(w) || rc.SetWriteDeadline(time.Time{}) != nil {
+		h.writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	return rc.Flush() == nil
+}
+
+// canFlush reports whether w, or a writer it wraps, can flush, which is what
+// http.ResponseController.Flush looks for. Flushing to find out would commit
+// the response headers.
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		switch t := w.(type) {
+		case http.Flusher, interface{ FlushError() error }:
+			return true
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// writeSSE writes one server-sent event with a JSON payload and flushes it.
+func writeSSE(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// plainWriter hides the recorder's Flush method and counts header writes.
+type plainWriter struct {
+	rec          *httptest.ResponseRecorder
+	headerWrites int
+}
+
+func (w *plainWriter) Header() http.Header         { return w.rec.Header() }
+func (w *plainWriter) Write(b []byte) (int, error) { return w.rec.Write(b) }
+func (w *plainWriter) WriteHeader(status int) {
+	w.headerWrites++
+	w.rec.WriteHeader(status)
+}
+
+func TestStartSSE_UnsupportedWriterGetsOneJSONError(t *testing.T) {
+	w := &plainWriter{rec: httptest.NewRecorder()}
+	if New(nil).startSSE(w) {
+		t.Fatal("startSSE() = true for a writer that cannot flush")
+	}
+	if w.headerWrites != 1 || w.rec.Code != http.StatusInternalServerError {
+		t.Errorf("header writes = %d, status = %d, want a single 500", w.headerWrites, w.rec.Code)
+	}
+	if ct := w.rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+}
+
+func TestStartSSE_StreamsOverServer(t *testing.T) {
+	h := New(nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.startSSE(w) {
+			writeSSE(w, "status", map[string]int{"soc": 50})
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("response = %d %q, want 200 text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
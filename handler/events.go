@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/service"
+)
+
+// eventsKeepAliveInterval is how often an idle event stream sends a comment,
+// so that proxies do not close it.
+const eventsKeepAliveInterval = 30 * time.Second
+
+// eventsHandler streams service events as server-sent events named after
+// their type, until the client disconnects or the server shuts down.
+// Query parameter types: comma-separated event types (default all).
+func (h *Handler) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+	types, err := service.ParseEventTypes(r.URL.Query().Get("types"))
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	sub := h.svc.Events().Subscribe(types...)
+	defer sub.Close()
+	if !h.startSSE(w) {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, string(event.Type), event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
@@ -3,8 +3,12 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,12 +20,21 @@ import (
 
 // Handler holds HTTP handler dependencies.
 type Handler struct {
-	svc *service.Service
+	svc            *service.Service
+	control        ControlAuth   // credentials of the control API
+	controlEnabled bool          // control API is configured
+	done           chan struct{} // closed by Close to end streaming responses
 }
 
 // New creates a new HTTP handler.
 func New(svc *service.Service) *Handler {
-	return &Handler{svc: svc}
+	return &Handler{svc: svc, done: make(chan struct{})}
+}
+
+// Close ends open streaming responses, so that server shutdown does not wait
+// for them. Register it with http.Server.RegisterOnShutdown.
+func (h *Handler) Close() {
+	close(h.done)
 }
 
 // NewRouter creates and configures the HTTP router.
@@ -35,6 +48,18 @@ func (h *Handler) NewRouter() *chi.Mux {
 	r.Get("/health", h.healthHandler)
 	r.Get("/metrics", h.metricsHandler())
 	r.Get("/status", h.statusHandler)
+	r.Get("/commands", h.commandsHandler)
+	r.Get("/telemetry", h.telemetryHandler)
+	r.Get("/cycles", h.cyclesHandler)
+	r.Get("/export/trades", h.exportTradesHandler)
+	r.Get("/export/daily", h.exportDailyHandler)
+	r.Get("/reconciliation", h.reconciliationHandler)
+	r.Get("/plan", h.planHandler)
+	r.Get("/plan/explain", h.planExplainHandler)
+	r.Get("/prices", h.pricesHandler)
+	r.Get("/events", h.eventsHandler)
+	h.controlRoutes(r)
+	h.dashboardRoutes(r)
 
 	return r
 }
@@ -67,6 +92,256 @@ func (h *Handler) statusHandler(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, resp)
 }
 
+// commandsHandler returns a page of the battery command journal, newest first.
+// Query parameters: offset (default 0) and limit (default 50, max 500).
+func (h *Handler) commandsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil || h.svc.GetCommandJournal() == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	offset, err := queryInt(r, "offset")
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.svc.GetCommandJournal().Page(offset, limit))
+}
+
+// telemetryHandler returns recorded control loop samples.
+// Query parameters: from and to (RFC 3339, default the last hour) and step
+// (Go duration such as 10s or 5m, default full resolution).
+func (h *Handler) telemetryHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil || h.svc.GetTelemetry() == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "telemetry disabled"})
+		return
+	}
+
+	to := time.Now()
+	if raw := r.URL.Query().Get("to"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be an RFC 3339 time"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour)
+	if raw := r.URL.Query().Get("from"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be an RFC 3339 time"})
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be before to"})
+		return
+	}
+	var step time.Duration
+	if raw := r.URL.Query().Get("step"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "step must be a positive duration such as 10s or 5m"})
+			return
+		}
+		step = d
+	}
+
+	samples, err := h.svc.GetTelemetry().Query(from, to, step)
+	if errors.Is(err, service.ErrTooManyPoints) {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"from": from, "to": to, "step": step.String(), "samples": samples})
+}
+
+// cyclesHandler returns the planned cycles of a day with their realized prices,
+// energy and missed slots. Query parameter: day (YYYY-MM-DD, default today).
+func (h *Handler) cyclesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	cycles, err := h.svc.GetCycles(r.URL.Query().Get("day"))
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"cycles": cycles})
+}
+
+// exportTradesHandler exports trades for spreadsheets. Query parameters: from
+// and to (YYYY-MM-DD, inclusive), action (comma-separated charge, discharge,
+// solar_charge) and format (json or csv, default json).
+func (h *Handler) exportTradesHandler(w http.ResponseWriter, r *http.Request) {
+	q, format, ok := h.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	trades := h.svc.GetRecorder().ExportTrades(q)
+	if format == "csv" {
+		writeCSVHeaders(w, "trades.csv")
+		service.WriteTradesCSV(w, trades)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"from": q.From, "to": q.To, "trades": trades})
+}
+
+// exportDailyHandler exports daily summaries, optionally aggregated by month
+// or year. Query parameters: from and to (YYYY-MM-DD, inclusive), period (day,
+// month or year, default day) and format (json or csv, default json).
+func (h *Handler) exportDailyHandler(w http.ResponseWriter, r *http.Request) {
+	q, format, ok := h.exportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	summaries := h.svc.GetRecorder().ExportSummaries(q)
+	if format == "csv" {
+		writeCSVHeaders(w, "daily.csv")
+		service.WriteSummariesCSV(w, summaries)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, map[string]any{"from": q.From, "to": q.To, "period": q.Period, "summaries": summaries})
+}
+
+// reconciliationHandler compares the trades with the meter data and the bill
+// with a no-battery counterfactual. Query parameters: from and to (YYYY-MM-DD,
+// inclusive, default today) and detail (true to include every slot).
+func (h *Handler) reconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	params := r.URL.Query()
+	detail, _ := strconv.ParseBool(params.Get("detail"))
+	report, err := h.svc.Reconcile(r.Context(), params.Get("from"), params.Get("to"), detail)
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.writeJSON(w, http.StatusOK, report)
+}
+
+// planHandler returns the trading plan. Query parameter: day (today, tomorrow
+// or YYYY-MM-DD, default today).
+func (h *Handler) planHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	plan, err := h.svc.GetPlan(r.URL.Query().Get("day"))
+	if err != nil {
+		h.writeDayError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, plan)
+}
+
+// planExplainHandler explains why the plan has no profitable cycle. Query
+// parameter: day (today, tomorrow or YYYY-MM-DD, default today).
+func (h *Handler) planExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	explanation, err := h.svc.ExplainPlan(r.URL.Query().Get("day"))
+	if err != nil {
+		h.writeDayError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, explanation)
+}
+
+// pricesHandler returns the 15-minute prices annotated with the plan. Query
+// parameter: day (today, tomorrow or YYYY-MM-DD, default today).
+func (h *Handler) pricesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return
+	}
+
+	prices, err := h.svc.GetPrices(r.URL.Query().Get("day"))
+	if err != nil {
+		h.writeDayError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, prices)
+}
+
+// writeDayError writes 404 when a day's prices are not fetched yet and 400
+// for an invalid day.
+func (h *Handler) writeDayError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, service.ErrPricesUnavailable) {
+		status = http.StatusNotFound
+	}
+	h.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// exportQuery parses the export query parameters, writing the error response
+// when they are invalid.
+func (h *Handler) exportQuery(w http.ResponseWriter, r *http.Request) (service.ExportQuery, string, bool) {
+	if h.svc == nil {
+		h.writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not ready"})
+		return service.ExportQuery{}, "", false
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "csv":
+	default:
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be json or csv"})
+		return service.ExportQuery{}, "", false
+	}
+	q, err := service.ParseExportQuery(params.Get("from"), params.Get("to"), params.Get("action"), params.Get("period"))
+	if err != nil {
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return service.ExportQuery{}, "", false
+	}
+	return q, format, true
+}
+
+// writeCSVHeaders sets the headers of a CSV download.
+func writeCSVHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// queryInt parses an optional non-negative integer query parameter.
+func queryInt(r *http.Request, name string) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return v, nil
+}
+
 // writeJSON writes a JSON response.
 func (h *Handler) writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -88,7 +363,27 @@ var (
 
 	traderPnL = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "energy_trader_pnl_eur_total",
-		Help: "Total profit and loss in EUR",
+		Help: "Total realized profit and loss in EUR",
+	})
+
+	inventoryEnergy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "energy_trader_inventory_kwh",
+		Help: "Energy stored according to the trade ledger, after round-trip losses",
+	})
+
+	inventoryCost = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "energy_trader_inventory_cost_eur",
+		Help: "Cost basis of the energy stored according to the trade ledger",
+	})
+
+	powerFlow = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "energy_trader_power_watts",
+		Help: "Current energy flow in watts: pv production, grid (positive imports), battery (positive charging) and house load",
+	}, []string{"flow"})
+
+	selfSufficiency = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "energy_trader_self_sufficiency_percent",
+		Help: "Share of the current house load not imported from the grid",
 	})
 )
 
@@ -96,6 +391,10 @@ func init() {
 	prometheus.MustRegister(batterySOC)
 	prometheus.MustRegister(traderState)
 	prometheus.MustRegister(traderPnL)
+	prometheus.MustRegister(inventoryEnergy)
+	prometheus.MustRegister(inventoryCost)
+	prometheus.MustRegister(powerFlow)
+	prometheus.MustRegister(selfSufficiency)
 }
 
 // metricsHandler returns the Prometheus metrics handler.
@@ -122,6 +421,9 @@ func (h *Handler) updateMetrics(ctx context.Context) {
 	if recorder != nil {
 		pnl, _ := recorder.GetTotalPnL().Float64()
 		traderPnL.Set(pnl)
+		inventory := recorder.GetInventory()
+		inventoryEnergy.Set(inventory.EnergyKWh.InexactFloat64())
+		inventoryCost.Set(inventory.CostBasisEUR.InexactFloat64())
 	}
 
 	// Update battery SOC from current status
@@ -131,4 +433,19 @@ func (h *Handler) updateMetrics(ctx context.Context) {
 	} else {
 		batterySOC.Set(math.NaN())
 	}
+
+	// Energy flow breakdown, dropped while the grid meter is unavailable
+	powerFlow.Reset()
+	flow := status.EnergyFlow
+	if flow == nil {
+		selfSufficiency.Set(math.NaN())
+		return
+	}
+	powerFlow.WithLabelValues("grid").Set(flow.GridPowerW)
+	powerFlow.WithLabelValues("battery").Set(flow.BatteryPowerW)
+	powerFlow.WithLabelValues("house").Set(flow.HouseLoadW)
+	if flow.PVPowerW != nil {
+		powerFlow.WithLabelValues("pv").Set(*flow.PVPowerW)
+	}
+	selfSufficiency.Set(flow.SelfSufficiencyPct)
 }
@@ -0,0 +1,401 @@
+"use strict";
+
+// Energy Trader dashboard: live state over server-sent events, charts drawn
+// as inline SVG from the JSON API. No external dependencies.
+
+const SVG_NS = "http://www.w3.org/2000/svg";
+const CHART = { width: 1000, height: 200, left: 44, right: 44, top: 10, bottom: 22 };
+const REFRESH_MS = 60 * 1000;
+const COLORS = {
+  charge: "#2e9d5b", discharge: "#e07b24", solar: "#e6b800", line: "#3867d6",
+  grid: "#e5e7eb", text: "#1d2330", loss: "#b91c1c",
+};
+
+const $ = (id) => document.getElementById(id);
+const num = (v) => (v === undefined || v === null ? null : parseFloat(v));
+
+function fmtWatts(w) {
+  if (w === null || w === undefined) return "–";
+  return Math.abs(w) >= 1000 ? `${(w / 1000).toFixed(2)} kW` : `${Math.round(w)} W`;
+}
+
+function fmtTime(t) {
+  return new Date(t).toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+}
+
+function fmtDate(d) {
+  const pad = (n) => String(n).padStart(2, "0");
+  return `${d.getFullYear()}-${pad(d.getMonth() + 1)}-${pad(d.getDate())}`;
+}
+
+function startOfDay(offsetDays = 0) {
+  const d = new Date();
+  d.setHours(0, 0, 0, 0);
+  d.setDate(d.getDate() + offsetDays);
+  return d;
+}
+
+async function getJSON(url) {
+  const resp = await fetch(url, { headers: { Accept: "application/json" } });
+  const body = await resp.json().catch(() => ({}));
+  if (!resp.ok) throw new Error(body.error || resp.statusText);
+  return body;
+}
+
+// --- SVG helpers ---
+
+function el(name, attrs = {}, text) {
+  const node = document.createElementNS(SVG_NS, name);
+  for (const [k, v] of Object.entries(attrs)) node.setAttribute(k, v);
+  if (text !== undefined) node.textContent = text;
+  return node;
+}
+
+function newChart(container, height = CHART.height) {
+  const svg = el("svg", { viewBox: `0 0 ${CHART.width} ${height}`, preserveAspectRatio: "none" });
+  container.replaceChildren(svg);
+  return svg;
+}
+
+function showEmpty(container, message) {
+  const div = document.createElement("div");
+  div.className = "empty";
+  div.textContent = message;
+  container.replaceChildren(div);
+}
+
+function scale(domainMin, domainMax, rangeMin, rangeMax) {
+  const span = domainMax - domainMin || 1;
+  return (v) => rangeMin + ((v - domainMin) / span) * (rangeMax - rangeMin);
+}
+
+function hourAxis(svg, x, from, to, height) {
+  for (let t = new Date(from); t <= to; t.setHours(t.getHours() + 3)) {
+    const px = x(t.getTime());
+    svg.append(el("line", { x1: px, x2: px, y1: CHART.top, y2: height - CHART.bottom, stroke: COLORS.grid }));
+    svg.append(el("text", { x: px, y: height - 6, "text-anchor": "middle" }, fmtTime(t)));
+  }
+}
+
+function valueAxis(svg, y, min, max, format, side = "left") {
+  const xPos = side === "left" ? CHART.left - 6 : CHART.width - CHART.right + 6;
+  const anchor = side === "left" ? "end" : "start";
+  for (const v of [min, (min + max) / 2, max]) {
+    svg.append(el("text", { x: xPos, y: y(v) + 4, "text-anchor": anchor }, format(v)));
+  }
+}
+
+// --- Live state ---
+
+let lastState = null;
+
+function updateLive(live) {
+  $("state").textContent = (live.state || "–").replace("_", " ");
+  $("next-action").textContent = live.next_action || "";
+  $("price").textContent = live.current_price_eur_kwh !== undefined ? `€${live.current_price_eur_kwh.toFixed(3)}/kWh` : "";
+
+  const s = live.sample;
+  if (s) {
+    $("soc").textContent = `${Math.round(s.soc)}%`;
+    $("soc-bar").style.width = `${Math.max(0, Math.min(100, s.soc))}%`;
+    $("battery-power").textContent = fmtWatts(s.bat_w);
+    $("commanded-power").textContent = s.cmd_w ? `commanded ${fmtWatts(s.cmd_w)}` : "";
+    $("grid-power").textContent = fmtWatts(s.meter_w);
+  }
+
+  if (lastState !== null && lastState !== live.state) refreshAll();
+  lastState = live.state;
+}
+
+function connectLive() {
+  const source = new EventSource("live");
+  source.addEventListener("status", (e) => {
+    $("connection").textContent = "live";
+    $("connection").className = "badge online";
+    updateLive(JSON.parse(e.data));
+  });
+  source.onerror = () => {
+    $("connection").textContent = "reconnecting";
+    $("connection").className = "badge offline";
+  };
+}
+
+// Redraw the charts as soon as a trade ends or a new plan is analyzed.
+function connectEvents() {
+  const events = new EventSource("../events?types=trade_end,plan");
+  events.addEventListener("trade_end", refreshAll);
+  events.addEventListener("plan", refreshAll);
+}
+
+// --- Price curves ---
+
+async function drawPrices(day, container) {
+  let data;
+  try {
+    data = await getJSON(`../prices?day=${day}`);
+  } catch (err) {
+    showEmpty(container, err.message);
+    return;
+  }
+  const slots = data.slots;
+  if (!slots.length) {
+    showEmpty(container, "no prices");
+    return;
+  }
+
+  const svg = newChart(container);
+  const from = new Date(slots[0].start).getTime();
+  const to = new Date(slots[slots.length - 1].end).getTime();
+  const prices = slots.map((s) => s.price_eur_kwh);
+  const min = Math.min(0, ...prices);
+  const max = Math.max(...prices);
+  const x = scale(from, to, CHART.left, CHART.width - CHART.right);
+  const y = scale(min, max, CHART.height - CHART.bottom, CHART.top);
+
+  hourAxis(svg, x, new Date(from), new Date(to), CHART.height);
+  valueAxis(svg, y, min, max, (v) => `€${v.toFixed(2)}`);
+
+  for (const s of slots) {
+    const x0 = x(new Date(s.start).getTime());
+    const x1 = x(new Date(s.end).getTime());
+    if (s.window) {
+      svg.append(el("rect", {
+        x: x0, width: x1 - x0, y: CHART.top, height: CHART.height - CHART.top - CHART.bottom,
+        fill: COLORS[s.window], opacity: 0.18,
+      }));
+    }
+    const fill = s.window ? COLORS[s.window] : COLORS.line;
+    const top = y(Math.max(0, s.price_eur_kwh));
+    const bottom = y(Math.min(0, s.price_eur_kwh));
+    svg.append(el("rect", { x: x0 + 0.5, width: Math.max(x1 - x0 - 1, 1), y: top, height: Math.max(bottom - top, 1), fill }));
+    if (s.current) {
+      svg.append(el("line", { x1: x0, x2: x0, y1: CHART.top, y2: CHART.height - CHART.bottom, stroke: COLORS.text, "stroke-width": 2 }));
+    }
+  }
+}
+
+// --- Today: SOC and battery power ---
+
+async function drawToday() {
+  const container = $("today");
+  const from = startOfDay();
+  const to = startOfDay(1);
+  let samples;
+  try {
+    samples = (await getJSON(`../telemetry?from=${from.toISOString()}&to=${new Date().toISOString()}&step=5m`)).samples;
+  } catch (err) {
+    todaySamples = [];
+    showEmpty(container, err.message);
+    return;
+  }
+  todaySamples = samples;
+  if (!samples.length) {
+    showEmpty(container, "no telemetry yet today");
+    return;
+  }
+
+  const svg = newChart(container);
+  const x = scale(from.getTime(), to.getTime(), CHART.left, CHART.width - CHART.right);
+  const ySOC = scale(0, 100, CHART.height - CHART.bottom, CHART.top);
+  const powers = samples.map((s) => num(s.bat_w)).filter((v) => v !== null);
+  const maxPower = Math.max(1000, ...powers.map(Math.abs));
+  const yPower = scale(-maxPower, maxPower, CHART.height - CHART.bottom, CHART.top);
+
+  hourAxis(svg, x, from, to, CHART.height);
+  valueAxis(svg, ySOC, 0, 100, (v) => `${v}%`);
+  valueAxis(svg, yPower, -maxPower, maxPower, (v) => fmtWatts(v), "right");
+  svg.append(el("line", { x1: CHART.left, x2: CHART.width - CHART.right, y1: yPower(0), y2: yPower(0), stroke: COLORS.grid }));
+
+  const path = (points) => points.map(([px, py], i) => `${i ? "L" : "M"}${px.toFixed(1)},${py.toFixed(1)}`).join("");
+  const power = samples.filter((s) => num(s.bat_w) !== null).map((s) => [x(new Date(s.t).getTime()), yPower(s.bat_w)]);
+  svg.append(el("path", { d: path(power), fill: "none", stroke: COLORS.discharge, "stroke-width": 1.5 }));
+  const soc = samples.map((s) => [x(new Date(s.t).getTime()), ySOC(s.soc)]);
+  svg.append(el("path", { d: path(soc), fill: "none", stroke: COLORS.line, "stroke-width": 2 }));
+}
+
+// --- Solar session timeline ---
+
+let todaySamples = [];
+
+// runningSolarStart returns when the running solar session started according
+// to today's telemetry, or null when none is running.
+function runningSolarStart() {
+  let start = null;
+  for (const s of todaySamples) {
+    if (s.state === "solar_charging") start = start ?? new Date(s.t).getTime();
+    else start = null;
+  }
+  return lastState === "solar_charging" ? start : null;
+}
+
+function drawSolar(trades) {
+  const container = $("solar");
+  const sessions = trades.filter((t) => t.action === "solar_charge");
+  const runningStart = runningSolarStart();
+  if (runningStart !== null) {
+    sessions.push({ running: true, start: runningStart });
+  }
+  if (!sessions.length) {
+    showEmpty(container, "no solar sessions today");
+    return;
+  }
+
+  const height = 48;
+  const svg = newChart(container, height);
+  const from = startOfDay();
+  const to = startOfDay(1);
+  const x = scale(from.getTime(), to.getTime(), CHART.left, CHART.width - CHART.right);
+  hourAxis(svg, x, from, to, height);
+
+  for (const s of sessions) {
+    let start, end;
+    if (s.running) {
+      // The running session is not a trade yet
+      start = s.start;
+      end = Date.now();
+    } else {
+      start = new Date(s.timestamp).getTime();
+      end = start + s.duration_s * 1000;
+    }
+    const rect = el("rect", {
+      x: x(start), width: Math.max(x(end) - x(start), 2), y: CHART.top, height: height - CHART.top - CHART.bottom,
+      fill: COLORS.solar, rx: 2,
+    });
+    const label = s.running ? `${fmtTime(start)}–now, solar charging` : `${fmtTime(start)}–${fmtTime(end)}, ${num(s.energy_kwh).toFixed(2)} kWh`;
+    rect.append(el("title", {}, label));
+    svg.append(rect);
+  }
+}
+
+// --- Trades and P&L ---
+
+function drawTrades(trades) {
+  const rows = trades.slice(-15).reverse().map((t) => {
+    const tr = document.createElement("tr");
+    const cells = [
+      new Date(t.timestamp).toLocaleString([], { weekday: "short", hour: "2-digit", minute: "2-digit" }),
+      t.action.replace("_", " "),
+      t.action === "solar_charge" ? "–" : `€${num(t.price_eur).toFixed(3)}`,
+      `${num(t.energy_kwh).toFixed(2)} kWh${t.partial ? " (partial)" : ""}`,
+      `${Math.round(t.duration_s / 60)} min`,
+      `${t.start_soc}% → ${t.end_soc}%`,
+    ];
+    cells.forEach((text, i) => {
+      const td = document.createElement("td");
+      td.textContent = text;
+      if (i === 1) td.className = t.action;
+      tr.append(td);
+    });
+    return tr;
+  });
+  $("trades").replaceChildren(...rows);
+}
+
+async function drawPnL() {
+  const container = $("pnl");
+  let days;
+  try {
+    days = (await getJSON(`../export/daily?from=${fmtDate(startOfDay(-29))}`)).summaries;
+  } catch (err) {
+    showEmpty(container, err.message);
+    return;
+  }
+  if (!days.length) {
+    showEmpty(container, "no trades yet");
+    $("pnl-total").textContent = "";
+    return;
+  }
+
+  const values = days.map((d) => num(d.pnl_eur));
+  const total = values.reduce((a, b) => a + b, 0);
+  $("pnl-total").textContent = `Total €${total.toFixed(2)} over ${days.length} trading days`;
+
+  const svg = newChart(container);
+  const min = Math.min(0, ...values);
+  const max = Math.max(0.01, ...values);
+  const y = scale(min, max, CHART.height - CHART.bottom, CHART.top);
+  const slot = (CHART.width - CHART.left - CHART.right) / 30;
+  valueAxis(svg, y, min, max, (v) => `€${v.toFixed(2)}`);
+  svg.append(el("line", { x1: CHART.left, x2: CHART.width - CHART.right, y1: y(0), y2: y(0), stroke: COLORS.grid }));
+
+  const first = startOfDay(-29).getTime();
+  days.forEach((d, i) => {
+    const index = Math.round((new Date(`${d.date}T00:00:00`).getTime() - first) / 86400000);
+    const px = CHART.left + index * slot;
+    const top = y(Math.max(0, values[i]));
+    const bar = el("rect", {
+      x: px + 1, width: Math.max(slot - 2, 1), y: top, height: Math.max(y(Math.min(0, values[i])) - top, 1),
+      fill: values[i] >= 0 ? COLORS.charge : COLORS.loss,
+    });
+    bar.append(el("title", {}, `${d.date}: €${values[i].toFixed(2)}`));
+    svg.append(bar);
+    if (index % 5 === 0) {
+      svg.append(el("text", { x: px + slot / 2, y: CHART.height - 6, "text-anchor": "middle" }, d.date.slice(5)));
+    }
+  });
+}
+
+async function drawTradeData() {
+  try {
+    const trades = (await getJSON(`../export/trades?from=${fmtDate(startOfDay(-6))}`)).trades;
+    drawTrades(trades);
+    const today = fmtDate(startOfDay());
+    drawSolar(trades.filter((t) => fmtDate(new Date(t.timestamp)) === today));
+  } catch (err) {
+    showEmpty($("solar"), err.message);
+  }
+}
+
+// --- Manual override ---
+
+async function setupControl() {
+  let config;
+  try {
+    config = await getJSON("config");
+  } catch {
+    return;
+  }
+  if (!config.control_enabled) return;
+  $("control").hidden = false;
+
+  const form = $("control-form");
+  form.token.value = sessionStorage.getItem("control-token") || "";
+  form.addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const action = form.action.value;
+    const body = {};
+    if (form.power_w.value) body.power_w = parseInt(form.power_w.value, 10);
+    if (form.duration_minutes.value) body.duration_minutes = parseInt(form.duration_minutes.value, 10);
+    const headers = { "Content-Type": "application/json" };
+    if (form.token.value) {
+      headers.Authorization = `Bearer ${form.token.value}`;
+      sessionStorage.setItem("control-token", form.token.value);
+    }
+
+    const result = $("control-result");
+    try {
+      const resp = await fetch(`../control/${action}`, { method: "POST", headers, body: JSON.stringify(body) });
+      const data = await resp.json().catch(() => ({}));
+      if (!resp.ok) throw new Error(data.error || resp.statusText);
+      result.textContent = `${action}: ${data.message || "ok"}`;
+      refreshAll();
+    } catch (err) {
+      result.textContent = `${action} failed: ${err.message}`;
+    }
+  });
+}
+
+// --- Refresh ---
+
+function refreshAll() {
+  drawPrices("today", $("prices-today"));
+  drawPrices("tomorrow", $("prices-tomorrow"));
+  drawPnL();
+  drawToday().then(drawTradeData); // the solar timeline uses today's telemetry
+}
+
+connectLive();
+connectEvents();
+setupControl();
+refreshAll();
+setInterval(refreshAll, REFRESH_MS);
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v11"
@@ -13,9 +15,22 @@ type Config struct {
 	ServiceName    string `env:"SERVICE_NAME" envDefault:"energy-trader"`
 	LogLevel       string `env:"LOG_LEVEL" envDefault:"info"`
 	HTTPListenAddr string `env:"HTTP_LISTEN_ADDR" envDefault:":8080"`
+	HTTPTLSCert    string `env:"HTTP_TLS_CERT"` // PEM certificate file, serves HTTPS together with HTTP_TLS_KEY
+	HTTPTLSKey     string `env:"HTTP_TLS_KEY"`  // PEM private key file
 	DataDir        string `env:"DATA_DIR" envDefault:"./data"`
 	TZ             string `env:"TZ" envDefault:"Europe/Amsterdam"`
 
+	// Control API: manual commands under /control, disabled without credentials
+	ControlTokens   []string `env:"CONTROL_TOKENS" envSeparator:","` // Accepted bearer tokens
+	ControlUser     string   `env:"CONTROL_USER"`                    // HTTP basic auth, with CONTROL_PASSWORD
+	ControlPassword string   `env:"CONTROL_PASSWORD"`
+
+	// Telemetry: control loop samples in DATA_DIR/telemetry, downsampled to 1 min after a day
+	TelemetryRetentionDays int `env:"TELEMETRY_RETENTION_DAYS" envDefault:"90"` // 0 = disabled
+
+	// Battery command journal: daily files in DATA_DIR/commands
+	CommandRetentionDays int `env:"COMMAND_RETENTION_DAYS" envDefault:"90"` // 0 = keep all
+
 	// NordPool
 	NordPoolArea     string `env:"NORDPOOL_AREA" envDefault:"NL"`
 	NordPoolCurrency string `env:"NORDPOOL_CURRENCY" envDefault:"EUR"`
@@ -27,16 +42,58 @@ type Config struct {
 	BatteryMinSOC      float64 `env:"BATTERY_MIN_SOC" envDefault:"0.11"`
 	MaxCyclesPerDay    int     `env:"MAX_CYCLES_PER_DAY" envDefault:"2"`
 
+	// P&L: cost basis of stored energy, booked when it is discharged
+	PnLCostBasis   string `env:"PNL_COST_BASIS" envDefault:"fifo"`  // "fifo" or "average"
+	SolarValuation string `env:"SOLAR_VALUATION" envDefault:"zero"` // Solar energy cost: "zero" or "export" (spot price)
+
+	// Net metering (salderingsregeling): exports offset imports at the retail price up to the yearly import total
+	NetMeteringEnd        string  `env:"NET_METERING_END" envDefault:"2027-01-01"` // First day without netting (YYYY-MM-DD)
+	ImportSurchargeEURKWh float64 `env:"IMPORT_SURCHARGE_EUR_KWH"`                 // Energy tax, supplier margin and VAT on top of the spot price
+	FeedInFeeEURKWh       float64 `env:"FEED_IN_FEE_EUR_KWH"`                      // Deducted from the spot price for exports that are not netted
+
 	// Battery
+	BatteryBackend      string `env:"BATTERY_BACKEND" envDefault:"esphome"`         // "esphome" (REST bridge) or "marstek" (local UDP API)
 	BatteryUDPAddr      string `env:"BATTERY_UDP_ADDR"`                             // No default (optional, for UDP client)
 	ESPHomeURL          string `env:"ESPHOME_URL" envDefault:"http://192.168.1.50"` // ESPHome REST API
+	ESPHomeProfile      string `env:"ESPHOME_PROFILE" envDefault:"default"`         // Built-in entity profile
+	ESPHomeEntityMap    string `env:"ESPHOME_ENTITY_MAP"`                           // Optional JSON file overriding profile entities
 	ChargePowerW        int    `env:"CHARGE_POWER_W" envDefault:"2500"`
 	DischargePowerW     int    `env:"DISCHARGE_POWER_W" envDefault:"2500"`
 	PassiveModeTimeoutS int    `env:"PASSIVE_MODE_TIMEOUT_S" envDefault:"300"`
+	WatchdogIntervalS   int    `env:"WATCHDOG_INTERVAL_S" envDefault:"30"`   // Drift watchdog interval, 0 = disabled
+	WatchdogAction      string `env:"WATCHDOG_ACTION" envDefault:"reassert"` // "reassert" or "idle"
+
+	// Fuse protection (requires a meter that reports per-phase values)
+	MainFuseA         int `env:"MAIN_FUSE_A"`                  // Main fuse rating per phase in amps, 0 = disabled
+	BatteryPhase      int `env:"BATTERY_PHASE" envDefault:"1"` // Phase (1-3) the battery is connected to
+	PhaseFeedInLimitW int `env:"PHASE_FEED_IN_LIMIT_W"`        // Max export per phase in watts, 0 = fuse rating only
+
+	// Network discovery: IPv4 CIDRs to scan, empty = the host's interface subnets
+	DiscoverySubnets     []string `env:"DISCOVERY_SUBNETS" envSeparator:","`
+	RediscoveryIntervalS int      `env:"REDISCOVERY_INTERVAL_S" envDefault:"60"` // Meter/battery health check interval, 0 = disabled
+
+	// Grid meter backend: "auto" (DSMR when DSMR_SOURCE is set, else HomeWizard), "homewizard", "shelly", "dsmr" or "marstek"
+	MeterBackend string `env:"METER_BACKEND" envDefault:"auto"`
 
 	// HomeWizard P1 meter (optional)
-	HomeWizardP1URL string `env:"HOMEWIZARD_P1_URL"`              // Empty = disabled
-	SolarMinSurplusW int   `env:"SOLAR_MIN_SURPLUS_W" envDefault:"100"` // Min surplus watts to start solar charging
+	HomeWizardP1URL  string `env:"HOMEWIZARD_P1_URL"`                     // Empty = disabled
+	HomeWizardAPI    string `env:"HOMEWIZARD_API" envDefault:"v1"`        // "v1" (HTTP) or "v2" (HTTPS, token pairing, websocket)
+	HomeWizardCAFile string `env:"HOMEWIZARD_CA_FILE"`                    // v2: HomeWizard's CA (PEM) to verify the device certificate
+	HomeWizardSerial string `env:"HOMEWIZARD_P1_SERIAL"`                  // v2: device serial, empty = from discovery
+	HomeWizardPinTLS bool   `env:"HOMEWIZARD_TLS_PIN" envDefault:"false"` // v2 without CA: trust the first certificate and pin it
+	SolarMinSurplusW int    `env:"SOLAR_MIN_SURPLUS_W" envDefault:"100"`  // Min surplus watts to start solar charging
+
+	// PV production meter (optional): "none", "homewizard" (kWh meter on the inverter circuit) or "marstek" (PV.GetStatus)
+	PVMeterBackend string `env:"PV_METER_BACKEND" envDefault:"none"`
+	PVMeterURL     string `env:"PV_METER_URL"` // HomeWizard kWh meter URL
+
+	// Shelly Pro 3EM / EM meter (METER_BACKEND=shelly)
+	ShellyURL         string `env:"SHELLY_URL"`                                          // Empty = mDNS discovery
+	ShellyEM1Channels []int  `env:"SHELLY_EM1_CHANNELS" envDefault:"0" envSeparator:","` // Grid channels on single-meter devices
+
+	// Raw DSMR P1 meter (optional, replaces the HomeWizard P1 meter when set)
+	DSMRSource string `env:"DSMR_SOURCE"`                   // Serial device or host:port, empty = disabled
+	DSMRBaud   int    `env:"DSMR_BAUD" envDefault:"115200"` // 115200 (DSMR 4/5) or 9600 (DSMR 2.2/3)
 
 	// Telegram (optional)
 	TelegramBotToken string `env:"TELEGRAM_BOT_TOKEN"`
@@ -63,12 +120,132 @@ func (c *Config) validate() error {
 	if c.BatteryMinSOC < 0 || c.BatteryMinSOC >= 1.0 {
 		return fmt.Errorf("BATTERY_MIN_SOC must be in [0.0, 1.0), got %f", c.BatteryMinSOC)
 	}
+	switch c.WatchdogAction {
+	case "", "reassert", "idle":
+	default:
+		return fmt.Errorf("WATCHDOG_ACTION must be reassert or idle, got %q", c.WatchdogAction)
+	}
+	switch c.BatteryBackend {
+	case "", "esphome", "marstek":
+	default:
+		return fmt.Errorf("BATTERY_BACKEND must be esphome or marstek, got %q", c.BatteryBackend)
+	}
+	if c.BatteryBackend == "marstek" && c.BatteryUDPAddr == "" {
+		return fmt.Errorf("BATTERY_BACKEND=marstek requires BATTERY_UDP_ADDR")
+	}
+	switch c.MeterBackend {
+	case "", "auto", "homewizard", "shelly", "dsmr", "marstek":
+	default:
+		return fmt.Errorf("METER_BACKEND must be auto, homewizard, shelly, dsmr or marstek, got %q", c.MeterBackend)
+	}
+	if c.MeterBackend == "dsmr" && c.DSMRSource == "" {
+		return fmt.Errorf("METER_BACKEND=dsmr requires DSMR_SOURCE")
+	}
+	if c.MeterBackend == "marstek" && c.BatteryUDPAddr == "" {
+		return fmt.Errorf("METER_BACKEND=marstek requires BATTERY_UDP_ADDR")
+	}
+	switch c.PVMeterBackend {
+	case "", "none", "homewizard", "marstek":
+	default:
+		return fmt.Errorf("PV_METER_BACKEND must be none, homewizard or marstek, got %q", c.PVMeterBackend)
+	}
+	if c.PVMeterBackend == "homewizard" && c.PVMeterURL == "" {
+		return fmt.Errorf("PV_METER_BACKEND=homewizard requires PV_METER_URL")
+	}
+	if c.PVMeterBackend == "marstek" && c.BatteryUDPAddr == "" {
+		return fmt.Errorf("PV_METER_BACKEND=marstek requires BATTERY_UDP_ADDR")
+	}
+	switch c.PnLCostBasis {
+	case "", "fifo", "average":
+	default:
+		return fmt.Errorf("PNL_COST_BASIS must be fifo or average, got %q", c.PnLCostBasis)
+	}
+	switch c.SolarValuation {
+	case "", "zero", "export":
+	default:
+		return fmt.Errorf("SOLAR_VALUATION must be zero or export, got %q", c.SolarValuation)
+	}
+	if c.NetMeteringEnd != "" {
+		if _, err := time.Parse("2006-01-02", c.NetMeteringEnd); err != nil {
+			return fmt.Errorf("NET_METERING_END must be a date in YYYY-MM-DD format, got %q", c.NetMeteringEnd)
+		}
+	}
+	if c.ImportSurchargeEURKWh < 0 {
+		return fmt.Errorf("IMPORT_SURCHARGE_EUR_KWH must be >= 0, got %f", c.ImportSurchargeEURKWh)
+	}
+	if c.FeedInFeeEURKWh < 0 {
+		return fmt.Errorf("FEED_IN_FEE_EUR_KWH must be >= 0, got %f", c.FeedInFeeEURKWh)
+	}
+	switch c.HomeWizardAPI {
+	case "", "v1", "v2":
+	default:
+		return fmt.Errorf("HOMEWIZARD_API must be v1 or v2, got %q", c.HomeWizardAPI)
+	}
+	if c.HomeWizardAPI == "v2" && c.HomeWizardCAFile == "" && !c.HomeWizardPinTLS {
+		return fmt.Errorf("HOMEWIZARD_API=v2 requires HOMEWIZARD_CA_FILE (or HOMEWIZARD_TLS_PIN=true to pin the certificate on first use)")
+	}
+	if c.HomeWizardAPI == "v2" && c.HomeWizardCAFile != "" && c.HomeWizardP1URL != "" && c.HomeWizardSerial == "" {
+		return fmt.Errorf("HOMEWIZARD_CA_FILE with HOMEWIZARD_P1_URL requires HOMEWIZARD_P1_SERIAL")
+	}
+	for _, cidr := range c.DiscoverySubnets {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil || !prefix.Addr().Is4() {
+			return fmt.Errorf("DISCOVERY_SUBNETS must be IPv4 CIDRs such as 10.0.0.0/24, got %q", cidr)
+		}
+		if prefix.Bits() < 20 {
+			return fmt.Errorf("DISCOVERY_SUBNETS entry %q is too large to scan, use /20 or smaller", cidr)
+		}
+	}
+	if c.TelemetryRetentionDays < 0 {
+		return fmt.Errorf("TELEMETRY_RETENTION_DAYS must be >= 0, got %d", c.TelemetryRetentionDays)
+	}
+	if c.CommandRetentionDays < 0 {
+		return fmt.Errorf("COMMAND_RETENTION_DAYS must be >= 0, got %d", c.CommandRetentionDays)
+	}
+	if c.RediscoveryIntervalS < 0 {
+		return fmt.Errorf("REDISCOVERY_INTERVAL_S must be >= 0, got %d", c.RediscoveryIntervalS)
+	}
+	if c.MainFuseA < 0 {
+		return fmt.Errorf("MAIN_FUSE_A must be >= 0, got %d", c.MainFuseA)
+	}
+	if c.BatteryPhase < 0 || c.BatteryPhase > 3 {
+		return fmt.Errorf("BATTERY_PHASE must be 1, 2 or 3, got %d", c.BatteryPhase)
+	}
+	switch c.DSMRBaud {
+	case 0, 9600, 115200:
+	default:
+		return fmt.Errorf("DSMR_BAUD must be 115200 or 9600, got %d", c.DSMRBaud)
+	}
 	if c.MinPriceSpread < 0 {
 		return fmt.Errorf("MIN_PRICE_SPREAD must be >= 0, got %f", c.MinPriceSpread)
 	}
+	if (c.HTTPTLSCert == "") != (c.HTTPTLSKey == "") {
+		return fmt.Errorf("HTTP_TLS_CERT and HTTP_TLS_KEY must be set together")
+	}
+	for _, token := range c.ControlTokens {
+		if len(strings.TrimSpace(token)) < minControlTokenLength {
+			return fmt.Errorf("CONTROL_TOKENS entries must be at least %d characters", minControlTokenLength)
+		}
+	}
+	if (c.ControlUser == "") != (c.ControlPassword == "") {
+		return fmt.Errorf("CONTROL_USER and CONTROL_PASSWORD must be set together")
+	}
 	return nil
 }
 
+// minControlTokenLength is the shortest accepted control API bearer token.
+const minControlTokenLength = 16
+
+// ControlEnabled returns true if the control API has credentials configured.
+func (c *Config) ControlEnabled() bool {
+	return len(c.ControlTokens) > 0 || c.ControlUser != ""
+}
+
+// TLSEnabled returns true if the HTTP server serves HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.HTTPTLSCert != ""
+}
+
 // TelegramEnabled returns true if Telegram notifications are configured.
 func (c *Config) TelegramEnabled() bool {
 	return c.TelegramBotToken != "" && c.TelegramChatID != ""
@@ -82,3 +259,13 @@ func (c *Config) Location() *time.Location {
 	}
 	return loc
 }
+
+// NetMeteringEndTime returns the start of the first day without net metering
+// in the configured timezone, or the zero time when it is not configured.
+func (c *Config) NetMeteringEndTime() time.Time {
+	end, err := time.ParseInLocation("2006-01-02", c.NetMeteringEnd, c.Location())
+	if err != nil {
+		return time.Time{}
+	}
+	return end
+}
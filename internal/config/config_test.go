@@ -173,3 +173,233 @@ func TestLoad_CustomValues(t *testing.T) {
 		t.Errorf("SolarMinSurplusW = %d, want 200", cfg.SolarMinSurplusW)
 	}
 }
+
+func TestValidate_MeterBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		source  string
+		wantErr bool
+	}{
+		{"auto", "auto", "", false},
+		{"shelly", "shelly", "", false},
+		{"dsmr with source", "dsmr", "/dev/ttyUSB0", false},
+		{"dsmr without source", "dsmr", "", true},
+		{"marstek without address", "marstek", "", true},
+		{"unknown", "p1ib", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{BatteryEfficiency: 0.90, BatteryMinSOC: 0.11, MeterBackend: tt.backend, DSMRSource: tt.source}
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_BatteryBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"esphome", Config{BatteryBackend: "esphome"}, false},
+		{"marstek with address", Config{BatteryBackend: "marstek", BatteryUDPAddr: "10.0.0.40:30000"}, false},
+		{"marstek without address", Config{BatteryBackend: "marstek"}, true},
+		{"unknown", Config{BatteryBackend: "modbus"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_PVMeterBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"none", Config{PVMeterBackend: "none"}, false},
+		{"homewizard with URL", Config{PVMeterBackend: "homewizard", PVMeterURL: "http://10.0.0.13"}, false},
+		{"homewizard without URL", Config{PVMeterBackend: "homewizard"}, true},
+		{"marstek with address", Config{PVMeterBackend: "marstek", BatteryUDPAddr: "10.0.0.40:30000"}, false},
+		{"marstek without address", Config{PVMeterBackend: "marstek"}, true},
+		{"unknown", Config{PVMeterBackend: "shelly"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_HomeWizardV2Trust(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"v1", Config{HomeWizardAPI: "v1"}, false},
+		{"v2 with CA, discovered", Config{HomeWizardAPI: "v2", HomeWizardCAFile: "/data/homewizard-ca.pem"}, false},
+		{"v2 with CA and serial", Config{HomeWizardAPI: "v2", HomeWizardCAFile: "/data/homewizard-ca.pem", HomeWizardP1URL: "https://10.0.0.12", HomeWizardSerial: "5c2fafabcdef"}, false},
+		{"v2 with CA, URL without serial", Config{HomeWizardAPI: "v2", HomeWizardCAFile: "/data/homewizard-ca.pem", HomeWizardP1URL: "https://10.0.0.12"}, true},
+		{"v2 pinning", Config{HomeWizardAPI: "v2", HomeWizardPinTLS: true, HomeWizardP1URL: "https://10.0.0.12"}, false},
+		{"v2 without CA or pinning", Config{HomeWizardAPI: "v2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_PnLValuation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"defaults", Config{PnLCostBasis: "fifo", SolarValuation: "zero"}, false},
+		{"average and export", Config{PnLCostBasis: "average", SolarValuation: "export"}, false},
+		{"unknown cost basis", Config{PnLCostBasis: "lifo"}, true},
+		{"unknown solar valuation", Config{SolarValuation: "import"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_NetMetering(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"default end date", Config{NetMeteringEnd: "2027-01-01"}, false},
+		{"surcharge and fee", Config{NetMeteringEnd: "2027-01-01", ImportSurchargeEURKWh: 0.15, FeedInFeeEURKWh: 0.02}, false},
+		{"invalid end date", Config{NetMeteringEnd: "01-01-2027"}, true},
+		{"negative surcharge", Config{ImportSurchargeEURKWh: -0.1}, true},
+		{"negative fee", Config{FeedInFeeEURKWh: -0.01}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetMeteringEndTime(t *testing.T) {
+	cfg := Config{TZ: "Europe/Amsterdam", NetMeteringEnd: "2027-01-01"}
+	end := cfg.NetMeteringEndTime()
+	if want := time.Date(2027, 1, 1, 0, 0, 0, 0, cfg.Location()); !end.Equal(want) {
+		t.Errorf("NetMeteringEndTime() = %v, want %v", end, want)
+	}
+	if !(&Config{}).NetMeteringEndTime().IsZero() {
+		t.Error("expected zero time without an end date")
+	}
+}
+
+func TestValidate_ControlAPI(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+		enabled bool
+	}{
+		{"disabled", Config{}, false, false},
+		{"bearer tokens", Config{ControlTokens: []string{"0123456789abcdef", "fedcba9876543210"}}, false, true},
+		{"short token", Config{ControlTokens: []string{"secret"}}, true, true},
+		{"basic auth", Config{ControlUser: "admin", ControlPassword: "hunter2"}, false, true},
+		{"user without password", Config{ControlUser: "admin"}, true, true},
+		{"tls", Config{HTTPTLSCert: "cert.pem", HTTPTLSKey: "key.pem"}, false, false},
+		{"tls cert without key", Config{HTTPTLSCert: "cert.pem"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.BatteryEfficiency, cfg.BatteryMinSOC = 0.90, 0.11
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cfg.ControlEnabled() != tt.enabled {
+				t.Errorf("ControlEnabled() = %v, want %v", cfg.ControlEnabled(), tt.enabled)
+			}
+		})
+	}
+}
+
+func TestLoad_ShellyEM1Channels(t *testing.T) {
+	t.Setenv("METER_BACKEND", "shelly")
+	t.Setenv("SHELLY_EM1_CHANNELS", "0,1")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.ShellyEM1Channels) != 2 || cfg.ShellyEM1Channels[1] != 1 {
+		t.Errorf("ShellyEM1Channels = %v, want [0 1]", cfg.ShellyEM1Channels)
+	}
+}
+
+func TestValidate_DiscoverySubnets(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnets []string
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"home networks", []string{"10.0.0.0/24", "172.16.0.0/22"}, false},
+		{"no prefix length", []string{"10.0.0.0"}, true},
+		{"too large", []string{"10.0.0.0/8"}, true},
+		{"ipv6", []string{"fd00::/64"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{BatteryEfficiency: 0.90, BatteryMinSOC: 0.11, DiscoverySubnets: tt.subnets}
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
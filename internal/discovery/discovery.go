@@ -0,0 +1,370 @@
+// Package discovery finds the trader's devices on the local network: HomeWizard
+// energy meters, ESPHome bridges exposing the Marstek battery entities, and
+// Marstek batteries answering on the UDP API.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/esphome"
+	"github.com/foae/marstek-energy-trading/clients/homewizard"
+	"github.com/foae/marstek-energy-trading/clients/marstek"
+)
+
+const (
+	// HTTP scan settings
+	scanConnectTimeout = 500 * time.Millisecond
+	scanReadTimeout    = 2 * time.Second
+	scanWorkers        = 64
+
+	marstekPort = 30000
+	marstekWait = 3 * time.Second // how long to collect broadcast replies
+)
+
+// Kind is the type of a discovered device.
+type Kind string
+
+const (
+	KindHomeWizard Kind = "homewizard"
+	KindESPHome    Kind = "esphome"
+	KindMarstek    Kind = "marstek"
+)
+
+// kindOrder is the order devices are listed in.
+var kindOrder = []Kind{KindHomeWizard, KindESPHome, KindMarstek}
+
+// Device is a device found on the network.
+type Device struct {
+	Kind     Kind
+	Model    string // HomeWizard product type or Marstek device type
+	Name     string
+	URL      string // HTTP devices
+	Addr     string // Marstek UDP host:port
+	Serial   string
+	MAC      string
+	Hostname string
+	Profile  string   // ESPHome entity profile, empty if the device has no Marstek entities
+	Methods  []string // how the device was found: "mdns", "http_scan", "udp_broadcast"
+}
+
+// Host returns the device's IP address (or host name).
+func (d Device) Host() string {
+	if d.Addr != "" {
+		host, _, err := net.SplitHostPort(d.Addr)
+		if err == nil {
+			return host
+		}
+		return d.Addr
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL
+	}
+	return u.Hostname()
+}
+
+// Summary describes the device in one line.
+func (d Device) Summary() string {
+	var b strings.Builder
+	switch d.Kind {
+	case KindHomeWizard:
+		fmt.Fprintf(&b, "HomeWizard %s", d.Model)
+	case KindESPHome:
+		fmt.Fprintf(&b, "ESPHome %q", d.Name)
+	case KindMarstek:
+		fmt.Fprintf(&b, "Marstek %s", d.Model)
+	}
+	fmt.Fprintf(&b, " at %s", d.Host())
+	for _, kv := range [][2]string{{"serial", d.Serial}, {"mac", d.MAC}, {"hostname", d.Hostname}} {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, " %s=%s", kv[0], kv[1])
+		}
+	}
+	switch {
+	case d.Kind == KindHomeWizard && d.Model != "HWE-P1":
+		b.WriteString(" (kWh meter, not used by the trader)")
+	case d.Kind == KindESPHome && d.Profile == "":
+		b.WriteString(" (no Marstek entities)")
+	}
+	fmt.Fprintf(&b, " [%s]", strings.Join(d.Methods, ", "))
+	return b.String()
+}
+
+// ConfigLines returns the .env lines that configure the trader for this device,
+// or nil if the trader cannot use it.
+func (d Device) ConfigLines() []string {
+	switch {
+	case d.Kind == KindHomeWizard && d.Model == "HWE-P1":
+		return []string{"HOMEWIZARD_P1_URL=" + d.URL}
+	case d.Kind == KindESPHome && d.Profile != "":
+		return []string{"ESPHOME_URL=" + d.URL, "ESPHOME_PROFILE=" + d.Profile}
+	case d.Kind == KindMarstek:
+		return []string{"BATTERY_UDP_ADDR=" + d.Addr}
+	}
+	return nil
+}
+
+// Scan searches the subnets for devices using mDNS, an HTTP scan of every host
+// and a Marstek UDP broadcast, all running concurrently until they finish or ctx
+// is done. Only the methods that can find the given kinds run (all kinds when
+// none are given). Devices found by several methods are merged. The error joins
+// the failures of individual methods; devices found by the others are still returned.
+func Scan(ctx context.Context, subnets []netip.Prefix, kinds ...Kind) ([]Device, error) {
+	want := func(k Kind) bool { return len(kinds) == 0 || slices.Contains(kinds, k) }
+	httpClient := newScanClient()
+	found := newDeviceSet()
+	var (
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	run := func(name string, fn func() ([]Device, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			devices, err := fn()
+			if err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				errsMu.Unlock()
+			}
+			found.add(devices...)
+		}()
+	}
+
+	if want(KindHomeWizard) {
+		run("HomeWizard mDNS", func() ([]Device, error) { return browseHomeWizard(ctx) })
+	}
+	if want(KindESPHome) {
+		run("ESPHome mDNS", func() ([]Device, error) { return browseESPHome(ctx, httpClient) })
+	}
+	if (want(KindHomeWizard) || want(KindESPHome)) && len(subnets) > 0 {
+		run("HTTP scan", func() ([]Device, error) { return scanHTTP(ctx, httpClient, Hosts(subnets)), nil })
+	}
+	if want(KindMarstek) && len(subnets) > 0 {
+		run("Marstek UDP broadcast", func() ([]Device, error) { return broadcastMarstek(ctx, Broadcasts(subnets)) })
+	}
+	wg.Wait()
+
+	devices := slices.DeleteFunc(found.list(), func(d Device) bool { return !want(d.Kind) })
+	return devices, errors.Join(errs...)
+}
+
+func newScanClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: scanConnectTimeout,
+			}).DialContext,
+			DisableKeepAlives: true,
+		},
+		Timeout: scanReadTimeout,
+	}
+}
+
+func browseHomeWizard(ctx context.Context) ([]Device, error) {
+	results, err := homewizard.Browse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(results))
+	for _, r := range results {
+		devices = append(devices, Device{
+			Kind:     KindHomeWizard,
+			Model:    r.ProductType,
+			URL:      r.URL,
+			Serial:   r.Serial,
+			Hostname: r.Hostname,
+			Methods:  []string{r.Method},
+		})
+	}
+	return devices, nil
+}
+
+// browseESPHome lists the ESPHome devices answering over mDNS and probes each
+// for the Marstek entities.
+func browseESPHome(ctx context.Context, client *http.Client) ([]Device, error) {
+	results, err := esphome.Browse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(results))
+	for _, r := range results {
+		profile, err := esphome.DetectProfile(ctx, client, r.URL)
+		if err != nil && !errors.Is(err, esphome.ErrNoMarstekEntities) {
+			slog.Debug("ESPHome device did not answer the entity probe", "url", r.URL, "error", err)
+		}
+		devices = append(devices, Device{
+			Kind:     KindESPHome,
+			Name:     r.Name,
+			URL:      r.URL,
+			MAC:      r.MAC,
+			Hostname: r.Hostname,
+			Profile:  profile,
+			Methods:  []string{r.Method},
+		})
+	}
+	return devices, nil
+}
+
+// scanHTTP probes every host (IP or host:port) for a HomeWizard meter and, on
+// hosts that run another HTTP server, for an ESPHome bridge with the Marstek
+// entities. It uses a worker pool for concurrency.
+func scanHTTP(ctx context.Context, client *http.Client, hosts []string) []Device {
+	slog.Debug("starting HTTP scan", "total_ips", len(hosts))
+
+	hostCh := make(chan string, len(hosts))
+	for _, host := range hosts {
+		hostCh <- host
+	}
+	close(hostCh)
+
+	var (
+		mu      sync.Mutex
+		devices []Device
+		wg      sync.WaitGroup
+	)
+	for range scanWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hostCh {
+				if ctx.Err() != nil {
+					return
+				}
+				if d := probeHost(ctx, client, host); d != nil {
+					mu.Lock()
+					devices = append(devices, *d)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return devices
+}
+
+// probeHost identifies the device at host, or returns nil.
+func probeHost(ctx context.Context, client *http.Client, host string) *Device {
+	info, err := homewizard.Probe(ctx, client, host)
+	if err == nil {
+		return &Device{
+			Kind:    KindHomeWizard,
+			Model:   info.ProductType,
+			Name:    info.ProductName,
+			URL:     "http://" + host,
+			Serial:  info.Serial,
+			Methods: []string{"http_scan"},
+		}
+	}
+	// Transport errors mean nothing answers HTTP on this host.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return nil
+	}
+
+	baseURL := "http://" + host
+	profile, err := esphome.DetectProfile(ctx, client, baseURL)
+	if err != nil {
+		return nil
+	}
+	return &Device{
+		Kind:    KindESPHome,
+		URL:     baseURL,
+		Profile: profile,
+		Methods: []string{"http_scan"},
+	}
+}
+
+func broadcastMarstek(ctx context.Context, addrs []string) ([]Device, error) {
+	targets := make([]string, len(addrs))
+	for i, addr := range addrs {
+		targets[i] = net.JoinHostPort(addr, strconv.Itoa(marstekPort))
+	}
+	ctx, cancel := context.WithTimeout(ctx, marstekWait)
+	defer cancel()
+	infos, err := marstek.Broadcast(ctx, targets)
+	devices := make([]Device, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, Device{
+			Kind:    KindMarstek,
+			Model:   info.Device,
+			Addr:    net.JoinHostPort(info.IP, strconv.Itoa(marstekPort)),
+			MAC:     info.WiFiMAC,
+			Methods: []string{"udp_broadcast"},
+		})
+	}
+	return devices, err
+}
+
+// deviceSet merges devices found by several methods, keyed by kind and host.
+type deviceSet struct {
+	mu      sync.Mutex
+	devices map[string]*Device
+}
+
+func newDeviceSet() *deviceSet {
+	return &deviceSet{devices: make(map[string]*Device)}
+}
+
+func (s *deviceSet) add(devices ...Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range devices {
+		key := string(d.Kind) + "/" + d.Host()
+		existing, ok := s.devices[key]
+		if !ok {
+			d.Methods = slices.Clone(d.Methods)
+			s.devices[key] = &d
+			continue
+		}
+		for _, f := range []struct{ dst, src *string }{
+			{&existing.Model, &d.Model}, {&existing.Name, &d.Name}, {&existing.URL, &d.URL},
+			{&existing.Addr, &d.Addr}, {&existing.Serial, &d.Serial}, {&existing.MAC, &d.MAC},
+			{&existing.Hostname, &d.Hostname}, {&existing.Profile, &d.Profile},
+		} {
+			if *f.dst == "" {
+				*f.dst = *f.src
+			}
+		}
+		for _, m := range d.Methods {
+			if !slices.Contains(existing.Methods, m) {
+				existing.Methods = append(existing.Methods, m)
+			}
+		}
+	}
+}
+
+// list returns the devices ordered by kind, then address.
+func (s *deviceSet) list() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devices := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, *d)
+	}
+	slices.SortFunc(devices, func(a, b Device) int {
+		if c := slices.Index(kindOrder, a.Kind) - slices.Index(kindOrder, b.Kind); c != 0 {
+			return c
+		}
+		ha, errA := netip.ParseAddr(a.Host())
+		hb, errB := netip.ParseAddr(b.Host())
+		if errA == nil && errB == nil {
+			return ha.Compare(hb)
+		}
+		return strings.Compare(a.Host(), b.Host())
+	})
+	return devices
+}
@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/foae/marstek-energy-trading/clients/homewizard"
+)
+
+func serverHost(url string) string {
+	return strings.TrimPrefix(url, "http://")
+}
+
+func TestScanHTTP(t *testing.T) {
+	p1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(homewizard.DeviceInfo{ProductName: "P1 Meter", ProductType: "HWE-P1", Serial: "5c2fafabcdef"})
+	}))
+	defer p1.Close()
+
+	// An ESPHome bridge running the default Marstek YAML.
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sensor/Battery State Of Charge", "/sensor/Battery Power", "/text_sensor/Device Name",
+			"/number/Forcible Charge Power", "/number/Forcible Discharge Power",
+			"/select/RS485 Control Mode", "/select/Forcible Charge⁄Discharge":
+			w.Write([]byte(`{"value":1,"state":"1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer bridge.Close()
+
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	hosts := []string{serverHost(p1.URL), serverHost(bridge.URL), serverHost(other.URL), serverHost(closed.URL)}
+	set := newDeviceSet()
+	set.add(scanHTTP(context.Background(), newScanClient(), hosts)...)
+	devices := set.list()
+
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2: %+v", len(devices), devices)
+	}
+	if d := devices[0]; d.Kind != KindHomeWizard || d.Model != "HWE-P1" || d.Serial != "5c2fafabcdef" || d.URL != p1.URL {
+		t.Errorf("devices[0] = %+v, want the P1 meter", d)
+	}
+	if d := devices[1]; d.Kind != KindESPHome || d.Profile != "default" || d.URL != bridge.URL {
+		t.Errorf("devices[1] = %+v, want the ESPHome bridge with the default profile", d)
+	}
+}
+
+func TestDeviceSet_MergesMethods(t *testing.T) {
+	set := newDeviceSet()
+	set.add(
+		Device{Kind: KindHomeWizard, Model: "HWE-P1", URL: "http://10.0.0.12:80", Hostname: "p1meter-abcdef.local.", Methods: []string{"mdns"}},
+		Device{Kind: KindMarstek, Model: "VenusE", Addr: "10.0.0.40:30000", Methods: []string{"udp_broadcast"}},
+		Device{Kind: KindHomeWizard, Model: "HWE-P1", Name: "P1 Meter", URL: "http://10.0.0.12", Serial: "5c2fafabcdef", Methods: []string{"http_scan"}},
+		Device{Kind: KindESPHome, Name: "Marstek", URL: "http://10.0.0.9", Methods: []string{"mdns"}},
+	)
+	devices := set.list()
+	if len(devices) != 3 {
+		t.Fatalf("got %d devices, want 3: %+v", len(devices), devices)
+	}
+	kinds := []Kind{devices[0].Kind, devices[1].Kind, devices[2].Kind}
+	if !slices.Equal(kinds, kindOrder) {
+		t.Errorf("kinds = %v, want %v", kinds, kindOrder)
+	}
+	p1 := devices[0]
+	if p1.Serial != "5c2fafabcdef" || p1.Hostname != "p1meter-abcdef.local." || !slices.Equal(p1.Methods, []string{"mdns", "http_scan"}) {
+		t.Errorf("merged P1 = %+v", p1)
+	}
+}
+
+func TestDevice_ConfigLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		device Device
+		want   []string
+	}{
+		{"P1 meter", Device{Kind: KindHomeWizard, Model: "HWE-P1", URL: "http://10.0.0.12"}, []string{"HOMEWIZARD_P1_URL=http://10.0.0.12"}},
+		{"kWh meter", Device{Kind: KindHomeWizard, Model: "HWE-KWH3", URL: "http://10.0.0.13"}, nil},
+		{"ESPHome bridge", Device{Kind: KindESPHome, URL: "http://172.16.0.30", Profile: "default"}, []string{"ESPHOME_URL=http://172.16.0.30", "ESPHOME_PROFILE=default"}},
+		{"other ESPHome device", Device{Kind: KindESPHome, URL: "http://172.16.0.31"}, nil},
+		{"Marstek battery", Device{Kind: KindMarstek, Addr: "10.0.0.40:30000"}, []string{"BATTERY_UDP_ADDR=10.0.0.40:30000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.device.ConfigLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("ConfigLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_Summary(t *testing.T) {
+	d := Device{Kind: KindHomeWizard, Model: "HWE-KWH1", URL: "http://10.0.0.13", Serial: "abc", Methods: []string{"mdns", "http_scan"}}
+	want := "HomeWizard HWE-KWH1 at 10.0.0.13 serial=abc (kWh meter, not used by the trader) [mdns, http_scan]"
+	if got := d.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
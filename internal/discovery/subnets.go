@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+const (
+	// MinPrefixBits bounds the size of a scanned subnet: a /20 has 4094 hosts.
+	MinPrefixBits = 20
+	// Interface subnets larger than a /24 are narrowed to the host's own /24.
+	interfacePrefixBits = 24
+)
+
+// virtualInterfaces are name prefixes of container and VM bridges, which never host devices.
+var virtualInterfaces = []string{"docker", "veth", "br-", "virbr", "cni", "flannel"}
+
+// ParseSubnets parses IPv4 CIDRs such as "10.0.0.0/24". Host bits are cleared.
+func ParseSubnets(cidrs []string) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+		}
+		if !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid subnet %q: only IPv4 is supported", cidr)
+		}
+		if prefix.Bits() < MinPrefixBits {
+			return nil, fmt.Errorf("subnet %q is too large to scan: use /%d or smaller", cidr, MinPrefixBits)
+		}
+		subnets = appendUnique(subnets, prefix.Masked())
+	}
+	return subnets, nil
+}
+
+// Subnets returns the configured CIDRs or, when none are configured, the IPv4
+// subnets of the host's network interfaces.
+func Subnets(cidrs []string) ([]netip.Prefix, error) {
+	if len(cidrs) > 0 {
+		return ParseSubnets(cidrs)
+	}
+	return InterfaceSubnets()
+}
+
+// InterfaceSubnets returns the IPv4 subnets of the host's active, non-loopback
+// interfaces. Subnets larger than a /24 (a 10.0.0.0/8 or 172.16.0.0/16 home
+// network) are narrowed to the /24 around the host's address.
+func InterfaceSubnets() ([]netip.Prefix, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list network interfaces: %w", err)
+	}
+
+	var subnets []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || isVirtual(iface.Name) {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			slog.Debug("skipping interface", "interface", iface.Name, "error", err)
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if prefix, ok := interfacePrefix(ipNet); ok {
+				subnets = appendUnique(subnets, prefix)
+			}
+		}
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no IPv4 network interfaces found")
+	}
+	return subnets, nil
+}
+
+// interfacePrefix returns the subnet to scan for an interface address.
+func interfacePrefix(ipNet *net.IPNet) (netip.Prefix, bool) {
+	ip, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ip = ip.Unmap()
+	if !ip.Is4() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return netip.Prefix{}, false
+	}
+	bits, _ := ipNet.Mask.Size()
+	if bits < interfacePrefixBits {
+		bits = interfacePrefixBits
+	}
+	return netip.PrefixFrom(ip, bits).Masked(), true
+}
+
+// Hosts returns the host addresses of the subnets, without the network and
+// broadcast addresses of subnets that have them.
+func Hosts(subnets []netip.Prefix) []string {
+	var hosts []string
+	for _, subnet := range subnets {
+		first, last := subnet.Addr(), lastAddr(subnet)
+		if subnet.Bits() < 31 {
+			first, last = first.Next(), last.Prev()
+		}
+		for addr := first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+			hosts = append(hosts, addr.String())
+		}
+	}
+	return hosts
+}
+
+// Broadcasts returns the broadcast address of each subnet.
+func Broadcasts(subnets []netip.Prefix) []string {
+	addrs := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		addrs = append(addrs, lastAddr(subnet).String())
+	}
+	return addrs
+}
+
+// lastAddr returns the highest address of an IPv4 subnet.
+func lastAddr(subnet netip.Prefix) netip.Addr {
+	a := subnet.Masked().Addr().As4()
+	hostBits := 32 - subnet.Bits()
+	for i := 3; i >= 0 && hostBits > 0; i-- {
+		n := min(hostBits, 8)
+		a[i] |= byte(1<<n - 1)
+		hostBits -= n
+	}
+	return netip.AddrFrom4(a)
+}
+
+func isVirtual(name string) bool {
+	for _, prefix := range virtualInterfaces {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(subnets []netip.Prefix, prefix netip.Prefix) []netip.Prefix {
+	if slices.Contains(subnets, prefix) {
+		return subnets
+	}
+	return append(subnets, prefix)
+}
@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseSubnets(t *testing.T) {
+	subnets, err := ParseSubnets([]string{"10.0.0.0/24", " 172.16.5.9/24", "10.0.0.0/24"})
+	if err != nil {
+		t.Fatalf("ParseSubnets() error = %v", err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24"), netip.MustParsePrefix("172.16.5.0/24")}
+	if !slices.Equal(subnets, want) {
+		t.Errorf("ParseSubnets() = %v, want %v", subnets, want)
+	}
+
+	for _, bad := range []string{"10.0.0.0", "10.0.0.0/8", "fd00::/64", "nonsense"} {
+		if _, err := ParseSubnets([]string{bad}); err == nil {
+			t.Errorf("ParseSubnets(%q) error = nil, want error", bad)
+		}
+	}
+}
+
+func TestInterfacePrefix(t *testing.T) {
+	tests := []struct {
+		cidr   string
+		want   string
+		wantOK bool
+	}{
+		{"192.168.1.23/24", "192.168.1.0/24", true},
+		{"10.0.3.7/16", "10.0.3.0/24", true}, // narrowed to the host's /24
+		{"172.16.40.2/12", "172.16.40.0/24", true},
+		{"192.168.8.130/25", "192.168.8.128/25", true},
+		{"127.0.0.1/8", "", false},
+		{"169.254.10.1/16", "", false},
+		{"fe80::1/64", "", false},
+	}
+	for _, tt := range tests {
+		ip, ipNet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		got, ok := interfacePrefix(ipNet)
+		if ok != tt.wantOK || (ok && got.String() != tt.want) {
+			t.Errorf("interfacePrefix(%s) = %v, %v; want %s, %v", tt.cidr, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts := Hosts([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/30"), netip.MustParsePrefix("172.16.0.8/31")})
+	want := []string{"10.0.0.1", "10.0.0.2", "172.16.0.8", "172.16.0.9"}
+	if !slices.Equal(hosts, want) {
+		t.Errorf("Hosts() = %v, want %v", hosts, want)
+	}
+
+	if got := len(Hosts([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/20")})); got != 4094 {
+		t.Errorf("len(Hosts(/20)) = %d, want 4094", got)
+	}
+}
+
+func TestBroadcasts(t *testing.T) {
+	got := Broadcasts([]netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("10.0.16.0/20")})
+	want := []string{"192.168.1.255", "10.0.31.255"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Broadcasts() = %v, want %v", got, want)
+	}
+}
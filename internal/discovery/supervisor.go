@@ -0,0 +1,246 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// supervisorFailures consecutive failed checks start a rediscovery.
+	supervisorFailures = 3
+	// rediscoverInterval is the minimum time between rediscovery scans for one endpoint.
+	rediscoverInterval = 5 * time.Minute
+)
+
+// Swappable is a client whose device address can be replaced at runtime.
+type Swappable interface {
+	URL() string
+	SetURL(url string)
+}
+
+// Notifier announces address changes.
+type Notifier interface {
+	SendAddressChange(ctx context.Context, device, oldURL, newURL string) error
+}
+
+// Identity identifies a device independently of its IP address.
+type Identity struct {
+	Serial   string
+	MAC      string
+	Hostname string
+}
+
+// IsZero reports whether nothing is known about the device.
+func (id Identity) IsZero() bool {
+	return id == Identity{}
+}
+
+// Matches reports whether d is the device with this identity: same serial
+// number, MAC address or mDNS host name.
+func (id Identity) Matches(d Device) bool {
+	switch {
+	case id.Serial != "" && strings.EqualFold(id.Serial, d.Serial):
+		return true
+	case id.MAC != "" && normalizeMAC(id.MAC) == normalizeMAC(d.MAC):
+		return true
+	case id.Hostname != "" && normalizeHostname(id.Hostname) == normalizeHostname(d.Hostname):
+		return true
+	}
+	return false
+}
+
+// merge fills the fields of id that are still unknown.
+func (id *Identity) merge(other Identity) {
+	if id.Serial == "" {
+		id.Serial = other.Serial
+	}
+	if id.MAC == "" {
+		id.MAC = other.MAC
+	}
+	if id.Hostname == "" {
+		id.Hostname = other.Hostname
+	}
+}
+
+// Endpoint is a device connection watched by the Supervisor.
+type Endpoint struct {
+	Name   string // used in logs and notifications, e.g. "HomeWizard P1 meter"
+	Kind   Kind
+	Client Swappable
+	// Check probes the device at the client's current URL. A successful check
+	// may return what it learned about the device, such as its serial number.
+	Check func(ctx context.Context) (Identity, error)
+}
+
+// watched is an Endpoint with its supervision state.
+type watched struct {
+	Endpoint
+	identity Identity
+	learned  bool // mDNS identity lookup done
+	failures int
+	lastScan time.Time
+}
+
+// Supervisor watches device endpoints and, when one keeps failing, rediscovers
+// the device by serial number, MAC address or host name and points the running
+// client at its new address (for example after a DHCP lease change).
+type Supervisor struct {
+	subnets   []netip.Prefix
+	interval  time.Duration
+	notifier  Notifier
+	endpoints []*watched
+
+	scan    func(ctx context.Context, subnets []netip.Prefix, kinds ...Kind) ([]Device, error)
+	nowFunc func() time.Time
+}
+
+// NewSupervisor creates a supervisor that checks its endpoints every interval
+// and rediscovers devices on the subnets. notifier may be nil.
+func NewSupervisor(subnets []netip.Prefix, interval time.Duration, notifier Notifier) *Supervisor {
+	return &Supervisor{
+		subnets:  subnets,
+		interval: interval,
+		notifier: notifier,
+		scan:     Scan,
+		nowFunc:  time.Now,
+	}
+}
+
+// Watch adds an endpoint. It must be called before Run.
+func (s *Supervisor) Watch(e Endpoint) {
+	s.endpoints = append(s.endpoints, &watched{Endpoint: e})
+}
+
+// Run checks the endpoints until ctx is cancelled.
+func (s *Supervisor) Run(ctx context.Context) {
+	if len(s.endpoints) == 0 || s.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, w := range s.endpoints {
+			s.check(ctx, w)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes one endpoint and rediscovers it after sustained failures.
+func (s *Supervisor) check(ctx context.Context, w *watched) {
+	id, err := w.Check(ctx)
+	if err == nil {
+		w.failures = 0
+		w.identity.merge(id)
+		if !w.learned {
+			w.learned = true
+			s.learnIdentity(ctx, w)
+		}
+		return
+	}
+
+	w.failures++
+	current := w.Client.URL()
+	l := slog.With("device", w.Name, "url", current, "failures", w.failures)
+	if w.failures < supervisorFailures {
+		l.Debug("device check failed", "error", err)
+		return
+	}
+	now := s.nowFunc()
+	if !w.lastScan.IsZero() && now.Sub(w.lastScan) < rediscoverInterval {
+		return
+	}
+	w.lastScan = now
+	if w.identity.IsZero() {
+		l.Warn("device unreachable, cannot rediscover it: serial, MAC and host name unknown", "error", err)
+		return
+	}
+
+	l.Warn("device unreachable, rediscovering", "error", err, "serial", w.identity.Serial,
+		"mac", w.identity.MAC, "hostname", w.identity.Hostname)
+	devices, scanErr := s.scan(ctx, s.subnets, w.Kind)
+	if scanErr != nil {
+		l.Debug("rediscovery incomplete", "error", scanErr)
+	}
+	for _, d := range devices {
+		if !w.identity.Matches(d) {
+			continue
+		}
+		if d.Host() == hostOf(current) {
+			l.Info("rediscovered device at its configured address", "host", d.Host())
+			return
+		}
+		newURL := withHost(current, d.Host())
+		w.Client.SetURL(newURL)
+		w.failures = 0
+		w.identity.merge(Identity{Serial: d.Serial, MAC: d.MAC, Hostname: d.Hostname})
+		slog.Warn("device address changed, switched client to new address",
+			"device", w.Name, "old_url", current, "new_url", newURL, "methods", d.Methods)
+		if s.notifier != nil {
+			if err := s.notifier.SendAddressChange(ctx, w.Name, current, newURL); err != nil {
+				slog.Warn("failed to send address change notification", "error", err)
+			}
+		}
+		return
+	}
+	l.Warn("device not found on the network, retrying later", "retry_in", rediscoverInterval)
+}
+
+// learnIdentity looks the endpoint up over mDNS to learn its MAC address and
+// host name, which survive an IP address change.
+func (s *Supervisor) learnIdentity(ctx context.Context, w *watched) {
+	host := hostOf(w.Client.URL())
+	if _, err := netip.ParseAddr(host); err != nil {
+		return // configured by name: DNS follows the device
+	}
+	devices, _ := s.scan(ctx, nil, w.Kind)
+	for _, d := range devices {
+		if d.Host() == host {
+			w.identity.merge(Identity{Serial: d.Serial, MAC: d.MAC, Hostname: d.Hostname})
+			break
+		}
+	}
+	slog.Debug("device identity", "device", w.Name, "serial", w.identity.Serial,
+		"mac", w.identity.MAC, "hostname", w.identity.Hostname)
+}
+
+// hostOf returns the host name or IP of a URL.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Hostname()
+}
+
+// withHost returns rawURL with its host replaced, keeping scheme and port.
+func withHost(rawURL, host string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "http://" + host
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else {
+		u.Host = host
+	}
+	return u.String()
+}
+
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(mac))
+}
+
+func normalizeHostname(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(name, "."), ".local"))
+}
@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+type fakeClient struct{ url string }
+
+func (c *fakeClient) URL() string       { return c.url }
+func (c *fakeClient) SetURL(url string) { c.url = url }
+
+type fakeNotifier struct{ changes [][3]string }
+
+func (n *fakeNotifier) SendAddressChange(_ context.Context, device, oldURL, newURL string) error {
+	n.changes = append(n.changes, [3]string{device, oldURL, newURL})
+	return nil
+}
+
+// newTestSupervisor returns a supervisor whose scans return *network and whose
+// clock can be advanced, watching one endpoint whose reachability is *up.
+func newTestSupervisor(client *fakeClient, id Identity, network *[]Device) (*Supervisor, *watched, *bool, *time.Time, *fakeNotifier, *int) {
+	notifier := &fakeNotifier{}
+	s := NewSupervisor([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}, time.Minute, notifier)
+	scans := 0
+	s.scan = func(_ context.Context, subnets []netip.Prefix, _ ...Kind) ([]Device, error) {
+		if len(subnets) > 0 {
+			scans++
+		}
+		return *network, nil
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.nowFunc = func() time.Time { return now }
+	up := true
+	s.Watch(Endpoint{
+		Name:   "HomeWizard P1 meter",
+		Kind:   KindHomeWizard,
+		Client: client,
+		Check: func(context.Context) (Identity, error) {
+			if !up {
+				return Identity{}, errors.New("connection refused")
+			}
+			return id, nil
+		},
+	})
+	return s, s.endpoints[0], &up, &now, notifier, &scans
+}
+
+func TestSupervisor_SwapsURLAfterSustainedFailures(t *testing.T) {
+	client := &fakeClient{url: "http://10.0.0.12"}
+	network := []Device{{Kind: KindHomeWizard, URL: "http://10.0.0.12:80", Hostname: "p1meter-abcdef.local.", Methods: []string{"mdns"}}}
+	s, w, up, _, notifier, scans := newTestSupervisor(client, Identity{Serial: "5c2fafabcdef"}, &network)
+	ctx := context.Background()
+
+	s.check(ctx, w)
+	if w.identity.Serial != "5c2fafabcdef" || w.identity.Hostname != "p1meter-abcdef.local." {
+		t.Fatalf("identity = %+v, want serial from the check and host name from mDNS", w.identity)
+	}
+
+	// DHCP hands the meter a new address.
+	*up = false
+	network = []Device{{Kind: KindHomeWizard, URL: "http://10.0.0.57", Serial: "5C2FAFABCDEF", Methods: []string{"http_scan"}}}
+	for range supervisorFailures - 1 {
+		s.check(ctx, w)
+	}
+	if *scans != 0 || client.url != "http://10.0.0.12" {
+		t.Fatalf("rediscovered after %d failures, want %d", supervisorFailures-1, supervisorFailures)
+	}
+	s.check(ctx, w)
+	if client.url != "http://10.0.0.57" {
+		t.Fatalf("client URL = %q, want http://10.0.0.57", client.url)
+	}
+	if len(notifier.changes) != 1 || notifier.changes[0] != [3]string{"HomeWizard P1 meter", "http://10.0.0.12", "http://10.0.0.57"} {
+		t.Errorf("notifications = %v", notifier.changes)
+	}
+	if w.failures != 0 {
+		t.Errorf("failures = %d after swap, want 0", w.failures)
+	}
+}
+
+func TestSupervisor_MatchesByHostnameAndKeepsSchemeAndPort(t *testing.T) {
+	client := &fakeClient{url: "https://10.0.0.12:8443"}
+	network := []Device{{Kind: KindHomeWizard, URL: "http://10.0.0.12:80", Hostname: "p1meter-abcdef.local."}}
+	s, w, up, _, _, _ := newTestSupervisor(client, Identity{}, &network)
+	ctx := context.Background()
+
+	s.check(ctx, w)
+	*up = false
+	network = []Device{
+		{Kind: KindHomeWizard, URL: "http://10.0.0.30:80", Hostname: "p1meter-other.local."},
+		{Kind: KindHomeWizard, URL: "http://10.0.0.31:80", Hostname: "P1meter-abcdef.local."},
+	}
+	for range supervisorFailures {
+		s.check(ctx, w)
+	}
+	if client.url != "https://10.0.0.31:8443" {
+		t.Errorf("client URL = %q, want https://10.0.0.31:8443", client.url)
+	}
+}
+
+func TestSupervisor_RateLimitsRediscovery(t *testing.T) {
+	client := &fakeClient{url: "http://10.0.0.12"}
+	network := []Device{}
+	s, w, up, now, notifier, scans := newTestSupervisor(client, Identity{Serial: "5c2fafabcdef"}, &network)
+	ctx := context.Background()
+
+	s.check(ctx, w)
+	*up = false
+	for range supervisorFailures + 3 {
+		s.check(ctx, w)
+	}
+	if *scans != 1 {
+		t.Fatalf("scans = %d, want 1 within the rediscovery interval", *scans)
+	}
+
+	*now = now.Add(rediscoverInterval)
+	s.check(ctx, w)
+	if *scans != 2 {
+		t.Errorf("scans = %d, want 2 after the rediscovery interval", *scans)
+	}
+	if client.url != "http://10.0.0.12" || len(notifier.changes) != 0 {
+		t.Errorf("client URL = %q with %d notifications, want unchanged", client.url, len(notifier.changes))
+	}
+}
+
+func TestSupervisor_NoIdentityNoScan(t *testing.T) {
+	client := &fakeClient{url: "http://10.0.0.12"}
+	network := []Device{}
+	s, w, up, _, _, scans := newTestSupervisor(client, Identity{}, &network)
+	*up = false
+	for range supervisorFailures {
+		s.check(context.Background(), w)
+	}
+	if *scans != 0 {
+		t.Errorf("scans = %d, want 0 without a known identity", *scans)
+	}
+}
+
+func TestIdentity_Matches(t *testing.T) {
+	id := Identity{MAC: "A0:B1:C2:D3:E4:F5"}
+	if !id.Matches(Device{MAC: "a0b1c2d3e4f5"}) {
+		t.Error("MAC match should ignore case and separators")
+	}
+	if id.Matches(Device{MAC: "a0b1c2d3e4f6"}) || id.Matches(Device{}) {
+		t.Error("unexpected match")
+	}
+	if !(Identity{Hostname: "marstek.local."}).Matches(Device{Hostname: "Marstek.local"}) {
+		t.Error("host name match should ignore case and trailing dot")
+	}
+}
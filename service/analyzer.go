@@ -26,28 +26,35 @@ type priceSlot struct {
 
 // TimeWindow represents a time window for charging or discharging.
 type TimeWindow struct {
-	Start time.Time
-	End   time.Time
-	Price decimal.Decimal // Average price in this window
+	Start time.Time       `json:"start"`
+	End   time.Time       `json:"end"`
+	Price decimal.Decimal `json:"price"` // Average price in this window
 }
 
 // TradeCycle represents a paired charge and discharge window.
 type TradeCycle struct {
-	ChargeWindow    TimeWindow
-	DischargeWindow TimeWindow
-	Profit          decimal.Decimal // Expected profit per kWh (accounting for efficiency)
+	ChargeWindow    TimeWindow      `json:"charge_window"`
+	DischargeWindow TimeWindow      `json:"discharge_window"`
+	Profit          decimal.Decimal `json:"profit"`      // Expected profit per kWh (accounting for efficiency)
+	ExportRate      ExportRate      `json:"export_rate"` // Rate the discharge is valued at
+}
+
+// ID identifies the cycle by the start of its charge window, so a cycle keeps
+// its ID when the same plan is analyzed again after a restart.
+func (c TradeCycle) ID() string {
+	return c.ChargeWindow.Start.Format("2006-01-02T15:04Z07:00")
 }
 
 // TradingPlan contains the charge and discharge windows for a day.
 type TradingPlan struct {
-	Date             time.Time
-	ChargeWindows    []TimeWindow
-	DischargeWindows []TimeWindow
-	Cycles           []TradeCycle // Paired charge/discharge windows
-	MinPrice         decimal.Decimal
-	MaxPrice         decimal.Decimal
-	Spread           decimal.Decimal // MaxPrice - MinPrice
-	IsProfitable     bool            // At least one profitable cycle exists
+	Date             time.Time       `json:"date"`
+	ChargeWindows    []TimeWindow    `json:"charge_windows"`
+	DischargeWindows []TimeWindow    `json:"discharge_windows"`
+	Cycles           []TradeCycle    `json:"cycles"` // Paired charge/discharge windows
+	MinPrice         decimal.Decimal `json:"min_price"`
+	MaxPrice         decimal.Decimal `json:"max_price"`
+	Spread           decimal.Decimal `json:"spread"`        // MaxPrice - MinPrice
+	IsProfitable     bool            `json:"is_profitable"` // At least one profitable cycle exists
 }
 
 // AnalyzerConfig contains parameters for price analysis.
@@ -59,6 +66,13 @@ type AnalyzerConfig struct {
 	ChargePowerW       int     // Charge power in watts
 	DischargePowerW    int     // Discharge power in watts
 	MaxCyclesPerDay    int     // Maximum charge/discharge cycles per day
+
+	// Net metering: exports offset imports at the retail price up to the
+	// yearly import total. Zero values value energy at the spot price.
+	ImportSurcharge float64              // EUR/kWh added to the spot price for imports (tax, margin, VAT)
+	FeedInFee       float64              // EUR/kWh deducted from the spot price for exports that are not netted
+	NetMeteringEnd  time.Time            // Discharges before this time are netted, zero = no net metering
+	NetPosition     *NetMeteringPosition // Year-to-date imports and exports, nil = unknown
 }
 
 // AnalyzePrices analyzes the day-ahead prices and returns a trading plan.
@@ -69,18 +83,7 @@ func AnalyzePrices(prices []nordpool.Price, cfg AnalyzerConfig) *TradingPlan {
 		return &TradingPlan{}
 	}
 
-	// Sort prices by time
-	sorted := make([]nordpool.Price, len(prices))
-	copy(sorted, prices)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].Time.Before(sorted[j].Time)
-	})
-
-	// Convert API float64 prices to decimal for precise monetary arithmetic
-	slots := make([]priceSlot, len(sorted))
-	for i, p := range sorted {
-		slots[i] = priceSlot{Time: p.Time, Value: decimal.NewFromFloat(p.Value)}
-	}
+	slots := sortedPriceSlots(prices)
 
 	// Find min and max prices
 	minPrice := slots[0].Value
@@ -115,6 +118,7 @@ func AnalyzePrices(prices []nordpool.Price, cfg AnalyzerConfig) *TradingPlan {
 
 	efficiency := decimal.NewFromFloat(cfg.Efficiency)
 	minSpread := decimal.NewFromFloat(cfg.MinPriceSpread)
+	rates := newCycleRates(cfg, usableCapacity)
 
 	// Find trade cycles using sliding window algorithm
 	var cycles []TradeCycle
@@ -128,11 +132,12 @@ func AnalyzePrices(prices []nordpool.Price, cfg AnalyzerConfig) *TradingPlan {
 
 	// Try to find profitable cycles
 	for i := 0; i < maxCycles; i++ {
-		cycle, found := findBestCycle(slots, searchStartIdx, chargeWindowSize, dischargeWindowSize, efficiency, minSpread)
+		cycle, found := findBestCycle(slots, searchStartIdx, chargeWindowSize, dischargeWindowSize, efficiency, minSpread, rates)
 		if !found {
 			break
 		}
 		cycles = append(cycles, cycle)
+		rates.book(cycle)
 		// Next search starts after the discharge window ends
 		searchStartIdx = findSlotIndex(slots, cycle.DischargeWindow.End)
 		if searchStartIdx < 0 || searchStartIdx >= len(slots) {
@@ -159,10 +164,135 @@ func AnalyzePrices(prices []nordpool.Price, cfg AnalyzerConfig) *TradingPlan {
 	}
 }
 
+// sortedPriceSlots sorts prices by time and converts the API float64 prices to
+// decimal for precise monetary arithmetic.
+func sortedPriceSlots(prices []nordpool.Price) []priceSlot {
+	sorted := make([]nordpool.Price, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	slots := make([]priceSlot, len(sorted))
+	for i, p := range sorted {
+		slots[i] = priceSlot{Time: p.Time, Value: decimal.NewFromFloat(p.Value)}
+	}
+	return slots
+}
+
+// Reasons reported by ExplainPrices.
+const (
+	ExplainProfitable         = "profitable"           // at least one cycle is planned
+	ExplainNoPrices           = "no_prices"            // no prices to analyze
+	ExplainInsufficientSlots  = "insufficient_slots"   // too few slots for a charge window followed by a discharge window
+	ExplainBelowBreakEven     = "below_break_even"     // the discharge does not cover the charge cost after losses
+	ExplainSpreadBelowMinimum = "spread_below_minimum" // profitable, but the spread is below MIN_PRICE_SPREAD
+)
+
+// CandidateCycle is a charge/discharge pair with the numbers that decide
+// whether it is traded. Costs and values include the import surcharge and the
+// export rate; the windows hold spot prices.
+type CandidateCycle struct {
+	ChargeWindow       TimeWindow      `json:"charge_window"`
+	DischargeWindow    TimeWindow      `json:"discharge_window"`
+	ChargeCost         decimal.Decimal `json:"charge_cost"`          // EUR/kWh paid for imports
+	DischargeValue     decimal.Decimal `json:"discharge_value"`      // EUR/kWh earned for exports
+	ExportRate         ExportRate      `json:"export_rate"`          // Rate the discharge is valued at
+	BreakEvenPrice     decimal.Decimal `json:"break_even_price"`     // Discharge value that covers the charge cost after losses
+	BreakEvenShortfall decimal.Decimal `json:"break_even_shortfall"` // Discharge value missing to break even, 0 when covered
+	Spread             decimal.Decimal `json:"spread"`               // DischargeValue - ChargeCost
+	MinPriceSpread     decimal.Decimal `json:"min_price_spread"`     // Configured MIN_PRICE_SPREAD
+	SpreadShortfall    decimal.Decimal `json:"spread_shortfall"`     // Spread missing to reach MIN_PRICE_SPREAD, 0 when met
+	Profit             decimal.Decimal `json:"profit"`               // Expected profit per kWh (accounting for efficiency)
+}
+
+// PlanExplanation explains the outcome of a price analysis.
+type PlanExplanation struct {
+	Date          time.Time       `json:"date"`
+	IsProfitable  bool            `json:"is_profitable"`
+	Cycles        int             `json:"cycles"`
+	Reason        string          `json:"reason"`
+	Efficiency    float64         `json:"battery_efficiency"`
+	BestCandidate *CandidateCycle `json:"best_candidate,omitempty"` // First planned cycle, or the best pair when none is profitable
+}
+
+// ExplainPrices analyzes the prices like AnalyzePrices and reports why no
+// cycle is profitable: the most profitable pair regardless of the thresholds,
+// its break-even price and its shortfall against the minimum spread.
+func ExplainPrices(prices []nordpool.Price, cfg AnalyzerConfig) *PlanExplanation {
+	plan := AnalyzePrices(prices, cfg)
+	explanation := &PlanExplanation{
+		Date:         plan.Date,
+		IsProfitable: plan.IsProfitable,
+		Cycles:       len(plan.Cycles),
+		Efficiency:   cfg.Efficiency,
+	}
+	if len(prices) == 0 {
+		explanation.Reason = ExplainNoPrices
+		return explanation
+	}
+
+	usableCapacity := cfg.BatteryCapacityKWh * (1.0 - cfg.BatteryMinSOC)
+	efficiency := decimal.NewFromFloat(cfg.Efficiency)
+	rates := newCycleRates(cfg, usableCapacity)
+	cycle, found := TradeCycle{}, false
+	if plan.IsProfitable {
+		cycle, found = plan.Cycles[0], true
+	} else {
+		slots := sortedPriceSlots(prices)
+		chargeWindowSize := calculateWindowSize(usableCapacity, cfg.ChargePowerW)
+		dischargeWindowSize := calculateWindowSize(usableCapacity, cfg.DischargePowerW)
+		cycle, found = searchCycles(slots, 0, chargeWindowSize, dischargeWindowSize, efficiency, rates, func(_, _ decimal.Decimal) bool { return true })
+	}
+	if !found {
+		explanation.Reason = ExplainInsufficientSlots
+		return explanation
+	}
+
+	chargeCost := rates.importPrice(cycle.ChargeWindow.Price)
+	dischargeValue, exportRate := rates.exportPrice(cycle.DischargeWindow.Price, cycle.DischargeWindow.Start)
+	minSpread := decimal.NewFromFloat(cfg.MinPriceSpread)
+	candidate := &CandidateCycle{
+		ChargeWindow:    cycle.ChargeWindow,
+		DischargeWindow: cycle.DischargeWindow,
+		ChargeCost:      chargeCost,
+		DischargeValue:  dischargeValue,
+		ExportRate:      exportRate,
+		BreakEvenPrice:  chargeCost.Div(efficiency).Round(4),
+		Spread:          dischargeValue.Sub(chargeCost),
+		MinPriceSpread:  minSpread,
+		Profit:          cycle.Profit,
+	}
+	candidate.BreakEvenShortfall = decimal.Max(candidate.BreakEvenPrice.Sub(dischargeValue), decimal.Zero)
+	candidate.SpreadShortfall = decimal.Max(minSpread.Sub(candidate.Spread), decimal.Zero)
+	explanation.BestCandidate = candidate
+
+	switch {
+	case plan.IsProfitable:
+		explanation.Reason = ExplainProfitable
+	case dischargeValue.LessThanOrEqual(chargeCost.Div(efficiency)):
+		explanation.Reason = ExplainBelowBreakEven
+	default:
+		explanation.Reason = ExplainSpreadBelowMinimum
+	}
+	return explanation
+}
+
 // findBestCycle finds the most profitable charge/discharge pair starting from the given index.
 // It evaluates ALL possible charge windows and picks the pair with maximum profit.
+// Window prices are spot prices; profit uses the import and export rates that apply.
 // Returns the cycle and true if a profitable pair was found.
-func findBestCycle(prices []priceSlot, startIdx, chargeWindowSize, dischargeWindowSize int, efficiency, minSpread decimal.Decimal) (TradeCycle, bool) {
+func findBestCycle(prices []priceSlot, startIdx, chargeWindowSize, dischargeWindowSize int, efficiency, minSpread decimal.Decimal, rates *cycleRates) (TradeCycle, bool) {
+	// Profitable if: discharge_value > charge_cost / efficiency AND spread >= minSpread
+	return searchCycles(prices, startIdx, chargeWindowSize, dischargeWindowSize, efficiency, rates, func(chargeCost, dischargeValue decimal.Decimal) bool {
+		breakEvenPrice := chargeCost.Div(efficiency)
+		return dischargeValue.GreaterThan(breakEvenPrice) && dischargeValue.Sub(chargeCost).GreaterThanOrEqual(minSpread)
+	})
+}
+
+// searchCycles returns the most profitable charge/discharge pair from startIdx
+// among those accept allows.
+func searchCycles(prices []priceSlot, startIdx, chargeWindowSize, dischargeWindowSize int, efficiency decimal.Decimal, rates *cycleRates, accept func(chargeCost, dischargeValue decimal.Decimal) bool) (TradeCycle, bool) {
 	var bestCycle TradeCycle
 	var bestProfit decimal.Decimal
 	found := false
@@ -186,16 +316,16 @@ func findBestCycle(prices []priceSlot, startIdx, chargeWindowSize, dischargeWind
 			continue
 		}
 
-		// Check if the trade is profitable
-		// Profitable if: discharge_price > charge_price / efficiency AND spread >= minSpread
-		breakEvenPrice := chargeAvg.Div(efficiency)
-		if dischargeAvg.LessThanOrEqual(breakEvenPrice) || dischargeAvg.Sub(chargeAvg).LessThan(minSpread) {
+		// Check if the trade is acceptable
+		chargeCost := rates.importPrice(chargeAvg)
+		dischargeValue, exportRate := rates.exportPrice(dischargeAvg, prices[dischargeStart].Time)
+		if !accept(chargeCost, dischargeValue) {
 			continue
 		}
 
 		// Calculate expected profit per kWh
-		// profit = discharge_price * efficiency - charge_price
-		profit := dischargeAvg.Mul(efficiency).Sub(chargeAvg)
+		// profit = discharge_value * efficiency - charge_cost
+		profit := dischargeValue.Mul(efficiency).Sub(chargeCost)
 
 		// Keep the most profitable pair
 		if !found || profit.GreaterThan(bestProfit) {
@@ -213,7 +343,8 @@ func findBestCycle(prices []priceSlot, startIdx, chargeWindowSize, dischargeWind
 					End:   prices[dischargeStart+dischargeWindowSize-1].Time.Add(15 * time.Minute),
 					Price: dischargeAvg,
 				},
-				Profit: profit,
+				Profit:     profit,
+				ExportRate: exportRate,
 			}
 		}
 	}
@@ -320,6 +451,18 @@ func (p *TradingPlan) IsInChargeWindow(t time.Time) bool {
 	return false
 }
 
+// CycleAt returns the cycle whose charge or discharge window contains t.
+func (p *TradingPlan) CycleAt(t time.Time) (TradeCycle, bool) {
+	for _, c := range p.Cycles {
+		for _, w := range []TimeWindow{c.ChargeWindow, c.DischargeWindow} {
+			if !t.Before(w.Start) && t.Before(w.End) {
+				return c, true
+			}
+		}
+	}
+	return TradeCycle{}, false
+}
+
 // IsInDischargeWindow checks if the given time is within a discharge window.
 func (p *TradingPlan) IsInDischargeWindow(t time.Time) bool {
 	for _, w := range p.DischargeWindows {
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandType identifies a control command sent to the battery.
+type CommandType string
+
+const (
+	CommandCharge         CommandType = "charge"
+	CommandDischarge      CommandType = "discharge"
+	CommandSolarCharge    CommandType = "solar_charge"
+	CommandSolarAdjust    CommandType = "solar_adjust"
+	CommandPassiveRefresh CommandType = "passive_refresh"
+	CommandPhaseLimit     CommandType = "phase_limit" // power adjusted by fuse protection
+	CommandIdle           CommandType = "idle"
+)
+
+const (
+	commandsDir           = "commands"       // daily files: commands/YYYY-MM-DD.jsonl
+	commandsLegacyFile    = "commands.jsonl" // single file before daily rotation, moved on load
+	commandJournalMaxKept = 10000            // most recent commands kept in memory for the API
+	commandPageDefault    = 50
+	commandPageMax        = 500
+)
+
+// CommandRecord is a single battery control command and its outcome.
+type CommandRecord struct {
+	Time           time.Time   `json:"time"`
+	Command        CommandType `json:"command"`
+	Reason         string      `json:"reason,omitempty"`
+	PowerW         int         `json:"power_w"` // requested power: positive charges, negative discharges
+	StateBefore    State       `json:"state_before"`
+	StateAfter     State       `json:"state_after"`
+	Err            string      `json:"error,omitempty"`
+	LatencyMS      int64       `json:"latency_ms"`
+	MeasuredPowerW *float64    `json:"measured_power_w,omitempty"` // battery power confirmed after a start command
+	VerifyErr      string      `json:"verify_error,omitempty"`
+}
+
+// setVerification records the outcome of waitForBatteryPower.
+func (c *CommandRecord) setVerification(measuredPowerW float64, err error) {
+	c.MeasuredPowerW = &measuredPowerW
+	if err != nil {
+		c.VerifyErr = err.Error()
+	}
+}
+
+// CommandPage is a page of the command journal, newest first.
+type CommandPage struct {
+	Commands []CommandRecord `json:"commands"`
+	Total    int             `json:"total"`
+	Offset   int             `json:"offset"`
+	Limit    int             `json:"limit"`
+}
+
+// CommandJournal is an append-only log of battery control commands, persisted
+// in daily JSON Lines files that are deleted after the retention period.
+type CommandJournal struct {
+	mu              sync.Mutex
+	dir             string // "" keeps the journal in memory only
+	loc             *time.Location
+	retentionDays   int // 0 keeps all days
+	commands        []CommandRecord
+	lastMaintenance string // day of the last retention pass
+}
+
+// NewCommandJournal creates a command journal in dataDir/commands that keeps
+// retentionDays days (0 keeps all). An empty dataDir keeps the journal in memory only.
+func NewCommandJournal(dataDir string, retentionDays int, loc *time.Location) *CommandJournal {
+	if loc == nil {
+		loc = time.UTC
+	}
+	dir := ""
+	if dataDir != "" {
+		dir = filepath.Join(dataDir, commandsDir)
+	}
+	return &CommandJournal{
+		dir:           dir,
+		loc:           loc,
+		retentionDays: retentionDays,
+		commands:      make([]CommandRecord, 0),
+	}
+}
+
+// Record appends a command to the journal.
+func (j *CommandJournal) Record(rec CommandRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.commands = append(j.commands, rec)
+	if len(j.commands) > commandJournalMaxKept {
+		j.commands = j.commands[len(j.commands)-commandJournalMaxKept:]
+	}
+	return j.appendToFile(rec)
+}
+
+// Page returns limit commands starting offset entries back from the newest.
+func (j *CommandJournal) Page(offset, limit int) CommandPage {
+	if limit <= 0 {
+		limit = commandPageDefault
+	}
+	limit = min(limit, commandPageMax)
+	offset = max(offset, 0)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	page := CommandPage{
+		Commands: make([]CommandRecord, 0, limit),
+		Total:    len(j.commands),
+		Offset:   offset,
+		Limit:    limit,
+	}
+	for i := len(j.commands) - 1 - offset; i >= 0 && len(page.Commands) < limit; i-- {
+		page.Commands = append(page.Commands, j.commands[i])
+	}
+	return page
+}
+
+// dayPath returns the file of the day a command was sent in.
+func (j *CommandJournal) dayPath(t time.Time) string {
+	return filepath.Join(j.dir, t.In(j.loc).Format("2006-01-02")+".jsonl")
+}
+
+// appendToFile writes one JSON line to the file of the command's day and
+// syncs it to disk. The first command of a day removes expired files.
+// Caller must hold j.mu.
+func (j *CommandJournal) appendToFile(rec CommandRecord) error {
+	if j.dir == "" {
+		return nil // No persistence configured
+	}
+
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return fmt.Errorf("create command journal dir: %w", err)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal command: %w", err)
+	}
+
+	f, err := os.OpenFile(j.dayPath(rec.Time), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open command journal: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write command journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync command journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close command journal: %w", err)
+	}
+
+	if today := rec.Time.In(j.loc).Format("2006-01-02"); today != j.lastMaintenance {
+		j.lastMaintenance = today
+		j.removeExpiredLocked(rec.Time)
+	}
+	return nil
+}
+
+// dayFiles returns the daily journal files, oldest first. Caller must hold j.mu.
+func (j *CommandJournal) dayFiles() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list command journal: %w", err)
+	}
+	var days []string
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || len(day) != len("2006-01-02") {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// removeExpiredLocked deletes the files of days older than the retention
+// period. Caller must hold j.mu.
+func (j *CommandJournal) removeExpiredLocked(now time.Time) {
+	if j.retentionDays <= 0 {
+		return
+	}
+	expiredBefore := now.In(j.loc).AddDate(0, 0, -j.retentionDays).Format("2006-01-02")
+	days, err := j.dayFiles()
+	if err != nil {
+		slog.Warn("failed to list command journal files", "error", err)
+		return
+	}
+	for _, day := range days {
+		if day >= expiredBefore {
+			break
+		}
+		if err := os.Remove(filepath.Join(j.dir, day+".jsonl")); err != nil {
+			slog.Warn("failed to remove expired command journal file", "day", day, "error", err)
+		}
+	}
+}
+
+// migrateLocked moves a legacy DATA_DIR/commands.jsonl into the daily files
+// as the day it was last written, so it expires with the other days. Caller
+// must hold j.mu.
+func (j *CommandJournal) migrateLocked() error {
+	legacyPath := filepath.Join(filepath.Dir(j.dir), commandsLegacyFile)
+	info, err := os.Stat(legacyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("stat command journal: %w", err)
+	}
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return fmt.Errorf("create command journal dir: %w", err)
+	}
+	target := j.dayPath(info.ModTime())
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("move %s: %s already exists", commandsLegacyFile, target)
+	}
+	if err := os.Rename(legacyPath, target); err != nil {
+		return fmt.Errorf("move %s: %w", commandsLegacyFile, err)
+	}
+	slog.Info("moved command journal to daily files", "file", target)
+	return nil
+}
+
+// LoadCommands loads the most recent commands from the newest daily files,
+// reading only as many days as needed. Malformed lines, such as a final line
+// cut short by a crash, are skipped.
+func (j *CommandJournal) LoadCommands() error {
+	if j.dir == "" {
+		return nil // No persistence configured
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.migrateLocked(); err != nil {
+		slog.Warn("failed to migrate command journal", "error", err)
+	}
+	j.removeExpiredLocked(time.Now())
+
+	days, err := j.dayFiles()
+	if err != nil {
+		return err
+	}
+	var commands []CommandRecord
+	for i := len(days) - 1; i >= 0 && len(commands) < commandJournalMaxKept; i-- {
+		dayCommands, err := readCommandFile(filepath.Join(j.dir, days[i]+".jsonl"))
+		if err != nil {
+			return err
+		}
+		commands = append(dayCommands, commands...)
+	}
+	if len(commands) > commandJournalMaxKept {
+		commands = commands[len(commands)-commandJournalMaxKept:]
+	}
+	j.commands = append(commands, j.commands...)
+	return nil
+}
+
+// readCommandFile reads one daily journal file, skipping malformed lines.
+func readCommandFile(path string) ([]CommandRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open command journal: %w", err)
+	}
+	defer f.Close()
+
+	var commands []CommandRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for scanner.Scan() {
+		var rec CommandRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			slog.Warn("skipping malformed command journal line", "file", filepath.Base(path), "error", err)
+			continue
+		}
+		commands = append(commands, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read command journal: %w", err)
+	}
+	return commands, nil
+}
+
+// sendCommand sends a battery command and returns its journal record.
+// The caller fills in StateAfter and journals it once the outcome is known.
+// Must be called without holding s.mu.
+func (s *Service) sendCommand(ctx context.Context, rec CommandRecord, send func(context.Context) error) (CommandRecord, error) {
+	rec.Time = s.now()
+	start := time.Now()
+	err := send(ctx)
+	rec.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	return rec, err
+}
+
+// journalCommands writes command records to the journal. Must be called without holding s.mu.
+func (s *Service) journalCommands(recs ...CommandRecord) {
+	if s.commands == nil {
+		return
+	}
+	for _, rec := range recs {
+		if err := s.commands.Record(rec); err != nil {
+			slog.Warn("failed to journal battery command", "command", rec.Command, "error", err)
+		}
+	}
+}
+
+// GetCommandJournal returns the battery command journal.
+func (s *Service) GetCommandJournal() *CommandJournal {
+	return s.commands
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommandJournal_PageNewestFirst(t *testing.T) {
+	j := NewCommandJournal("", 0, time.UTC)
+	base := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		j.Record(CommandRecord{Time: base.Add(time.Duration(i) * time.Minute), Command: CommandPassiveRefresh, PowerW: i})
+	}
+
+	page := j.Page(1, 2)
+	if page.Total != 5 || len(page.Commands) != 2 {
+		t.Fatalf("page = %+v, want 2 of 5 commands", page)
+	}
+	if page.Commands[0].PowerW != 3 || page.Commands[1].PowerW != 2 {
+		t.Errorf("page powers = %d, %d; want 3, 2", page.Commands[0].PowerW, page.Commands[1].PowerW)
+	}
+
+	if page := j.Page(10, 2); len(page.Commands) != 0 {
+		t.Errorf("page past the end = %+v, want empty", page.Commands)
+	}
+	if page := j.Page(0, 0); page.Limit != commandPageDefault || len(page.Commands) != 5 {
+		t.Errorf("default page = %+v, want limit %d and all 5 commands", page, commandPageDefault)
+	}
+}
+
+func TestCommandJournal_RoundTripSkipsTruncatedLine(t *testing.T) {
+	dir := t.TempDir()
+	j := NewCommandJournal(dir, 0, time.UTC)
+	base := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	measured := 1980.0
+	j.Record(CommandRecord{Time: base, Command: CommandCharge, PowerW: 2000, StateBefore: StateIdle, StateAfter: StateCharging, MeasuredPowerW: &measured})
+	j.Record(CommandRecord{Time: base.Add(time.Minute), Command: CommandIdle, StateBefore: StateCharging, StateAfter: StateCharging, Err: "timeout"})
+
+	// Simulate a crash in the middle of writing a record.
+	f, err := os.OpenFile(j.dayPath(base), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-01-15T00:00:00Z","comm`)
+	f.Close()
+
+	j2 := NewCommandJournal(dir, 0, time.UTC)
+	if err := j2.LoadCommands(); err != nil {
+		t.Fatalf("LoadCommands() error = %v", err)
+	}
+	page := j2.Page(0, 10)
+	if page.Total != 2 {
+		t.Fatalf("loaded %d commands, want 2", page.Total)
+	}
+	if page.Commands[0].Err != "timeout" {
+		t.Errorf("newest command error = %q, want timeout", page.Commands[0].Err)
+	}
+	if got := page.Commands[1].MeasuredPowerW; got == nil || *got != measured {
+		t.Errorf("measured power = %v, want %.0f", got, measured)
+	}
+}
+
+func TestCommandJournal_RotatesDailyAndExpires(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -40)
+
+	// A journal from before daily rotation, last written 40 days ago
+	legacy := filepath.Join(dir, commandsLegacyFile)
+	os.WriteFile(legacy, []byte(`{"time":"`+old.Format(time.RFC3339)+`","command":"idle"}`+"\n"), 0644)
+	os.Chtimes(legacy, old, old)
+
+	j := NewCommandJournal(dir, 30, time.UTC)
+	if err := j.LoadCommands(); err != nil {
+		t.Fatalf("LoadCommands() error = %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy journal still exists: %v", err)
+	}
+	if page := j.Page(0, 10); page.Total != 0 {
+		t.Errorf("loaded %d commands, want the expired legacy day dropped", page.Total)
+	}
+
+	j.Record(CommandRecord{Time: now.Add(-24 * time.Hour), Command: CommandSolarAdjust})
+	j.Record(CommandRecord{Time: now, Command: CommandPassiveRefresh})
+	days, err := j.dayFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 2 {
+		t.Fatalf("day files = %v, want yesterday and today", days)
+	}
+
+	j2 := NewCommandJournal(dir, 30, time.UTC)
+	if err := j2.LoadCommands(); err != nil {
+		t.Fatalf("LoadCommands() error = %v", err)
+	}
+	page := j2.Page(0, 10)
+	if page.Total != 2 || page.Commands[0].Command != CommandPassiveRefresh {
+		t.Errorf("page = %+v, want both days newest first", page.Commands)
+	}
+}
+
+func TestCommandJournal_RecordsChargeSession(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	svc := newTestService(testConfigSmallBattery(), NewMockBattery(50), prices, baseTime)
+	svc.commands = NewCommandJournal("", 0, time.UTC)
+
+	svc.tick(context.Background())
+	svc.nowFunc = func() time.Time { return baseTime.Add(15 * time.Minute) }
+	svc.tick(context.Background())
+
+	page := svc.commands.Page(0, 10)
+	if page.Total != 2 {
+		t.Fatalf("journal = %+v, want charge and idle", page.Commands)
+	}
+	stop, start := page.Commands[0], page.Commands[1]
+	if start.Command != CommandCharge || start.PowerW != 2000 || start.StateBefore != StateIdle || start.StateAfter != StateCharging {
+		t.Errorf("start = %+v, want charge 2000 W idle -> charging", start)
+	}
+	if start.Err != "" || start.MeasuredPowerW == nil || *start.MeasuredPowerW < batteryActivePowerThresholdW {
+		t.Errorf("start = %+v, want ok with measured charge power", start)
+	}
+	if stop.Command != CommandIdle || stop.StateBefore != StateCharging || stop.StateAfter != StateIdle {
+		t.Errorf("stop = %+v, want idle charging -> idle", stop)
+	}
+}
+
+func TestCommandJournal_RecordsFailedStart(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	battery := NewMockBattery(50)
+	battery.IgnorePowerCommands = true
+	svc := newTestService(testConfigSmallBattery(), battery, prices, baseTime)
+	svc.batteryVerificationTimeout = 10 * time.Millisecond
+	svc.batteryVerificationInterval = time.Millisecond
+	svc.commands = NewCommandJournal("", 0, time.UTC)
+
+	svc.tick(context.Background())
+
+	page := svc.commands.Page(0, 10)
+	if page.Total != 2 {
+		t.Fatalf("journal = %+v, want charge and cleanup idle", page.Commands)
+	}
+	idle, charge := page.Commands[0], page.Commands[1]
+	if charge.Command != CommandCharge || charge.VerifyErr == "" || charge.StateAfter != StateIdle {
+		t.Errorf("charge = %+v, want verification error and idle state", charge)
+	}
+	if idle.Command != CommandIdle || idle.Reason != "start failed" || idle.Err != "" {
+		t.Errorf("idle = %+v, want successful cleanup idle", idle)
+	}
+}
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/marstek"
+)
+
+// ControlAction is a manual command of the control API.
+type ControlAction string
+
+const (
+	ControlCharge    ControlAction = "charge"    // charge from the grid
+	ControlDischarge ControlAction = "discharge" // discharge to the grid
+	ControlIdle      ControlAction = "idle"      // hold the battery idle, solar charging included
+	ControlPause     ControlAction = "pause"     // suspend planned grid trading, solar charging continues
+	ControlResume    ControlAction = "resume"    // end the override and trade automatically
+)
+
+const (
+	controlDefaultDuration = time.Hour
+	controlMaxDuration     = 24 * time.Hour
+	commandReasonManual    = "manual" // command journal reason for commands of a manual override
+)
+
+var (
+	// ErrInvalidControl is returned for a malformed control request.
+	ErrInvalidControl = errors.New("invalid control request")
+	// ErrControlRejected is returned when the battery's state does not allow the command.
+	ErrControlRejected = errors.New("control request rejected")
+	// ErrControlFailed is returned when the battery did not carry out the command.
+	ErrControlFailed = errors.New("control command failed")
+)
+
+// ControlRequest is a manual command.
+type ControlRequest struct {
+	Action   ControlAction
+	PowerW   int           // charge or discharge power, 0 = CHARGE_POWER_W or DISCHARGE_POWER_W
+	Duration time.Duration // how long the override holds, 0 = one hour
+}
+
+// ManualOverride is a manual command that takes precedence over the trading
+// plan until it expires or is resumed.
+type ManualOverride struct {
+	Action ControlAction `json:"action"`
+	PowerW int           `json:"power_w,omitempty"`
+	Since  time.Time     `json:"since"`
+	Until  time.Time     `json:"until"`
+}
+
+// ControlResult is the outcome of a manual command.
+type ControlResult struct {
+	Message  string          `json:"message"`
+	State    State           `json:"state"`
+	Override *ManualOverride `json:"override,omitempty"` // nil after resume
+}
+
+// OverrideEvent is the data of an EventOverride.
+type OverrideEvent struct {
+	Active   bool            `json:"active"`
+	Override *ManualOverride `json:"override"`
+	Reason   string          `json:"reason,omitempty"` // why the override ended
+}
+
+// controlCall hands a manual command to the trading loop.
+type controlCall struct {
+	req   ControlRequest
+	reply chan controlReply
+}
+
+type controlReply struct {
+	result *ControlResult
+	err    error
+}
+
+// Control carries out a manual command. It is applied by the trading loop,
+// through the same state machine, recording and notifications as planned
+// trades, and returns once the battery has acted on it.
+func (s *Service) Control(ctx context.Context, req ControlRequest) (*ControlResult, error) {
+	if err := s.validateControl(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidControl, err)
+	}
+	call := controlCall{req: req, reply: make(chan controlReply, 1)}
+	select {
+	case s.controlCh <- call:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case r := <-call.reply:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// validateControl checks a request and fills in the default duration.
+func (s *Service) validateControl(req *ControlRequest) error {
+	switch req.Action {
+	case ControlCharge, ControlDischarge:
+		maxW := s.cfg.ChargePowerW
+		if req.Action == ControlDischarge {
+			maxW = s.cfg.DischargePowerW
+		}
+		if req.PowerW < 0 || req.PowerW > maxW || (req.PowerW > 0 && req.PowerW < fuseMinSessionW) {
+			return fmt.Errorf("power_w must be between %d and %d, got %d", fuseMinSessionW, maxW, req.PowerW)
+		}
+	case ControlIdle, ControlPause, ControlResume:
+		if req.PowerW != 0 {
+			return fmt.Errorf("power_w applies only to charge and discharge")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", req.Action)
+	}
+	if req.Duration < 0 {
+		return fmt.Errorf("duration must not be negative, got %s", req.Duration)
+	}
+	if req.Duration > controlMaxDuration {
+		return fmt.Errorf("duration must be at most %s, got %s", controlMaxDuration, req.Duration)
+	}
+	if req.Duration == 0 {
+		req.Duration = controlDefaultDuration
+	}
+	return nil
+}
+
+// applyControl sets or ends the override and runs a tick to carry it out.
+// Called by the trading loop without holding s.mu.
+func (s *Service) applyControl(ctx context.Context, req ControlRequest) (*ControlResult, error) {
+	now := s.now()
+	s.mu.Lock()
+	s.overrideErr = nil
+	if req.Action == ControlResume {
+		s.endOverrideLocked(ctx, "resumed via the control API", nil)
+	} else {
+		s.override = &ManualOverride{Action: req.Action, PowerW: req.PowerW, Since: now, Until: now.Add(req.Duration)}
+		override := *s.override
+		s.publish(EventOverride, OverrideEvent{Active: true, Override: &override})
+	}
+	s.mu.Unlock()
+	slog.Info("manual override", "action", req.Action, "power_w", req.PowerW, "duration", req.Duration)
+
+	s.tick(ctx)
+
+	s.mu.Lock()
+	err := s.overrideErr
+	s.overrideErr = nil
+	switch {
+	case err != nil:
+	case req.Action == ControlCharge && s.state != StateCharging,
+		req.Action == ControlDischarge && s.state != StateDischarging:
+		err = fmt.Errorf("%w: battery is %s", ErrControlFailed, s.state)
+		s.endOverrideLocked(ctx, "battery did not start", nil)
+	case req.Action == ControlIdle && s.state != StateIdle,
+		req.Action == ControlPause && (s.state == StateCharging || s.state == StateDischarging || s.state == StateStopping):
+		err = fmt.Errorf("%w: battery has not stopped yet, retrying", ErrControlFailed)
+	}
+	result := &ControlResult{State: s.state}
+	if s.override != nil {
+		override := *s.override
+		result.Override = &override
+	}
+	powerW := s.sessionPowerLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Override == nil {
+		result.Message = "automatic trading resumed"
+		return result, nil
+	}
+	until := result.Override.Until.Format("15:04")
+	switch req.Action {
+	case ControlCharge:
+		result.Message = fmt.Sprintf("charging at %d W until %s", powerW, until)
+	case ControlDischarge:
+		result.Message = fmt.Sprintf("discharging at %d W until %s", powerW, until)
+	case ControlIdle:
+		result.Message = "battery idle until " + until
+	case ControlPause:
+		result.Message = "trading paused until " + until
+	}
+	if s.telegramEnabled() {
+		if err := s.telegram.SendOverride(ctx, string(req.Action), req.PowerW, result.Override.Until); err != nil {
+			slog.Warn("failed to send override notification", "error", err)
+		}
+	}
+	return result, nil
+}
+
+// overrideTickLocked carries out the manual override instead of the plan.
+// Other sessions yield to it, solar charging included. It returns the missed
+// slot when a planned window is overridden. Caller must hold s.mu.
+func (s *Service) overrideTickLocked(ctx context.Context, l *slog.Logger, now time.Time, bat *marstek.BatteryStatus) *MissedSlot {
+	o := s.override
+	missed := s.overriddenSlotLocked(now, o.Action)
+	l = l.With("override", o.Action, "override_until", o.Until.Format("15:04"))
+
+	if o.Action == ControlPause {
+		switch s.state {
+		case StateCharging:
+			l.Info("decision: stop charging - trading paused")
+			s.stopChargingLocked(ctx, bat.SOC)
+		case StateDischarging:
+			l.Info("decision: stop discharging - trading paused")
+			s.stopDischargingLocked(ctx, bat.SOC)
+		}
+		return missed
+	}
+
+	switch {
+	case s.state == StateSolarCharging:
+		l.Info("decision: stop solar charging - manual override")
+		s.stopSolarChargingLocked(ctx, bat.SOC, solarStopReasonYieldWindow)
+	case s.state == StateCharging && o.Action != ControlCharge:
+		l.Info("decision: stop charging - manual override")
+		s.stopChargingLocked(ctx, bat.SOC)
+	case s.state == StateDischarging && o.Action != ControlDischarge:
+		l.Info("decision: stop discharging - manual override")
+		s.stopDischargingLocked(ctx, bat.SOC)
+	}
+
+	price, _ := GetCurrentPrice(s.todayPrices, now)
+	minSOC := int(s.cfg.BatteryMinSOC * 100)
+	switch o.Action {
+	case ControlCharge:
+		switch s.state {
+		case StateIdle:
+			switch {
+			case bat.SOC >= 100:
+				s.rejectOverrideLocked(ctx, "battery is full")
+			case !bat.ChargingFlag:
+				s.rejectOverrideLocked(ctx, "battery charging is disabled")
+			case s.chargePowerLocked() < fuseMinSessionW:
+				s.rejectOverrideLocked(ctx, "no headroom below the main fuse")
+			default:
+				l.Info("decision: start charging - manual override")
+				s.startChargingLocked(ctx, price, bat.SOC)
+				if s.state != StateCharging {
+					s.endOverrideLocked(ctx, "battery did not start charging", fmt.Errorf("%w: battery did not start charging", ErrControlFailed))
+				}
+			}
+		case StateCharging:
+			if bat.SOC >= 100 {
+				l.Info("decision: stop charging - battery full")
+				s.stopChargingLocked(ctx, bat.SOC)
+				s.endOverrideLocked(ctx, "battery is full", nil)
+			} else if power := s.chargePowerLocked(); power != s.sessionPowerLocked() {
+				if err := s.adjustSessionPowerLocked(ctx, power, commandReasonManual); err != nil {
+					l.Error("failed to adjust charge power", "error", err)
+				}
+			} else {
+				s.refreshPassiveModeLocked(ctx, -s.sessionPowerLocked())
+			}
+		}
+
+	case ControlDischarge:
+		switch s.state {
+		case StateIdle:
+			switch {
+			case bat.SOC <= minSOC:
+				s.rejectOverrideLocked(ctx, "battery is at the minimum SOC")
+			case !bat.DischargFlag:
+				s.rejectOverrideLocked(ctx, "battery discharging is disabled")
+			case s.dischargePowerLocked() < fuseMinSessionW:
+				s.rejectOverrideLocked(ctx, "no headroom below the feed-in limit")
+			default:
+				l.Info("decision: start discharging - manual override")
+				s.startDischargingLocked(ctx, price, bat.SOC)
+				if s.state != StateDischarging {
+					s.endOverrideLocked(ctx, "battery did not start discharging", fmt.Errorf("%w: battery did not start discharging", ErrControlFailed))
+				}
+			}
+		case StateDischarging:
+			if bat.SOC <= minSOC {
+				l.Info("decision: stop discharging - battery at min SOC", "min_soc", minSOC)
+				s.stopDischargingLocked(ctx, bat.SOC)
+				s.endOverrideLocked(ctx, "battery is at the minimum SOC", nil)
+			} else if power := s.dischargePowerLocked(); power != s.sessionPowerLocked() {
+				if err := s.adjustSessionPowerLocked(ctx, power, commandReasonManual); err != nil {
+					l.Error("failed to adjust discharge power", "error", err)
+				}
+			} else {
+				s.refreshPassiveModeLocked(ctx, s.sessionPowerLocked())
+			}
+		}
+	}
+	return missed
+}
+
+// expireOverrideLocked ends an override whose time is up. A manual session is
+// stopped first, so the plan takes over from idle. Caller must hold s.mu.
+func (s *Service) expireOverrideLocked(ctx context.Context, soc int) {
+	switch {
+	case s.override.Action == ControlCharge && s.state == StateCharging:
+		s.stopChargingLocked(ctx, soc)
+	case s.override.Action == ControlDischarge && s.state == StateDischarging:
+		s.stopDischargingLocked(ctx, soc)
+	}
+	s.endOverrideLocked(ctx, "expired", nil)
+}
+
+// rejectOverrideLocked ends an override the battery's state does not allow. Caller must hold s.mu.
+func (s *Service) rejectOverrideLocked(ctx context.Context, reason string) {
+	slog.Warn("manual override rejected", "action", s.override.Action, "reason", reason)
+	s.endOverrideLocked(ctx, reason, fmt.Errorf("%w: %s", ErrControlRejected, reason))
+}
+
+// endOverrideLocked ends the override, if any, and notifies. err is reported
+// to the pending control request. Caller must hold s.mu.
+func (s *Service) endOverrideLocked(ctx context.Context, reason string, err error) {
+	o := s.override
+	if o == nil {
+		return
+	}
+	s.override = nil
+	s.overrideErr = err
+	slog.Info("manual override ended", "action", o.Action, "reason", reason)
+	s.publish(EventOverride, OverrideEvent{Override: o, Reason: reason})
+
+	// Release lock for notification
+	s.mu.Unlock()
+	if s.telegramEnabled() {
+		if err := s.telegram.SendOverrideEnd(ctx, string(o.Action), reason); err != nil {
+			slog.Warn("failed to send override notification", "error", err)
+		}
+	}
+	s.mu.Lock()
+}
+
+// overriddenSlotLocked returns the missed slot when now is in a planned window
+// the override action does not carry out. Caller must hold s.mu.
+func (s *Service) overriddenSlotLocked(now time.Time, action ControlAction) *MissedSlot {
+	if s.currentPlan == nil || !s.currentPlan.ShouldTrade() {
+		return nil
+	}
+	if s.currentPlan.IsInChargeWindow(now) && action != ControlCharge {
+		return s.missedSlotLocked(now, ActionCharge, MissManualOverride)
+	}
+	if s.currentPlan.IsInDischargeWindow(now) && action != ControlDischarge {
+		return s.missedSlotLocked(now, ActionDischarge, MissManualOverride)
+	}
+	return nil
+}
+
+// overrideHoldsBatteryLocked reports whether an override other than pause is
+// active, which solar charging yields to. Caller must hold s.mu.
+func (s *Service) overrideHoldsBatteryLocked(now time.Time) bool {
+	return s.override != nil && s.override.Action != ControlPause && now.Before(s.override.Until)
+}
+
+// manualLocked reports whether the active override is the given action. Caller must hold s.mu.
+func (s *Service) manualLocked(action ControlAction) bool {
+	return s.override != nil && s.override.Action == action
+}
+
+// GetOverride returns the active manual override, or nil.
+func (s *Service) GetOverride() *ManualOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.override == nil {
+		return nil
+	}
+	override := *s.override
+	return &override
+}
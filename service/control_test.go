@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateControl(t *testing.T) {
+	svc := &Service{cfg: testConfigSmallBattery()}
+	tests := []struct {
+		name    string
+		req     ControlRequest
+		wantErr bool
+	}{
+		{"charge at configured power", ControlRequest{Action: ControlCharge}, false},
+		{"discharge at lower power", ControlRequest{Action: ControlDischarge, PowerW: 800, Duration: 2 * time.Hour}, false},
+		{"charge above configured power", ControlRequest{Action: ControlCharge, PowerW: 3000}, true},
+		{"charge below session minimum", ControlRequest{Action: ControlCharge, PowerW: 50}, true},
+		{"pause with power", ControlRequest{Action: ControlPause, PowerW: 500}, true},
+		{"resume", ControlRequest{Action: ControlResume}, false},
+		{"unknown action", ControlRequest{Action: "boost"}, true},
+		{"too long", ControlRequest{Action: ControlIdle, Duration: 25 * time.Hour}, true},
+		{"negative duration", ControlRequest{Action: ControlPause, Duration: -time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := svc.validateControl(&req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateControl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && req.Duration <= 0 {
+				t.Errorf("duration = %s, want a default", req.Duration)
+			}
+		})
+	}
+}
+
+func TestValidateControl_NegativeDuration(t *testing.T) {
+	svc := &Service{cfg: testConfigSmallBattery()}
+	req := ControlRequest{Action: ControlPause, Duration: -5 * time.Minute}
+	err := svc.validateControl(&req)
+	if err == nil || err.Error() != "duration must not be negative, got -5m0s" {
+		t.Errorf("validateControl() error = %v, want a negative duration error", err)
+	}
+}
+
+func TestApplyControl_ManualChargeAndResume(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	battery := NewMockBattery(50)
+	clock := baseTime.Add(15 * time.Minute) // outside the planned windows
+
+	svc := newTestService(testConfigSmallBattery(), battery, prices, clock)
+	svc.SetClock(func() time.Time { return clock })
+	ctx := context.Background()
+
+	result, err := svc.applyControl(ctx, ControlRequest{Action: ControlCharge, PowerW: 1200, Duration: time.Hour})
+	if err != nil {
+		t.Fatalf("applyControl(charge) error = %v", err)
+	}
+	if result.State != StateCharging || result.Override == nil || result.Message == "" {
+		t.Fatalf("result = %+v, want charging with an override", result)
+	}
+	if len(battery.ChargeCalls) != 1 || battery.ChargeCalls[0].PowerW != 1200 {
+		t.Fatalf("charge calls = %+v, want one at 1200 W", battery.ChargeCalls)
+	}
+	if svc.currentCycleID != "" || !svc.currentTradeManual {
+		t.Errorf("session cycle = %q, manual = %v, want a manual session outside the plan", svc.currentCycleID, svc.currentTradeManual)
+	}
+
+	// Later ticks keep charging although the plan has no window now
+	clock = clock.Add(5 * time.Minute)
+	svc.tick(ctx)
+	if svc.state != StateCharging {
+		t.Fatalf("state after tick = %s, want charging", svc.state)
+	}
+
+	battery.SOC = 60
+	result, err = svc.applyControl(ctx, ControlRequest{Action: ControlResume})
+	if err != nil {
+		t.Fatalf("applyControl(resume) error = %v", err)
+	}
+	if result.State != StateIdle || result.Override != nil {
+		t.Errorf("result = %+v, want idle without override", result)
+	}
+	trade := svc.recorder.GetLastChargeTrade()
+	if trade == nil || !trade.Manual || trade.PowerW != 1200 || trade.CycleID != "" {
+		t.Fatalf("last charge = %+v, want a manual charge at 1200 W", trade)
+	}
+}
+
+func TestApplyControl_RejectedWhenBatteryFull(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(100)
+	svc := newTestService(testConfigSmallBattery(), battery, makePrices(baseTime, 0.05, 0.15, 0.25, 0.10), baseTime.Add(15*time.Minute))
+
+	_, err := svc.applyControl(context.Background(), ControlRequest{Action: ControlCharge, Duration: time.Hour})
+	if !errors.Is(err, ErrControlRejected) {
+		t.Fatalf("applyControl() error = %v, want ErrControlRejected", err)
+	}
+	if svc.override != nil || len(battery.ChargeCalls) != 0 {
+		t.Errorf("override = %+v, charge calls = %d, want neither", svc.override, len(battery.ChargeCalls))
+	}
+}
+
+func TestApplyControl_PauseStopsPlannedSession(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(50)
+	svc := newTestService(testConfigSmallBattery(), battery, makePrices(baseTime, 0.05, 0.15, 0.25, 0.10), baseTime)
+	ctx := context.Background()
+
+	svc.tick(ctx) // planned charge window
+	if svc.state != StateCharging {
+		t.Fatalf("state = %s, want charging", svc.state)
+	}
+
+	if _, err := svc.applyControl(ctx, ControlRequest{Action: ControlPause, Duration: time.Hour}); err != nil {
+		t.Fatalf("applyControl(pause) error = %v", err)
+	}
+	if svc.state != StateIdle {
+		t.Fatalf("state = %s, want idle", svc.state)
+	}
+	svc.tick(ctx) // still in the charge window
+	if svc.state != StateIdle || len(battery.ChargeCalls) != 1 {
+		t.Errorf("state = %s with %d charge calls, want paused trading", svc.state, len(battery.ChargeCalls))
+	}
+	if got := svc.nextActionLocked(baseTime); got != "trading paused until 01:00" {
+		t.Errorf("next action = %q", got)
+	}
+}
+
+func TestTick_OverrideExpiresAndPlanResumes(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(50)
+	clock := baseTime
+	svc := newTestService(testConfigSmallBattery(), battery, makePrices(baseTime, 0.05, 0.15, 0.25, 0.10), clock)
+	svc.SetClock(func() time.Time { return clock })
+	svc.events = NewEventBus()
+	sub := svc.Events().Subscribe(EventOverride)
+	defer sub.Close()
+	ctx := context.Background()
+
+	if _, err := svc.applyControl(ctx, ControlRequest{Action: ControlIdle, Duration: 10 * time.Minute}); err != nil {
+		t.Fatalf("applyControl(idle) error = %v", err)
+	}
+	if svc.state != StateIdle || len(battery.ChargeCalls) != 0 {
+		t.Fatalf("state = %s with %d charge calls, want idle during the charge window", svc.state, len(battery.ChargeCalls))
+	}
+
+	clock = baseTime.Add(10 * time.Minute)
+	svc.tick(ctx)
+	if svc.override != nil || svc.state != StateCharging {
+		t.Errorf("override = %+v, state = %s, want the plan to charge after expiry", svc.override, svc.state)
+	}
+
+	events := drainEvents(sub)
+	if len(events) != 2 {
+		t.Fatalf("override events = %d, want set and expired", len(events))
+	}
+	if ended := events[1].Data.(OverrideEvent); ended.Active || ended.Reason != "expired" {
+		t.Errorf("end event = %+v, want expired", ended)
+	}
+}
+
+func TestSolarTick_YieldsToManualIdle(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(50)
+	meter := NewMockMeter(true, -500)
+	svc := newTestServiceWithMeter(testConfigSmallBattery(), battery, meter, makePrices(baseTime, 0.10, 0.10, 0.10, 0.10), baseTime)
+	svc.override = &ManualOverride{Action: ControlIdle, Since: baseTime, Until: baseTime.Add(time.Hour)}
+
+	ctx := context.Background()
+	for range solarStartQualificationCount + 1 {
+		svc.solarTick(ctx)
+	}
+	if svc.state != StateIdle || len(battery.ChargeCalls) != 0 {
+		t.Errorf("state = %s with %d charge calls, want no solar charging", svc.state, len(battery.ChargeCalls))
+	}
+
+	// Pause suspends grid trading only
+	svc.override.Action = ControlPause
+	for range solarStartQualificationCount {
+		svc.solarTick(ctx)
+	}
+	if svc.state != StateSolarCharging {
+		t.Errorf("state = %s, want solar charging while paused", svc.state)
+	}
+}
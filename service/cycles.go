@@ -0,0 +1,445 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/foae/marstek-energy-trading/clients/telegram"
+)
+
+const (
+	plansFile       = "plans.jsonl"        // every trading plan that became active
+	missedSlotsFile = "missed_slots.jsonl" // planned slots that were not traded, with the reason
+	slotDuration    = 15 * time.Minute
+)
+
+// MissReason explains why a planned slot was not traded.
+type MissReason string
+
+const (
+	MissBatteryFull         MissReason = "battery_full"
+	MissBatteryEmpty        MissReason = "battery_empty" // at the minimum SOC
+	MissChargingDisabled    MissReason = "charging_disabled"
+	MissDischargingDisabled MissReason = "discharging_disabled"
+	MissFuseHeadroom        MissReason = "fuse_headroom"
+	MissCooldown            MissReason = "cooldown" // retry cooldown after a failed command
+	MissCommandFailure      MissReason = "command_failure"
+	MissManualOverride      MissReason = "manual_override" // a manual command or pause took precedence
+	MissUnknown             MissReason = "unknown"         // no decision recorded, e.g. the service was not running
+)
+
+// PlanRecord is a trading plan as it was activated.
+type PlanRecord struct {
+	CreatedAt time.Time   `json:"created_at"`
+	Plan      TradingPlan `json:"plan"`
+}
+
+// MissedSlot is a 15-minute slot of a planned window that was not traded.
+type MissedSlot struct {
+	Time    time.Time   `json:"time"` // slot start
+	CycleID string      `json:"cycle_id"`
+	Action  TradeAction `json:"action"`
+	Reason  MissReason  `json:"reason"`
+}
+
+// CycleReport compares a planned cycle with its execution.
+type CycleReport struct {
+	ID                     string           `json:"id"`
+	PlannedAt              time.Time        `json:"planned_at"`
+	ChargeWindow           TimeWindow       `json:"charge_window"`    // planned average charge price in Price
+	DischargeWindow        TimeWindow       `json:"discharge_window"` // planned average discharge price in Price
+	PlannedProfit          decimal.Decimal  `json:"planned_profit_eur_kwh"`
+	RealizedChargePrice    *decimal.Decimal `json:"realized_charge_price,omitempty"`
+	RealizedDischargePrice *decimal.Decimal `json:"realized_discharge_price,omitempty"`
+	RealizedProfit         *decimal.Decimal `json:"realized_profit_eur_kwh,omitempty"` // set when both legs traded
+	ChargedKWh             decimal.Decimal  `json:"charged_kwh"`
+	DischargedKWh          decimal.Decimal  `json:"discharged_kwh"`
+	PnLEUR                 decimal.Decimal  `json:"pnl_eur"` // discharge revenue minus charge cost of the cycle's trades
+	Trades                 int              `json:"trades"`
+	PlannedSlots           int              `json:"planned_slots"` // slots of both windows that have ended
+	MissedSlots            []MissedSlot     `json:"missed_slots"`
+}
+
+// CycleJournal persists activated trading plans and the planned slots the
+// control loop did not trade.
+type CycleJournal struct {
+	mu      sync.Mutex
+	dataDir string
+	plans   []PlanRecord
+	missed  []MissedSlot
+}
+
+// NewCycleJournal creates a cycle journal persisted in dataDir.
+// An empty dataDir keeps the journal in memory only.
+func NewCycleJournal(dataDir string) *CycleJournal {
+	return &CycleJournal{dataDir: dataDir}
+}
+
+// RecordPlan appends an activated plan to the journal.
+func (j *CycleJournal) RecordPlan(createdAt time.Time, plan TradingPlan) error {
+	rec := PlanRecord{CreatedAt: createdAt, Plan: plan}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.plans = append(j.plans, rec)
+	return j.appendToFile(plansFile, rec)
+}
+
+// RecordMissed appends a missed slot to the journal. A slot is recorded once,
+// with the first reason seen for it.
+func (j *CycleJournal) RecordMissed(slot MissedSlot) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if slices.ContainsFunc(j.missed, func(m MissedSlot) bool {
+		return m.CycleID == slot.CycleID && m.Action == slot.Action && m.Time.Equal(slot.Time)
+	}) {
+		return nil
+	}
+	j.missed = append(j.missed, slot)
+	return j.appendToFile(missedSlotsFile, slot)
+}
+
+// appendToFile writes one JSON line to a journal file. Caller must hold j.mu.
+func (j *CycleJournal) appendToFile(name string, v any) error {
+	return appendJSONLine(j.dataDir, name, v)
+}
+
+// appendJSONLine appends one JSON record to a file in dataDir. An empty
+// dataDir disables persistence.
+func appendJSONLine(dataDir, name string, v any) error {
+	if dataDir == "" {
+		return nil // No persistence configured
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s record: %w", name, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dataDir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// Load reads the plans and missed slots recorded by earlier runs.
+func (j *CycleJournal) Load() error {
+	if j.dataDir == "" {
+		return nil // No persistence configured
+	}
+	plans, err := readJSONLines[PlanRecord](filepath.Join(j.dataDir, plansFile))
+	if err != nil {
+		return err
+	}
+	missed, err := readJSONLines[MissedSlot](filepath.Join(j.dataDir, missedSlotsFile))
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.plans = append(plans, j.plans...)
+	j.missed = append(missed, j.missed...)
+	return nil
+}
+
+// readJSONLines reads a JSON Lines file. A missing file is empty; malformed
+// lines, such as a final line cut short by a crash, are skipped.
+func readJSONLines[T any](path string) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open %s: %w", filepath.Base(path), err)
+	}
+	defer f.Close()
+
+	var records []T
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for scanner.Scan() {
+		var rec T
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			slog.Warn("skipping malformed journal line", "path", path, "error", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+	return records, nil
+}
+
+// plannedCycle is a cycle from the most recent plan that contained it.
+type plannedCycle struct {
+	cycle     TradeCycle
+	plannedAt time.Time
+}
+
+// cyclesBetween returns the planned cycles whose charge window starts in
+// [from, to), in charge window order.
+func (j *CycleJournal) cyclesBetween(from, to time.Time) []plannedCycle {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	byID := make(map[string]plannedCycle)
+	for _, rec := range j.plans {
+		for _, c := range rec.Plan.Cycles {
+			if c.ChargeWindow.Start.Before(from) || !c.ChargeWindow.Start.Before(to) {
+				continue
+			}
+			if prev, ok := byID[c.ID()]; !ok || !rec.CreatedAt.Before(prev.plannedAt) {
+				byID[c.ID()] = plannedCycle{cycle: c, plannedAt: rec.CreatedAt}
+			}
+		}
+	}
+	cycles := make([]plannedCycle, 0, len(byID))
+	for _, pc := range byID {
+		cycles = append(cycles, pc)
+	}
+	slices.SortFunc(cycles, func(a, b plannedCycle) int {
+		return a.cycle.ChargeWindow.Start.Compare(b.cycle.ChargeWindow.Start)
+	})
+	return cycles
+}
+
+// missedFor returns the recorded missed slots of a cycle.
+func (j *CycleJournal) missedFor(cycleID string) []MissedSlot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var missed []MissedSlot
+	for _, m := range j.missed {
+		if m.CycleID == cycleID {
+			missed = append(missed, m)
+		}
+	}
+	return missed
+}
+
+// session is a stretch of time the battery traded for a cycle.
+type session struct {
+	action     TradeAction
+	start, end time.Time
+}
+
+// covers reports whether any session of the action overlaps the slot starting at t.
+func covers(sessions []session, action TradeAction, t time.Time) bool {
+	return slices.ContainsFunc(sessions, func(s session) bool {
+		return s.action == action && s.start.Before(t.Add(slotDuration)) && s.end.After(t)
+	})
+}
+
+// buildCycleReport compares a planned cycle with the trades linked to it.
+// Slots of the plan that ended before now without a session are missed; the
+// reason comes from the control loop's record, or MissUnknown without one.
+func buildCycleReport(pc plannedCycle, trades []Trade, active *session, recorded []MissedSlot, efficiency decimal.Decimal, now time.Time) CycleReport {
+	c := pc.cycle
+	report := CycleReport{
+		ID:              c.ID(),
+		PlannedAt:       pc.plannedAt,
+		ChargeWindow:    c.ChargeWindow,
+		DischargeWindow: c.DischargeWindow,
+		PlannedProfit:   c.Profit,
+		ChargedKWh:      decimal.Zero,
+		DischargedKWh:   decimal.Zero,
+		PnLEUR:          decimal.Zero,
+		Trades:          len(trades),
+		MissedSlots:     []MissedSlot{},
+	}
+
+	var sessions []session
+	chargeCost, dischargeRevenue := decimal.Zero, decimal.Zero
+	for _, t := range trades {
+		sessions = append(sessions, session{action: t.Action, start: t.Timestamp, end: t.Timestamp.Add(time.Duration(t.DurationS) * time.Second)})
+		switch t.Action {
+		case ActionCharge:
+			report.ChargedKWh = report.ChargedKWh.Add(t.EnergyKWh)
+			chargeCost = chargeCost.Add(t.PriceEUR.Mul(t.EnergyKWh))
+		case ActionDischarge:
+			report.DischargedKWh = report.DischargedKWh.Add(t.EnergyKWh)
+			dischargeRevenue = dischargeRevenue.Add(t.PriceEUR.Mul(t.EnergyKWh))
+		}
+	}
+	if active != nil {
+		sessions = append(sessions, *active)
+	}
+	report.PnLEUR = dischargeRevenue.Sub(chargeCost)
+
+	if report.ChargedKWh.Sign() > 0 {
+		price := chargeCost.Div(report.ChargedKWh).Round(4)
+		report.RealizedChargePrice = &price
+	}
+	if report.DischargedKWh.Sign() > 0 {
+		price := dischargeRevenue.Div(report.DischargedKWh).Round(4)
+		report.RealizedDischargePrice = &price
+	}
+	if report.RealizedChargePrice != nil && report.RealizedDischargePrice != nil {
+		// Same formula as the analyzer: discharge price * efficiency - charge price
+		profit := report.RealizedDischargePrice.Mul(efficiency).Sub(*report.RealizedChargePrice).Round(4)
+		report.RealizedProfit = &profit
+	}
+
+	legs := []struct {
+		action TradeAction
+		window TimeWindow
+	}{{ActionCharge, c.ChargeWindow}, {ActionDischarge, c.DischargeWindow}}
+	for _, leg := range legs {
+		for slot := leg.window.Start; slot.Before(leg.window.End) && !slot.Add(slotDuration).After(now); slot = slot.Add(slotDuration) {
+			report.PlannedSlots++
+			if covers(sessions, leg.action, slot) {
+				continue
+			}
+			missed := MissedSlot{Time: slot, CycleID: report.ID, Action: leg.action, Reason: MissUnknown}
+			if i := slices.IndexFunc(recorded, func(m MissedSlot) bool { return m.Action == leg.action && m.Time.Equal(slot) }); i >= 0 {
+				missed.Reason = recorded[i].Reason
+			}
+			report.MissedSlots = append(report.MissedSlots, missed)
+		}
+	}
+	return report
+}
+
+// cycleIDLocked returns the ID of the planned cycle at now, or "" outside one.
+// Caller must hold s.mu.
+func (s *Service) cycleIDLocked(now time.Time) string {
+	if s.currentPlan == nil {
+		return ""
+	}
+	if c, ok := s.currentPlan.CycleAt(now); ok {
+		return c.ID()
+	}
+	return ""
+}
+
+// missedSlotLocked returns the missed slot for a planned window at now, or nil
+// outside a planned cycle. Caller must hold s.mu.
+func (s *Service) missedSlotLocked(now time.Time, action TradeAction, reason MissReason) *MissedSlot {
+	id := s.cycleIDLocked(now)
+	if id == "" {
+		return nil
+	}
+	return &MissedSlot{Time: now.Truncate(slotDuration), CycleID: id, Action: action, Reason: reason}
+}
+
+// journalMissedSlot records a missed slot. Must be called without holding s.mu.
+func (s *Service) journalMissedSlot(slot *MissedSlot) {
+	if s.cycles == nil || slot == nil {
+		return
+	}
+	if err := s.cycles.RecordMissed(*slot); err != nil {
+		slog.Warn("failed to journal missed slot", "cycle", slot.CycleID, "error", err)
+	}
+}
+
+// recordPlan persists a plan that became active. Must be called without holding s.mu.
+func (s *Service) recordPlan(plan *TradingPlan) {
+	if s.cycles == nil || plan == nil || len(plan.Cycles) == 0 {
+		return
+	}
+	if err := s.cycles.RecordPlan(s.now(), *plan); err != nil {
+		slog.Warn("failed to journal trading plan", "error", err)
+	}
+}
+
+// parseDay parses a YYYY-MM-DD date as midnight in the configured timezone.
+// An empty date is today.
+func (s *Service) parseDay(date string) (time.Time, error) {
+	if date == "" {
+		return localMidnight(s.now()), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, s.loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("day must be a date such as 2024-01-15, got %q", date)
+	}
+	return day, nil
+}
+
+// GetCycles returns planned-vs-realized reports for the cycles whose charge
+// window starts on the given day (YYYY-MM-DD, empty for today).
+func (s *Service) GetCycles(date string) ([]CycleReport, error) {
+	from, err := s.parseDay(date)
+	if err != nil {
+		return nil, err
+	}
+	return s.cycleReports(from), nil
+}
+
+// cycleReports builds the reports of the cycles planned for the day starting at from.
+func (s *Service) cycleReports(from time.Time) []CycleReport {
+	reports := make([]CycleReport, 0)
+	if s.cycles == nil {
+		return reports
+	}
+	planned := s.cycles.cyclesBetween(from, from.AddDate(0, 0, 1))
+	if len(planned) == 0 {
+		return reports
+	}
+	trades := s.recorder.GetCycleTrades()
+
+	s.mu.RLock()
+	now := s.now()
+	var active *session
+	if s.currentCycleID != "" && (s.state == StateCharging || s.state == StateDischarging) {
+		action := ActionCharge
+		if s.state == StateDischarging {
+			action = ActionDischarge
+		}
+		active = &session{action: action, start: s.currentTradeStart, end: now}
+	}
+	activeID := s.currentCycleID
+	s.mu.RUnlock()
+
+	efficiency := decimal.NewFromFloat(s.cfg.BatteryEfficiency)
+	for _, pc := range planned {
+		id := pc.cycle.ID()
+		var cycleActive *session
+		if id == activeID {
+			cycleActive = active
+		}
+		reports = append(reports, buildCycleReport(pc, trades[id], cycleActive, s.cycles.missedFor(id), efficiency, now))
+	}
+	return reports
+}
+
+// cycleSummary converts a cycle report for the Telegram daily summary.
+func cycleSummary(c CycleReport) telegram.CycleSummary {
+	summary := telegram.CycleSummary{
+		ChargeStart:    c.ChargeWindow.Start,
+		DischargeStart: c.DischargeWindow.Start,
+		PlannedProfit:  c.PlannedProfit.InexactFloat64(),
+		ChargedKWh:     c.ChargedKWh.InexactFloat64(),
+		DischargedKWh:  c.DischargedKWh.InexactFloat64(),
+		PnLEUR:         c.PnLEUR.InexactFloat64(),
+		MissedSlots:    len(c.MissedSlots),
+	}
+	if c.RealizedProfit != nil {
+		realized := c.RealizedProfit.InexactFloat64()
+		summary.RealizedProfit = &realized
+	}
+	for _, m := range c.MissedSlots {
+		if !slices.Contains(summary.MissReasons, string(m.Reason)) {
+			summary.MissReasons = append(summary.MissReasons, string(m.Reason))
+		}
+	}
+	return summary
+}
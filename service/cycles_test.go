@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGetCycles_LinksTradesAndMissedSlots(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	battery := NewMockBattery(50)
+	svc := newTestService(testConfigSmallBattery(), battery, prices, baseTime)
+	svc.cycles = NewCycleJournal("")
+	svc.recordPlan(svc.currentPlan)
+	ctx := context.Background()
+
+	// Charge through the charge window
+	svc.tick(ctx)
+	battery.SOC = 80
+	svc.nowFunc = func() time.Time { return baseTime.Add(15 * time.Minute) }
+	svc.tick(ctx)
+
+	// The battery is drained by the house before the discharge window
+	battery.SOC = 10
+	svc.nowFunc = func() time.Time { return baseTime.Add(30 * time.Minute) }
+	svc.tick(ctx)
+	if svc.state != StateIdle {
+		t.Fatalf("state = %s, want idle at min SOC", svc.state)
+	}
+
+	svc.nowFunc = func() time.Time { return baseTime.Add(time.Hour) }
+	cycles, err := svc.GetCycles("2024-01-15")
+	if err != nil {
+		t.Fatalf("GetCycles() error = %v", err)
+	}
+	if len(cycles) != 1 {
+		t.Fatalf("GetCycles() returned %d cycles, want 1", len(cycles))
+	}
+	c := cycles[0]
+	if c.Trades != 1 || !c.ChargedKWh.Equal(decimal.NewFromFloat(0.15)) {
+		t.Errorf("cycle trades = %d, charged %s kWh; want the 0.15 kWh charge linked", c.Trades, c.ChargedKWh)
+	}
+	if c.RealizedChargePrice == nil || !c.RealizedChargePrice.Equal(decimal.NewFromFloat(0.05)) {
+		t.Errorf("RealizedChargePrice = %v, want 0.05", c.RealizedChargePrice)
+	}
+	if c.RealizedProfit != nil {
+		t.Errorf("RealizedProfit = %s, want nil without a discharge", c.RealizedProfit)
+	}
+	if c.PlannedSlots != 2 || len(c.MissedSlots) != 1 {
+		t.Fatalf("planned %d slots, missed %+v; want 2 planned and the discharge slot missed", c.PlannedSlots, c.MissedSlots)
+	}
+	if m := c.MissedSlots[0]; m.Action != ActionDischarge || m.Reason != MissBatteryEmpty {
+		t.Errorf("missed slot = %+v, want discharge missed because the battery was empty", m)
+	}
+
+	if _, err := svc.GetCycles("15-01-2024"); err == nil {
+		t.Error("GetCycles() with a malformed day succeeded, want an error")
+	}
+}
+
+func TestCycleJournal_ReloadsPlansAndMissedSlots(t *testing.T) {
+	dir := t.TempDir()
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	plan := AnalyzePrices(makePrices(baseTime, 0.05, 0.15, 0.25, 0.10), (&Service{cfg: testConfigSmallBattery()}).analyzerConfig())
+	cycle := plan.Cycles[0]
+
+	j := NewCycleJournal(dir)
+	if err := j.RecordPlan(baseTime, *plan); err != nil {
+		t.Fatalf("RecordPlan() error = %v", err)
+	}
+	missed := MissedSlot{Time: baseTime, CycleID: cycle.ID(), Action: ActionCharge, Reason: MissCooldown}
+	j.RecordMissed(missed)
+	missed.Reason = MissCommandFailure
+	j.RecordMissed(missed) // same slot: the first reason is kept
+
+	reloaded := NewCycleJournal(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	planned := reloaded.cyclesBetween(baseTime, baseTime.AddDate(0, 0, 1))
+	if len(planned) != 1 || planned[0].cycle.ID() != cycle.ID() {
+		t.Fatalf("reloaded cycles = %+v, want the planned cycle", planned)
+	}
+
+	// Nothing traded and the service stopped after the first slot
+	report := buildCycleReport(planned[0], nil, nil, reloaded.missedFor(cycle.ID()), decimal.NewFromFloat(0.9), baseTime.Add(time.Hour))
+	if len(report.MissedSlots) != 2 {
+		t.Fatalf("missed slots = %+v, want both slots", report.MissedSlots)
+	}
+	if report.MissedSlots[0].Reason != MissCooldown || report.MissedSlots[1].Reason != MissUnknown {
+		t.Errorf("reasons = %s, %s; want cooldown, unknown", report.MissedSlots[0].Reason, report.MissedSlots[1].Reason)
+	}
+}
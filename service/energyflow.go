@@ -0,0 +1,78 @@
+package service
+
+import (
+	"log/slog"
+	"math"
+	"time"
+)
+
+// EnergyFlow breaks the site's power down into solar production, grid
+// exchange, battery and house consumption. The house load is not measured
+// directly: it is what remains after the other flows, grid + PV - battery.
+type EnergyFlow struct {
+	Time               time.Time `json:"time"`
+	PVPowerW           *float64  `json:"pv_power_w,omitempty"` // solar production, nil without a PV meter
+	GridPowerW         float64   `json:"grid_power_w"`         // positive imports, negative exports
+	BatteryPowerW      float64   `json:"battery_power_w"`      // positive charging, negative discharging
+	HouseLoadW         float64   `json:"house_load_w"`         // consumption of everything else
+	SelfSufficiencyPct float64   `json:"self_sufficiency_pct"` // share of the house load not imported from the grid
+	PVMeasured         bool      `json:"pv_measured"`          // false: the house load excludes unmeasured solar production
+	PVError            string    `json:"pv_error,omitempty"`   // why the PV meter reading is missing
+}
+
+// SetPVMeter sets the optional PV production meter. Not thread-safe, call before Start().
+func (s *Service) SetPVMeter(pv PVReader) {
+	s.pv = pv
+}
+
+// pvEnabled returns true if a PV production meter is configured.
+func (s *Service) pvEnabled() bool {
+	return s.pv != nil && s.pv.Enabled()
+}
+
+// readEnergyFlow reads the grid and PV meters and combines them with the
+// measured battery power. It returns nil when no grid meter is available.
+// Performs network I/O: must be called without holding s.mu.
+func (s *Service) readEnergyFlow(batteryPowerW float64) *EnergyFlow {
+	if !s.meterEnabled() {
+		return nil
+	}
+	gridW, err := s.meter.GetActivePowerW()
+	if err != nil {
+		slog.Debug("energy flow: failed to read grid meter", "error", err)
+		return nil
+	}
+
+	flow := &EnergyFlow{
+		Time:          s.now(),
+		GridPowerW:    gridW,
+		BatteryPowerW: batteryPowerW,
+	}
+	if s.pvEnabled() {
+		if pvW, err := s.pv.GetPVPowerW(); err != nil {
+			slog.Debug("energy flow: failed to read PV meter", "error", err)
+			flow.PVError = err.Error()
+		} else {
+			flow.PVPowerW = &pvW
+			flow.PVMeasured = true
+		}
+	}
+	flow.HouseLoadW, flow.SelfSufficiencyPct = houseLoad(gridW, flow.PVPowerW, batteryPowerW)
+	return flow
+}
+
+// houseLoad returns the house consumption, grid + PV - battery, and the
+// percentage of it covered by solar and battery rather than grid import.
+// Small negative results from meter timing skew are clamped to zero.
+func houseLoad(gridW float64, pvW *float64, batteryW float64) (loadW, selfSufficiencyPct float64) {
+	loadW = gridW - batteryW
+	if pvW != nil {
+		loadW += *pvW
+	}
+	loadW = max(loadW, 0)
+	if loadW == 0 {
+		return 0, 100
+	}
+	imported := min(max(gridW, 0), loadW)
+	return loadW, math.Round((1-imported/loadW)*1000) / 10
+}
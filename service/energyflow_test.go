@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// MockPVReader implements PVReader for testing.
+type MockPVReader struct {
+	PVPowerW float64
+	Err      error
+}
+
+func (m *MockPVReader) Enabled() bool { return true }
+
+func (m *MockPVReader) GetPVPowerW() (float64, error) {
+	return m.PVPowerW, m.Err
+}
+
+func TestHouseLoad(t *testing.T) {
+	pv := func(w float64) *float64 { return &w }
+	tests := []struct {
+		name     string
+		gridW    float64
+		pvW      *float64
+		batteryW float64
+		wantLoad float64
+		wantPct  float64
+	}{
+		{"grid only", 800, nil, 0, 800, 0},
+		{"solar covers load and exports", -1200, pv(2000), 0, 800, 100},
+		{"solar charges battery, grid tops up", 300, pv(1500), 1200, 600, 50},
+		{"battery discharges into the house", 0, pv(0), -700, 700, 100},
+		{"grid charging is not house load", 2900, pv(0), 2500, 400, 0},
+		{"meter skew clamps to zero", -20, pv(0), 10, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			load, pct := houseLoad(tt.gridW, tt.pvW, tt.batteryW)
+			if load != tt.wantLoad || pct != tt.wantPct {
+				t.Errorf("houseLoad() = %v W, %v%%; want %v W, %v%%", load, pct, tt.wantLoad, tt.wantPct)
+			}
+		})
+	}
+}
+
+func TestGetCurrentStatus_EnergyFlow(t *testing.T) {
+	baseTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.10, 0.10, 0.10)
+	battery := NewMockBattery(60)
+	battery.CurrentPower = 1000 // solar charging
+	meter := NewMockMeter(true, -300)
+	svc := newTestServiceWithMeter(testConfigSmallBattery(), battery, meter, prices, baseTime)
+	pv := &MockPVReader{PVPowerW: 1800}
+	svc.SetPVMeter(pv)
+
+	flow := svc.GetCurrentStatus(context.Background()).EnergyFlow
+	if flow == nil {
+		t.Fatal("EnergyFlow = nil, want a breakdown with grid meter and battery available")
+	}
+	if !flow.PVMeasured || flow.PVPowerW == nil || *flow.PVPowerW != 1800 {
+		t.Errorf("PV = %v (measured %v), want 1800 W", flow.PVPowerW, flow.PVMeasured)
+	}
+	if flow.GridPowerW != -300 || flow.BatteryPowerW != 1000 || flow.HouseLoadW != 500 || flow.SelfSufficiencyPct != 100 {
+		t.Errorf("flow = %+v, want 500 W house load fully self-supplied", flow)
+	}
+
+	// A failing PV meter leaves the house load without solar production.
+	pv.Err = errors.New("timeout")
+	flow = svc.GetCurrentStatus(context.Background()).EnergyFlow
+	if flow == nil || flow.PVMeasured || flow.PVError == "" || flow.HouseLoadW != 0 {
+		t.Errorf("flow = %+v, want unmeasured PV with the error reported", flow)
+	}
+
+	// No grid meter, no breakdown.
+	svc.meter = NewMockMeter(false, 0)
+	if flow := svc.GetCurrentStatus(context.Background()).EnergyFlow; flow != nil {
+		t.Errorf("EnergyFlow = %+v without grid meter, want nil", flow)
+	}
+}
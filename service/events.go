@@ -0,0 +1,192 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of event published on the event bus.
+type EventType string
+
+const (
+	EventStateChange EventType = "state_change" // trading state transition
+	EventTradeStart  EventType = "trade_start"  // charge, discharge or solar charge session started
+	EventTradeEnd    EventType = "trade_end"    // session ended, data is the recorded trade
+	EventPlan        EventType = "plan"         // trading plan for today or tomorrow analyzed
+	EventSolarPower  EventType = "solar_power"  // solar charge power adjusted to the surplus
+	EventOverride    EventType = "override"     // manual override set or ended
+	EventError       EventType = "error"        // error that is also sent to Telegram
+	EventTelemetry   EventType = "telemetry"    // control loop sample
+)
+
+// EventTypes lists all event types in a stable order.
+var EventTypes = []EventType{
+	EventStateChange, EventTradeStart, EventTradeEnd, EventPlan, EventSolarPower, EventOverride, EventError, EventTelemetry,
+}
+
+// eventBufferSize is the number of events a subscriber may fall behind
+// before newer events are dropped for it.
+const eventBufferSize = 64
+
+// Event is published on the event bus.
+type Event struct {
+	ID   uint64    `json:"id"` // increases by one per published event
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// StateChangeEvent is the data of an EventStateChange.
+type StateChangeEvent struct {
+	From State `json:"from"`
+	To   State `json:"to"`
+}
+
+// TradeStartEvent is the data of an EventTradeStart.
+type TradeStartEvent struct {
+	Action   TradeAction `json:"action"`
+	PriceEUR float64     `json:"price_eur_kwh"`
+	PowerW   int         `json:"power_w"`
+	SOC      int         `json:"soc"`
+	CycleID  string      `json:"cycle_id,omitempty"`
+}
+
+// PlanEvent is the data of an EventPlan.
+type PlanEvent struct {
+	Day  string       `json:"day"` // today or tomorrow
+	Plan *TradingPlan `json:"plan"`
+}
+
+// SolarPowerEvent is the data of an EventSolarPower.
+type SolarPowerEvent struct {
+	OldPowerW int     `json:"old_power_w"`
+	NewPowerW int     `json:"new_power_w"`
+	SurplusW  float64 `json:"surplus_w"` // smoothed surplus the power follows
+}
+
+// ErrorEvent is the data of an EventError.
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+// ParseEventTypes parses a comma-separated list of event types. Empty means all types.
+func ParseEventTypes(s string) ([]EventType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var types []EventType
+	for part := range strings.SplitSeq(s, ",") {
+		t := EventType(strings.TrimSpace(part))
+		if !slices.Contains(EventTypes, t) {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// EventBus fans out service events to subscribers. Publishing never blocks:
+// a subscriber that falls behind by more than its buffer misses events.
+type EventBus struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[*Subscription]struct{}
+}
+
+// NewEventBus creates an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events of a subscriber.
+type Subscription struct {
+	bus     *EventBus
+	ch      chan Event
+	types   map[EventType]bool // nil receives all types
+	dropped int
+}
+
+// Subscribe returns a subscription to the given event types, or to all
+// events when none are given. Close it when done.
+func (b *EventBus) Subscribe(types ...EventType) *Subscription {
+	sub := &Subscription{bus: b, ch: make(chan Event, eventBufferSize)}
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish sends an event to the subscribers of its type.
+func (b *EventBus) Publish(t EventType, at time.Time, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	event := Event{ID: b.nextID, Type: t, Time: at, Data: data}
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[t] {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+			slog.Debug("event subscriber is behind, dropping event", "type", t, "dropped", sub.dropped)
+		}
+	}
+}
+
+// Events returns the channel of the subscription's events. It is closed by Close.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.ch
+}
+
+// Dropped returns the number of events missed because the subscriber was behind.
+func (sub *Subscription) Dropped() int {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	return sub.dropped
+}
+
+// Close unsubscribes and closes the events channel. It may be called more than once.
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	if _, ok := sub.bus.subs[sub]; !ok {
+		return
+	}
+	delete(sub.bus.subs, sub)
+	close(sub.ch)
+}
+
+// Events returns the service's event bus.
+func (s *Service) Events() *EventBus {
+	return s.events
+}
+
+// publish publishes an event stamped with the service clock. Safe to call with or without s.mu.
+func (s *Service) publish(t EventType, data any) {
+	if s.events == nil {
+		return
+	}
+	s.events.Publish(t, s.now(), data)
+}
+
+// setStateLocked changes the trading state and publishes the transition. Caller must hold s.mu.
+func (s *Service) setStateLocked(state State) {
+	if s.state == state {
+		return
+	}
+	from := s.state
+	s.state = state
+	s.publish(EventStateChange, StateChangeEvent{From: from, To: state})
+}
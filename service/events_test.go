@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// drainEvents returns the events already delivered to a subscription.
+func drainEvents(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-sub.Events():
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func eventTypesOf(events []Event) []EventType {
+	types := make([]EventType, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestEventBus_FiltersByType(t *testing.T) {
+	bus := NewEventBus()
+	all := bus.Subscribe()
+	trades := bus.Subscribe(EventTradeStart, EventTradeEnd)
+	defer all.Close()
+	defer trades.Close()
+
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	bus.Publish(EventTelemetry, at, TelemetrySample{SOC: 50})
+	bus.Publish(EventTradeStart, at, TradeStartEvent{Action: ActionCharge})
+	bus.Publish(EventError, at, ErrorEvent{Message: "boom"})
+
+	if got := eventTypesOf(drainEvents(all)); len(got) != 3 {
+		t.Errorf("unfiltered subscriber got %v, want 3 events", got)
+	}
+	got := drainEvents(trades)
+	if len(got) != 1 || got[0].Type != EventTradeStart {
+		t.Fatalf("filtered subscriber got %v, want only trade_start", eventTypesOf(got))
+	}
+	if got[0].ID != 2 || !got[0].Time.Equal(at) {
+		t.Errorf("event = id %d at %v, want id 2 at %v", got[0].ID, got[0].Time, at)
+	}
+}
+
+func TestEventBus_DropsWhenSubscriberIsBehind(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe()
+	defer sub.Close()
+
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for range eventBufferSize + 5 {
+		bus.Publish(EventTelemetry, at, nil) // must not block
+	}
+	if got := len(drainEvents(sub)); got != eventBufferSize {
+		t.Errorf("received %d events, want %d", got, eventBufferSize)
+	}
+	if got := sub.Dropped(); got != 5 {
+		t.Errorf("Dropped() = %d, want 5", got)
+	}
+}
+
+func TestEventBus_CloseUnsubscribes(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe()
+	sub.Close()
+	sub.Close() // idempotent
+
+	bus.Publish(EventError, time.Now(), ErrorEvent{Message: "after close"})
+	if _, ok := <-sub.Events(); ok {
+		t.Error("closed subscription received an event")
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	types, err := ParseEventTypes("trade_start, trade_end")
+	if err != nil {
+		t.Fatalf("ParseEventTypes() error = %v", err)
+	}
+	if len(types) != 2 || types[0] != EventTradeStart || types[1] != EventTradeEnd {
+		t.Errorf("ParseEventTypes() = %v", types)
+	}
+	if types, err := ParseEventTypes(""); err != nil || types != nil {
+		t.Errorf("ParseEventTypes(\"\") = %v, %v, want all types", types, err)
+	}
+	if _, err := ParseEventTypes("trade_start,bogus"); err == nil {
+		t.Error("ParseEventTypes() accepted an unknown type")
+	}
+}
+
+func TestTick_PublishesChargeSessionEvents(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	cfg := testConfigSmallBattery()
+	battery := NewMockBattery(50)
+	clock := baseTime
+
+	svc := newTestService(cfg, battery, prices, clock)
+	svc.SetClock(func() time.Time { return clock })
+	svc.events = NewEventBus()
+	sub := svc.Events().Subscribe(EventStateChange, EventTradeStart, EventTradeEnd)
+	defer sub.Close()
+
+	ctx := context.Background()
+	svc.tick(ctx) // charge window: start charging
+	battery.SOC = 70
+	clock = baseTime.Add(15 * time.Minute)
+	svc.tick(ctx) // window over: stop and record
+
+	events := drainEvents(sub)
+	want := []EventType{EventStateChange, EventTradeStart, EventStateChange, EventTradeEnd}
+	got := eventTypesOf(events)
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+
+	if change := events[0].Data.(StateChangeEvent); change.From != StateIdle || change.To != StateCharging {
+		t.Errorf("first state change = %+v, want idle -> charging", change)
+	}
+	start := events[1].Data.(TradeStartEvent)
+	if start.Action != ActionCharge || start.SOC != 50 || start.PriceEUR != 0.05 || start.CycleID == "" {
+		t.Errorf("trade start = %+v", start)
+	}
+	if change := events[2].Data.(StateChangeEvent); change.To != StateIdle {
+		t.Errorf("second state change = %+v, want -> idle", change)
+	}
+	trade := events[3].Data.(Trade)
+	if trade.Action != ActionCharge || trade.EndSOC != 70 || !trade.PriceEUR.Equal(decimal.NewFromFloat(0.05)) {
+		t.Errorf("trade end = %+v", trade)
+	}
+}
+
+func TestNotifyError_PublishesWithoutTelegram(t *testing.T) {
+	svc := &Service{events: NewEventBus(), nowFunc: time.Now, loc: time.UTC}
+	sub := svc.Events().Subscribe(EventError)
+	defer sub.Close()
+
+	svc.notifyError(context.Background(), "battery unreachable")
+
+	events := drainEvents(sub)
+	if len(events) != 1 || events[0].Data.(ErrorEvent).Message != "battery unreachable" {
+		t.Errorf("events = %+v, want one error event", events)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExportPeriod is the aggregation period of exported summaries.
+type ExportPeriod string
+
+const (
+	PeriodDay   ExportPeriod = "day"
+	PeriodMonth ExportPeriod = "month"
+	PeriodYear  ExportPeriod = "year"
+)
+
+// ExportQuery selects the trades or summaries to export.
+type ExportQuery struct {
+	From    string        // first day included (YYYY-MM-DD), empty for no bound
+	To      string        // last day included (YYYY-MM-DD), empty for no bound
+	Actions []TradeAction // trade actions to include, empty for all
+	Period  ExportPeriod  // summary aggregation
+}
+
+// ParseExportQuery validates export parameters. actions is a comma-separated
+// list of trade actions; period defaults to day.
+func ParseExportQuery(from, to, actions, period string) (ExportQuery, error) {
+	q := ExportQuery{From: from, To: to, Period: PeriodDay}
+	for _, bound := range []struct{ name, day string }{{"from", from}, {"to", to}} {
+		if bound.day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", bound.day); err != nil {
+			return ExportQuery{}, fmt.Errorf("%s must be a date in YYYY-MM-DD format", bound.name)
+		}
+	}
+	if from != "" && to != "" && to < from {
+		return ExportQuery{}, fmt.Errorf("from must not be after to")
+	}
+	for a := range strings.SplitSeq(actions, ",") {
+		switch action := TradeAction(strings.TrimSpace(a)); action {
+		case "":
+		case ActionCharge, ActionDischarge, ActionSolarCharge:
+			q.Actions = append(q.Actions, action)
+		default:
+			return ExportQuery{}, fmt.Errorf("unknown action %q (valid: charge, discharge, solar_charge)", action)
+		}
+	}
+	switch p := ExportPeriod(period); p {
+	case "":
+	case PeriodDay, PeriodMonth, PeriodYear:
+		q.Period = p
+	default:
+		return ExportQuery{}, fmt.Errorf("unknown period %q (valid: day, month, year)", period)
+	}
+	return q, nil
+}
+
+// includesDay reports whether a YYYY-MM-DD day is in the query's range.
+func (q ExportQuery) includesDay(day string) bool {
+	return (q.From == "" || day >= q.From) && (q.To == "" || day <= q.To)
+}
+
+// ExportTrades returns the trades matching the query, oldest first. Trades are
+// assigned to days the same way as in GetHistory.
+func (r *Recorder) ExportTrades(q ExportQuery) []Trade {
+	r.mu.Lock()
+	r.loadArchivesLocked()
+	trades := sortedByTime(r.trades)
+	r.mu.Unlock()
+
+	result := make([]Trade, 0, len(trades))
+	for _, t := range trades {
+		if !q.includesDay(t.Timestamp.Format("2006-01-02")) {
+			continue
+		}
+		if len(q.Actions) > 0 && !slices.Contains(q.Actions, t.Action) {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// ExportSummaries returns the daily summaries in the query's range, oldest
+// first, aggregated by the query's period and without their trades. P&L stays
+// booked on the day of each discharge, so it is unaffected by the range.
+func (r *Recorder) ExportSummaries(q ExportQuery) []DailySummary {
+	history := r.GetHistory()
+	days := make([]DailySummary, 0, len(history.Days))
+	for _, day := range slices.Backward(history.Days) {
+		if q.includesDay(day.Date) {
+			day.Trades = nil
+			days = append(days, day)
+		}
+	}
+	return aggregateSummaries(days, q.Period)
+}
+
+// aggregateSummaries merges chronological daily summaries into months or
+// years. Average prices stay weighted by energy.
+func aggregateSummaries(days []DailySummary, period ExportPeriod) []DailySummary {
+	var keyLen int
+	switch period {
+	case PeriodMonth:
+		keyLen = len("2006-01")
+	case PeriodYear:
+		keyLen = len("2006")
+	default:
+		return days
+	}
+
+	result := make([]DailySummary, 0)
+	var chargeCost, dischargeRevenue decimal.Decimal
+	flush := func() {
+		last := &result[len(result)-1]
+		if grid := last.ChargedKWh.Sub(last.SolarChargedKWh); !grid.IsZero() {
+			last.AvgChargePrice = chargeCost.Div(grid).Round(4)
+		}
+		if !last.DischargedKWh.IsZero() {
+			last.AvgDischargePrice = dischargeRevenue.Div(last.DischargedKWh).Round(4)
+		}
+	}
+	for _, day := range days {
+		key := day.Date[:keyLen]
+		if len(result) == 0 || result[len(result)-1].Date != key {
+			if len(result) > 0 {
+				flush()
+			}
+			result = append(result, DailySummary{Date: key})
+			chargeCost, dischargeRevenue = decimal.Zero, decimal.Zero
+		}
+		agg := &result[len(result)-1]
+		if day.ChargeCycles > 0 && (agg.ChargeCycles == 0 || day.MinChargePrice.LessThan(agg.MinChargePrice)) {
+			agg.MinChargePrice = day.MinChargePrice
+		}
+		if day.DischargeCycles > 0 && (agg.DischargeCycles == 0 || day.MaxDischargePrice.GreaterThan(agg.MaxDischargePrice)) {
+			agg.MaxDischargePrice = day.MaxDischargePrice
+		}
+		chargeCost = chargeCost.Add(day.AvgChargePrice.Mul(day.ChargedKWh.Sub(day.SolarChargedKWh)))
+		dischargeRevenue = dischargeRevenue.Add(day.AvgDischargePrice.Mul(day.DischargedKWh))
+		agg.ChargedKWh = agg.ChargedKWh.Add(day.ChargedKWh)
+		agg.DischargedKWh = agg.DischargedKWh.Add(day.DischargedKWh)
+		agg.ChargeCycles += day.ChargeCycles
+		agg.DischargeCycles += day.DischargeCycles
+		agg.SolarChargedKWh = agg.SolarChargedKWh.Add(day.SolarChargedKWh)
+		agg.SolarChargeCycles += day.SolarChargeCycles
+		agg.PnLEUR = agg.PnLEUR.Add(day.PnLEUR)
+		agg.CostBasisEUR = agg.CostBasisEUR.Add(day.CostBasisEUR)
+	}
+	if len(result) > 0 {
+		flush()
+	}
+	return result
+}
+
+// WriteTradesCSV writes trades as CSV with a header row.
+func WriteTradesCSV(w io.Writer, trades []Trade) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"timestamp", "action", "price_eur", "power_w", "duration_s", "energy_kwh", "nominal_kwh",
+		"energy_source", "start_soc", "end_soc", "market_price_eur", "cycle_id", "partial", "manual",
+	})
+	for _, t := range trades {
+		cw.Write([]string{
+			t.Timestamp.Format(time.RFC3339),
+			string(t.Action),
+			t.PriceEUR.String(),
+			strconv.Itoa(t.PowerW),
+			strconv.Itoa(t.DurationS),
+			t.EnergyKWh.String(),
+			optionalDecimal(t.NominalKWh),
+			string(t.EnergySource),
+			strconv.Itoa(t.StartSOC),
+			strconv.Itoa(t.EndSOC),
+			optionalDecimal(t.MarketPriceEUR),
+			t.CycleID,
+			strconv.FormatBool(t.Partial),
+			strconv.FormatBool(t.Manual),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteSummariesCSV writes summaries as CSV with a header row. The period
+// column holds the day, month or year.
+func WriteSummariesCSV(w io.Writer, days []DailySummary) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"period", "charged_kwh", "discharged_kwh", "charge_cycles", "discharge_cycles",
+		"solar_charged_kwh", "solar_charge_cycles", "pnl_eur", "cost_basis_eur",
+		"avg_charge_price", "min_charge_price", "avg_discharge_price", "max_discharge_price",
+	})
+	for _, d := range days {
+		cw.Write([]string{
+			d.Date,
+			d.ChargedKWh.String(),
+			d.DischargedKWh.String(),
+			strconv.Itoa(d.ChargeCycles),
+			strconv.Itoa(d.DischargeCycles),
+			d.SolarChargedKWh.String(),
+			strconv.Itoa(d.SolarChargeCycles),
+			d.PnLEUR.Round(4).String(),
+			d.CostBasisEUR.Round(4).String(),
+			d.AvgChargePrice.Round(4).String(),
+			d.MinChargePrice.String(),
+			d.AvgDischargePrice.Round(4).String(),
+			d.MaxDischargePrice.String(),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// optionalDecimal formats an optional value, empty when nil.
+func optionalDecimal(d *decimal.Decimal) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
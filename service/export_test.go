@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func exportRecorder() *Recorder {
+	r := NewRecorder("", 0.90, time.UTC)
+	jan := time.Date(2024, 1, 30, 2, 0, 0, 0, time.UTC)
+	r.RecordTrade(ledgerTrade(jan, ActionCharge, 0.10, 2.0))
+	r.RecordTrade(ledgerTrade(jan.Add(16*time.Hour), ActionDischarge, 0.30, 0.9))
+	r.RecordTrade(ledgerTrade(jan.AddDate(0, 0, 1), ActionCharge, 0.20, 1.0))
+	r.RecordTrade(ledgerTrade(jan.AddDate(0, 0, 3), ActionDischarge, 0.40, 1.8)) // February 2
+	return r
+}
+
+func TestExportTrades_FiltersRangeAndActions(t *testing.T) {
+	r := exportRecorder()
+
+	q, err := ParseExportQuery("2024-01-31", "2024-02-02", "discharge, charge", "")
+	if err != nil {
+		t.Fatalf("ParseExportQuery() error = %v", err)
+	}
+	trades := r.ExportTrades(q)
+	if len(trades) != 2 || trades[0].Action != ActionCharge || trades[1].Action != ActionDischarge {
+		t.Fatalf("ExportTrades() = %+v, want the January 31 charge and the February 2 discharge", trades)
+	}
+
+	q, _ = ParseExportQuery("", "", "discharge", "")
+	if trades := r.ExportTrades(q); len(trades) != 2 {
+		t.Errorf("ExportTrades(discharge) returned %d trades, want 2", len(trades))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTradesCSV(&buf, trades); err != nil {
+		t.Fatalf("WriteTradesCSV() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "2024-01-31T02:00:00Z,charge,0.2,") {
+		t.Errorf("CSV = %q, want a header and two rows starting with the charge", buf.String())
+	}
+}
+
+func TestExportSummaries_MonthlyAggregation(t *testing.T) {
+	r := exportRecorder()
+
+	q, err := ParseExportQuery("", "", "", "month")
+	if err != nil {
+		t.Fatalf("ParseExportQuery() error = %v", err)
+	}
+	months := r.ExportSummaries(q)
+	if len(months) != 2 || months[0].Date != "2024-01" || months[1].Date != "2024-02" {
+		t.Fatalf("ExportSummaries() = %+v, want January and February", months)
+	}
+
+	jan := months[0]
+	if !jan.ChargedKWh.Equal(decimal.NewFromFloat(3.0)) || jan.ChargeCycles != 2 {
+		t.Errorf("January charged %s kWh in %d cycles, want 3 kWh in 2", jan.ChargedKWh, jan.ChargeCycles)
+	}
+	// (2 × 0.10 + 1 × 0.20) / 3
+	if !jan.AvgChargePrice.Equal(decimal.NewFromFloat(0.1333)) || !jan.MinChargePrice.Equal(decimal.NewFromFloat(0.10)) {
+		t.Errorf("January charge prices avg %s min %s, want 0.1333 and 0.10", jan.AvgChargePrice, jan.MinChargePrice)
+	}
+	// 0.9 kWh sold at 0.30 against 1 kWh bought at 0.10
+	if !jan.PnLEUR.Equal(decimal.NewFromFloat(0.17)) {
+		t.Errorf("January P&L = %s, want 0.17", jan.PnLEUR)
+	}
+	// The rest of the first lot and the second lot: 0.72 - 0.1 - 0.2
+	if feb := months[1]; !feb.PnLEUR.Equal(decimal.NewFromFloat(0.42)) || feb.Trades != nil {
+		t.Errorf("February P&L = %s with %d trades, want 0.42 without trades", feb.PnLEUR, len(feb.Trades))
+	}
+
+	q, _ = ParseExportQuery("2024-02-01", "", "", "year")
+	if years := r.ExportSummaries(q); len(years) != 1 || years[0].Date != "2024" || years[0].DischargeCycles != 1 {
+		t.Errorf("yearly export from February = %+v, want one 2024 row with the February discharge", years)
+	}
+}
+
+func TestParseExportQuery_Errors(t *testing.T) {
+	tests := []struct {
+		name                      string
+		from, to, actions, period string
+	}{
+		{"bad date", "2024-1-5", "", "", ""},
+		{"reversed range", "2024-02-01", "2024-01-01", "", ""},
+		{"unknown action", "", "", "sell", ""},
+		{"unknown period", "", "", "", "week"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseExportQuery(tt.from, tt.to, tt.actions, tt.period); err == nil {
+				t.Error("ParseExportQuery() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	fuseCheckInterval   = 5 * time.Second
+	fuseHeadroomA       = 1.0              // stay this far below the fuse rating
+	fuseLimitStaleAfter = 30 * time.Second // older limits are ignored
+	fuseMeasurementAge  = 10 * time.Second // older battery power samples are measured again
+	fuseAdjustStepW     = 100              // minimum change before re-commanding the battery (< headroom)
+	fuseMinSessionW     = 100              // don't start a session below this power
+)
+
+// PhaseLimits is the battery power the battery's phase can currently take
+// without exceeding the main fuse or the per-phase feed-in limit.
+type PhaseLimits struct {
+	Time            time.Time `json:"time"`
+	Phase           int       `json:"phase"`             // 1-based phase the battery is connected to
+	PhasePowerW     float64   `json:"phase_power_w"`     // measured: positive imports, negative exports
+	PhaseCurrentA   float64   `json:"phase_current_a"`   // measured (or derived from power)
+	HouseLoadW      float64   `json:"house_load_w"`      // phase power without the battery's own contribution
+	MaxChargeW      int       `json:"max_charge_w"`      // capped at CHARGE_POWER_W
+	MaxDischargeW   int       `json:"max_discharge_w"`   // capped at DISCHARGE_POWER_W
+	Throttled       bool      `json:"throttled"`         // running session is below its configured power
+	ThrottledPowerW int       `json:"throttled_power_w"` // power of the throttled session
+}
+
+// fuseProtectionEnabled returns true if a fuse rating is configured and a meter is available.
+func (s *Service) fuseProtectionEnabled() bool {
+	return s.cfg.MainFuseA > 0 && s.meterEnabled()
+}
+
+// currentPhaseLimitsLocked returns the latest limits, or nil if none are fresh. Caller must hold s.mu.
+func (s *Service) currentPhaseLimitsLocked() *PhaseLimits {
+	if s.phaseLimits == nil || s.now().Sub(s.phaseLimits.Time) > fuseLimitStaleAfter {
+		return nil
+	}
+	return s.phaseLimits
+}
+
+// fullPowerLocked returns the unthrottled power of a grid session in the given
+// state: the power of a manual override, or CHARGE_POWER_W or
+// DISCHARGE_POWER_W. Caller must hold s.mu.
+func (s *Service) fullPowerLocked(state State) int {
+	if state == StateDischarging {
+		if s.manualLocked(ControlDischarge) && s.override.PowerW > 0 {
+			return s.override.PowerW
+		}
+		return s.cfg.DischargePowerW
+	}
+	if s.manualLocked(ControlCharge) && s.override.PowerW > 0 {
+		return s.override.PowerW
+	}
+	return s.cfg.ChargePowerW
+}
+
+// chargePowerLocked returns the grid charge power to command: the full charge
+// power, throttled to the fuse headroom on the battery's phase. Caller must hold s.mu.
+func (s *Service) chargePowerLocked() int {
+	power := s.fullPowerLocked(StateCharging)
+	if limits := s.currentPhaseLimitsLocked(); limits != nil {
+		power = min(power, limits.MaxChargeW)
+	}
+	return power
+}
+
+// dischargePowerLocked returns the discharge power to command: the full
+// discharge power, limited by the per-phase feed-in limit. Caller must hold s.mu.
+func (s *Service) dischargePowerLocked() int {
+	power := s.fullPowerLocked(StateDischarging)
+	if limits := s.currentPhaseLimitsLocked(); limits != nil {
+		power = min(power, limits.MaxDischargeW)
+	}
+	return power
+}
+
+// sessionPowerLocked returns the power of the running grid session: the
+// full power, or the throttled power set by fuseTick. Caller must hold s.mu.
+func (s *Service) sessionPowerLocked() int {
+	full := s.fullPowerLocked(s.state)
+	if s.phaseThrottled {
+		return min(full, s.phaseThrottleW)
+	}
+	return full
+}
+
+// tradePowerLocked returns the unthrottled power the current session was
+// started at, which outlives an override that ends before the session does.
+// Caller must hold s.mu.
+func (s *Service) tradePowerLocked(state State) int {
+	if s.currentTradePowerW > 0 {
+		return s.currentTradePowerW
+	}
+	return s.fullPowerLocked(state)
+}
+
+// setSessionPowerLocked records the power a grid session was started or adjusted to. Caller must hold s.mu.
+func (s *Service) setSessionPowerLocked(powerW, fullW int) {
+	s.phaseThrottled = powerW < fullW
+	s.phaseThrottleW = powerW
+}
+
+// batteryPhasePowerLocked returns the battery's own contribution to the phase
+// power as seen by the meter: positive while charging. Grid sessions use the
+// measured power, not the commanded power the battery may not have reached
+// yet. Without a fresh measurement the battery counts as 0 W, so its actual
+// power is taken for house load and the limits err on the safe side.
+// Caller must hold s.mu.
+func (s *Service) batteryPhasePowerLocked() float64 {
+	switch s.state {
+	case StateCharging, StateDischarging:
+		if !s.sessionSampleFreshLocked() {
+			return 0
+		}
+		if s.state == StateDischarging {
+			return -s.tradePowerW
+		}
+		return s.tradePowerW
+	case StateSolarCharging:
+		return s.solarMeasuredChargePowerW
+	}
+	return 0
+}
+
+// sessionSampleFreshLocked reports whether the grid session's last battery
+// power sample is recent and was taken after the session's last command.
+// Caller must hold s.mu.
+func (s *Service) sessionSampleFreshLocked() bool {
+	return s.tradeLastSample.After(s.lastPassiveRefresh) && s.now().Sub(s.tradeLastSample) <= fuseMeasurementAge
+}
+
+// fuseTick reads the battery's phase and throttles a running grid charge or
+// discharge session so that house load plus battery power stays below the
+// main fuse (charging) and the per-phase feed-in limit (discharging).
+func (s *Service) fuseTick(ctx context.Context) {
+	// Read meter OUTSIDE lock (network I/O)
+	phases, err := s.meter.GetPhases()
+	if err != nil {
+		slog.Debug("fuse protection: failed to read meter phases", "error", err)
+		return
+	}
+	phaseIdx := max(s.cfg.BatteryPhase, 1) - 1
+	if phaseIdx >= len(phases) {
+		slog.Warn("fuse protection: meter does not report the battery's phase",
+			"battery_phase", s.cfg.BatteryPhase, "phases", len(phases))
+		return
+	}
+	phase := phases[phaseIdx]
+
+	// Measure the battery OUTSIDE lock when the session's last sample is stale
+	s.mu.RLock()
+	measure := (s.state == StateCharging || s.state == StateDischarging) && !s.sessionSampleFreshLocked()
+	s.mu.RUnlock()
+	var batteryPowerW *float64
+	if measure {
+		if power, err := s.battery.GetBatteryPower(ctx); err != nil {
+			slog.Debug("fuse protection: failed to read battery power", "error", err)
+		} else {
+			batteryPowerW = &power
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	voltage := phase.Voltage()
+	batteryW := s.batteryPhasePowerLocked()
+	if batteryPowerW != nil && (s.state == StateCharging || s.state == StateDischarging) {
+		s.accumulateTradeEnergyLocked(*batteryPowerW)
+		batteryW = *batteryPowerW // measured after the session's last command
+	}
+	houseLoadW := phase.PowerW - batteryW
+	fuseW := (float64(s.cfg.MainFuseA) - fuseHeadroomA) * voltage
+	feedInW := fuseW
+	if s.cfg.PhaseFeedInLimitW > 0 {
+		feedInW = min(feedInW, float64(s.cfg.PhaseFeedInLimitW))
+	}
+
+	// Charging imports on top of the house load; discharging first covers the
+	// house load and exports the rest.
+	limits := &PhaseLimits{
+		Time:          s.now(),
+		Phase:         phaseIdx + 1,
+		PhasePowerW:   phase.PowerW,
+		PhaseCurrentA: phase.Current(),
+		HouseLoadW:    houseLoadW,
+		MaxChargeW:    clampPowerW(fuseW-houseLoadW, s.fullPowerLocked(StateCharging)),
+		MaxDischargeW: clampPowerW(feedInW+houseLoadW, s.fullPowerLocked(StateDischarging)),
+	}
+	s.phaseLimits = limits
+
+	var target int
+	switch s.state {
+	case StateCharging:
+		target = limits.MaxChargeW
+	case StateDischarging:
+		target = limits.MaxDischargeW
+	default:
+		return
+	}
+	current := s.sessionPowerLocked()
+	full := s.fullPowerLocked(s.state)
+	if abs(target-current) < fuseAdjustStepW && !(target == full && current != full) {
+		limits.Throttled, limits.ThrottledPowerW = s.phaseThrottled, current
+		return
+	}
+
+	l := slog.With("state", s.state, "phase", limits.Phase, "phase_power_w", phase.PowerW,
+		"phase_current_a", limits.PhaseCurrentA, "house_load_w", houseLoadW, "old_w", current, "new_w", target)
+	if target < current {
+		l.Warn("fuse protection: throttling battery power")
+	} else {
+		l.Info("fuse protection: restoring battery power")
+	}
+	if err := s.adjustSessionPowerLocked(ctx, target, "fuse"); err != nil {
+		l.Error("fuse protection: failed to adjust battery power", "error", err)
+	}
+	limits.Throttled, limits.ThrottledPowerW = s.phaseThrottled, s.sessionPowerLocked()
+}
+
+// adjustSessionPowerLocked re-commands the running grid session at powerW,
+// journaled with the given reason. Caller must hold s.mu.
+func (s *Service) adjustSessionPowerLocked(ctx context.Context, powerW int, reason string) error {
+	state := s.state
+	full := s.fullPowerLocked(state)
+	passivePower := -powerW // passive mode: negative charges
+	if state == StateDischarging {
+		passivePower = powerW
+	}
+
+	// Release lock during network I/O
+	s.mu.Unlock()
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandPhaseLimit, Reason: reason, PowerW: -passivePower, StateBefore: state, StateAfter: state},
+		func(ctx context.Context) error {
+			return s.battery.SetPassiveModeContext(ctx, passivePower, s.cfg.PassiveModeTimeoutS)
+		})
+	s.journalCommands(cmd)
+	s.mu.Lock()
+
+	if err != nil {
+		return err
+	}
+	if s.state != state {
+		return nil // session ended while the lock was released
+	}
+	s.setSessionPowerLocked(powerW, full)
+	s.lastPassiveRefresh = s.now()
+	return nil
+}
+
+// clampPowerW rounds w down to whole watts within [0, maxW].
+func clampPowerW(w float64, maxW int) int {
+	return min(max(int(math.Floor(w)), 0), maxW)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/grid"
+)
+
+// newFuseTestService returns a service with a 25 A main fuse on L1 and a clock it can advance.
+func newFuseTestService(t *testing.T) (*Service, *MockBattery, *MockMeterReader, *time.Time) {
+	t.Helper()
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	cfg := testConfigSmallBattery()
+	cfg.MainFuseA = 25
+	cfg.BatteryPhase = 1
+	battery := NewMockBattery(50)
+	meter := NewMockMeter(true, 0)
+	meter.SetPhases(grid.Phase{PowerW: 300, VoltageV: 230}, grid.Phase{VoltageV: 230}, grid.Phase{VoltageV: 230})
+	svc := newTestServiceWithMeter(cfg, battery, meter, prices, baseTime)
+	now := baseTime
+	svc.nowFunc = func() time.Time { return now }
+	return svc, battery, meter, &now
+}
+
+func batteryPower(b *MockBattery) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.CurrentPower
+}
+
+func TestFuseTick_ThrottlesAndRestoresCharging(t *testing.T) {
+	svc, battery, meter, _ := newFuseTestService(t)
+	ctx := context.Background()
+
+	svc.fuseTick(ctx)
+	svc.tick(ctx)
+	if svc.state != StateCharging || batteryPower(battery) != 2000 {
+		t.Fatalf("state = %s at %d W, want charging at 2000 W", svc.state, batteryPower(battery))
+	}
+
+	// Dinner: 4.5 kW of house load plus the 2 kW charge on L1.
+	// Fuse budget (25 A - 1 A headroom) * 230 V = 5520 W leaves 1020 W for the battery.
+	meter.SetPhases(grid.Phase{PowerW: 6500, VoltageV: 230}, grid.Phase{VoltageV: 230}, grid.Phase{VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != 1020 {
+		t.Fatalf("battery power = %d W, want throttled to 1020 W", got)
+	}
+	limits := svc.currentPhaseLimitsLocked()
+	if limits == nil || !limits.Throttled || limits.HouseLoadW != 4500 {
+		t.Errorf("limits = %+v, want throttled with 4500 W house load", limits)
+	}
+	if svc.sessionPowerLocked() != 1020 {
+		t.Errorf("session power = %d, want 1020", svc.sessionPowerLocked())
+	}
+
+	// Small load change within the adjustment step: no new command.
+	meter.SetPhases(grid.Phase{PowerW: 5570, VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != 1020 {
+		t.Errorf("battery power = %d W, want unchanged 1020 W", got)
+	}
+
+	// Cooking done: back to full power.
+	meter.SetPhases(grid.Phase{PowerW: 1520, VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != 2000 {
+		t.Errorf("battery power = %d W, want restored to 2000 W", got)
+	}
+	if svc.phaseThrottled {
+		t.Error("phaseThrottled = true after restore, want false")
+	}
+}
+
+func TestFuseTick_DoesNotStartWithoutHeadroom(t *testing.T) {
+	svc, battery, meter, _ := newFuseTestService(t)
+	ctx := context.Background()
+
+	meter.SetPhases(grid.Phase{PowerW: 5500, CurrentA: 24, VoltageV: 230})
+	svc.fuseTick(ctx)
+	svc.tick(ctx)
+	if svc.state != StateIdle || len(battery.ChargeCalls) != 0 {
+		t.Errorf("state = %s with %d charge calls, want idle without charging", svc.state, len(battery.ChargeCalls))
+	}
+}
+
+func TestFuseTick_StartsChargingAtReducedPower(t *testing.T) {
+	svc, battery, meter, _ := newFuseTestService(t)
+	ctx := context.Background()
+
+	meter.SetPhases(grid.Phase{PowerW: 4000, VoltageV: 230})
+	svc.fuseTick(ctx)
+	svc.tick(ctx)
+	if len(battery.ChargeCalls) != 1 || battery.ChargeCalls[0].PowerW != 1520 {
+		t.Fatalf("charge calls = %+v, want one at 1520 W", battery.ChargeCalls)
+	}
+
+	// The passive refresh keeps the throttled power.
+	svc.lastPassiveRefresh = time.Time{}
+	svc.tick(ctx)
+	if got := batteryPower(battery); got != 1520 {
+		t.Errorf("battery power after refresh = %d W, want 1520 W", got)
+	}
+}
+
+func TestFuseTick_LimitsDischargeByFeedIn(t *testing.T) {
+	svc, battery, meter, now := newFuseTestService(t)
+	svc.cfg.PhaseFeedInLimitW = 800
+	ctx := context.Background()
+
+	svc.tick(ctx)
+	*now = now.Add(15 * time.Minute)
+	svc.tick(ctx)
+	*now = now.Add(15 * time.Minute)
+	meter.SetPhases(grid.Phase{PowerW: 300, VoltageV: 230})
+	svc.fuseTick(ctx)
+	svc.tick(ctx)
+	if svc.state != StateDischarging {
+		t.Fatalf("state = %s, want discharging", svc.state)
+	}
+	// 300 W house load + 800 W feed-in limit.
+	if len(battery.DischargeCalls) != 1 || battery.DischargeCalls[0].PowerW != 1100 {
+		t.Fatalf("discharge calls = %+v, want one at 1100 W", battery.DischargeCalls)
+	}
+
+	// House load drops to 100 W: 1000 W would be exported, limit discharge to 900 W.
+	meter.SetPhases(grid.Phase{PowerW: -1000, VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != -900 {
+		t.Errorf("battery power = %d W, want -900 W", got)
+	}
+}
+
+func TestFuseTick_WatchdogIgnoresSessionThrottledToZero(t *testing.T) {
+	svc, battery, meter, now := newFuseTestService(t)
+	ctx := context.Background()
+
+	svc.tick(ctx)
+	meter.SetPhases(grid.Phase{PowerW: 8000, VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != 0 {
+		t.Fatalf("battery power = %d W, want throttled to 0 W", got)
+	}
+
+	*now = now.Add(watchdogSettleTime)
+	svc.watchdogTick(ctx)
+	svc.watchdogTick(ctx)
+	if svc.lastDrift != nil {
+		t.Errorf("lastDrift = %+v, want no drift for a throttled session", svc.lastDrift)
+	}
+}
+
+func TestFuseTick_UsesMeasuredBatteryPowerWhileRampingUp(t *testing.T) {
+	svc, battery, meter, now := newFuseTestService(t)
+	ctx := context.Background()
+
+	svc.tick(ctx)
+	if svc.state != StateCharging {
+		t.Fatalf("state = %s, want charging", svc.state)
+	}
+
+	// Commanded 2000 W but still ramping at 800 W: the house draws 5200 W, so
+	// only 320 W fit under the 5520 W fuse budget, not 1520 W.
+	*now = now.Add(time.Minute)
+	battery.mu.Lock()
+	battery.CurrentPower = 800
+	battery.mu.Unlock()
+	meter.SetPhases(grid.Phase{PowerW: 6000, VoltageV: 230}, grid.Phase{VoltageV: 230}, grid.Phase{VoltageV: 230})
+	svc.fuseTick(ctx)
+	if limits := svc.currentPhaseLimitsLocked(); limits == nil || limits.HouseLoadW != 5200 {
+		t.Fatalf("limits = %+v, want 5200 W house load", limits)
+	}
+	if got := batteryPower(battery); got != 320 {
+		t.Errorf("battery power = %d W, want throttled to 320 W", got)
+	}
+}
+
+func TestFuseTick_AssumesNoBatteryPowerWithoutMeasurement(t *testing.T) {
+	svc, battery, meter, now := newFuseTestService(t)
+	ctx := context.Background()
+
+	svc.tick(ctx)
+	*now = now.Add(time.Minute)
+	battery.mu.Lock()
+	battery.GetStatusErr = errors.New("timeout")
+	battery.mu.Unlock()
+
+	// The whole phase power counts as house load: 5520 W - 5000 W leaves 520 W.
+	meter.SetPhases(grid.Phase{PowerW: 5000, VoltageV: 230}, grid.Phase{VoltageV: 230}, grid.Phase{VoltageV: 230})
+	svc.fuseTick(ctx)
+	if got := batteryPower(battery); got != 520 {
+		t.Errorf("battery power = %d W, want throttled to 520 W", got)
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"time"
 
+	"github.com/foae/marstek-energy-trading/clients/grid"
 	"github.com/foae/marstek-energy-trading/clients/marstek"
 	"github.com/foae/marstek-energy-trading/clients/nordpool"
 )
@@ -21,16 +23,42 @@ type BatteryController interface {
 	GetBatteryStatusContext(ctx context.Context) (*marstek.BatteryStatus, error)
 	GetESStatus(ctx context.Context) (*marstek.ESStatus, error)
 	GetBatteryPower(ctx context.Context) (float64, error)
+	GetControlStatus(ctx context.Context) (*marstek.ControlStatus, error)
 	ChargeContext(ctx context.Context, powerW int, timeoutS int) error
 	DischargeContext(ctx context.Context, powerW int, timeoutS int) error
 	SetPassiveModeContext(ctx context.Context, power int, cdTime int) error
 	IdleContext(ctx context.Context) error
 }
 
+// EnergyCounterReader is implemented by batteries that report lifetime energy
+// counters. Grid trades use them to measure the energy actually moved.
+type EnergyCounterReader interface {
+	GetEnergyCounters(ctx context.Context) (chargedWh, dischargedWh float64, err error)
+}
+
 // MeterReader reads power data from a smart meter.
 type MeterReader interface {
 	Enabled() bool
 	GetActivePowerW() (float64, error)
+	GetPhases() ([]grid.Phase, error) // L1..L3; single-phase connections report L1 only
+}
+
+// MeterRegisterReader is implemented by meters that report their cumulative
+// import and export registers, used to reconcile trades with the meter.
+type MeterRegisterReader interface {
+	GetEnergyRegisters() (importKWh, exportKWh float64, err error)
+}
+
+// HistoricalPriceProvider fetches the day-ahead prices of any day, used to
+// price meter data after the fact.
+type HistoricalPriceProvider interface {
+	FetchDayAheadPrices(ctx context.Context, date time.Time) ([]nordpool.Price, error)
+}
+
+// PVReader reads solar production from a second meter on the inverter circuit.
+type PVReader interface {
+	Enabled() bool
+	GetPVPowerW() (float64, error) // production in watts, never negative
 }
 
 // Notifier sends notifications.
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// CostBasis selects how discharged energy is matched to the energy stored.
+type CostBasis string
+
+const (
+	CostBasisFIFO    CostBasis = "fifo"    // oldest stored energy is discharged first
+	CostBasisAverage CostBasis = "average" // every stored kWh carries the weighted-average cost
+)
+
+// SolarValuation selects the cost basis of solar-charged energy.
+type SolarValuation string
+
+const (
+	SolarValuationZero   SolarValuation = "zero"   // solar energy is free
+	SolarValuationExport SolarValuation = "export" // solar energy costs the export revenue it gave up
+)
+
+// Inventory is the energy stored in the battery according to the trade
+// ledger, in kWh that can still be delivered after round-trip losses.
+type Inventory struct {
+	EnergyKWh    decimal.Decimal `json:"energy_kwh"`
+	CostBasisEUR decimal.Decimal `json:"cost_basis_eur"`
+	AvgCostEUR   decimal.Decimal `json:"avg_cost_eur_kwh"`             // cost basis per deliverable kWh
+	LossKWh      decimal.Decimal `json:"loss_kwh"`                     // charged energy lost to round-trip efficiency
+	UnmatchedKWh decimal.Decimal `json:"unmatched_kwh"`                // discharged beyond the ledger, booked at zero cost
+	MarketValue  *float64        `json:"market_value_eur,omitempty"`   // inventory at the current price, set in the status
+	Unrealized   *float64        `json:"unrealized_pnl_eur,omitempty"` // market value minus cost basis
+}
+
+// lot is stored energy with its total cost.
+type lot struct {
+	kwh  decimal.Decimal
+	cost decimal.Decimal
+}
+
+// ledger tracks the battery's energy inventory. Charges add lots: the
+// round-trip efficiency is applied when energy is stored, so a lot holds the
+// kWh it will deliver and carries the full cost of the kWh bought. Discharges
+// remove energy and return its cost basis.
+type ledger struct {
+	method     CostBasis
+	solar      SolarValuation
+	efficiency decimal.Decimal
+	lots       []lot // oldest first; the average method keeps a single lot
+	loss       decimal.Decimal
+	unmatched  decimal.Decimal
+}
+
+func newLedger(method CostBasis, solar SolarValuation, efficiency decimal.Decimal) *ledger {
+	if efficiency.Sign() <= 0 {
+		efficiency = decimal.NewFromInt(1)
+	}
+	return &ledger{method: method, solar: solar, efficiency: efficiency}
+}
+
+// apply books a trade and returns the cost basis of discharged energy.
+func (l *ledger) apply(t Trade) decimal.Decimal {
+	switch t.Action {
+	case ActionCharge:
+		l.store(t.EnergyKWh, t.PriceEUR.Mul(t.EnergyKWh))
+	case ActionSolarCharge:
+		cost := decimal.Zero
+		if l.solar == SolarValuationExport && t.MarketPriceEUR != nil {
+			cost = t.MarketPriceEUR.Mul(t.EnergyKWh)
+		}
+		l.store(t.EnergyKWh, cost)
+	case ActionDischarge:
+		return l.remove(t.EnergyKWh)
+	}
+	return decimal.Zero
+}
+
+// store adds charged energy after efficiency losses.
+func (l *ledger) store(chargedKWh, cost decimal.Decimal) {
+	if chargedKWh.Sign() <= 0 {
+		return
+	}
+	stored := chargedKWh.Mul(l.efficiency)
+	l.loss = l.loss.Add(chargedKWh.Sub(stored))
+	if l.method == CostBasisAverage && len(l.lots) > 0 {
+		l.lots[0].kwh = l.lots[0].kwh.Add(stored)
+		l.lots[0].cost = l.lots[0].cost.Add(cost)
+		return
+	}
+	l.lots = append(l.lots, lot{kwh: stored, cost: cost})
+}
+
+// remove takes discharged energy out of the inventory, oldest lots first, and
+// returns its cost basis. Energy beyond the inventory costs nothing.
+func (l *ledger) remove(kwh decimal.Decimal) decimal.Decimal {
+	cost := decimal.Zero
+	for kwh.Sign() > 0 && len(l.lots) > 0 {
+		head := &l.lots[0]
+		if kwh.GreaterThanOrEqual(head.kwh) {
+			cost = cost.Add(head.cost)
+			kwh = kwh.Sub(head.kwh)
+			l.lots = l.lots[1:]
+			continue
+		}
+		part := head.cost.Mul(kwh).Div(head.kwh)
+		cost = cost.Add(part)
+		head.cost = head.cost.Sub(part)
+		head.kwh = head.kwh.Sub(kwh)
+		kwh = decimal.Zero
+	}
+	if kwh.Sign() > 0 {
+		l.unmatched = l.unmatched.Add(kwh)
+	}
+	return cost
+}
+
+// inventory returns the energy still stored and its cost basis.
+func (l *ledger) inventory() Inventory {
+	inv := Inventory{
+		EnergyKWh:    decimal.Zero,
+		CostBasisEUR: decimal.Zero,
+		AvgCostEUR:   decimal.Zero,
+		LossKWh:      l.loss.Round(4),
+		UnmatchedKWh: l.unmatched.Round(4),
+	}
+	for _, lt := range l.lots {
+		inv.EnergyKWh = inv.EnergyKWh.Add(lt.kwh)
+		inv.CostBasisEUR = inv.CostBasisEUR.Add(lt.cost)
+	}
+	if inv.EnergyKWh.Sign() > 0 {
+		inv.AvgCostEUR = inv.CostBasisEUR.Div(inv.EnergyKWh).Round(4)
+	}
+	inv.EnergyKWh = inv.EnergyKWh.Round(4)
+	inv.CostBasisEUR = inv.CostBasisEUR.Round(4)
+	return inv
+}
+
+// ledgerCheckpoint is the ledger and realized P&L after the archived trades,
+// saved so that startup only reads the current month's journal. It holds for
+// the cost settings and archive files it was built from.
+type ledgerCheckpoint struct {
+	Archives     string          `json:"archives"` // fingerprint of the archive files
+	CostBasis    CostBasis       `json:"cost_basis"`
+	Solar        SolarValuation  `json:"solar_valuation"`
+	Efficiency   decimal.Decimal `json:"efficiency"`
+	Lots         []checkpointLot `json:"lots"`
+	LossKWh      decimal.Decimal `json:"loss_kwh"`
+	UnmatchedKWh decimal.Decimal `json:"unmatched_kwh"`
+	RealizedPnL  decimal.Decimal `json:"realized_pnl_eur"`
+	LastCharge   *Trade          `json:"last_charge,omitempty"`
+}
+
+type checkpointLot struct {
+	KWh     decimal.Decimal `json:"kwh"`
+	CostEUR decimal.Decimal `json:"cost_eur"`
+}
+
+// newLedgerCheckpoint books the archived trades on an empty ledger l.
+func newLedgerCheckpoint(l *ledger, archived []Trade, fingerprint string) *ledgerCheckpoint {
+	cp := &ledgerCheckpoint{
+		Archives:   fingerprint,
+		CostBasis:  l.method,
+		Solar:      l.solar,
+		Efficiency: l.efficiency,
+	}
+	for _, t := range sortedByTime(archived) {
+		if cost := l.apply(t); t.Action == ActionDischarge {
+			cp.RealizedPnL = cp.RealizedPnL.Add(t.PriceEUR.Mul(t.EnergyKWh).Sub(cost.Round(4)))
+		}
+		if t.Action == ActionCharge {
+			cp.LastCharge = &t
+		}
+	}
+	for _, lt := range l.lots {
+		cp.Lots = append(cp.Lots, checkpointLot{KWh: lt.kwh, CostEUR: lt.cost})
+	}
+	cp.LossKWh, cp.UnmatchedKWh = l.loss, l.unmatched
+	return cp
+}
+
+// matches reports whether the checkpoint was built with the settings of the
+// empty ledger l from the archives with the given fingerprint.
+func (cp *ledgerCheckpoint) matches(l *ledger, fingerprint string) bool {
+	return cp.Archives == fingerprint && cp.CostBasis == l.method && cp.Solar == l.solar && cp.Efficiency.Equal(l.efficiency)
+}
+
+// ledger returns a ledger in the checkpoint's state.
+func (cp *ledgerCheckpoint) ledger() *ledger {
+	l := newLedger(cp.CostBasis, cp.Solar, cp.Efficiency)
+	for _, lt := range cp.Lots {
+		l.lots = append(l.lots, lot{kwh: lt.KWh, cost: lt.CostEUR})
+	}
+	l.loss, l.unmatched = cp.LossKWh, cp.UnmatchedKWh
+	return l
+}
+
+// sortedByTime returns the trades ordered by timestamp, keeping the record
+// order of trades with the same timestamp.
+func sortedByTime(trades []Trade) []Trade {
+	sorted := append([]Trade(nil), trades...)
+	sort.SliceStable(sorted, func(a, b int) bool { return sorted[a].Timestamp.Before(sorted[b].Timestamp) })
+	return sorted
+}
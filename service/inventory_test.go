@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func ledgerTrade(ts time.Time, action TradeAction, price, kwh float64) Trade {
+	return Trade{Timestamp: ts, Action: action, PriceEUR: decimal.NewFromFloat(price), EnergyKWh: decimal.NewFromFloat(kwh)}
+}
+
+func TestGetHistory_OvernightCycleBookedOnDischargeDay(t *testing.T) {
+	r := NewRecorder("", 0.90, time.UTC)
+	night := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	r.RecordTrade(ledgerTrade(night, ActionCharge, 0.10, 2.0))
+	r.RecordTrade(ledgerTrade(night.Add(9*time.Hour), ActionDischarge, 0.30, 1.8))
+
+	history := r.GetHistory()
+	if len(history.Days) != 2 {
+		t.Fatalf("expected 2 days, got %d", len(history.Days))
+	}
+	// Sorted descending: the discharge day first
+	if discharge := history.Days[0]; !discharge.PnLEUR.Equal(decimal.NewFromFloat(0.34)) || !discharge.CostBasisEUR.Equal(decimal.NewFromFloat(0.20)) {
+		t.Errorf("discharge day P&L = %s (cost basis %s), want 0.34 (0.20)", discharge.PnLEUR, discharge.CostBasisEUR)
+	}
+	if charge := history.Days[1]; !charge.PnLEUR.IsZero() {
+		t.Errorf("charge day P&L = %s, want 0 (nothing realized)", charge.PnLEUR)
+	}
+	if !history.TotalPnL.Equal(decimal.NewFromFloat(0.34)) || !r.GetTotalPnL().Equal(history.TotalPnL) {
+		t.Errorf("total P&L = %s / %s, want 0.34", history.TotalPnL, r.GetTotalPnL())
+	}
+	if inv := history.Inventory; !inv.EnergyKWh.IsZero() || !inv.LossKWh.Equal(decimal.NewFromFloat(0.2)) {
+		t.Errorf("inventory = %+v, want empty with 0.2 kWh lost", inv)
+	}
+}
+
+func TestLedger_CostBasisMethods(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC)
+	trades := []Trade{
+		ledgerTrade(ts, ActionCharge, 0.10, 1.0),
+		ledgerTrade(ts.Add(24*time.Hour), ActionCharge, 0.30, 1.0),
+		ledgerTrade(ts.Add(30*time.Hour), ActionDischarge, 0.40, 0.9),
+	}
+
+	tests := []struct {
+		method      CostBasis
+		wantPnL     float64
+		wantStored  float64
+		wantAvgCost float64
+	}{
+		{CostBasisFIFO, 0.26, 0.30, 0.3333},
+		{CostBasisAverage, 0.16, 0.20, 0.2222},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.method), func(t *testing.T) {
+			r := NewRecorder("", 0.90, time.UTC)
+			r.SetCostBasis(tt.method, SolarValuationZero)
+			for _, trade := range trades {
+				r.RecordTrade(trade)
+			}
+			if pnl := r.GetTotalPnL(); !pnl.Equal(decimal.NewFromFloat(tt.wantPnL)) {
+				t.Errorf("GetTotalPnL() = %s, want %v", pnl, tt.wantPnL)
+			}
+			inv := r.GetInventory()
+			if !inv.EnergyKWh.Equal(decimal.NewFromFloat(0.9)) || !inv.CostBasisEUR.Equal(decimal.NewFromFloat(tt.wantStored)) {
+				t.Errorf("inventory = %s kWh at %s EUR, want 0.9 kWh at %v EUR", inv.EnergyKWh, inv.CostBasisEUR, tt.wantStored)
+			}
+			if !inv.AvgCostEUR.Equal(decimal.NewFromFloat(tt.wantAvgCost)) {
+				t.Errorf("AvgCostEUR = %s, want %v", inv.AvgCostEUR, tt.wantAvgCost)
+			}
+		})
+	}
+}
+
+func TestLedger_SolarValuation(t *testing.T) {
+	marketPrice := decimal.NewFromFloat(0.08)
+	solar := ledgerTrade(time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC), ActionSolarCharge, 0, 1.0)
+	solar.MarketPriceEUR = &marketPrice
+
+	free := newLedger(CostBasisFIFO, SolarValuationZero, decimal.NewFromFloat(0.9))
+	free.apply(solar)
+	if inv := free.inventory(); !inv.CostBasisEUR.IsZero() || !inv.EnergyKWh.Equal(decimal.NewFromFloat(0.9)) {
+		t.Errorf("zero valuation inventory = %+v, want 0.9 kWh at no cost", inv)
+	}
+
+	export := newLedger(CostBasisFIFO, SolarValuationExport, decimal.NewFromFloat(0.9))
+	export.apply(solar)
+	if inv := export.inventory(); !inv.CostBasisEUR.Equal(marketPrice) {
+		t.Errorf("export valuation cost basis = %s, want the forgone export revenue 0.08", inv.CostBasisEUR)
+	}
+
+	// Discharging more than the ledger holds books the excess at zero cost
+	if cost := export.apply(ledgerTrade(solar.Timestamp.Add(6*time.Hour), ActionDischarge, 0.30, 1.5)); !cost.Equal(marketPrice) {
+		t.Errorf("discharge cost basis = %s, want 0.08", cost)
+	}
+	if inv := export.inventory(); !inv.UnmatchedKWh.Equal(decimal.NewFromFloat(0.6)) {
+		t.Errorf("UnmatchedKWh = %s, want 0.6", inv.UnmatchedKWh)
+	}
+}
+
+func TestGetCurrentStatus_UnrealizedPnL(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.10, 0.10, 0.10)
+	svc := newTestServiceWithMeter(testConfigSmallBattery(), NewMockBattery(60), NewMockMeter(false, 0), prices, baseTime)
+	svc.recorder.RecordTrade(ledgerTrade(baseTime.Add(-8*time.Hour), ActionCharge, 0.05, 1.0))
+
+	inv := svc.GetCurrentStatus(context.Background()).Inventory
+	if !inv.EnergyKWh.Equal(decimal.NewFromFloat(0.9)) || !inv.CostBasisEUR.Equal(decimal.NewFromFloat(0.05)) {
+		t.Fatalf("inventory = %s kWh at %s EUR, want 0.9 kWh at 0.05 EUR", inv.EnergyKWh, inv.CostBasisEUR)
+	}
+	if inv.MarketValue == nil || *inv.MarketValue != 0.09 {
+		t.Errorf("MarketValue = %v, want 0.09", inv.MarketValue)
+	}
+	if inv.Unrealized == nil || *inv.Unrealized != 0.04 {
+		t.Errorf("Unrealized = %v, want 0.04", inv.Unrealized)
+	}
+}
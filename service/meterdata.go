@@ -0,0 +1,306 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	usageFile     = "meter_usage.jsonl"     // usage intervals imported from a supplier export
+	registersFile = "meter_registers.jsonl" // P1 import/export register readings, one per slot
+
+	// registerMaxGap is the longest gap between register readings that is
+	// still used as one interval; longer gaps (service down) are skipped.
+	registerMaxGap = time.Hour
+)
+
+// Usage data sources.
+const (
+	UsageSourceSupplier = "supplier" // imported supplier export
+	UsageSourceP1       = "p1"       // recorded meter registers
+)
+
+// UsageInterval is the grid energy the meter recorded over an interval.
+type UsageInterval struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	ImportKWh float64   `json:"import_kwh"`
+	ExportKWh float64   `json:"export_kwh"`
+	PriceEUR  *float64  `json:"price_eur_kwh,omitempty"` // price from the supplier export, if any
+}
+
+// RegisterReading is a reading of the meter's cumulative registers.
+type RegisterReading struct {
+	Time      time.Time `json:"time"`
+	ImportKWh float64   `json:"import_kwh"`
+	ExportKWh float64   `json:"export_kwh"`
+}
+
+// MeterDataStore persists imported supplier usage and P1 register readings.
+type MeterDataStore struct {
+	mu      sync.Mutex
+	dataDir string
+}
+
+// NewMeterDataStore creates a meter data store persisted in dataDir.
+func NewMeterDataStore(dataDir string) *MeterDataStore {
+	return &MeterDataStore{dataDir: dataDir}
+}
+
+// ImportUsage appends the intervals that are not stored yet and returns how
+// many were added. Intervals already imported are kept as they are.
+func (m *MeterDataStore) ImportUsage(intervals []UsageInterval) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, err := readJSONLines[UsageInterval](filepath.Join(m.dataDir, usageFile))
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[int64]bool, len(existing))
+	for _, iv := range existing {
+		seen[iv.Start.Unix()] = true
+	}
+	added := 0
+	for _, iv := range intervals {
+		if seen[iv.Start.Unix()] {
+			continue
+		}
+		if err := appendJSONLine(m.dataDir, usageFile, iv); err != nil {
+			return added, err
+		}
+		seen[iv.Start.Unix()] = true
+		added++
+	}
+	return added, nil
+}
+
+// RecordRegisters appends a register reading.
+func (m *MeterDataStore) RecordRegisters(r RegisterReading) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return appendJSONLine(m.dataDir, registersFile, r)
+}
+
+// Usage returns the intervals starting in [from, to), oldest first, with
+// their source. Imported supplier data is preferred; without it the intervals
+// are derived from consecutive register readings.
+func (m *MeterDataStore) Usage(from, to time.Time) ([]UsageInterval, string, error) {
+	if m.dataDir == "" {
+		return nil, "", nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	imported, err := readJSONLines[UsageInterval](filepath.Join(m.dataDir, usageFile))
+	if err != nil {
+		return nil, "", err
+	}
+	if intervals := intervalsBetween(imported, from, to); len(intervals) > 0 {
+		return intervals, UsageSourceSupplier, nil
+	}
+
+	readings, err := readJSONLines[RegisterReading](filepath.Join(m.dataDir, registersFile))
+	if err != nil {
+		return nil, "", err
+	}
+	return intervalsBetween(registerIntervals(readings), from, to), UsageSourceP1, nil
+}
+
+// intervalsBetween returns the intervals starting in [from, to), sorted by start.
+func intervalsBetween(intervals []UsageInterval, from, to time.Time) []UsageInterval {
+	var result []UsageInterval
+	for _, iv := range intervals {
+		if !iv.Start.Before(from) && iv.Start.Before(to) {
+			result = append(result, iv)
+		}
+	}
+	slices.SortFunc(result, func(a, b UsageInterval) int { return a.Start.Compare(b.Start) })
+	return result
+}
+
+// registerIntervals converts register readings into the energy recorded
+// between consecutive readings. Long gaps and register resets are skipped.
+func registerIntervals(readings []RegisterReading) []UsageInterval {
+	slices.SortFunc(readings, func(a, b RegisterReading) int { return a.Time.Compare(b.Time) })
+	var intervals []UsageInterval
+	for i := 1; i < len(readings); i++ {
+		prev, cur := readings[i-1], readings[i]
+		gap := cur.Time.Sub(prev.Time)
+		imported, exported := cur.ImportKWh-prev.ImportKWh, cur.ExportKWh-prev.ExportKWh
+		if gap <= 0 || gap > registerMaxGap || imported < 0 || exported < 0 {
+			continue
+		}
+		intervals = append(intervals, UsageInterval{Start: prev.Time, End: cur.Time, ImportKWh: imported, ExportKWh: exported})
+	}
+	return intervals
+}
+
+// usageColumns are the accepted header names of a supplier usage export,
+// after normalizing (lowercase, units in parentheses dropped, spaces as _).
+var usageColumns = map[string][]string{
+	"start":  {"start", "from", "timestamp", "time", "datetime", "date", "van", "datum", "begin"},
+	"end":    {"end", "to", "tot", "einde"},
+	"import": {"import", "import_kwh", "consumption", "usage", "delivered", "afname", "levering", "verbruik"},
+	"export": {"export", "export_kwh", "feed_in", "production", "returned", "teruglevering", "terug"},
+	"price":  {"price", "price_eur_kwh", "prijs", "tarief"},
+}
+
+// usageTimeLayouts are the timestamp layouts accepted in a supplier export.
+// Layouts without a zone are read in the configured timezone.
+var usageTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"02-01-2006 15:04:05",
+	"02-01-2006 15:04",
+	"02/01/2006 15:04",
+}
+
+// ParseUsageCSV reads a supplier's hourly or 15-minute usage export. The
+// delimiter (comma, semicolon or tab) and decimal commas are detected. Each
+// row needs a start time and the imported kWh; export, end time and price
+// columns are optional. Without an end column an interval ends where the next
+// one starts, and the last one lasts as long as the one before it.
+func ParseUsageCSV(r io.Reader, loc *time.Location) ([]UsageInterval, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read usage export: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM from spreadsheet exports
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.Comma = ','
+	for _, delim := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(delim))) > bytes.Count(header, []byte(string(cr.Comma))) {
+			cr.Comma = delim
+		}
+	}
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse usage export: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("usage export has no data rows")
+	}
+
+	cols := make(map[string]int)
+	for i, name := range records[0] {
+		name = normalizeColumn(name)
+		for col, aliases := range usageColumns {
+			if _, ok := cols[col]; !ok && slices.Contains(aliases, name) {
+				cols[col] = i
+			}
+		}
+	}
+	for _, required := range []string{"start", "import"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("usage export has no %s column (accepted: %s)", required, strings.Join(usageColumns[required], ", "))
+		}
+	}
+
+	intervals := make([]UsageInterval, 0, len(records)-1)
+	for n, rec := range records[1:] {
+		line := n + 2
+		field := func(col string) string {
+			i, ok := cols[col]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		if field("start") == "" {
+			continue // trailing empty line
+		}
+
+		var iv UsageInterval
+		if iv.Start, err = parseUsageTime(field("start"), loc); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if raw := field("end"); raw != "" {
+			if iv.End, err = parseUsageTime(raw, loc); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if iv.ImportKWh, err = parseUsageNumber(field("import")); err != nil {
+			return nil, fmt.Errorf("line %d: import: %w", line, err)
+		}
+		if raw := field("export"); raw != "" {
+			if iv.ExportKWh, err = parseUsageNumber(raw); err != nil {
+				return nil, fmt.Errorf("line %d: export: %w", line, err)
+			}
+		}
+		if raw := field("price"); raw != "" {
+			price, err := parseUsageNumber(raw)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: price: %w", line, err)
+			}
+			iv.PriceEUR = &price
+		}
+		intervals = append(intervals, iv)
+	}
+
+	slices.SortFunc(intervals, func(a, b UsageInterval) int { return a.Start.Compare(b.Start) })
+	for i := range intervals {
+		if !intervals[i].End.IsZero() {
+			continue
+		}
+		switch {
+		case i+1 < len(intervals):
+			intervals[i].End = intervals[i+1].Start
+		case i > 0:
+			intervals[i].End = intervals[i].Start.Add(intervals[i-1].End.Sub(intervals[i-1].Start))
+		default:
+			intervals[i].End = intervals[i].Start.Add(time.Hour)
+		}
+	}
+	for _, iv := range intervals {
+		if !iv.End.After(iv.Start) {
+			return nil, fmt.Errorf("interval starting %s does not end after it starts", iv.Start.Format(time.RFC3339))
+		}
+	}
+	return intervals, nil
+}
+
+// normalizeColumn lowercases a header name, drops a unit in parentheses or
+// brackets and joins words with underscores.
+func normalizeColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.IndexAny(name, "(["); i > 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	return strings.Join(strings.Fields(strings.NewReplacer("-", " ", "_", " ").Replace(name)), "_")
+}
+
+func parseUsageTime(raw string, loc *time.Location) (time.Time, error) {
+	for _, layout := range usageTimeLayouts {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", raw)
+}
+
+// parseUsageNumber parses a number with a decimal point or a decimal comma.
+func parseUsageNumber(raw string) (float64, error) {
+	if strings.Contains(raw, ",") && !strings.Contains(raw, ".") {
+		raw = strings.Replace(raw, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", raw)
+	}
+	return v, nil
+}
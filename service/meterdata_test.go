@@ -0,0 +1,112 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUsageCSV_SemicolonsAndDecimalCommas(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/Amsterdam")
+	data := "\xef\xbb\xbfDatum;Afname (kWh);Teruglevering (kWh)\n" +
+		"2024-06-01 10:00;0,125;0,000\n" +
+		"2024-06-01 10:15;0,050;0,300\n" +
+		"2024-06-01 10:30;0,000;0,410\n"
+
+	intervals, err := ParseUsageCSV(strings.NewReader(data), loc)
+	if err != nil {
+		t.Fatalf("ParseUsageCSV: %v", err)
+	}
+	if len(intervals) != 3 {
+		t.Fatalf("expected 3 intervals, got %d", len(intervals))
+	}
+	first := intervals[0]
+	if want := time.Date(2024, 6, 1, 10, 0, 0, 0, loc); !first.Start.Equal(want) {
+		t.Errorf("start = %v, want %v", first.Start, want)
+	}
+	if first.ImportKWh != 0.125 || intervals[1].ExportKWh != 0.3 {
+		t.Errorf("unexpected energy: %+v %+v", first, intervals[1])
+	}
+	// End inferred from the next start, the last one from the previous duration
+	for i, iv := range intervals {
+		if iv.End.Sub(iv.Start) != 15*time.Minute {
+			t.Errorf("interval %d lasts %v, want 15m", i, iv.End.Sub(iv.Start))
+		}
+	}
+}
+
+func TestParseUsageCSV_RequiresImportColumn(t *testing.T) {
+	_, err := ParseUsageCSV(strings.NewReader("start,export\n2024-06-01 10:00,0.1\n"), time.UTC)
+	if err == nil || !strings.Contains(err.Error(), "no import column") {
+		t.Fatalf("expected missing import column error, got %v", err)
+	}
+}
+
+func TestParseUsageCSV_PriceAndEndColumns(t *testing.T) {
+	data := "start,end,import,price\n2024-06-01T10:00:00Z,2024-06-01T11:00:00Z,1.5,0.21\n"
+	intervals, err := ParseUsageCSV(strings.NewReader(data), time.UTC)
+	if err != nil {
+		t.Fatalf("ParseUsageCSV: %v", err)
+	}
+	iv := intervals[0]
+	if iv.End.Sub(iv.Start) != time.Hour || iv.PriceEUR == nil || *iv.PriceEUR != 0.21 {
+		t.Errorf("unexpected interval: %+v", iv)
+	}
+}
+
+func TestRegisterIntervals_SkipsGapsAndResets(t *testing.T) {
+	base := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	readings := []RegisterReading{
+		{Time: base.Add(15 * time.Minute), ImportKWh: 100.2, ExportKWh: 50.0},
+		{Time: base, ImportKWh: 100.0, ExportKWh: 50.0},
+		{Time: base.Add(3 * time.Hour), ImportKWh: 101.0, ExportKWh: 50.5},           // gap
+		{Time: base.Add(3*time.Hour + 15*time.Minute), ImportKWh: 0.1, ExportKWh: 0}, // reset
+		{Time: base.Add(3*time.Hour + 30*time.Minute), ImportKWh: 0.3, ExportKWh: 0.1},
+	}
+
+	intervals := registerIntervals(readings)
+	if len(intervals) != 2 {
+		t.Fatalf("expected 2 intervals, got %d: %+v", len(intervals), intervals)
+	}
+	if !intervals[0].Start.Equal(base) || round4(intervals[0].ImportKWh) != 0.2 {
+		t.Errorf("unexpected first interval: %+v", intervals[0])
+	}
+	if round4(intervals[1].ImportKWh) != 0.2 || round4(intervals[1].ExportKWh) != 0.1 {
+		t.Errorf("unexpected second interval: %+v", intervals[1])
+	}
+}
+
+func TestMeterDataStore_ImportDedupesAndPrefersSupplier(t *testing.T) {
+	store := NewMeterDataStore(t.TempDir())
+	base := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	usage := []UsageInterval{
+		{Start: base, End: base.Add(time.Hour), ImportKWh: 1.0},
+		{Start: base.Add(time.Hour), End: base.Add(2 * time.Hour), ImportKWh: 0.5},
+	}
+
+	if added, err := store.ImportUsage(usage); err != nil || added != 2 {
+		t.Fatalf("first import: added=%d err=%v", added, err)
+	}
+	if added, err := store.ImportUsage(usage); err != nil || added != 0 {
+		t.Fatalf("second import: added=%d err=%v", added, err)
+	}
+	store.RecordRegisters(RegisterReading{Time: base, ImportKWh: 10})
+	store.RecordRegisters(RegisterReading{Time: base.Add(15 * time.Minute), ImportKWh: 10.2})
+
+	intervals, source, err := store.Usage(base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if source != UsageSourceSupplier || len(intervals) != 2 {
+		t.Errorf("expected 2 supplier intervals, got %d from %q", len(intervals), source)
+	}
+
+	// No supplier data for the next day: fall back to the registers
+	next := base.Add(24 * time.Hour)
+	store.RecordRegisters(RegisterReading{Time: next, ImportKWh: 20})
+	store.RecordRegisters(RegisterReading{Time: next.Add(15 * time.Minute), ImportKWh: 20.4})
+	intervals, source, _ = store.Usage(next, next.Add(24*time.Hour))
+	if source != UsageSourceP1 || len(intervals) != 1 || round4(intervals[0].ImportKWh) != 0.4 {
+		t.Errorf("expected 1 P1 interval of 0.4 kWh, got %+v from %q", intervals, source)
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExportRate is the rate discharged energy is valued at.
+type ExportRate string
+
+const (
+	ExportRateRetail ExportRate = "retail"  // netted against imports: spot price plus import surcharge
+	ExportRateFeedIn ExportRate = "feed_in" // not netted: spot price minus feed-in fee
+	ExportRateMixed  ExportRate = "mixed"   // partly netted
+)
+
+// Net position sources.
+const (
+	NetPositionSourceMeter  = "meter"  // P1 import/export registers, includes the household
+	NetPositionSourceTrades = "trades" // battery grid trades only
+)
+
+// NetMeteringPosition is the year-to-date balance of grid imports and exports
+// that annual net metering settles.
+type NetMeteringPosition struct {
+	Year        int        `json:"year"`
+	Active      bool       `json:"active"` // net metering applies now
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	Source      string     `json:"source"`
+	Since       *time.Time `json:"since,omitempty"` // first data of the year included
+	ImportKWh   float64    `json:"import_kwh"`
+	ExportKWh   float64    `json:"export_kwh"`
+	NetKWh      float64    `json:"net_kwh"`      // imports minus exports
+	HeadroomKWh float64    `json:"headroom_kwh"` // exports still netted at the retail price
+	ExportRate  ExportRate `json:"marginal_export_rate"`
+}
+
+// NetPosition returns the battery's grid imports (charges) and exports
+// (discharges) in the given year, by trade start in the recorder's timezone.
+func (r *Recorder) NetPosition(year int) NetMeteringPosition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadArchivesLocked()
+
+	pos := NetMeteringPosition{Year: year, Source: NetPositionSourceTrades}
+	var imported, exported decimal.Decimal
+	for _, t := range sortedByTime(r.trades) {
+		ts := t.Timestamp.In(r.loc)
+		if ts.Year() != year {
+			continue
+		}
+		switch t.Action {
+		case ActionCharge:
+			imported = imported.Add(t.EnergyKWh)
+		case ActionDischarge:
+			exported = exported.Add(t.EnergyKWh)
+		default:
+			continue
+		}
+		if pos.Since == nil {
+			pos.Since = &ts
+		}
+	}
+	pos.ImportKWh, pos.ExportKWh = imported.InexactFloat64(), exported.InexactFloat64()
+	return pos
+}
+
+// RegistersBetween returns the energy imported and exported according to the
+// register readings in [since, until) (zero until = no end), and the time of
+// the first reading used. Register resets are skipped. ok is false with fewer
+// than two readings.
+func (m *MeterDataStore) RegistersBetween(since, until time.Time) (importKWh, exportKWh float64, first time.Time, ok bool, err error) {
+	if m.dataDir == "" {
+		return 0, 0, time.Time{}, false, nil
+	}
+	m.mu.Lock()
+	readings, err := readJSONLines[RegisterReading](filepath.Join(m.dataDir, registersFile))
+	m.mu.Unlock()
+	if err != nil {
+		return 0, 0, time.Time{}, false, err
+	}
+	readings = slices.DeleteFunc(readings, func(r RegisterReading) bool {
+		return r.Time.Before(since) || (!until.IsZero() && !r.Time.Before(until))
+	})
+	if len(readings) < 2 {
+		return 0, 0, time.Time{}, false, nil
+	}
+	slices.SortFunc(readings, func(a, b RegisterReading) int { return a.Time.Compare(b.Time) })
+	for i := 1; i < len(readings); i++ {
+		importKWh += max(readings[i].ImportKWh-readings[i-1].ImportKWh, 0)
+		exportKWh += max(readings[i].ExportKWh-readings[i-1].ExportKWh, 0)
+	}
+	return importKWh, exportKWh, readings[0].Time, true, nil
+}
+
+// refreshNetPosition recomputes the year-to-date net position used to value
+// exports. The meter registers are preferred, as they include the household;
+// without them the battery's trades are used. Must be called without holding s.mu.
+func (s *Service) refreshNetPosition() {
+	now := s.now()
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, s.loc)
+
+	pos := s.recorder.NetPosition(now.Year())
+	if s.meterData != nil {
+		importKWh, exportKWh, first, ok, err := s.meterData.RegistersBetween(yearStart, time.Time{})
+		if err != nil {
+			slog.Warn("failed to read meter registers for net metering", "error", err)
+		} else if ok {
+			pos = NetMeteringPosition{Year: now.Year(), Source: NetPositionSourceMeter, Since: &first, ImportKWh: importKWh, ExportKWh: exportKWh}
+		}
+	}
+	pos.ImportKWh, pos.ExportKWh = round4(pos.ImportKWh), round4(pos.ExportKWh)
+	pos.NetKWh = round4(pos.ImportKWh - pos.ExportKWh)
+	pos.HeadroomKWh = max(pos.NetKWh, 0)
+
+	end := s.cfg.NetMeteringEndTime()
+	if !end.IsZero() {
+		pos.EndsAt = &end
+		pos.Active = now.Before(end)
+	}
+	pos.ExportRate = ExportRateFeedIn
+	if pos.Active && pos.HeadroomKWh > 0 {
+		pos.ExportRate = ExportRateRetail
+	}
+
+	s.mu.Lock()
+	wasActive := s.netPosition != nil && s.netPosition.Active
+	s.netPosition = &pos
+	s.mu.Unlock()
+
+	if wasActive && !pos.Active {
+		slog.Info("net metering ended, exports are valued at the feed-in rate", "ended", end)
+	}
+}
+
+// cycleRates values the energy of planned cycles. Imports cost the spot price
+// plus the surcharge. A discharge is worth the same while net metering applies
+// and the year's imports, including the cycle's own charge, can absorb it, and
+// the spot price minus the feed-in fee beyond that.
+type cycleRates struct {
+	surcharge      decimal.Decimal
+	feedInFee      decimal.Decimal
+	netMeteringEnd time.Time
+	year           int     // year of netKWh
+	netKWh         float64 // imports minus exports so far, including planned cycles
+	chargeKWh      float64 // grid energy imported by a full cycle
+	exportKWh      float64 // grid energy exported by a full cycle
+}
+
+func newCycleRates(cfg AnalyzerConfig, usableCapacity float64) *cycleRates {
+	r := &cycleRates{
+		surcharge:      decimal.NewFromFloat(cfg.ImportSurcharge),
+		feedInFee:      decimal.NewFromFloat(cfg.FeedInFee),
+		netMeteringEnd: cfg.NetMeteringEnd,
+		chargeKWh:      usableCapacity,
+		exportKWh:      usableCapacity * cfg.Efficiency,
+	}
+	if p := cfg.NetPosition; p != nil {
+		r.year, r.netKWh = p.Year, p.NetKWh
+	}
+	return r
+}
+
+// importPrice returns the cost of importing at the spot price.
+func (r *cycleRates) importPrice(spot decimal.Decimal) decimal.Decimal {
+	return spot.Add(r.surcharge)
+}
+
+// exportPrice returns the value of a cycle discharging at the spot price from at.
+func (r *cycleRates) exportPrice(spot decimal.Decimal, at time.Time) (decimal.Decimal, ExportRate) {
+	feedIn := spot.Sub(r.feedInFee)
+	if r.netMeteringEnd.IsZero() || !at.Before(r.netMeteringEnd) {
+		return feedIn, ExportRateFeedIn
+	}
+	share := 1.0
+	if r.exportKWh > 0 {
+		share = min(max((r.netKWhIn(at.Year())+r.chargeKWh)/r.exportKWh, 0), 1)
+	}
+	switch share {
+	case 1:
+		return r.importPrice(spot), ExportRateRetail
+	case 0:
+		return feedIn, ExportRateFeedIn
+	}
+	netted := decimal.NewFromFloat(share)
+	return r.importPrice(spot).Mul(netted).Add(feedIn.Mul(decimal.NewFromInt(1).Sub(netted))), ExportRateMixed
+}
+
+// book adds a planned cycle to the net position, so later cycles see its
+// imports and exports.
+func (r *cycleRates) book(c TradeCycle) {
+	year := c.DischargeWindow.Start.Year()
+	r.netKWh = r.netKWhIn(year) + r.chargeKWh - r.exportKWh
+	r.year = year
+}
+
+// settle books metered imports and exports of an interval starting at at and
+// returns their cost: imports at the import price, exports netted at the same
+// price while net metering applies and the year's imports absorb them, at the
+// feed-in price beyond that.
+func (r *cycleRates) settle(spot decimal.Decimal, at time.Time, importKWh, exportKWh float64) decimal.Decimal {
+	year := at.Year()
+	net := r.netKWhIn(year) + importKWh
+	netted := 0.0
+	if !r.netMeteringEnd.IsZero() && at.Before(r.netMeteringEnd) {
+		netted = min(max(net, 0), exportKWh)
+	}
+	r.netKWh, r.year = net-exportKWh, year
+
+	cost := r.importPrice(spot).Mul(decimal.NewFromFloat(importKWh - netted))
+	return cost.Sub(spot.Sub(r.feedInFee).Mul(decimal.NewFromFloat(exportKWh - netted)))
+}
+
+// netKWhIn returns the net position in the given year; a new year starts at zero.
+func (r *cycleRates) netKWhIn(year int) float64 {
+	if year != r.year {
+		return 0
+	}
+	return r.netKWh
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/internal/config"
+)
+
+// netMeteringTestConfig values imports at spot + 0.10 with net metering until 2027.
+func netMeteringTestConfig() AnalyzerConfig {
+	cfg := smallWindowConfig()
+	cfg.ImportSurcharge = 0.10
+	cfg.NetMeteringEnd = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	return cfg
+}
+
+func TestAnalyzePrices_NetMeteringValuesExportsAtRetail(t *testing.T) {
+	baseTime := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	plan := AnalyzePrices(makePrices(baseTime, 0.10, 0.16), netMeteringTestConfig())
+
+	if len(plan.Cycles) != 1 {
+		t.Fatalf("expected 1 cycle, got %d", len(plan.Cycles))
+	}
+	c := plan.Cycles[0]
+	if c.ExportRate != ExportRateRetail {
+		t.Errorf("export rate = %s, want retail", c.ExportRate)
+	}
+	// (0.16 + 0.10) * 0.9 - (0.10 + 0.10)
+	if !decimalEqual(c.Profit, 0.034) {
+		t.Errorf("profit = %s, want 0.034", c.Profit)
+	}
+	// Window prices stay spot prices
+	if !decimalEqual(c.DischargeWindow.Price, 0.16) {
+		t.Errorf("discharge price = %s, want 0.16", c.DischargeWindow.Price)
+	}
+}
+
+func TestAnalyzePrices_NetMeteringEndSwitchesToFeedIn(t *testing.T) {
+	// Same prices after the end date: the surcharge paid on import is no
+	// longer earned back on export, so the cycle is not worth it
+	baseTime := time.Date(2027, 1, 5, 0, 0, 0, 0, time.UTC)
+	plan := AnalyzePrices(makePrices(baseTime, 0.10, 0.16), netMeteringTestConfig())
+	if plan.IsProfitable {
+		t.Fatalf("expected no cycles after net metering ended, got %+v", plan.Cycles)
+	}
+
+	// A spread that covers the surcharge still trades, at the feed-in rate
+	plan = AnalyzePrices(makePrices(baseTime, 0.10, 0.40), netMeteringTestConfig())
+	if len(plan.Cycles) != 1 || plan.Cycles[0].ExportRate != ExportRateFeedIn {
+		t.Fatalf("expected 1 feed-in cycle, got %+v", plan.Cycles)
+	}
+	// 0.40 * 0.9 - 0.20
+	if !decimalEqual(plan.Cycles[0].Profit, 0.16) {
+		t.Errorf("profit = %s, want 0.16", plan.Cycles[0].Profit)
+	}
+}
+
+func TestAnalyzePrices_NetExporterGetsFeedInRate(t *testing.T) {
+	baseTime := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.40)
+
+	tests := []struct {
+		name   string
+		netKWh float64
+		want   ExportRate
+		profit float64
+	}{
+		{"net importer", 100, ExportRateRetail, 0.25},  // 0.50 * 0.9 - 0.20
+		{"net exporter", -100, ExportRateFeedIn, 0.16}, // 0.40 * 0.9 - 0.20
+		// 0.2 of the 0.45 kWh exported is netted
+		{"partly netted", -0.3, ExportRateMixed, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := netMeteringTestConfig()
+			cfg.NetPosition = &NetMeteringPosition{Year: 2026, NetKWh: tt.netKWh}
+			plan := AnalyzePrices(prices, cfg)
+			if len(plan.Cycles) != 1 {
+				t.Fatalf("expected 1 cycle, got %d", len(plan.Cycles))
+			}
+			c := plan.Cycles[0]
+			if c.ExportRate != tt.want {
+				t.Errorf("export rate = %s, want %s", c.ExportRate, tt.want)
+			}
+			if got := c.Profit.Round(4); !decimalEqual(got, tt.profit) {
+				t.Errorf("profit = %s, want %v", got, tt.profit)
+			}
+		})
+	}
+}
+
+func TestAnalyzePrices_NetPositionOfPreviousYearIgnored(t *testing.T) {
+	// Tomorrow is in a new year: last year's export surplus does not count
+	baseTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cfg := netMeteringTestConfig()
+	cfg.NetPosition = &NetMeteringPosition{Year: 2025, NetKWh: -100}
+	plan := AnalyzePrices(makePrices(baseTime, 0.10, 0.40), cfg)
+	if len(plan.Cycles) != 1 || plan.Cycles[0].ExportRate != ExportRateRetail {
+		t.Fatalf("expected 1 retail cycle, got %+v", plan.Cycles)
+	}
+}
+
+func TestRecorder_NetPosition(t *testing.T) {
+	r := NewRecorder("", 0.90, time.UTC)
+	r.RecordTrade(ledgerTrade(time.Date(2025, 12, 31, 2, 0, 0, 0, time.UTC), ActionCharge, 0.10, 5.0))
+	r.RecordTrade(ledgerTrade(time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC), ActionCharge, 0.10, 2.0))
+	r.RecordTrade(ledgerTrade(time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC), ActionSolarCharge, 0, 1.0))
+	r.RecordTrade(ledgerTrade(time.Date(2026, 1, 2, 18, 0, 0, 0, time.UTC), ActionDischarge, 0.30, 2.5))
+
+	pos := r.NetPosition(2026)
+	if pos.ImportKWh != 2.0 || pos.ExportKWh != 2.5 || pos.Source != NetPositionSourceTrades {
+		t.Errorf("unexpected position: %+v", pos)
+	}
+	if pos.Since == nil || !pos.Since.Equal(time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("since = %v, want the first charge of the year", pos.Since)
+	}
+}
+
+func TestRefreshNetPosition_PrefersMeterRegisters(t *testing.T) {
+	cfg := testConfigSmallBattery()
+	cfg.NetMeteringEnd = "2027-01-01"
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	svc := newTestService(cfg, &MockBattery{SOC: 50}, nil, now)
+	svc.recorder.RecordTrade(ledgerTrade(now.Add(-24*time.Hour), ActionCharge, 0.10, 2.0))
+
+	svc.refreshNetPosition()
+	if pos := svc.netPosition; pos.Source != NetPositionSourceTrades || pos.NetKWh != 2.0 || pos.ExportRate != ExportRateRetail || !pos.Active {
+		t.Errorf("unexpected trade position: %+v", pos)
+	}
+
+	svc.meterData = NewMeterDataStore(t.TempDir())
+	svc.meterData.RecordRegisters(RegisterReading{Time: time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC), ImportKWh: 900, ExportKWh: 100})
+	svc.meterData.RecordRegisters(RegisterReading{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), ImportKWh: 1000, ExportKWh: 200})
+	svc.meterData.RecordRegisters(RegisterReading{Time: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), ImportKWh: 1200, ExportKWh: 500})
+	svc.refreshNetPosition()
+	pos := svc.netPosition
+	if pos.Source != NetPositionSourceMeter || pos.ImportKWh != 200 || pos.ExportKWh != 300 || pos.NetKWh != -100 {
+		t.Errorf("unexpected meter position: %+v", pos)
+	}
+	if pos.HeadroomKWh != 0 || pos.ExportRate != ExportRateFeedIn {
+		t.Errorf("net exporter should have no headroom: %+v", pos)
+	}
+	if cfg := svc.analyzerConfig(); cfg.NetPosition != pos || !cfg.NetMeteringEnd.Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("analyzer config does not carry the position: %+v", cfg)
+	}
+}
+
+func TestRefreshNetPosition_AfterEnd(t *testing.T) {
+	cfg := &config.Config{BatteryEfficiency: 0.9, NetMeteringEnd: "2027-01-01"}
+	svc := newTestService(cfg, &MockBattery{SOC: 50}, nil, time.Date(2027, 1, 1, 0, 5, 0, 0, time.UTC))
+	svc.netPosition = &NetMeteringPosition{Active: true}
+	svc.refreshNetPosition()
+	if pos := svc.netPosition; pos.Active || pos.ExportRate != ExportRateFeedIn || pos.Year != 2027 {
+		t.Errorf("unexpected position after the end date: %+v", pos)
+	}
+}
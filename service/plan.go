@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/nordpool"
+)
+
+// Days with day-ahead prices.
+const (
+	DayToday    = "today"
+	DayTomorrow = "tomorrow"
+)
+
+// ErrPricesUnavailable is returned when the prices of a day are not fetched yet.
+var ErrPricesUnavailable = errors.New("prices not available")
+
+// PriceSlotInfo is a 15-minute price slot annotated with the plan.
+type PriceSlotInfo struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	PriceEUR float64   `json:"price_eur_kwh"`
+	Window   string    `json:"window,omitempty"`   // "charge" or "discharge" when the slot is in a planned window
+	CycleID  string    `json:"cycle_id,omitempty"` // planned cycle of the window
+	Current  bool      `json:"current,omitempty"`  // slot containing the current time
+}
+
+// DayPrices is a day's price series.
+type DayPrices struct {
+	Day   string          `json:"day"`
+	Date  time.Time       `json:"date"`
+	Slots []PriceSlotInfo `json:"slots"`
+}
+
+// resolveDay maps today, tomorrow or a date (YYYY-MM-DD) to today or
+// tomorrow. Empty means today.
+func (s *Service) resolveDay(day string) (string, error) {
+	switch day {
+	case "", DayToday:
+		return DayToday, nil
+	case DayTomorrow:
+		return DayTomorrow, nil
+	}
+	date, err := s.parseDay(day)
+	if err != nil {
+		return "", fmt.Errorf("day must be today, tomorrow or a date such as 2024-01-15, got %q", day)
+	}
+	today := localMidnight(s.now())
+	switch {
+	case date.Equal(today):
+		return DayToday, nil
+	case date.Equal(today.AddDate(0, 0, 1)):
+		return DayTomorrow, nil
+	}
+	return "", fmt.Errorf("only today and tomorrow have prices, got %q", day)
+}
+
+// dayPricesLocked returns the fetched prices of a resolved day. Caller must hold s.mu.
+func (s *Service) dayPricesLocked(day string) ([]nordpool.Price, error) {
+	prices := s.todayPrices
+	if day == DayTomorrow {
+		prices = s.tomorrowPrices
+	}
+	if len(prices) == 0 {
+		return nil, fmt.Errorf("%s: %w", day, ErrPricesUnavailable)
+	}
+	return prices, nil
+}
+
+// planLocked returns the plan of a resolved day: the active plan today, or
+// tomorrow's prices analyzed with the current settings. Caller must hold s.mu.
+func (s *Service) planLocked(day string) (*TradingPlan, error) {
+	prices, err := s.dayPricesLocked(day)
+	if err != nil {
+		return nil, err
+	}
+	if day == DayToday && s.currentPlan != nil {
+		return s.currentPlan, nil
+	}
+	return AnalyzePrices(prices, s.analyzerConfig()), nil
+}
+
+// GetPlan returns the trading plan for today or tomorrow. Today's plan covers
+// the slots that were ahead when the prices were fetched.
+func (s *Service) GetPlan(day string) (*TradingPlan, error) {
+	day, err := s.resolveDay(day)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.planLocked(day)
+}
+
+// GetPrices returns the 15-minute prices of today or tomorrow, annotated with
+// the planned charge and discharge windows.
+func (s *Service) GetPrices(day string) (*DayPrices, error) {
+	day, err := s.resolveDay(day)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prices, err := s.dayPricesLocked(day)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := s.planLocked(day)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+
+	slots := sortedPriceSlots(prices)
+	result := &DayPrices{Day: day, Date: localMidnight(slots[0].Time.In(s.loc)), Slots: make([]PriceSlotInfo, 0, len(slots))}
+	for _, slot := range slots {
+		info := PriceSlotInfo{
+			Start:    slot.Time,
+			End:      slot.Time.Add(slotDuration),
+			PriceEUR: slot.Value.InexactFloat64(),
+			Current:  !now.Before(slot.Time) && now.Before(slot.Time.Add(slotDuration)),
+		}
+		if c, ok := plan.CycleAt(slot.Time); ok {
+			info.CycleID = c.ID()
+			info.Window = "discharge"
+			if plan.IsInChargeWindow(slot.Time) {
+				info.Window = "charge"
+			}
+		}
+		result.Slots = append(result.Slots, info)
+	}
+	return result, nil
+}
+
+// ExplainPlan explains the plan of today or tomorrow. Today only the slots
+// that have not ended are analyzed, as for the active plan.
+func (s *Service) ExplainPlan(day string) (*PlanExplanation, error) {
+	day, err := s.resolveDay(day)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prices, err := s.dayPricesLocked(day)
+	if err != nil {
+		return nil, err
+	}
+	if day == DayToday {
+		now := s.now()
+		remaining := make([]nordpool.Price, 0, len(prices))
+		for _, p := range prices {
+			if !p.Time.Add(slotDuration).Before(now) {
+				remaining = append(remaining, p)
+			}
+		}
+		prices = remaining
+	}
+	return ExplainPrices(prices, s.analyzerConfig()), nil
+}
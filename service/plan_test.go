@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExplainPrices_BelowBreakEven(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	explanation := ExplainPrices(makePrices(baseTime, 0.12, 0.10, 0.105), smallWindowConfig())
+
+	if explanation.IsProfitable || explanation.Reason != ExplainBelowBreakEven {
+		t.Fatalf("unexpected explanation: %+v", explanation)
+	}
+	c := explanation.BestCandidate
+	if c == nil {
+		t.Fatal("expected a best candidate")
+	}
+	if !c.ChargeWindow.Start.Equal(baseTime.Add(15*time.Minute)) || !decimalEqual(c.DischargeValue, 0.105) {
+		t.Errorf("unexpected candidate windows: %+v", c)
+	}
+	// 0.10 / 0.9
+	if !decimalEqual(c.BreakEvenPrice, 0.1111) || !decimalEqual(c.BreakEvenShortfall, 0.0061) {
+		t.Errorf("break-even = %s, shortfall = %s", c.BreakEvenPrice, c.BreakEvenShortfall)
+	}
+	if !c.Profit.IsNegative() {
+		t.Errorf("expected a negative profit, got %s", c.Profit)
+	}
+}
+
+func TestExplainPrices_SpreadBelowMinimum(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	cfg := smallWindowConfig()
+	cfg.MinPriceSpread = 0.05
+	explanation := ExplainPrices(makePrices(baseTime, 0.10, 0.14), cfg)
+
+	if explanation.Reason != ExplainSpreadBelowMinimum {
+		t.Fatalf("reason = %s, want %s", explanation.Reason, ExplainSpreadBelowMinimum)
+	}
+	c := explanation.BestCandidate
+	if !decimalEqual(c.Spread, 0.04) || !decimalEqual(c.SpreadShortfall, 0.01) || !c.BreakEvenShortfall.IsZero() {
+		t.Errorf("unexpected candidate: spread=%s shortfall=%s break-even shortfall=%s", c.Spread, c.SpreadShortfall, c.BreakEvenShortfall)
+	}
+}
+
+func TestExplainPrices_Profitable(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.30)
+	explanation := ExplainPrices(prices, smallWindowConfig())
+	plan := AnalyzePrices(prices, smallWindowConfig())
+
+	if !explanation.IsProfitable || explanation.Reason != ExplainProfitable || explanation.Cycles != 1 {
+		t.Fatalf("unexpected explanation: %+v", explanation)
+	}
+	if !explanation.BestCandidate.Profit.Equal(plan.Cycles[0].Profit) {
+		t.Errorf("candidate profit %s differs from the planned cycle %s", explanation.BestCandidate.Profit, plan.Cycles[0].Profit)
+	}
+	if !explanation.BestCandidate.SpreadShortfall.IsZero() {
+		t.Errorf("expected no spread shortfall, got %s", explanation.BestCandidate.SpreadShortfall)
+	}
+}
+
+func TestExplainPrices_NotEnoughSlots(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	if got := ExplainPrices(makePrices(baseTime, 0.10), smallWindowConfig()); got.Reason != ExplainInsufficientSlots || got.BestCandidate != nil {
+		t.Errorf("unexpected explanation: %+v", got)
+	}
+	if got := ExplainPrices(nil, smallWindowConfig()); got.Reason != ExplainNoPrices {
+		t.Errorf("reason = %s, want %s", got.Reason, ExplainNoPrices)
+	}
+}
+
+func TestGetPrices_AnnotatesPlanWindows(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	svc := newTestService(testConfigSmallBattery(), &MockBattery{SOC: 50}, prices, baseTime.Add(20*time.Minute))
+
+	day, err := svc.GetPrices("")
+	if err != nil {
+		t.Fatalf("GetPrices: %v", err)
+	}
+	if len(day.Slots) != 4 || day.Day != DayToday || !day.Date.Equal(baseTime) {
+		t.Fatalf("unexpected prices: %+v", day)
+	}
+	cycleID := svc.currentPlan.Cycles[0].ID()
+	want := []struct {
+		window  string
+		current bool
+	}{{"charge", false}, {"", true}, {"discharge", false}, {"", false}}
+	for i, w := range want {
+		slot := day.Slots[i]
+		if slot.Window != w.window || slot.Current != w.current {
+			t.Errorf("slot %d: window=%q current=%v, want %q %v", i, slot.Window, slot.Current, w.window, w.current)
+		}
+		if (slot.Window != "") != (slot.CycleID == cycleID) {
+			t.Errorf("slot %d: cycle_id = %q", i, slot.CycleID)
+		}
+	}
+}
+
+func TestGetPlan_Days(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	svc := newTestService(testConfigSmallBattery(), &MockBattery{SOC: 50}, makePrices(baseTime, 0.05, 0.25), baseTime)
+
+	if plan, err := svc.GetPlan("2024-01-15"); err != nil || plan != svc.currentPlan {
+		t.Errorf("today by date: plan=%v err=%v", plan, err)
+	}
+	if _, err := svc.GetPlan(DayTomorrow); !errors.Is(err, ErrPricesUnavailable) {
+		t.Errorf("expected ErrPricesUnavailable before tomorrow's prices, got %v", err)
+	}
+	svc.tomorrowPrices = makePrices(baseTime.Add(24*time.Hour), 0.05, 0.25)
+	plan, err := svc.GetPlan("2024-01-16")
+	if err != nil || !plan.IsProfitable || !plan.Date.Equal(baseTime.Add(24*time.Hour)) {
+		t.Errorf("tomorrow: plan=%+v err=%v", plan, err)
+	}
+	for _, day := range []string{"yesterday", "2024-01-14"} {
+		if _, err := svc.GetPlan(day); err == nil || errors.Is(err, ErrPricesUnavailable) {
+			t.Errorf("day %q: expected an invalid day error, got %v", day, err)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/foae/marstek-energy-trading/clients/nordpool"
+)
+
+const (
+	// A slot is flagged when the meter disagrees with its grid charges by
+	// more than the larger of these tolerances.
+	reconcileToleranceKWh   = 0.02
+	reconcileToleranceShare = 0.10
+
+	reconcileMaxDays = 62 // day-ahead prices are fetched per day
+)
+
+// Discrepancy flags a slot where the meter and the trades disagree.
+type Discrepancy string
+
+const (
+	// The meter imported less than the grid charge attributed to the slot.
+	DiscrepancyImportBelowCharge Discrepancy = "import_below_charge"
+	// The meter exported while a grid charge was attributed to the slot: the
+	// battery charged at least partly from solar.
+	DiscrepancyExportDuringCharge Discrepancy = "export_during_charge"
+)
+
+// ReconciledSlot is one meter interval with the battery energy attributed to
+// it from the trades and the no-battery counterfactual.
+type ReconciledSlot struct {
+	Start              time.Time     `json:"start"`
+	End                time.Time     `json:"end"`
+	ImportKWh          float64       `json:"import_kwh"`
+	ExportKWh          float64       `json:"export_kwh"`
+	PriceEUR           *float64      `json:"price_eur_kwh,omitempty"`
+	ChargeKWh          float64       `json:"battery_charge_kwh"` // grid charges
+	DischargeKWh       float64       `json:"battery_discharge_kwh"`
+	SolarChargeKWh     float64       `json:"battery_solar_charge_kwh"`
+	NoBatteryImportKWh float64       `json:"no_battery_import_kwh"`
+	NoBatteryExportKWh float64       `json:"no_battery_export_kwh"`
+	Flags              []Discrepancy `json:"flags,omitempty"`
+}
+
+// BillTotals is the grid energy and its cost over the reconciled period.
+type BillTotals struct {
+	NetKWhAtStart float64 `json:"net_kwh_at_start"` // year-to-date imports minus exports before the period
+	ImportKWh     float64 `json:"import_kwh"`
+	ExportKWh     float64 `json:"export_kwh"`
+	CostEUR       float64 `json:"cost_eur"` // priced slots only: imports minus export revenue
+}
+
+// ReconciliationReport compares the trades with the meter and the bill with a
+// no-battery counterfactual computed from the same meter data.
+type ReconciliationReport struct {
+	From              string           `json:"from"`
+	To                string           `json:"to"`
+	Source            string           `json:"source"` // supplier or p1
+	Intervals         int              `json:"intervals"`
+	UnpricedIntervals int              `json:"unpriced_intervals"` // excluded from the costs
+	ChargeKWh         float64          `json:"battery_charge_kwh"`
+	DischargeKWh      float64          `json:"battery_discharge_kwh"`
+	SolarChargeKWh    float64          `json:"battery_solar_charge_kwh"`
+	UncoveredTradeKWh float64          `json:"uncovered_trade_kwh"` // trade energy outside the meter data
+	WithBattery       BillTotals       `json:"with_battery"`
+	WithoutBattery    BillTotals       `json:"without_battery"`
+	SavingsEUR        float64          `json:"savings_eur"` // without minus with battery
+	ToleranceKWh      float64          `json:"discrepancy_tolerance_kwh"`
+	Discrepancies     []ReconciledSlot `json:"discrepancies"`
+	Slots             []ReconciledSlot `json:"slots,omitempty"` // every interval, on request
+}
+
+// Reconcile compares the trades from the first to the last day (YYYY-MM-DD,
+// inclusive, default today) with the meter data. detail includes every slot.
+func (s *Service) Reconcile(ctx context.Context, from, to string, detail bool) (*ReconciliationReport, error) {
+	if s.meterData == nil {
+		return nil, fmt.Errorf("meter data not available")
+	}
+	last, err := s.parseDay(to)
+	if err != nil {
+		return nil, err
+	}
+	first := last
+	if from != "" {
+		if first, err = s.parseDay(from); err != nil {
+			return nil, err
+		}
+	}
+	end := last.AddDate(0, 0, 1)
+	if !first.Before(end) {
+		return nil, fmt.Errorf("from must not be after to")
+	}
+	if end.Sub(first) > reconcileMaxDays*24*time.Hour+time.Hour {
+		return nil, fmt.Errorf("at most %d days can be reconciled at once", reconcileMaxDays)
+	}
+
+	intervals, source, err := s.meterData.Usage(first, end)
+	if err != nil {
+		return nil, err
+	}
+	// Trades starting the day before may run into the range
+	trades := s.recorder.ExportTrades(ExportQuery{
+		From: first.AddDate(0, 0, -1).Format("2006-01-02"),
+		To:   last.Format("2006-01-02"),
+	})
+	prices := s.historicalPrices(ctx, first, end, intervals)
+	withRates, withoutRates := s.reconcileRates(first)
+
+	report := buildReconciliation(intervals, trades, prices, first, end, withRates, withoutRates)
+	report.From, report.To, report.Source = first.Format("2006-01-02"), last.Format("2006-01-02"), source
+	if !detail {
+		report.Slots = nil
+	}
+	return report, nil
+}
+
+// reconcileRates returns the rates of the metered and the no-battery bill,
+// starting from the year-to-date net position before from. The metered
+// position comes from the meter registers (zero without them); the
+// no-battery position also removes the battery's trades of the year.
+func (s *Service) reconcileRates(from time.Time) (withRates, withoutRates *cycleRates) {
+	yearStart := time.Date(from.Year(), 1, 1, 0, 0, 0, 0, s.loc)
+
+	var meteredNet, batteryNet float64
+	importKWh, exportKWh, _, ok, err := s.meterData.RegistersBetween(yearStart, from)
+	if err != nil {
+		slog.Warn("failed to read meter registers for reconciliation", "error", err)
+	} else if ok {
+		meteredNet = importKWh - exportKWh
+	}
+	if from.After(yearStart) {
+		for _, t := range s.recorder.ExportTrades(ExportQuery{
+			From: yearStart.Format("2006-01-02"),
+			To:   from.AddDate(0, 0, -1).Format("2006-01-02"),
+		}) {
+			withinKWh := tradeEnergyWithin(t, yearStart, from)
+			switch t.Action {
+			case ActionCharge, ActionSolarCharge:
+				batteryNet += withinKWh
+			case ActionDischarge:
+				batteryNet -= withinKWh
+			}
+		}
+	}
+
+	ratesFrom := func(netKWh float64) *cycleRates {
+		return newCycleRates(AnalyzerConfig{
+			ImportSurcharge: s.cfg.ImportSurchargeEURKWh,
+			FeedInFee:       s.cfg.FeedInFeeEURKWh,
+			NetMeteringEnd:  s.cfg.NetMeteringEndTime(),
+			NetPosition:     &NetMeteringPosition{Year: from.Year(), NetKWh: netKWh},
+		}, 0)
+	}
+	return ratesFrom(meteredNet), ratesFrom(meteredNet - batteryNet)
+}
+
+// historicalPrices returns the day-ahead prices of the days in [from, to) that
+// have intervals without a supplier price. Days that cannot be fetched are
+// left out. Must be called without holding s.mu.
+func (s *Service) historicalPrices(ctx context.Context, from, to time.Time, intervals []UsageInterval) []nordpool.Price {
+	needed := make(map[string]bool)
+	for _, iv := range intervals {
+		if iv.PriceEUR == nil {
+			needed[iv.Start.In(s.loc).Format("2006-01-02")] = true
+		}
+	}
+
+	s.mu.RLock()
+	today := localMidnight(s.now())
+	todayPrices := s.todayPrices
+	s.mu.RUnlock()
+
+	var prices []nordpool.Price
+	provider, _ := s.nordpool.(HistoricalPriceProvider)
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !needed[day.Format("2006-01-02")] {
+			continue
+		}
+		if day.Equal(today) && len(todayPrices) > 0 {
+			prices = append(prices, todayPrices...)
+			continue
+		}
+		if provider == nil {
+			continue
+		}
+		dayPrices, err := provider.FetchDayAheadPrices(ctx, day)
+		if err != nil {
+			slog.Warn("failed to fetch prices for reconciliation", "day", day.Format("2006-01-02"), "error", err)
+			continue
+		}
+		prices = append(prices, dayPrices...)
+	}
+	return prices
+}
+
+// buildReconciliation attributes the trades to the meter intervals, spreading
+// each trade's energy evenly over its duration, and prices both the metered
+// and the no-battery grid flows with their own rates, so that each bill nets
+// exports against its own imports.
+func buildReconciliation(intervals []UsageInterval, trades []Trade, prices []nordpool.Price, from, to time.Time, withRates, withoutRates *cycleRates) *ReconciliationReport {
+	report := &ReconciliationReport{
+		Intervals:      len(intervals),
+		Discrepancies:  []ReconciledSlot{},
+		Slots:          make([]ReconciledSlot, 0, len(intervals)),
+		ToleranceKWh:   reconcileToleranceKWh,
+		WithBattery:    BillTotals{NetKWhAtStart: withRates.netKWhIn(from.Year())},
+		WithoutBattery: BillTotals{NetKWhAtStart: withoutRates.netKWhIn(from.Year())},
+	}
+
+	var covered float64
+	for _, iv := range intervals {
+		slot := ReconciledSlot{Start: iv.Start, End: iv.End, ImportKWh: iv.ImportKWh, ExportKWh: iv.ExportKWh, PriceEUR: iv.PriceEUR}
+		for _, t := range trades {
+			kwh := tradeEnergyWithin(t, iv.Start, iv.End)
+			switch t.Action {
+			case ActionCharge:
+				slot.ChargeKWh += kwh
+			case ActionDischarge:
+				slot.DischargeKWh += kwh
+			case ActionSolarCharge:
+				slot.SolarChargeKWh += kwh
+			}
+			covered += kwh
+		}
+		slot.NoBatteryImportKWh, slot.NoBatteryExportKWh = withoutBattery(slot)
+		if slot.PriceEUR == nil {
+			if price, ok := averagePrice(prices, iv.Start, iv.End); ok {
+				slot.PriceEUR = &price
+			}
+		}
+		slot.Flags = discrepancies(slot)
+
+		report.ChargeKWh += slot.ChargeKWh
+		report.DischargeKWh += slot.DischargeKWh
+		report.SolarChargeKWh += slot.SolarChargeKWh
+		report.WithBattery.ImportKWh += slot.ImportKWh
+		report.WithBattery.ExportKWh += slot.ExportKWh
+		report.WithoutBattery.ImportKWh += slot.NoBatteryImportKWh
+		report.WithoutBattery.ExportKWh += slot.NoBatteryExportKWh
+		// Unpriced slots still count towards the net position
+		var spot decimal.Decimal
+		if slot.PriceEUR != nil {
+			spot = decimal.NewFromFloat(*slot.PriceEUR)
+		}
+		withCost := withRates.settle(spot, iv.Start, slot.ImportKWh, slot.ExportKWh)
+		withoutCost := withoutRates.settle(spot, iv.Start, slot.NoBatteryImportKWh, slot.NoBatteryExportKWh)
+		if slot.PriceEUR != nil {
+			report.WithBattery.CostEUR += withCost.InexactFloat64()
+			report.WithoutBattery.CostEUR += withoutCost.InexactFloat64()
+		} else {
+			report.UnpricedIntervals++
+		}
+
+		slot = roundSlot(slot)
+		if len(slot.Flags) > 0 {
+			report.Discrepancies = append(report.Discrepancies, slot)
+		}
+		report.Slots = append(report.Slots, slot)
+	}
+
+	var traded float64
+	for _, t := range trades {
+		traded += tradeEnergyWithin(t, from, to)
+	}
+	report.UncoveredTradeKWh = round4(max(traded-covered, 0))
+	report.SavingsEUR = round4(report.WithoutBattery.CostEUR - report.WithBattery.CostEUR)
+	report.ChargeKWh, report.DischargeKWh, report.SolarChargeKWh = round4(report.ChargeKWh), round4(report.DischargeKWh), round4(report.SolarChargeKWh)
+	for _, b := range []*BillTotals{&report.WithBattery, &report.WithoutBattery} {
+		b.NetKWhAtStart = round4(b.NetKWhAtStart)
+		b.ImportKWh, b.ExportKWh, b.CostEUR = round4(b.ImportKWh), round4(b.ExportKWh), round4(b.CostEUR)
+	}
+	return report
+}
+
+// tradeEnergyWithin returns the part of a trade's energy that falls in
+// [start, end), assuming constant power over the trade.
+func tradeEnergyWithin(t Trade, start, end time.Time) float64 {
+	kwh := t.EnergyKWh.InexactFloat64()
+	tradeEnd := t.Timestamp.Add(time.Duration(t.DurationS) * time.Second)
+	if !tradeEnd.After(t.Timestamp) {
+		if !t.Timestamp.Before(start) && t.Timestamp.Before(end) {
+			return kwh
+		}
+		return 0
+	}
+	lo, hi := t.Timestamp, tradeEnd
+	if start.After(lo) {
+		lo = start
+	}
+	if end.Before(hi) {
+		hi = end
+	}
+	if !hi.After(lo) {
+		return 0
+	}
+	return kwh * hi.Sub(lo).Seconds() / tradeEnd.Sub(t.Timestamp).Seconds()
+}
+
+// withoutBattery removes the battery from a slot's metered flows. A charge
+// raised the net import; without it, imports shrink first and any rest would
+// have been exported. A discharge lowered it; without it, exports shrink first
+// and the rest would have been imported.
+func withoutBattery(slot ReconciledSlot) (importKWh, exportKWh float64) {
+	importKWh, exportKWh = slot.ImportKWh, slot.ExportKWh
+	delta := slot.DischargeKWh - slot.ChargeKWh - slot.SolarChargeKWh // change of net import
+	if delta > 0 {
+		fromExport := min(exportKWh, delta)
+		return importKWh + delta - fromExport, exportKWh - fromExport
+	}
+	fromImport := min(importKWh, -delta)
+	return importKWh - fromImport, exportKWh - delta - fromImport
+}
+
+// discrepancies flags a slot whose meter data contradicts its grid charges.
+func discrepancies(slot ReconciledSlot) []Discrepancy {
+	if slot.ChargeKWh <= 0 {
+		return nil
+	}
+	tolerance := max(reconcileToleranceKWh, slot.ChargeKWh*reconcileToleranceShare)
+	var flags []Discrepancy
+	if slot.ImportKWh < slot.ChargeKWh-tolerance {
+		flags = append(flags, DiscrepancyImportBelowCharge)
+	}
+	if slot.ExportKWh > tolerance {
+		flags = append(flags, DiscrepancyExportDuringCharge)
+	}
+	return flags
+}
+
+// averagePrice returns the time-weighted price over [start, end), if the
+// prices cover all of it.
+func averagePrice(prices []nordpool.Price, start, end time.Time) (float64, bool) {
+	var sum, seconds float64
+	for _, p := range prices {
+		lo, hi := p.Time, p.Time.Add(slotDuration)
+		if start.After(lo) {
+			lo = start
+		}
+		if end.Before(hi) {
+			hi = end
+		}
+		if hi.After(lo) {
+			sum += p.Value * hi.Sub(lo).Seconds()
+			seconds += hi.Sub(lo).Seconds()
+		}
+	}
+	if seconds < end.Sub(start).Seconds() {
+		return 0, false
+	}
+	return sum / seconds, true
+}
+
+func roundSlot(slot ReconciledSlot) ReconciledSlot {
+	for _, v := range []*float64{&slot.ChargeKWh, &slot.DischargeKWh, &slot.SolarChargeKWh, &slot.NoBatteryImportKWh, &slot.NoBatteryExportKWh} {
+		*v = round4(*v)
+	}
+	if slot.PriceEUR != nil {
+		price := round4(*slot.PriceEUR)
+		slot.PriceEUR = &price
+	}
+	return slot
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+// recordMeterRegisters stores the meter's import and export registers once
+// per slot, when the meter reports them. Must be called without holding s.mu.
+func (s *Service) recordMeterRegisters() {
+	reader, ok := s.meter.(MeterRegisterReader)
+	if !ok || s.meterData == nil || !s.meterEnabled() {
+		return
+	}
+	now := s.now()
+	slot := now.Truncate(slotDuration)
+	if slot.Equal(s.lastRegisterSlot) {
+		return
+	}
+	importKWh, exportKWh, err := reader.GetEnergyRegisters()
+	if err != nil {
+		slog.Debug("failed to read meter registers", "error", err)
+		return
+	}
+	s.lastRegisterSlot = slot
+	if err := s.meterData.RecordRegisters(RegisterReading{Time: now, ImportKWh: importKWh, ExportKWh: exportKWh}); err != nil {
+		slog.Warn("failed to record meter registers", "error", err)
+	}
+}
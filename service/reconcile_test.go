@@ -0,0 +1,131 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/nordpool"
+)
+
+// flatRates prices imports and exports at the spot price.
+func flatRates() *cycleRates {
+	return newCycleRates(AnalyzerConfig{}, 0)
+}
+
+// chargeAndDischargeDay returns a night charge and an evening discharge with
+// their meter intervals and prices (0.05 at night, 0.30 in the evening).
+func chargeAndDischargeDay(base time.Time) ([]UsageInterval, []Trade, []nordpool.Price) {
+	intervals := []UsageInterval{
+		{Start: base, End: base.Add(time.Hour), ImportKWh: 2.3},                                          // charging
+		{Start: base.Add(16 * time.Hour), End: base.Add(17 * time.Hour), ImportKWh: 0.1, ExportKWh: 1.2}, // discharging
+	}
+	charge := ledgerTrade(base, ActionCharge, 0.05, 2.0)
+	charge.DurationS = 3600
+	discharge := ledgerTrade(base.Add(16*time.Hour), ActionDischarge, 0.30, 1.8)
+	discharge.DurationS = 3600
+	var prices []nordpool.Price
+	for i := range 4 {
+		prices = append(prices, nordpool.Price{Time: base.Add(time.Duration(i) * slotDuration), Value: 0.05})
+		prices = append(prices, nordpool.Price{Time: base.Add(16*time.Hour + time.Duration(i)*slotDuration), Value: 0.30})
+	}
+	return intervals, []Trade{charge, discharge}, prices
+}
+
+func TestBuildReconciliation_AttributesTradesAndCounterfactual(t *testing.T) {
+	base := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+	intervals, trades, prices := chargeAndDischargeDay(base)
+
+	report := buildReconciliation(intervals, trades, prices, base, base.Add(24*time.Hour), flatRates(), flatRates())
+
+	if report.ChargeKWh != 2.0 || report.DischargeKWh != 1.8 || report.UncoveredTradeKWh != 0 {
+		t.Errorf("unexpected attribution: charge=%v discharge=%v uncovered=%v", report.ChargeKWh, report.DischargeKWh, report.UncoveredTradeKWh)
+	}
+	// Without the battery: 0.3 kWh at night; 0.1 import, and 1.8 less export (1.2) plus 0.6 more import
+	slot := report.Slots[1]
+	if slot.NoBatteryImportKWh != 0.7 || slot.NoBatteryExportKWh != 0 {
+		t.Errorf("unexpected counterfactual: %+v", slot)
+	}
+	if got := report.WithoutBattery.ImportKWh; got != 1.0 {
+		t.Errorf("no-battery import = %v, want 1.0", got)
+	}
+	// With: 2.3*0.05 + (0.1-1.2)*0.30 = -0.215; without: 0.3*0.05 + 0.7*0.30 = 0.225
+	if report.WithBattery.CostEUR != -0.215 || report.WithoutBattery.CostEUR != 0.225 || report.SavingsEUR != 0.44 {
+		t.Errorf("unexpected bills: with=%v without=%v savings=%v", report.WithBattery.CostEUR, report.WithoutBattery.CostEUR, report.SavingsEUR)
+	}
+	if len(report.Discrepancies) != 0 || report.UnpricedIntervals != 0 {
+		t.Errorf("expected no discrepancies or unpriced intervals, got %+v", report)
+	}
+}
+
+func TestBuildReconciliation_NetMeteringRates(t *testing.T) {
+	base := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+	intervals, trades, prices := chargeAndDischargeDay(base)
+	rates := func(end time.Time, netKWh float64) *cycleRates {
+		return newCycleRates(AnalyzerConfig{
+			ImportSurcharge: 0.10,
+			FeedInFee:       0.02,
+			NetMeteringEnd:  end,
+			NetPosition:     &NetMeteringPosition{Year: 2024, NetKWh: netKWh},
+		}, 0)
+	}
+	active := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ended := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                  string
+		with, without         *cycleRates
+		wantWith, wantWithout float64
+	}{
+		// With: 2.3*0.15 + (0.1-1.2)*0.40 = -0.095; without: 0.3*0.15 + 0.7*0.40 = 0.325
+		{"exports netted", rates(active, 0), rates(active, 0), -0.095, 0.325},
+		// With: 2.3*0.15 + 0.1*0.40 - 1.2*0.28 = 0.049
+		{"net metering ended", rates(ended, 0), rates(ended, 0), 0.049, 0.325},
+		// Net exporter before the day: only 0.4 kWh netted, 0.8 kWh at 0.28
+		{"little headroom", rates(active, -2.0), rates(active, -2.0), 0.001, 0.325},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := buildReconciliation(intervals, trades, prices, base, base.Add(24*time.Hour), tt.with, tt.without)
+			if report.WithBattery.CostEUR != tt.wantWith || report.WithoutBattery.CostEUR != tt.wantWithout {
+				t.Errorf("bills = %v / %v, want %v / %v", report.WithBattery.CostEUR, report.WithoutBattery.CostEUR, tt.wantWith, tt.wantWithout)
+			}
+			if want := round4(tt.wantWithout - tt.wantWith); report.SavingsEUR != want {
+				t.Errorf("savings = %v, want %v", report.SavingsEUR, want)
+			}
+		})
+	}
+}
+
+func TestBuildReconciliation_FlagsChargeNotSeenByMeter(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	// The battery "charged from the grid" while the house exported solar
+	intervals := []UsageInterval{{Start: base, End: base.Add(slotDuration), ImportKWh: 0, ExportKWh: 0.4}}
+	charge := ledgerTrade(base, ActionCharge, 0.01, 0.5)
+	charge.DurationS = int(slotDuration.Seconds())
+
+	report := buildReconciliation(intervals, []Trade{charge}, nil, base, base.Add(time.Hour), flatRates(), flatRates())
+
+	if len(report.Discrepancies) != 1 {
+		t.Fatalf("expected 1 discrepancy, got %d", len(report.Discrepancies))
+	}
+	flags := report.Discrepancies[0].Flags
+	if len(flags) != 2 || flags[0] != DiscrepancyImportBelowCharge || flags[1] != DiscrepancyExportDuringCharge {
+		t.Errorf("unexpected flags: %v", flags)
+	}
+	if report.UnpricedIntervals != 1 {
+		t.Errorf("expected the slot to be unpriced, got %d", report.UnpricedIntervals)
+	}
+}
+
+func TestTradeEnergyWithin_SplitsAcrossIntervals(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 45, 0, 0, time.UTC)
+	trade := ledgerTrade(start, ActionDischarge, 0.30, 1.0)
+	trade.DurationS = 30 * 60
+
+	hour := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	first := tradeEnergyWithin(trade, hour, hour.Add(time.Hour))
+	second := tradeEnergyWithin(trade, hour.Add(time.Hour), hour.Add(2*time.Hour))
+	if first != 0.5 || second != 0.5 {
+		t.Errorf("expected 0.5/0.5 kWh, got %v/%v", first, second)
+	}
+}
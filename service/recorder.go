@@ -1,10 +1,8 @@
 package service
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"path/filepath"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -30,6 +28,24 @@ type Trade struct {
 	EnergyKWh decimal.Decimal `json:"energy_kwh"` // kWh traded
 	StartSOC  int             `json:"start_soc"`  // SOC at start
 	EndSOC    int             `json:"end_soc"`    // SOC at end
+
+	// MarketPriceEUR is the average spot price during a solar charge session,
+	// what the energy would have earned if exported instead.
+	MarketPriceEUR *decimal.Decimal `json:"market_price_eur,omitempty"`
+
+	// Grid trades: EnergyKWh is measured when the battery allows it, NominalKWh
+	// is the estimate from the SOC change and the configured capacity.
+	NominalKWh   *decimal.Decimal `json:"nominal_kwh,omitempty"`
+	EnergySource EnergySource     `json:"energy_source,omitempty"`
+
+	// CycleID links a grid trade to the planned TradeCycle it executed.
+	CycleID string `json:"cycle_id,omitempty"`
+
+	// Partial marks a session closed on startup after a restart interrupted it.
+	Partial bool `json:"partial,omitempty"`
+
+	// Manual marks a grid session started by a manual override.
+	Manual bool `json:"manual,omitempty"`
 }
 
 // DailySummary contains the daily trading summary.
@@ -41,7 +57,8 @@ type DailySummary struct {
 	DischargeCycles   int             `json:"discharge_cycles"`
 	SolarChargedKWh   decimal.Decimal `json:"solar_charged_kwh"`
 	SolarChargeCycles int             `json:"solar_charge_cycles"`
-	PnLEUR            decimal.Decimal `json:"pnl_eur"`
+	PnLEUR            decimal.Decimal `json:"pnl_eur"`        // realized: discharge revenue minus its cost basis
+	CostBasisEUR      decimal.Decimal `json:"cost_basis_eur"` // cost of the energy discharged this day
 	AvgChargePrice    decimal.Decimal `json:"avg_charge_price"`
 	MinChargePrice    decimal.Decimal `json:"min_charge_price"`
 	AvgDischargePrice decimal.Decimal `json:"avg_discharge_price"`
@@ -54,6 +71,7 @@ type History struct {
 	Days       []DailySummary  `json:"days"`
 	TotalPnL   decimal.Decimal `json:"total_pnl_eur"`
 	TotalDays  int             `json:"total_days"`
+	Inventory  Inventory       `json:"inventory"`
 	FirstTrade *time.Time      `json:"first_trade,omitempty"`
 	LastTrade  *time.Time      `json:"last_trade,omitempty"`
 }
@@ -63,8 +81,16 @@ type Recorder struct {
 	mu         sync.Mutex
 	dataDir    string
 	efficiency decimal.Decimal
+	costBasis  CostBasis
+	solar      SolarValuation
 	trades     []Trade
 	loc        *time.Location
+	journal    *tradeJournal // nil without a data dir
+
+	// archived is the ledger after the archived trades while they are not
+	// loaded: trades then holds only the trades recorded after them. It is
+	// nil once trades holds every trade.
+	archived *ledgerCheckpoint
 }
 
 // NewRecorder creates a new trade recorder.
@@ -72,30 +98,66 @@ func NewRecorder(dataDir string, efficiency float64, loc *time.Location) *Record
 	if loc == nil {
 		loc = time.UTC
 	}
-	return &Recorder{
+	r := &Recorder{
 		dataDir:    dataDir,
 		efficiency: decimal.NewFromFloat(efficiency),
+		costBasis:  CostBasisFIFO,
+		solar:      SolarValuationZero,
 		trades:     make([]Trade, 0),
 		loc:        loc,
 	}
+	if dataDir != "" {
+		r.journal = newTradeJournal(dataDir, loc)
+	}
+	return r
 }
 
-// RecordTrade records a completed trade.
-func (r *Recorder) RecordTrade(trade Trade) error {
+// SetCostBasis selects how discharged energy is matched to stored energy and
+// how solar-charged energy is valued. The defaults are FIFO and zero.
+func (r *Recorder) SetCostBasis(method CostBasis, solar SolarValuation) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.costBasis = method
+	r.solar = solar
+}
 
-	r.trades = append(r.trades, trade)
-	return r.saveTrades()
+// RecordTrade records a completed trade and appends it to the trade journal.
+// The trade is added in memory under the recorder lock; the journal write,
+// compaction and checkpoint happen under the journal lock only, which keeps
+// the journal in the order of the trades in memory.
+func (r *Recorder) RecordTrade(trade Trade) error {
+	record := func() *ledger {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.trades = append(r.trades, trade)
+		return r.ledgerLocked()
+	}
+	if r.journal == nil {
+		record()
+		return nil // No persistence configured
+	}
+	return r.journal.append(trade, record)
 }
 
 // GetHistory returns the full trading history grouped by day.
 func (r *Recorder) GetHistory() History {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.loadArchivesLocked()
 
 	if len(r.trades) == 0 {
-		return History{Days: []DailySummary{}}
+		return History{Days: []DailySummary{}, Inventory: r.ledgerLocked().inventory()}
+	}
+
+	// Realized P&L: each discharge is booked against the cost basis of the
+	// stored energy it used, on the day it was discharged.
+	led := r.ledgerLocked()
+	dayCostBasis := make(map[string]decimal.Decimal)
+	for _, t := range sortedByTime(r.trades) {
+		if cost := led.apply(t); t.Action == ActionDischarge {
+			dayKey := t.Timestamp.Format("2006-01-02")
+			dayCostBasis[dayKey] = dayCostBasis[dayKey].Add(cost.Round(4))
+		}
 	}
 
 	// Group trades by day
@@ -168,7 +230,8 @@ func (r *Recorder) GetHistory() History {
 			avgDischargePrice = dischargeRevenue.Div(dischargedKWh)
 		}
 
-		pnl := dischargeRevenue.Sub(chargeCost)
+		costBasis := dayCostBasis[dayKey]
+		pnl := dischargeRevenue.Sub(costBasis)
 		totalPnL = totalPnL.Add(pnl)
 
 		days = append(days, DailySummary{
@@ -180,6 +243,7 @@ func (r *Recorder) GetHistory() History {
 			SolarChargedKWh:   solarChargedKWh,
 			SolarChargeCycles: solarChargeCycles,
 			PnLEUR:            pnl,
+			CostBasisEUR:      costBasis,
 			AvgChargePrice:    avgChargePrice,
 			MinChargePrice:    minChargePrice,
 			AvgDischargePrice: avgDischargePrice,
@@ -201,6 +265,7 @@ func (r *Recorder) GetHistory() History {
 		Days:       days,
 		TotalPnL:   totalPnL,
 		TotalDays:  len(days),
+		Inventory:  led.inventory(),
 		FirstTrade: &firstTrade,
 		LastTrade:  &lastTrade,
 	}
@@ -220,24 +285,48 @@ func (r *Recorder) GetTodaySummary() DailySummary {
 	return DailySummary{Date: today, Trades: []Trade{}}
 }
 
-// GetTotalPnL returns the total P&L across all recorded trades.
+// GetTotalPnL returns the realized P&L across all recorded trades. Energy
+// still in the battery is not counted; see GetInventory.
 func (r *Recorder) GetTotalPnL() decimal.Decimal {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	totalRevenue := decimal.Zero
-	totalCost := decimal.Zero
-
-	for _, t := range r.trades {
-		switch t.Action {
-		case ActionCharge:
-			totalCost = totalCost.Add(t.PriceEUR.Mul(t.EnergyKWh))
-		case ActionDischarge:
-			totalRevenue = totalRevenue.Add(t.PriceEUR.Mul(t.EnergyKWh))
+	led, total := r.baseLedgerLocked()
+	for _, t := range sortedByTime(r.trades) {
+		if cost := led.apply(t); t.Action == ActionDischarge {
+			total = total.Add(t.PriceEUR.Mul(t.EnergyKWh).Sub(cost.Round(4)))
 		}
 	}
+	return total
+}
+
+// GetInventory returns the energy still stored according to the trade ledger
+// and its cost basis.
+func (r *Recorder) GetInventory() Inventory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	led, _ := r.baseLedgerLocked()
+	for _, t := range sortedByTime(r.trades) {
+		led.apply(t)
+	}
+	return led.inventory()
+}
+
+// baseLedgerLocked returns the ledger and realized P&L before the trades in
+// memory: the archive checkpoint while the archives are not loaded, else an
+// empty ledger. Caller must hold r.mu.
+func (r *Recorder) baseLedgerLocked() (*ledger, decimal.Decimal) {
+	if r.archived != nil {
+		return r.archived.ledger(), r.archived.RealizedPnL
+	}
+	return r.ledgerLocked(), decimal.Zero
+}
 
-	return totalRevenue.Sub(totalCost)
+// ledgerLocked returns an empty inventory ledger with the recorder's settings.
+// Caller must hold r.mu.
+func (r *Recorder) ledgerLocked() *ledger {
+	return newLedger(r.costBasis, r.solar, r.efficiency)
 }
 
 // GetLastChargeTrade returns the most recent charge trade, or nil if none.
@@ -245,6 +334,10 @@ func (r *Recorder) GetLastChargeTrade() *Trade {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.archived != nil && r.archived.LastCharge != nil && !slices.ContainsFunc(r.trades, func(t Trade) bool { return t.Action == ActionCharge }) {
+		t := *r.archived.LastCharge
+		return &t
+	}
 	for i := len(r.trades) - 1; i >= 0; i-- {
 		if r.trades[i].Action == ActionCharge {
 			t := r.trades[i]
@@ -254,59 +347,110 @@ func (r *Recorder) GetLastChargeTrade() *Trade {
 	return nil
 }
 
-// saveTrades persists trades to a JSON file atomically.
-func (r *Recorder) saveTrades() error {
-	if r.dataDir == "" {
-		return nil // No persistence configured
-	}
+// GetCycleTrades returns the trades linked to planned cycles, by cycle ID.
+func (r *Recorder) GetCycleTrades() map[string][]Trade {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadArchivesLocked()
 
-	if err := os.MkdirAll(r.dataDir, 0755); err != nil {
-		return fmt.Errorf("create data dir: %w", err)
+	byCycle := make(map[string][]Trade)
+	for _, t := range r.trades {
+		if t.CycleID != "" {
+			byCycle[t.CycleID] = append(byCycle[t.CycleID], t)
+		}
 	}
+	return byCycle
+}
 
-	path := filepath.Join(r.dataDir, "trades.json")
-	tmpPath := path + ".tmp"
+// LoadTrades loads the trade journal, migrating a legacy trades.json first.
+// The monthly archives are only read when the saved ledger checkpoint does
+// not match them or the cost settings; otherwise they are loaded on first use
+// by the history, exports and cycle reports.
+func (r *Recorder) LoadTrades() error {
+	if r.journal == nil {
+		return nil // No persistence configured
+	}
 
-	data, err := json.MarshalIndent(r.trades, "", "  ")
+	// Read files OUTSIDE the lock (file I/O)
+	trades, err := r.journal.load(time.Now())
 	if err != nil {
-		return fmt.Errorf("marshal trades: %w", err)
+		return err
 	}
+	r.mu.Lock()
+	empty := r.ledgerLocked()
+	r.mu.Unlock()
 
-	// Write to temp file first
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("write temp file: %w", err)
+	cp, err := r.journal.readCheckpoint()
+	if err != nil {
+		slog.Warn("ignoring ledger checkpoint", "error", err)
 	}
-
-	// Atomic rename (on POSIX systems)
-	if err := os.Rename(tmpPath, path); err != nil {
-		os.Remove(tmpPath) // Clean up on failure
-		return fmt.Errorf("rename trades file: %w", err)
+	fingerprint, err := r.journal.archivesFingerprint()
+	if err != nil {
+		return err
+	}
+	if fingerprint != "" && cp != nil && cp.matches(empty, fingerprint) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.trades = trades
+		r.archived = cp
+		return nil
 	}
 
-	return nil
-}
-
-// LoadTrades loads trades from the JSON file.
-func (r *Recorder) LoadTrades() error {
-	if r.dataDir == "" {
-		return nil // No persistence configured
+	archived, fingerprint, err := r.journal.readArchives()
+	if err != nil {
+		return err
+	}
+	if fingerprint != "" {
+		if err := r.journal.writeCheckpoint(newLedgerCheckpoint(empty, archived, fingerprint)); err != nil {
+			slog.Warn("failed to save ledger checkpoint", "error", err)
+		}
 	}
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.trades = append(archived, trades...)
+	r.archived = nil
+	return nil
+}
 
-	path := filepath.Join(r.dataDir, "trades.json")
-	data, err := os.ReadFile(path)
+// loadArchivesLocked adds the archived trades to the trades in memory if they
+// are not loaded yet. Trades archived since LoadTrades are already in memory
+// and are not added twice. On error the archives stay unloaded. Caller must
+// hold r.mu.
+func (r *Recorder) loadArchivesLocked() {
+	if r.archived == nil {
+		return
+	}
+	archived, _, err := r.journal.readArchives()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+		slog.Warn("failed to load trade archives", "error", err)
+		return
+	}
+	trades := make([]Trade, 0, len(archived)+len(r.trades))
+	for _, a := range archived {
+		if !slices.ContainsFunc(r.trades, func(t Trade) bool { return sameTrade(a, t) }) {
+			trades = append(trades, a)
 		}
-		return fmt.Errorf("read trades file: %w", err)
 	}
+	r.trades = append(trades, r.trades...)
+	r.archived = nil
+}
 
-	if err := json.Unmarshal(data, &r.trades); err != nil {
-		return fmt.Errorf("unmarshal trades: %w", err)
+// LoadTradesReadOnly loads trades without migrating or compacting the
+// journal, for reading a data directory the running service writes to.
+func (r *Recorder) LoadTradesReadOnly() error {
+	if r.journal == nil {
+		return nil // No persistence configured
 	}
 
+	trades, err := r.journal.read()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trades = trades
+	r.archived = nil
 	return nil
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,6 +36,7 @@ const (
 	solarStopReasonBatteryFull
 	solarStopReasonYieldWindow
 	solarStopReasonTelemetryFailure
+	solarStopReasonInterrupted // closed after a restart
 )
 
 // Solar anti-cycling constants.
@@ -61,18 +64,28 @@ const (
 	statusBatteryTimeout             = 5 * time.Second
 	solarStatusFailureThreshold      = 10
 	solarStatusFallbackTimeout       = 3 * time.Second
+	telegramLogDefaultCount          = 10
+	telegramLogMaxCount              = 20
 )
 
 // Service is the main trading engine.
 type Service struct {
-	cfg      *config.Config
-	nordpool PriceProvider
-	battery  BatteryController
-	meter    MeterReader
-	telegram *telegram.Client
-	recorder *Recorder
-	loc      *time.Location   // timezone location
-	nowFunc  func() time.Time // clock function for testing
+	cfg       *config.Config
+	nordpool  PriceProvider
+	battery   BatteryController
+	meter     MeterReader
+	pv        PVReader // optional PV production meter, set with SetPVMeter
+	telegram  *telegram.Client
+	recorder  *Recorder
+	commands  *CommandJournal
+	telemetry *TelemetryStore  // nil when telemetry is disabled
+	cycles    *CycleJournal    // activated plans and missed slots
+	sessions  *SessionStore    // active session persisted across restarts
+	meterData *MeterDataStore  // supplier usage imports and P1 register readings
+	events    *EventBus        // state, trade, plan, error and telemetry events
+	controlCh chan controlCall // manual commands, applied by the trading loop
+	loc       *time.Location   // timezone location
+	nowFunc   func() time.Time // clock function for testing
 
 	mu                          sync.RWMutex
 	state                       State
@@ -83,15 +96,41 @@ type Service struct {
 	currentTradeStart           time.Time
 	currentTradePrice           decimal.Decimal
 	currentTradeSOC             int
-	lastChargePrice             decimal.Decimal // track last charge price for profitability check
-	lastErrorNotify             time.Time       // rate limit error notifications
-	lastMidnightSwap            time.Time       // track last midnight price swap to avoid repeated fetches
-	lastDailySummary            time.Time       // track last daily summary to avoid duplicates on restart
-	batteryCooldownUntil        time.Time       // suppress command retries after the battery ignores a command
-	batteryVerificationTimeout  time.Duration   // test override for battery start verification timeout
-	batteryVerificationInterval time.Duration   // test override for battery start verification polling
-	batteryStopRetryDelay       time.Duration   // test override for failed-stop retry delay
-	lastStopAttempt             time.Time       // throttle retries when a stop command fails
+	currentCycleID              string               // planned cycle of the current charge/discharge session
+	currentTradePartial         bool                 // session restored after a restart interrupted it
+	currentTradeManual          bool                 // session started by a manual override
+	currentTradePowerW          int                  // unthrottled power the session was started at
+	override                    *ManualOverride      // manual command taking precedence over the plan
+	overrideErr                 error                // why the override ended early, reported to the control request
+	lastChargePrice             decimal.Decimal      // track last charge price for profitability check
+	lastErrorNotify             time.Time            // rate limit error notifications
+	lastMidnightSwap            time.Time            // track last midnight price swap to avoid repeated fetches
+	lastDailySummary            time.Time            // track last daily summary to avoid duplicates on restart
+	netPosition                 *NetMeteringPosition // year-to-date net metering position, refreshed before each analysis
+	lastSample                  *TelemetrySample     // latest control loop reading, also kept without telemetry
+	batteryCooldownUntil        time.Time            // suppress command retries after the battery ignores a command
+	batteryVerificationTimeout  time.Duration        // test override for battery start verification timeout
+	batteryVerificationInterval time.Duration        // test override for battery start verification polling
+	batteryStopRetryDelay       time.Duration        // test override for failed-stop retry delay
+	lastStopAttempt             time.Time            // throttle retries when a stop command fails
+	lastIdleTransition          time.Time            // when the battery was last confirmed idle
+
+	// Drift watchdog state
+	watchdogDriftCount int         // consecutive readings that contradict the service state
+	watchdogReasserts  int         // re-asserted commands during the current session
+	lastDrift          *DriftEvent // most recent drift event
+
+	// Fuse protection state
+	phaseLimits    *PhaseLimits // latest headroom on the battery's phase
+	phaseThrottled bool         // running grid session is below its configured power
+	phaseThrottleW int          // power of the throttled session
+
+	// Grid session energy measurement
+	tradeStartCounters *energyCounters // lifetime counters at session start, nil when unavailable
+	tradeEnergyWs      float64         // integrated battery power in the session direction
+	tradePowerW        float64         // latest measured power in the session direction
+	tradeFirstSample   time.Time       // first battery power sample of the session
+	tradeLastSample    time.Time       // last time trade energy was accumulated
 
 	// Solar charging state
 	solarSurplusCount             int       // consecutive surplus readings above threshold
@@ -104,6 +143,12 @@ type Service struct {
 	solarSurplusEMA               float64   // exponentially weighted moving average of surplus
 	solarConsecutiveShortSessions int       // count of successive short sessions ended by surplus loss
 	solarStatusFailures           int       // consecutive telemetry failures during solar charging
+
+	// Session persistence
+	lastSessionKey  []byte    // last saved snapshot without accumulators
+	lastSessionSave time.Time // when the session was last saved
+
+	lastRegisterSlot time.Time // slot of the last meter register reading, touched only by the Start loop
 }
 
 // waitForBatteryPower confirms that the inverter acted on a successful control request.
@@ -159,17 +204,29 @@ func New(
 	meterClient MeterReader,
 	telegramClient *telegram.Client,
 	recorder *Recorder,
+	commands *CommandJournal,
+	telemetry *TelemetryStore,
+	cycles *CycleJournal,
+	sessions *SessionStore,
+	meterData *MeterDataStore,
 ) *Service {
 	return &Service{
-		cfg:      cfg,
-		nordpool: nordpoolClient,
-		battery:  batteryClient,
-		meter:    meterClient,
-		telegram: telegramClient,
-		recorder: recorder,
-		state:    StateIdle,
-		loc:      cfg.Location(),
-		nowFunc:  time.Now,
+		cfg:       cfg,
+		nordpool:  nordpoolClient,
+		battery:   batteryClient,
+		meter:     meterClient,
+		telegram:  telegramClient,
+		recorder:  recorder,
+		commands:  commands,
+		telemetry: telemetry,
+		cycles:    cycles,
+		sessions:  sessions,
+		meterData: meterData,
+		events:    NewEventBus(),
+		controlCh: make(chan controlCall),
+		state:     StateIdle,
+		loc:       cfg.Location(),
+		nowFunc:   time.Now,
 	}
 }
 
@@ -206,6 +263,10 @@ func (s *Service) analyzerConfig() AnalyzerConfig {
 		ChargePowerW:       s.cfg.ChargePowerW,
 		DischargePowerW:    s.cfg.DischargePowerW,
 		MaxCyclesPerDay:    s.cfg.MaxCyclesPerDay,
+		ImportSurcharge:    s.cfg.ImportSurchargeEURKWh,
+		FeedInFee:          s.cfg.FeedInFeeEURKWh,
+		NetMeteringEnd:     s.cfg.NetMeteringEndTime(),
+		NetPosition:        s.netPosition,
 	}
 }
 
@@ -258,17 +319,45 @@ func (s *Service) Start(ctx context.Context) error {
 		slog.Warn("failed to load trades", "error", err)
 	}
 
+	if s.commands != nil {
+		if err := s.commands.LoadCommands(); err != nil {
+			slog.Warn("failed to load command journal", "error", err)
+		}
+	}
+	if s.cycles != nil {
+		if err := s.cycles.Load(); err != nil {
+			slog.Warn("failed to load cycle journal", "error", err)
+		}
+	}
+
 	// Restore last charge price for profitability checks after restart
 	if lastCharge := s.recorder.GetLastChargeTrade(); lastCharge != nil {
 		s.lastChargePrice = lastCharge.PriceEUR
 		slog.Info("restored last charge price", "price", s.lastChargePrice)
 	}
 
+	// Restore cooldowns and detect a session interrupted by a restart
+	var interrupted *SessionSnapshot
+	if s.sessions != nil {
+		snap, err := s.sessions.Load()
+		if err != nil {
+			slog.Warn("failed to load session", "error", err)
+		} else if snap != nil {
+			s.mu.Lock()
+			s.restoreCooldownsLocked(snap)
+			s.mu.Unlock()
+			interrupted = snap
+		}
+	}
+
 	// Connect to battery
 	if err := s.battery.Connect(); err != nil {
 		return err
 	}
-	if err := s.battery.IdleContext(ctx); err != nil {
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandIdle, Reason: "startup", StateBefore: StateIdle}, s.battery.IdleContext)
+	cmd.StateAfter = StateIdle
+	s.journalCommands(cmd)
+	if err != nil {
 		return fmt.Errorf("reset battery control on startup: %w", err)
 	}
 
@@ -290,6 +379,13 @@ func (s *Service) Start(ctx context.Context) error {
 		slog.Debug("tomorrow's prices not available yet", "error", err)
 	}
 
+	// The battery is idle now: book the interrupted session, then resume
+	// trading right away if its window is still active
+	if s.recoverSession(ctx, interrupted) {
+		s.tick(ctx)
+	}
+	s.saveSession(true)
+
 	// Send startup notification
 	if s.telegramEnabled() {
 		if err := s.telegram.SendStartup(ctx, s.cfg.ServiceName); err != nil {
@@ -321,18 +417,46 @@ func (s *Service) Start(ctx context.Context) error {
 		solarTickCh = solarTicker.C
 	}
 
+	// Drift watchdog: nil channel when disabled
+	var watchdogTickCh <-chan time.Time
+	if s.cfg.WatchdogIntervalS > 0 {
+		watchdogTicker := time.NewTicker(time.Duration(s.cfg.WatchdogIntervalS) * time.Second)
+		defer watchdogTicker.Stop()
+		watchdogTickCh = watchdogTicker.C
+	}
+
+	// Fuse protection: nil channel when no fuse rating or meter is configured
+	var fuseTickCh <-chan time.Time
+	if s.fuseProtectionEnabled() {
+		fuseTicker := time.NewTicker(fuseCheckInterval)
+		defer fuseTicker.Stop()
+		fuseTickCh = fuseTicker.C
+	}
+
+	// Telemetry flush: nil channel when telemetry is disabled
+	var telemetryTickCh <-chan time.Time
+	if s.telemetry != nil {
+		telemetryTicker := time.NewTicker(telemetryFlushInterval)
+		defer telemetryTicker.Stop()
+		telemetryTickCh = telemetryTicker.C
+		defer s.flushTelemetry()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			slog.Info("stopping trading service")
+			s.saveSession(true)
 			if err := s.stopBatteryOnShutdown(); err != nil {
 				slog.Error("failed to stop battery during shutdown", "error", err)
 				return fmt.Errorf("stop battery during shutdown: %w", err)
 			}
+			s.saveStoppedSession()
 			return ctx.Err()
 
 		case <-ticker.C:
 			s.tick(ctx)
+			s.recordMeterRegisters()
 
 		case <-priceTicker.C:
 			s.checkPriceFetch(ctx)
@@ -343,9 +467,23 @@ func (s *Service) Start(ctx context.Context) error {
 		case <-cmdTicker.C:
 			s.handleTelegramCommands(ctx)
 
+		case call := <-s.controlCh:
+			result, err := s.applyControl(ctx, call.req)
+			call.reply <- controlReply{result: result, err: err}
+
 		case <-solarTickCh:
 			s.solarTick(ctx)
+
+		case <-watchdogTickCh:
+			s.watchdogTick(ctx)
+
+		case <-fuseTickCh:
+			s.fuseTick(ctx)
+
+		case <-telemetryTickCh:
+			s.flushTelemetry()
 		}
+		s.saveSession(false)
 	}
 }
 
@@ -364,9 +502,14 @@ func (s *Service) tick(ctx context.Context) {
 		return
 	}
 
+	// A planned slot not traded this tick is journaled after unlocking
+	var missed *MissedSlot
+	defer func() { s.journalMissedSlot(missed) }()
+
 	// Now lock for state access and updates
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.recordTelemetryLocked(batStatus.SOC, nil, nil) // after the decision, before unlocking
 
 	// Create contextual logger for this tick
 	l := slog.With(
@@ -376,6 +519,16 @@ func (s *Service) tick(ctx context.Context) {
 	)
 
 	l.Debug("tick", "charging_enabled", batStatus.ChargingFlag, "discharging_enabled", batStatus.DischargFlag)
+
+	// A manual override takes precedence over the plan until it expires
+	if s.override != nil && !now.Before(s.override.Until) {
+		s.expireOverrideLocked(ctx, batStatus.SOC)
+	}
+	if s.override != nil {
+		missed = s.overrideTickLocked(ctx, l, now, batStatus)
+		return
+	}
+
 	// Check if we have a valid trading plan
 	if s.currentPlan == nil || !s.currentPlan.ShouldTrade() {
 		switch s.state {
@@ -411,25 +564,43 @@ func (s *Service) tick(ctx context.Context) {
 	case StateIdle:
 		if now.Before(s.batteryCooldownUntil) {
 			l.Debug("battery control retry cooling down", "retry_at", s.batteryCooldownUntil)
+			if inChargeWindow {
+				missed = s.missedSlotLocked(now, ActionCharge, MissCooldown)
+			} else if inDischargeWindow {
+				missed = s.missedSlotLocked(now, ActionDischarge, MissCooldown)
+			}
 			return
 		}
 		if inChargeWindow {
 			if batStatus.SOC >= 100 {
 				l.Debug("in charge window but battery full")
+				missed = s.missedSlotLocked(now, ActionCharge, MissBatteryFull)
 			} else if !batStatus.ChargingFlag {
 				l.Warn("in charge window but battery charging disabled")
+				missed = s.missedSlotLocked(now, ActionCharge, MissChargingDisabled)
+			} else if power := s.chargePowerLocked(); power < fuseMinSessionW {
+				l.Info("in charge window but no headroom below the main fuse", "max_charge_w", power)
+				missed = s.missedSlotLocked(now, ActionCharge, MissFuseHeadroom)
 			} else {
 				l.Info("decision: start charging",
 					"min_price", s.currentPlan.MinPrice,
 					"charge_threshold", s.currentPlan.MinPrice.Add(s.currentPlan.Spread.Mul(decimal.NewFromFloat(0.25))))
 				s.startChargingLocked(ctx, currentPrice, batStatus.SOC)
+				if s.state != StateCharging {
+					missed = s.missedSlotLocked(now, ActionCharge, MissCommandFailure)
+				}
 			}
 		} else if inDischargeWindow {
 			minSOC := int(s.cfg.BatteryMinSOC * 100)
 			if batStatus.SOC <= minSOC {
 				l.Debug("in discharge window but battery at min SOC", "min_soc", minSOC)
+				missed = s.missedSlotLocked(now, ActionDischarge, MissBatteryEmpty)
 			} else if !batStatus.DischargFlag {
 				l.Warn("in discharge window but battery discharging disabled")
+				missed = s.missedSlotLocked(now, ActionDischarge, MissDischargingDisabled)
+			} else if power := s.dischargePowerLocked(); power < fuseMinSessionW {
+				l.Info("in discharge window but no headroom below the feed-in limit", "max_discharge_w", power)
+				missed = s.missedSlotLocked(now, ActionDischarge, MissFuseHeadroom)
 			} else {
 				lastChargeF, _ := s.lastChargePrice.Float64()
 				l.Info("decision: start discharging",
@@ -437,6 +608,9 @@ func (s *Service) tick(ctx context.Context) {
 					"max_price", s.currentPlan.MaxPrice,
 					"discharge_threshold", s.currentPlan.MaxPrice.Sub(s.currentPlan.Spread.Mul(decimal.NewFromFloat(0.25))))
 				s.startDischargingLocked(ctx, currentPrice, batStatus.SOC)
+				if s.state != StateDischarging {
+					missed = s.missedSlotLocked(now, ActionDischarge, MissCommandFailure)
+				}
 			}
 		}
 
@@ -471,7 +645,7 @@ func (s *Service) tick(ctx context.Context) {
 			l.Info("decision: stop charging - battery full")
 			s.stopChargingLocked(ctx, batStatus.SOC)
 		} else {
-			s.refreshPassiveModeLocked(ctx, -s.cfg.ChargePowerW)
+			s.refreshPassiveModeLocked(ctx, -s.sessionPowerLocked())
 		}
 
 	case StateDischarging:
@@ -483,7 +657,7 @@ func (s *Service) tick(ctx context.Context) {
 			l.Info("decision: stop discharging - battery at min SOC", "min_soc", minSOC)
 			s.stopDischargingLocked(ctx, batStatus.SOC)
 		} else {
-			s.refreshPassiveModeLocked(ctx, s.cfg.DischargePowerW)
+			s.refreshPassiveModeLocked(ctx, s.sessionPowerLocked())
 		}
 	}
 }
@@ -528,7 +702,9 @@ func (s *Service) solarTick(ctx context.Context) {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.recordTelemetryLocked(batterySOC, &esStatus.BatteryPower, &activePowerW) // after the decision, before unlocking
 	s.solarStatusFailures = 0
+	s.accumulateTradeEnergyLocked(esStatus.BatteryPower)
 
 	switch s.state {
 	case StateIdle:
@@ -544,6 +720,12 @@ func (s *Service) solarTick(ctx context.Context) {
 			return
 		}
 
+		// A manual override other than pause holds the battery
+		if s.overrideHoldsBatteryLocked(s.now()) {
+			s.solarSurplusCount = 0
+			return
+		}
+
 		// Use raw surplus (not EMA) for start decision — EMA memory from
 		// previous sessions could cause false starts from a single spike.
 		minSurplus := float64(s.cfg.SolarMinSurplusW)
@@ -571,7 +753,7 @@ func (s *Service) solarTick(ctx context.Context) {
 		if s.solarSurplusCount >= solarStartQualificationCount {
 			power := int(surplus)
 			power = max(power, solarMinChargePowerW)
-			power = min(power, s.cfg.ChargePowerW)
+			power = min(power, s.chargePowerLocked())
 			s.startSolarChargingLocked(ctx, power, batterySOC)
 		}
 
@@ -608,6 +790,11 @@ func (s *Service) solarTick(ctx context.Context) {
 				return
 			}
 		}
+		if s.overrideHoldsBatteryLocked(s.now()) {
+			slog.Info("solar charging: yielding to manual override")
+			s.stopSolarChargingLocked(ctx, batterySOC, solarStopReasonYieldWindow)
+			return
+		}
 
 		// Hysteresis: stop threshold is lower than start threshold to avoid
 		// cycling when surplus hovers near the boundary. Requires solarStopDebounceCount
@@ -650,7 +837,7 @@ func (s *Service) solarTick(ctx context.Context) {
 			// During min session, clamp to floor as safety bound
 			targetPower = solarMinChargePowerW
 		}
-		targetPower = min(targetPower, s.cfg.ChargePowerW)
+		targetPower = min(targetPower, s.chargePowerLocked())
 
 		diff := targetPower - s.solarChargePower
 		if diff < 0 {
@@ -663,13 +850,19 @@ func (s *Service) solarTick(ctx context.Context) {
 				"effective_surplus_w", effectiveSurplus)
 
 			// Release lock during network I/O
+			state := s.state
 			s.mu.Unlock()
-			err := s.battery.ChargeContext(ctx, targetPower, s.cfg.PassiveModeTimeoutS)
+			cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandSolarAdjust, PowerW: targetPower, StateBefore: state, StateAfter: state},
+				func(ctx context.Context) error {
+					return s.battery.ChargeContext(ctx, targetPower, s.cfg.PassiveModeTimeoutS)
+				})
+			s.journalCommands(cmd)
 			s.mu.Lock()
 
 			if err != nil {
 				slog.Warn("solar charging: failed to adjust power", "error", err)
 			} else {
+				s.publish(EventSolarPower, SolarPowerEvent{OldPowerW: s.solarChargePower, NewPowerW: targetPower, SurplusW: round1(s.solarSurplusEMA)})
 				s.solarChargePower = targetPower
 				s.lastPassiveRefresh = s.now()
 			}
@@ -730,38 +923,41 @@ func (s *Service) handleSolarStatusFailure(ctx context.Context, telemetryErr err
 func (s *Service) startSolarChargingLocked(ctx context.Context, powerW int, soc int) {
 	l := slog.With("action", "solar_charge", "power_w", powerW, "soc", soc)
 	l.Info("starting solar charge session")
+	stateBefore := s.state
 
 	// Release lock during network I/O
 	s.mu.Unlock()
-	err := s.battery.ChargeContext(ctx, powerW, s.cfg.PassiveModeTimeoutS)
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandSolarCharge, PowerW: powerW, StateBefore: stateBefore},
+		func(ctx context.Context) error {
+			return s.battery.ChargeContext(ctx, powerW, s.cfg.PassiveModeTimeoutS)
+		})
 	var measuredPowerW float64
-	var idleErr error
 	if err == nil {
 		measuredPowerW, err = s.waitForBatteryPower(ctx, true, powerW)
+		cmd.setVerification(measuredPowerW, err)
 	}
-	if err != nil {
-		if idleErr = s.idleBattery(ctx); idleErr != nil {
-			l.Warn("failed to return battery to idle after start failure", "error", idleErr)
-		}
-	}
+	idleCmd, idleErr := s.idleAfterStartFailure(ctx, l, stateBefore, err)
 	s.mu.Lock()
 
 	if err != nil {
 		if idleErr != nil {
-			s.state = StateStopping
+			s.setStateLocked(StateStopping)
 			s.lastStopAttempt = s.now()
 		}
 		l.Error("failed to start solar charging", "error", err)
 		s.solarSurplusCount = 0
 		s.batteryCooldownUntil = s.now().Add(batteryControlFailureCooldown)
+		cmd.StateAfter, idleCmd.StateAfter = s.state, s.state
 		s.mu.Unlock()
+		s.journalCommands(cmd, idleCmd)
 		s.notifyError(ctx, "Battery did not start solar charging: "+err.Error())
 		s.mu.Lock()
 		return
 	}
 
-	s.state = StateSolarCharging
+	s.setStateLocked(StateSolarCharging)
 	s.currentTradeStart = s.now()
+	s.watchdogReasserts = 0
 	s.currentTradePrice = decimal.Zero // Solar is free
 	s.currentTradeSOC = soc
 	s.lastPassiveRefresh = s.now()
@@ -775,9 +971,12 @@ func (s *Service) startSolarChargingLocked(ctx context.Context, powerW int, soc
 	s.batteryCooldownUntil = time.Time{}
 
 	l.Info("solar charge session started", "state", s.state, "measured_battery_power_w", measuredPowerW)
+	cmd.StateAfter = s.state
+	s.publish(EventTradeStart, TradeStartEvent{Action: ActionSolarCharge, PowerW: powerW, SOC: soc})
 
 	// Release lock for notification
 	s.mu.Unlock()
+	s.journalCommands(cmd)
 	if s.telegramEnabled() {
 		if err := s.telegram.SendTradeStart(ctx, "Solar charging", 0, soc); err != nil {
 			l.Warn("failed to send trade notification", "error", err)
@@ -795,6 +994,12 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 	if !s.transitionToIdleLocked(ctx, endSOC) {
 		return
 	}
+	s.finishSolarChargeLocked(ctx, endSOC, stopTime, reason)
+}
+
+// finishSolarChargeLocked records the trade of a solar charge session that has
+// ended and sets the restart cooldown. Caller must hold s.mu.
+func (s *Service) finishSolarChargeLocked(ctx context.Context, endSOC int, stopTime time.Time, reason solarStopReason) {
 	duration := stopTime.Sub(s.currentTradeStart)
 	energyKWh := decimal.NewFromFloat(s.solarEnergyWs / 3_600_000.0) // watt-seconds to kWh
 	energyF, _ := energyKWh.Float64()
@@ -813,7 +1018,7 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 		} else {
 			cooldown = solarShortSessionCooldown
 		}
-	} else {
+	} else if reason != solarStopReasonInterrupted {
 		s.solarConsecutiveShortSessions = 0
 	}
 
@@ -837,6 +1042,12 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 		EnergyKWh: energyKWh,
 		StartSOC:  s.currentTradeSOC,
 		EndSOC:    endSOC,
+		Partial:   s.currentTradePartial,
+	}
+	if len(s.todayPrices) > 0 {
+		// Export value of the energy, used when solar is valued at the spot price
+		marketPrice := s.calculateAveragePrice(s.currentTradeStart, stopTime)
+		trade.MarketPriceEUR = &marketPrice
 	}
 
 	// Release lock for I/O
@@ -844,6 +1055,7 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 	if err := s.recorder.RecordTrade(trade); err != nil {
 		l.Error("failed to record solar trade", "error", err)
 	}
+	s.publish(EventTradeEnd, trade)
 	s.mu.Lock()
 
 	s.solarSurplusCount = 0
@@ -852,6 +1064,7 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 	s.solarMeasuredChargePowerW = 0
 	s.solarStatusFailures = 0
 	s.solarEnergyWs = 0
+	s.currentTradePartial = false
 	s.solarCooldownUntil = s.now().Add(cooldown)
 	s.solarSurplusEMA = 0
 	s.mu.Unlock()
@@ -866,40 +1079,58 @@ func (s *Service) stopSolarChargingLocked(ctx context.Context, endSOC int, reaso
 // startChargingLocked begins a charge session. Caller must hold s.mu.
 func (s *Service) startChargingLocked(ctx context.Context, price decimal.Decimal, soc int) {
 	priceF, _ := price.Float64()
-	l := slog.With("action", "charge", "price_eur_kwh", priceF, "soc", soc, "power_w", s.cfg.ChargePowerW)
+	powerW := s.chargePowerLocked()
+	manual := s.manualLocked(ControlCharge)
+	l := slog.With("action", "charge", "price_eur_kwh", priceF, "soc", soc, "power_w", powerW, "manual", manual)
 	l.Info("starting charge session")
+	stateBefore := s.state
+	reason, label := "", "Charging"
+	if manual {
+		reason, label = commandReasonManual, "Manual charging"
+	}
 
 	// Release lock during network I/O
 	s.mu.Unlock()
-	err := s.battery.ChargeContext(ctx, s.cfg.ChargePowerW, s.cfg.PassiveModeTimeoutS)
+	startCounters := s.readEnergyCounters(ctx)
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandCharge, Reason: reason, PowerW: powerW, StateBefore: stateBefore},
+		func(ctx context.Context) error {
+			return s.battery.ChargeContext(ctx, powerW, s.cfg.PassiveModeTimeoutS)
+		})
 	var measuredPowerW float64
-	var idleErr error
 	if err == nil {
-		measuredPowerW, err = s.waitForBatteryPower(ctx, true, s.cfg.ChargePowerW)
-	}
-	if err != nil {
-		if idleErr = s.idleBattery(ctx); idleErr != nil {
-			l.Warn("failed to return battery to idle after start failure", "error", idleErr)
-		}
+		measuredPowerW, err = s.waitForBatteryPower(ctx, true, powerW)
+		cmd.setVerification(measuredPowerW, err)
 	}
+	idleCmd, idleErr := s.idleAfterStartFailure(ctx, l, stateBefore, err)
 	s.mu.Lock()
 
 	if err != nil {
 		if idleErr != nil {
-			s.state = StateStopping
+			s.setStateLocked(StateStopping)
 			s.lastStopAttempt = s.now()
 		}
 		l.Error("failed to start charging", "error", err)
 		s.batteryCooldownUntil = s.now().Add(batteryControlFailureCooldown)
 		errMsg := "Failed to start charging: " + err.Error()
+		cmd.StateAfter, idleCmd.StateAfter = s.state, s.state
 		s.mu.Unlock()
+		s.journalCommands(cmd, idleCmd)
 		s.notifyError(ctx, errMsg)
 		s.mu.Lock()
 		return
 	}
 
-	s.state = StateCharging
+	s.setStateLocked(StateCharging)
+	s.setSessionPowerLocked(powerW, s.fullPowerLocked(StateCharging))
 	s.currentTradeStart = s.now()
+	s.currentTradeManual = manual
+	s.currentTradePowerW = s.fullPowerLocked(StateCharging)
+	s.currentCycleID = ""
+	if !manual {
+		s.currentCycleID = s.cycleIDLocked(s.currentTradeStart)
+	}
+	s.startTradeEnergyLocked(startCounters)
+	s.watchdogReasserts = 0
 	s.currentTradePrice = price
 	s.currentTradeSOC = soc
 	s.lastPassiveRefresh = s.now()
@@ -907,11 +1138,14 @@ func (s *Service) startChargingLocked(ctx context.Context, price decimal.Decimal
 	s.batteryCooldownUntil = time.Time{}
 
 	l.Info("charge session started", "state", s.state, "measured_battery_power_w", measuredPowerW)
+	cmd.StateAfter = s.state
+	s.publish(EventTradeStart, TradeStartEvent{Action: ActionCharge, PriceEUR: priceF, PowerW: powerW, SOC: soc, CycleID: s.currentCycleID})
 
 	// Release lock for notification
 	s.mu.Unlock()
+	s.journalCommands(cmd)
 	if s.telegramEnabled() {
-		if err := s.telegram.SendTradeStart(ctx, "Charging", priceF, soc); err != nil {
+		if err := s.telegram.SendTradeStart(ctx, label, priceF, soc); err != nil {
 			l.Warn("failed to send trade notification", "error", err)
 		}
 	}
@@ -924,12 +1158,19 @@ func (s *Service) stopChargingLocked(ctx context.Context, endSOC int) {
 	if !s.transitionToIdleLocked(ctx, endSOC) {
 		return
 	}
+	s.mu.Unlock()
+	endCounters := s.readEnergyCounters(ctx)
+	s.mu.Lock()
+	s.finishChargeLocked(ctx, endSOC, stopTime, endCounters)
+}
+
+// finishChargeLocked records the trade of a charge session that has ended. Caller must hold s.mu.
+func (s *Service) finishChargeLocked(ctx context.Context, endSOC int, stopTime time.Time, endCounters *energyCounters) {
 	duration := stopTime.Sub(s.currentTradeStart)
 	socDelta := max(endSOC-s.currentTradeSOC, 0)
-	energyKWh := decimal.NewFromFloat(s.cfg.BatteryCapacityKWh).
+	nominalKWh := decimal.NewFromFloat(s.cfg.BatteryCapacityKWh).
 		Mul(decimal.NewFromInt(int64(socDelta))).
 		Div(decimal.NewFromInt(100))
-	energyF, _ := energyKWh.Float64()
 
 	// Calculate average price paid during the actual charge period
 	avgPrice := s.calculateAveragePrice(s.currentTradeStart, stopTime)
@@ -942,6 +1183,21 @@ func (s *Service) stopChargingLocked(ctx context.Context, endSOC int) {
 	// Update lastChargePrice to the actual average (for accurate profitability check)
 	s.lastChargePrice = avgPrice
 
+	trade := Trade{
+		Timestamp: s.currentTradeStart,
+		Action:    ActionCharge,
+		PriceEUR:  avgPrice,
+		PowerW:    s.tradePowerLocked(StateCharging),
+		DurationS: int(duration.Seconds()),
+		StartSOC:  s.currentTradeSOC,
+		EndSOC:    endSOC,
+		CycleID:   s.currentCycleID,
+		Partial:   s.currentTradePartial,
+		Manual:    s.currentTradeManual,
+	}
+	s.tradeEnergyLocked(&trade, nominalKWh, endCounters, stopTime)
+	energyF, _ := trade.EnergyKWh.Float64()
+
 	l := slog.With(
 		"action", "charge",
 		"avg_price_eur_kwh", avgPriceF,
@@ -949,25 +1205,21 @@ func (s *Service) stopChargingLocked(ctx context.Context, endSOC int) {
 		"end_soc", endSOC,
 		"duration", duration,
 		"energy_kwh", energyF,
+		"nominal_energy_kwh", nominalKWh.InexactFloat64(),
+		"energy_source", trade.EnergySource,
 	)
 	l.Info("stopping charge session")
-
-	trade := Trade{
-		Timestamp: s.currentTradeStart,
-		Action:    ActionCharge,
-		PriceEUR:  avgPrice,
-		PowerW:    s.cfg.ChargePowerW,
-		DurationS: int(duration.Seconds()),
-		EnergyKWh: energyKWh,
-		StartSOC:  s.currentTradeSOC,
-		EndSOC:    endSOC,
-	}
+	s.currentCycleID = ""
+	s.currentTradePartial = false
+	s.currentTradeManual = false
+	s.currentTradePowerW = 0
 
 	// Release lock for I/O
 	s.mu.Unlock()
 	if err := s.recorder.RecordTrade(trade); err != nil {
 		l.Error("failed to record trade", "error", err)
 	}
+	s.publish(EventTradeEnd, trade)
 	s.mu.Lock()
 
 	s.mu.Unlock()
@@ -983,51 +1235,72 @@ func (s *Service) stopChargingLocked(ctx context.Context, endSOC int) {
 func (s *Service) startDischargingLocked(ctx context.Context, price decimal.Decimal, soc int) {
 	priceF, _ := price.Float64()
 	lastChargeF, _ := s.lastChargePrice.Float64()
-	l := slog.With("action", "discharge", "price_eur_kwh", priceF, "soc", soc, "power_w", s.cfg.DischargePowerW, "last_charge_price", lastChargeF)
+	powerW := s.dischargePowerLocked()
+	manual := s.manualLocked(ControlDischarge)
+	l := slog.With("action", "discharge", "price_eur_kwh", priceF, "soc", soc, "power_w", powerW, "last_charge_price", lastChargeF, "manual", manual)
 	l.Info("starting discharge session")
+	stateBefore := s.state
+	reason, label := "", "Discharging"
+	if manual {
+		reason, label = commandReasonManual, "Manual discharging"
+	}
 
 	// Release lock during network I/O
 	s.mu.Unlock()
-	err := s.battery.DischargeContext(ctx, s.cfg.DischargePowerW, s.cfg.PassiveModeTimeoutS)
+	startCounters := s.readEnergyCounters(ctx)
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandDischarge, Reason: reason, PowerW: -powerW, StateBefore: stateBefore},
+		func(ctx context.Context) error {
+			return s.battery.DischargeContext(ctx, powerW, s.cfg.PassiveModeTimeoutS)
+		})
 	var measuredPowerW float64
-	var idleErr error
 	if err == nil {
-		measuredPowerW, err = s.waitForBatteryPower(ctx, false, s.cfg.DischargePowerW)
-	}
-	if err != nil {
-		if idleErr = s.idleBattery(ctx); idleErr != nil {
-			l.Warn("failed to return battery to idle after start failure", "error", idleErr)
-		}
+		measuredPowerW, err = s.waitForBatteryPower(ctx, false, powerW)
+		cmd.setVerification(measuredPowerW, err)
 	}
+	idleCmd, idleErr := s.idleAfterStartFailure(ctx, l, stateBefore, err)
 	s.mu.Lock()
 
 	if err != nil {
 		if idleErr != nil {
-			s.state = StateStopping
+			s.setStateLocked(StateStopping)
 			s.lastStopAttempt = s.now()
 		}
 		l.Error("failed to start discharging", "error", err)
 		s.batteryCooldownUntil = s.now().Add(batteryControlFailureCooldown)
 		errMsg := "Failed to start discharging: " + err.Error()
+		cmd.StateAfter, idleCmd.StateAfter = s.state, s.state
 		s.mu.Unlock()
+		s.journalCommands(cmd, idleCmd)
 		s.notifyError(ctx, errMsg)
 		s.mu.Lock()
 		return
 	}
 
-	s.state = StateDischarging
+	s.setStateLocked(StateDischarging)
+	s.setSessionPowerLocked(powerW, s.fullPowerLocked(StateDischarging))
 	s.currentTradeStart = s.now()
+	s.currentTradeManual = manual
+	s.currentTradePowerW = s.fullPowerLocked(StateDischarging)
+	s.currentCycleID = ""
+	if !manual {
+		s.currentCycleID = s.cycleIDLocked(s.currentTradeStart)
+	}
+	s.startTradeEnergyLocked(startCounters)
+	s.watchdogReasserts = 0
 	s.currentTradePrice = price
 	s.currentTradeSOC = soc
 	s.lastPassiveRefresh = s.now()
 	s.batteryCooldownUntil = time.Time{}
 
 	l.Info("discharge session started", "state", s.state, "measured_battery_power_w", measuredPowerW)
+	cmd.StateAfter = s.state
+	s.publish(EventTradeStart, TradeStartEvent{Action: ActionDischarge, PriceEUR: priceF, PowerW: powerW, SOC: soc, CycleID: s.currentCycleID})
 
 	// Release lock for notification
 	s.mu.Unlock()
+	s.journalCommands(cmd)
 	if s.telegramEnabled() {
-		if err := s.telegram.SendTradeStart(ctx, "Discharging", priceF, soc); err != nil {
+		if err := s.telegram.SendTradeStart(ctx, label, priceF, soc); err != nil {
 			l.Warn("failed to send trade notification", "error", err)
 		}
 	}
@@ -1040,15 +1313,37 @@ func (s *Service) stopDischargingLocked(ctx context.Context, endSOC int) {
 	if !s.transitionToIdleLocked(ctx, endSOC) {
 		return
 	}
+	s.mu.Unlock()
+	endCounters := s.readEnergyCounters(ctx)
+	s.mu.Lock()
+	s.finishDischargeLocked(ctx, endSOC, stopTime, endCounters)
+}
+
+// finishDischargeLocked records the trade of a discharge session that has ended. Caller must hold s.mu.
+func (s *Service) finishDischargeLocked(ctx context.Context, endSOC int, stopTime time.Time, endCounters *energyCounters) {
 	duration := stopTime.Sub(s.currentTradeStart)
 	socDelta := max(s.currentTradeSOC-endSOC, 0)
-	energyKWh := decimal.NewFromFloat(s.cfg.BatteryCapacityKWh).
+	nominalKWh := decimal.NewFromFloat(s.cfg.BatteryCapacityKWh).
 		Mul(decimal.NewFromInt(int64(socDelta))).
 		Div(decimal.NewFromInt(100)).
 		Mul(decimal.NewFromFloat(s.cfg.BatteryEfficiency))
-	energyF, _ := energyKWh.Float64()
 	priceF, _ := s.currentTradePrice.Float64()
 
+	trade := Trade{
+		Timestamp: s.currentTradeStart,
+		Action:    ActionDischarge,
+		PriceEUR:  s.currentTradePrice,
+		PowerW:    s.tradePowerLocked(StateDischarging),
+		DurationS: int(duration.Seconds()),
+		StartSOC:  s.currentTradeSOC,
+		EndSOC:    endSOC,
+		CycleID:   s.currentCycleID,
+		Partial:   s.currentTradePartial,
+		Manual:    s.currentTradeManual,
+	}
+	s.tradeEnergyLocked(&trade, nominalKWh, endCounters, stopTime)
+	energyF, _ := trade.EnergyKWh.Float64()
+
 	l := slog.With(
 		"action", "discharge",
 		"price_eur_kwh", priceF,
@@ -1056,25 +1351,21 @@ func (s *Service) stopDischargingLocked(ctx context.Context, endSOC int) {
 		"end_soc", endSOC,
 		"duration", duration,
 		"energy_kwh", energyF,
+		"nominal_energy_kwh", nominalKWh.InexactFloat64(),
+		"energy_source", trade.EnergySource,
 	)
 	l.Info("stopping discharge session")
-
-	trade := Trade{
-		Timestamp: s.currentTradeStart,
-		Action:    ActionDischarge,
-		PriceEUR:  s.currentTradePrice,
-		PowerW:    s.cfg.DischargePowerW,
-		DurationS: int(duration.Seconds()),
-		EnergyKWh: energyKWh,
-		StartSOC:  s.currentTradeSOC,
-		EndSOC:    endSOC,
-	}
+	s.currentCycleID = ""
+	s.currentTradePartial = false
+	s.currentTradeManual = false
+	s.currentTradePowerW = 0
 
 	// Release lock for I/O
 	s.mu.Unlock()
 	if err := s.recorder.RecordTrade(trade); err != nil {
 		l.Error("failed to record trade", "error", err)
 	}
+	s.publish(EventTradeEnd, trade)
 	s.mu.Lock()
 
 	s.mu.Unlock()
@@ -1092,10 +1383,18 @@ func (s *Service) transitionToIdleLocked(ctx context.Context, soc int) bool {
 		return false
 	}
 	s.lastStopAttempt = s.now()
+	stateBefore := s.state
+	reason := ""
+	if s.override != nil {
+		reason = commandReasonManual
+	}
 
 	// Release lock during network I/O
 	s.mu.Unlock()
-	if err := s.idleBattery(ctx); err != nil {
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandIdle, Reason: reason, StateBefore: stateBefore}, s.idleBattery)
+	if err != nil {
+		cmd.StateAfter = stateBefore
+		s.journalCommands(cmd)
 		s.mu.Lock()
 		slog.Error("failed to set idle mode; retaining active state for retry", "state", s.state, "error", err)
 		s.mu.Unlock()
@@ -1103,10 +1402,13 @@ func (s *Service) transitionToIdleLocked(ctx context.Context, soc int) bool {
 		s.mu.Lock()
 		return false
 	}
+	cmd.StateAfter = StateIdle
+	s.journalCommands(cmd)
 	s.mu.Lock()
 
-	s.state = StateIdle
+	s.setStateLocked(StateIdle)
 	s.lastStopAttempt = time.Time{}
+	s.lastIdleTransition = s.now()
 	slog.Info("transitioned to idle", "soc", soc)
 	return true
 }
@@ -1135,8 +1437,14 @@ func (s *Service) stopBatteryOnShutdown() error {
 	var lastErr error
 	for {
 		attemptCtx, attemptCancel := context.WithTimeout(shutdownCtx, batteryShutdownAttemptTimeout)
-		lastErr = s.battery.IdleContext(attemptCtx)
+		var cmd CommandRecord
+		cmd, lastErr = s.sendCommand(attemptCtx, CommandRecord{Command: CommandIdle, Reason: "shutdown", StateBefore: s.State()}, s.battery.IdleContext)
 		attemptCancel()
+		cmd.StateAfter = cmd.StateBefore
+		if lastErr == nil {
+			cmd.StateAfter = StateIdle
+		}
+		s.journalCommands(cmd)
 		if lastErr == nil {
 			return nil
 		}
@@ -1151,6 +1459,19 @@ func (s *Service) stopBatteryOnShutdown() error {
 	}
 }
 
+// idleAfterStartFailure returns the battery to idle after a start command failed.
+// It is a no-op returning an empty record when startErr is nil. Must be called without holding s.mu.
+func (s *Service) idleAfterStartFailure(ctx context.Context, l *slog.Logger, stateBefore State, startErr error) (CommandRecord, error) {
+	if startErr == nil {
+		return CommandRecord{}, nil
+	}
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandIdle, Reason: "start failed", StateBefore: stateBefore}, s.idleBattery)
+	if err != nil {
+		l.Warn("failed to return battery to idle after start failure", "error", err)
+	}
+	return cmd, err
+}
+
 func (s *Service) idleBattery(ctx context.Context) error {
 	err := s.battery.IdleContext(ctx)
 	if err == nil || ctx.Err() == nil {
@@ -1171,10 +1492,15 @@ func (s *Service) refreshPassiveModeLocked(ctx context.Context, power int) {
 	}
 
 	slog.Debug("refreshing passive mode", "power", power)
+	state := s.state
 
 	// Release lock during network I/O
 	s.mu.Unlock()
-	err := s.battery.SetPassiveModeContext(ctx, power, s.cfg.PassiveModeTimeoutS)
+	cmd, err := s.sendCommand(ctx, CommandRecord{Command: CommandPassiveRefresh, PowerW: -power, StateBefore: state, StateAfter: state},
+		func(ctx context.Context) error {
+			return s.battery.SetPassiveModeContext(ctx, power, s.cfg.PassiveModeTimeoutS)
+		})
+	s.journalCommands(cmd)
 	s.mu.Lock()
 
 	if err != nil {
@@ -1201,6 +1527,7 @@ func (s *Service) checkPriceFetch(ctx context.Context) {
 	alreadySwappedToday := localMidnight(s.lastMidnightSwap).Equal(today)
 
 	if now.Hour() == 0 && now.Minute() < 15 && !alreadySwappedToday {
+		s.refreshNetPosition()
 		s.mu.Lock()
 		if len(s.tomorrowPrices) > 0 {
 			s.todayPrices = s.tomorrowPrices
@@ -1210,6 +1537,7 @@ func (s *Service) checkPriceFetch(ctx context.Context) {
 			plan := s.currentPlan
 			slotsTotal := len(s.todayPrices)
 			s.mu.Unlock()
+			s.recordPlan(plan)
 
 			l := slog.With("day", "today", "slots_total", slotsTotal)
 			l.Info("switched to new day's prices",
@@ -1248,10 +1576,13 @@ func (s *Service) fetchTodayPrices(ctx context.Context) error {
 		}
 	}
 
+	s.refreshNetPosition()
 	s.mu.Lock()
 	s.todayPrices = prices                                          // full day for price lookups
 	s.currentPlan = AnalyzePrices(futurePrices, s.analyzerConfig()) // analyze only future
+	plan := s.currentPlan
 	s.mu.Unlock()
+	s.recordPlan(plan)
 
 	l := slog.With(
 		"day", "today",
@@ -1281,11 +1612,12 @@ func (s *Service) fetchTomorrowPrices(ctx context.Context) error {
 		return nil
 	}
 
+	s.refreshNetPosition()
 	s.mu.Lock()
 	s.tomorrowPrices = prices
+	plan := AnalyzePrices(prices, s.analyzerConfig())
 	s.mu.Unlock()
 
-	plan := AnalyzePrices(prices, s.analyzerConfig())
 	l := slog.With(
 		"day", "tomorrow",
 		"slots_total", len(prices),
@@ -1301,8 +1633,10 @@ func (s *Service) fetchTomorrowPrices(ctx context.Context) error {
 	return nil
 }
 
-// logAndNotifyTradingPlan logs the trading plan and sends a Telegram notification.
+// logAndNotifyTradingPlan logs and publishes the trading plan and sends a Telegram notification.
 func (s *Service) logAndNotifyTradingPlan(ctx context.Context, l *slog.Logger, plan *TradingPlan, day string, slotsTotal, slotsAnalyzed int) {
+	s.publish(EventPlan, PlanEvent{Day: day, Plan: plan})
+
 	// Calculate break-even spread needed to overcome efficiency loss
 	efficiency := decimal.NewFromFloat(s.cfg.BatteryEfficiency)
 	breakEvenDischarge := plan.MinPrice.Div(efficiency)
@@ -1327,6 +1661,7 @@ func (s *Service) logAndNotifyTradingPlan(ctx context.Context, l *slog.Logger, p
 				"discharge_end", c.DischargeWindow.End.Format("15:04"),
 				"discharge_avg_eur_kwh", c.DischargeWindow.Price,
 				"expected_profit_eur_kwh", c.Profit,
+				"export_rate", c.ExportRate,
 			)
 		}
 	}
@@ -1404,6 +1739,9 @@ func (s *Service) checkDailySummary(ctx context.Context) {
 			MaxDischargePrice: maxDischargeF,
 			TotalPnLEUR:       totalPnLF,
 		}
+		for _, c := range s.cycleReports(localMidnight(now)) {
+			summaryData.Cycles = append(summaryData.Cycles, cycleSummary(c))
+		}
 
 		if s.telegramEnabled() {
 			if err := s.telegram.SendDailySummaryFull(ctx, summaryData); err != nil {
@@ -1413,8 +1751,10 @@ func (s *Service) checkDailySummary(ctx context.Context) {
 	}
 }
 
-// notifyError sends an error notification with rate limiting (max 1 per 15 minutes).
+// notifyError publishes an error event and sends an error notification with
+// rate limiting (max 1 per 15 minutes).
 func (s *Service) notifyError(ctx context.Context, msg string) {
+	s.publish(EventError, ErrorEvent{Message: msg})
 	if !s.telegramEnabled() {
 		return
 	}
@@ -1442,13 +1782,54 @@ func (s *Service) handleTelegramCommands(ctx context.Context) {
 	}
 
 	for _, cmd := range commands {
-		switch cmd {
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
 		case "/status":
 			s.sendTelegramStatus(ctx)
+		case "/log":
+			s.sendTelegramCommandLog(ctx, fields[1:])
 		}
 	}
 }
 
+// sendTelegramCommandLog sends the most recent battery commands via Telegram.
+// An optional argument sets the number of commands (default 10, max 20).
+func (s *Service) sendTelegramCommandLog(ctx context.Context, args []string) {
+	if !s.telegramEnabled() || s.commands == nil {
+		return
+	}
+	count := telegramLogDefaultCount
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil && n > 0 {
+			count = min(n, telegramLogMaxCount)
+		}
+	}
+
+	page := s.commands.Page(0, count)
+	entries := make([]telegram.CommandLogEntry, 0, len(page.Commands))
+	for _, c := range page.Commands {
+		entries = append(entries, telegram.CommandLogEntry{
+			Time:           c.Time.In(s.loc),
+			Command:        string(c.Command),
+			Reason:         c.Reason,
+			PowerW:         c.PowerW,
+			StateBefore:    string(c.StateBefore),
+			StateAfter:     string(c.StateAfter),
+			Err:            c.Err,
+			LatencyMS:      c.LatencyMS,
+			MeasuredPowerW: c.MeasuredPowerW,
+			VerifyErr:      c.VerifyErr,
+		})
+	}
+
+	if err := s.telegram.SendCommandLog(ctx, entries); err != nil {
+		slog.Warn("failed to send command log via telegram", "error", err)
+	}
+}
+
 // sendTelegramStatus sends current status via Telegram.
 func (s *Service) sendTelegramStatus(ctx context.Context) {
 	if !s.telegramEnabled() {
@@ -1470,6 +1851,16 @@ func (s *Service) sendTelegramStatus(ctx context.Context) {
 		NextAction:       status.NextAction,
 		TodayPnL:         todayPnLF,
 		TotalPnL:         totalPnLF,
+		InventoryKWh:     status.Inventory.EnergyKWh.InexactFloat64(),
+		AvgCostEUR:       status.Inventory.AvgCostEUR.InexactFloat64(),
+		UnrealizedPnL:    status.Inventory.Unrealized,
+	}
+	if flow := status.EnergyFlow; flow != nil {
+		data.FlowAvailable = true
+		data.PVPowerW = flow.PVPowerW
+		data.GridPowerW = flow.GridPowerW
+		data.HouseLoadW = flow.HouseLoadW
+		data.SelfSufficiencyPct = flow.SelfSufficiencyPct
 	}
 
 	if err := s.telegram.SendStatus(ctx, data); err != nil {
@@ -1491,12 +1882,18 @@ func (s *Service) GetRecorder() *Recorder {
 
 // CurrentStatus contains all current state info.
 type CurrentStatus struct {
-	State            State   `json:"state"`
-	BatteryAvailable bool    `json:"battery_available"`
-	BatterySOC       int     `json:"battery_soc"`
-	BatteryPowerW    float64 `json:"battery_power_w"`
-	CurrentPrice     float64 `json:"current_price_eur_kwh,omitempty"`
-	NextAction       string  `json:"next_action,omitempty"`
+	State            State                `json:"state"`
+	BatteryAvailable bool                 `json:"battery_available"`
+	BatterySOC       int                  `json:"battery_soc"`
+	BatteryPowerW    float64              `json:"battery_power_w"`
+	CurrentPrice     float64              `json:"current_price_eur_kwh,omitempty"`
+	NextAction       string               `json:"next_action,omitempty"`
+	LastDrift        *DriftEvent          `json:"last_drift,omitempty"`
+	PhaseLimits      *PhaseLimits         `json:"phase_limits,omitempty"`
+	EnergyFlow       *EnergyFlow          `json:"energy_flow,omitempty"`
+	Inventory        Inventory            `json:"inventory"`
+	NetMetering      *NetMeteringPosition `json:"net_metering,omitempty"`
+	Override         *ManualOverride      `json:"override,omitempty"`
 }
 
 // GetCurrentStatus returns the current battery and trading status.
@@ -1512,6 +1909,11 @@ func (s *Service) GetCurrentStatus(ctx context.Context) CurrentStatus {
 		batteryPowerW = esStatus.BatteryPower
 		batteryAvailable = true
 	}
+	var flow *EnergyFlow
+	if batteryAvailable {
+		flow = s.readEnergyFlow(batteryPowerW)
+	}
+	inventory := s.recorder.GetInventory()
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -1523,25 +1925,80 @@ func (s *Service) GetCurrentStatus(ctx context.Context) CurrentStatus {
 		BatteryAvailable: batteryAvailable,
 		BatterySOC:       batterySOC,
 		BatteryPowerW:    batteryPowerW,
+		LastDrift:        s.lastDrift,
+		PhaseLimits:      s.currentPhaseLimitsLocked(),
+		EnergyFlow:       flow,
+		Inventory:        inventory,
+		NetMetering:      s.netPosition,
+	}
+	if s.override != nil {
+		override := *s.override
+		status.Override = &override
 	}
 
 	// Get current price (convert to float64 for JSON API boundary)
 	if price, ok := GetCurrentPrice(s.todayPrices, now); ok {
 		status.CurrentPrice = price.InexactFloat64()
+		// Unrealized P&L: stored energy at the current price minus what it cost
+		marketValue := inventory.EnergyKWh.Mul(price).Round(4)
+		unrealized := marketValue.Sub(inventory.CostBasisEUR)
+		marketValueF, unrealizedF := marketValue.InexactFloat64(), unrealized.InexactFloat64()
+		status.Inventory.MarketValue = &marketValueF
+		status.Inventory.Unrealized = &unrealizedF
 	}
 
-	// Determine next action
-	if s.currentPlan != nil && s.currentPlan.IsProfitable {
-		if s.currentPlan.IsInChargeWindow(now) {
-			status.NextAction = "in charge window"
-		} else if s.currentPlan.IsInDischargeWindow(now) {
-			status.NextAction = "in discharge window"
-		} else {
-			status.NextAction = "waiting for next window"
+	status.NextAction = s.nextActionLocked(now)
+	return status
+}
+
+// nextActionLocked describes what the override or the plan does at now. Caller must hold s.mu.
+func (s *Service) nextActionLocked(now time.Time) string {
+	if o := s.override; o != nil {
+		until := o.Until.Format("15:04")
+		switch o.Action {
+		case ControlPause:
+			return "trading paused until " + until
+		case ControlIdle:
+			return "manual idle until " + until
+		default:
+			return fmt.Sprintf("manual %s until %s", o.Action, until)
 		}
-	} else {
-		status.NextAction = "no profitable trades today"
 	}
+	if s.currentPlan == nil || !s.currentPlan.IsProfitable {
+		return "no profitable trades today"
+	}
+	if s.currentPlan.IsInChargeWindow(now) {
+		return "in charge window"
+	}
+	if s.currentPlan.IsInDischargeWindow(now) {
+		return "in discharge window"
+	}
+	return "waiting for next window"
+}
 
-	return status
+// LiveStatus is the control loop state as of the latest reading. Unlike
+// CurrentStatus it does no battery I/O, so it can be polled often.
+type LiveStatus struct {
+	State        State            `json:"state"`
+	NextAction   string           `json:"next_action"`
+	CurrentPrice *float64         `json:"current_price_eur_kwh,omitempty"`
+	Sample       *TelemetrySample `json:"sample,omitempty"` // latest reading, nil before the first tick
+}
+
+// GetLiveStatus returns the state and the latest control loop reading.
+func (s *Service) GetLiveStatus() LiveStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	live := LiveStatus{State: s.state, NextAction: s.nextActionLocked(now)}
+	if price, ok := GetCurrentPrice(s.todayPrices, now); ok {
+		p := price.InexactFloat64()
+		live.CurrentPrice = &p
+	}
+	if s.lastSample != nil {
+		sample := *s.lastSample
+		live.Sample = &sample
+	}
+	return live
 }
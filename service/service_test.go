@@ -9,6 +9,7 @@ import (
 
 	"github.com/shopspring/decimal"
 
+	"github.com/foae/marstek-energy-trading/clients/grid"
 	"github.com/foae/marstek-energy-trading/clients/marstek"
 	"github.com/foae/marstek-energy-trading/clients/nordpool"
 	"github.com/foae/marstek-energy-trading/internal/config"
@@ -47,6 +48,10 @@ type MockBattery struct {
 	IdleFailures        int
 	RespectIdleContext  bool
 
+	// Lifetime energy counters; zero means not reported
+	ChargedWh    float64
+	DischargedWh float64
+
 	// Call tracking
 	ConnectCalled  bool
 	ChargeCalls    []ChargeCall
@@ -132,6 +137,33 @@ func (m *MockBattery) GetESStatus(_ context.Context) (*marstek.ESStatus, error)
 	return &marstek.ESStatus{BatterySOC: m.SOC, BatteryPower: float64(m.CurrentPower)}, nil
 }
 
+func (m *MockBattery) GetEnergyCounters(_ context.Context) (float64, float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.GetStatusErr != nil {
+		return 0, 0, m.GetStatusErr
+	}
+	return m.ChargedWh, m.DischargedWh, nil
+}
+
+func (m *MockBattery) GetControlStatus(_ context.Context) (*marstek.ControlStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.GetStatusErr != nil {
+		return nil, m.GetStatusErr
+	}
+	status := &marstek.ControlStatus{Mode: m.CurrentMode, Direction: marstek.ControlIdle, BatteryPower: float64(m.CurrentPower)}
+	if m.CurrentMode == "Passive" {
+		switch {
+		case m.CurrentPower > 0:
+			status.Direction = marstek.ControlCharge
+		case m.CurrentPower < 0:
+			status.Direction = marstek.ControlDischarge
+		}
+	}
+	return status, nil
+}
+
 func (m *MockBattery) ChargeContext(_ context.Context, powerW int, timeoutS int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -255,6 +287,7 @@ type MockMeterReader struct {
 	enabled        bool
 	ActivePowerW   float64
 	ActivePowerErr error
+	Phases         []grid.Phase // nil = single phase carrying ActivePowerW
 }
 
 func NewMockMeter(enabled bool, activePowerW float64) *MockMeterReader {
@@ -277,6 +310,24 @@ func (m *MockMeterReader) GetActivePowerW() (float64, error) {
 	return m.ActivePowerW, nil
 }
 
+func (m *MockMeterReader) GetPhases() ([]grid.Phase, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ActivePowerErr != nil {
+		return nil, m.ActivePowerErr
+	}
+	if m.Phases == nil {
+		return []grid.Phase{{PowerW: m.ActivePowerW}}, nil
+	}
+	return append([]grid.Phase(nil), m.Phases...), nil
+}
+
+func (m *MockMeterReader) SetPhases(phases ...grid.Phase) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Phases = phases
+}
+
 func (m *MockMeterReader) SetActivePowerW(w float64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
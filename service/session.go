@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	sessionFile = "session.json" // active session and cooldowns, rewritten on change
+
+	// sessionSaveInterval limits rewrites while only the energy accumulators change.
+	sessionSaveInterval = time.Minute
+)
+
+// SessionSnapshot is the control loop state needed to close an interrupted
+// session after a restart and to keep the anti-cycling backoff.
+type SessionSnapshot struct {
+	State      State           `json:"state"`
+	TradeStart time.Time       `json:"trade_start"`
+	TradePrice decimal.Decimal `json:"trade_price_eur"`
+	TradeSOC   int             `json:"trade_soc"`
+	CycleID    string          `json:"cycle_id,omitempty"`
+	Manual     bool            `json:"manual,omitempty"`
+	PowerW     int             `json:"power_w,omitempty"`
+
+	// Grid session energy measurement
+	StartCounters    *energyCounters `json:"start_counters,omitempty"`
+	TradeEnergyWs    float64         `json:"trade_energy_ws"`
+	TradeFirstSample time.Time       `json:"trade_first_sample"`
+	TradeLastSample  time.Time       `json:"trade_last_sample"`
+
+	// Solar session
+	SolarChargePower int       `json:"solar_charge_power_w"`
+	SolarEnergyWs    float64   `json:"solar_energy_ws"`
+	SolarLastUpdate  time.Time `json:"solar_last_update"`
+
+	// Cooldowns
+	SolarCooldownUntil            time.Time `json:"solar_cooldown_until"`
+	SolarConsecutiveShortSessions int       `json:"solar_consecutive_short_sessions"`
+	BatteryCooldownUntil          time.Time `json:"battery_cooldown_until"`
+
+	SavedAt   time.Time `json:"saved_at"`
+	StoppedAt time.Time `json:"stopped_at,omitempty"` // battery idled by a clean shutdown
+}
+
+// active reports whether the snapshot holds a running session.
+func (snap *SessionSnapshot) active() bool {
+	switch snap.State {
+	case StateCharging, StateDischarging, StateSolarCharging:
+		return true
+	}
+	return false
+}
+
+// stopTime returns when the interrupted session ended: at a clean shutdown,
+// or else once the passive mode timed out after the last save, but not later
+// than now.
+func (snap *SessionSnapshot) stopTime(now time.Time, passiveTimeout time.Duration) time.Time {
+	if !snap.StoppedAt.IsZero() {
+		return snap.StoppedAt
+	}
+	if snap.SavedAt.IsZero() {
+		return now
+	}
+	if expired := snap.SavedAt.Add(passiveTimeout); expired.Before(now) {
+		return expired
+	}
+	return now
+}
+
+// key returns the snapshot without its accumulators, to detect changes that
+// must be saved immediately.
+func (snap SessionSnapshot) key() ([]byte, error) {
+	snap.TradeEnergyWs = 0
+	snap.TradeLastSample = time.Time{}
+	snap.SolarEnergyWs = 0
+	snap.SolarLastUpdate = time.Time{}
+	snap.SavedAt = time.Time{}
+	return json.Marshal(snap)
+}
+
+// SessionStore persists the session snapshot in dataDir.
+type SessionStore struct {
+	mu      sync.Mutex
+	dataDir string
+}
+
+// NewSessionStore creates a session store persisted in dataDir. An empty
+// dataDir disables persistence.
+func NewSessionStore(dataDir string) *SessionStore {
+	return &SessionStore{dataDir: dataDir}
+}
+
+// Save atomically replaces the persisted snapshot: the data and the rename
+// are synced to disk before it returns.
+func (st *SessionStore) Save(snap SessionSnapshot) error {
+	if st.dataDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := os.MkdirAll(st.dataDir, 0755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(st.dataDir, sessionFile), data)
+}
+
+// Load returns the persisted snapshot, or nil when there is none.
+func (st *SessionStore) Load() (*SessionSnapshot, error) {
+	if st.dataDir == "" {
+		return nil, nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(st.dataDir, sessionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", sessionFile, err)
+	}
+	var snap SessionSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", sessionFile, err)
+	}
+	return &snap, nil
+}
+
+// sessionSnapshotLocked captures the current session. Caller must hold s.mu.
+func (s *Service) sessionSnapshotLocked() SessionSnapshot {
+	return SessionSnapshot{
+		State:                         s.state,
+		TradeStart:                    s.currentTradeStart,
+		TradePrice:                    s.currentTradePrice,
+		TradeSOC:                      s.currentTradeSOC,
+		CycleID:                       s.currentCycleID,
+		Manual:                        s.currentTradeManual,
+		PowerW:                        s.currentTradePowerW,
+		StartCounters:                 s.tradeStartCounters,
+		TradeEnergyWs:                 s.tradeEnergyWs,
+		TradeFirstSample:              s.tradeFirstSample,
+		TradeLastSample:               s.tradeLastSample,
+		SolarChargePower:              s.solarChargePower,
+		SolarEnergyWs:                 s.solarEnergyWs,
+		SolarLastUpdate:               s.solarLastUpdate,
+		SolarCooldownUntil:            s.solarCooldownUntil,
+		SolarConsecutiveShortSessions: s.solarConsecutiveShortSessions,
+		BatteryCooldownUntil:          s.batteryCooldownUntil,
+		SavedAt:                       s.now(),
+	}
+}
+
+// saveSession persists the session when it changed. Changes to the energy
+// accumulators alone are saved at most every sessionSaveInterval unless force
+// is set. Must be called without holding s.mu.
+func (s *Service) saveSession(force bool) {
+	if s.sessions == nil {
+		return
+	}
+	s.mu.Lock()
+	snap := s.sessionSnapshotLocked()
+	key, err := snap.key()
+	if err != nil {
+		s.mu.Unlock()
+		slog.Warn("failed to encode session", "error", err)
+		return
+	}
+	changed := string(key) != string(s.lastSessionKey)
+	due := snap.SavedAt.Sub(s.lastSessionSave) >= sessionSaveInterval && snap.State != StateIdle
+	if !force && !changed && !due {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSessionKey = key
+	s.lastSessionSave = snap.SavedAt
+	s.mu.Unlock()
+
+	if err := s.sessions.Save(snap); err != nil {
+		slog.Warn("failed to persist session", "error", err)
+	}
+}
+
+// saveStoppedSession persists a running session as stopped by a clean
+// shutdown, so the next start books it up to now. Must be called without
+// holding s.mu, after the battery was idled.
+func (s *Service) saveStoppedSession() {
+	if s.sessions == nil {
+		return
+	}
+	s.mu.Lock()
+	snap := s.sessionSnapshotLocked()
+	s.mu.Unlock()
+	if !snap.active() {
+		return
+	}
+	snap.StoppedAt = snap.SavedAt
+	if err := s.sessions.Save(snap); err != nil {
+		slog.Warn("failed to persist stopped session", "error", err)
+	}
+}
+
+// restoreCooldownsLocked restores the cooldowns of a persisted session. Caller must hold s.mu.
+func (s *Service) restoreCooldownsLocked(snap *SessionSnapshot) {
+	now := s.now()
+	if snap.SolarCooldownUntil.After(now) {
+		s.solarCooldownUntil = snap.SolarCooldownUntil
+	}
+	if snap.BatteryCooldownUntil.After(now) {
+		s.batteryCooldownUntil = snap.BatteryCooldownUntil
+	}
+	s.solarConsecutiveShortSessions = snap.SolarConsecutiveShortSessions
+}
+
+// recoverSession closes a session interrupted by a restart as a partial trade,
+// using the battery's current SOC and energy counters. The trade ends when the
+// battery stopped (see SessionSnapshot.stopTime), not at the restart. The
+// battery must already be idle. It reports whether a session was closed. Must be called without
+// holding s.mu.
+func (s *Service) recoverSession(ctx context.Context, snap *SessionSnapshot) bool {
+	if snap == nil || !snap.active() {
+		return false
+	}
+
+	endSOC := snap.TradeSOC
+	statusCtx, cancel := context.WithTimeout(ctx, statusBatteryTimeout)
+	status, err := s.battery.GetESStatus(statusCtx)
+	cancel()
+	if err != nil {
+		slog.Warn("failed to read battery for session recovery, using start SOC", "error", err)
+	} else {
+		endSOC = status.BatterySOC
+	}
+	endCounters := s.readEnergyCounters(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stopTime := snap.stopTime(s.now(), time.Duration(s.cfg.PassiveModeTimeoutS)*time.Second)
+	slog.Info("closing session interrupted by restart",
+		"state", snap.State,
+		"trade_start", snap.TradeStart,
+		"last_saved", snap.SavedAt,
+		"stopped_at", stopTime,
+		"start_soc", snap.TradeSOC,
+		"end_soc", endSOC,
+	)
+
+	s.currentTradeStart = snap.TradeStart
+	s.currentTradePrice = snap.TradePrice
+	s.currentTradeSOC = snap.TradeSOC
+	s.currentCycleID = snap.CycleID
+	s.currentTradeManual = snap.Manual
+	s.currentTradePowerW = snap.PowerW
+	s.currentTradePartial = true
+	// Power after the last save is unknown: integrate nothing past it
+	s.tradeStartCounters = snap.StartCounters
+	s.tradeEnergyWs = snap.TradeEnergyWs
+	s.tradePowerW = 0
+	s.tradeFirstSample = snap.TradeFirstSample
+	s.tradeLastSample = snap.TradeLastSample
+	s.solarChargePower = snap.SolarChargePower
+	s.solarEnergyWs = snap.SolarEnergyWs
+	s.solarMeasuredChargePowerW = 0
+
+	switch snap.State {
+	case StateCharging:
+		s.finishChargeLocked(ctx, endSOC, stopTime, endCounters)
+	case StateDischarging:
+		s.finishDischargeLocked(ctx, endSOC, stopTime, endCounters)
+	case StateSolarCharging:
+		s.finishSolarChargeLocked(ctx, endSOC, stopTime, solarStopReasonInterrupted)
+	}
+	s.setStateLocked(StateIdle)
+	return true
+}
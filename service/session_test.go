@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRecoverSession_ClosesInterruptedChargeAsPartialTrade(t *testing.T) {
+	dir := t.TempDir()
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+
+	// The first process is charging when it dies
+	before := newTestService(testConfigSmallBattery(), NewMockBattery(50), prices, baseTime.Add(5*time.Minute))
+	before.sessions = NewSessionStore(dir)
+	before.state = StateCharging
+	before.currentTradeStart = baseTime
+	before.currentTradePrice = decimal.NewFromFloat(0.05)
+	before.currentTradeSOC = 50
+	before.startTradeEnergyLocked(&energyCounters{ChargedWh: 10_000, DischargedWh: 8_000})
+	before.solarCooldownUntil = baseTime.Add(time.Hour)
+	before.solarConsecutiveShortSessions = 2
+	before.saveSession(false)
+
+	battery := NewMockBattery(70)
+	battery.ChargedWh = 10_250
+	battery.DischargedWh = 8_000
+	after := newTestService(testConfigSmallBattery(), battery, prices, baseTime.Add(20*time.Minute))
+	after.sessions = NewSessionStore(dir)
+	snap, err := after.sessions.Load()
+	if err != nil || snap == nil {
+		t.Fatalf("Load() = %v, %v; want the saved session", snap, err)
+	}
+	after.restoreCooldownsLocked(snap)
+	if !after.recoverSession(context.Background(), snap) {
+		t.Fatal("recoverSession() = false, want the charge session closed")
+	}
+
+	if after.state != StateIdle {
+		t.Errorf("state = %s, want idle", after.state)
+	}
+	trade := after.recorder.GetLastChargeTrade()
+	if trade == nil {
+		t.Fatal("expected the interrupted charge to be recorded")
+	}
+	if !trade.Partial || !trade.Timestamp.Equal(baseTime) || trade.StartSOC != 50 || trade.EndSOC != 70 {
+		t.Errorf("trade = %+v, want a partial trade from 50%% to 70%% started at %s", trade, baseTime)
+	}
+	// Saved at 00:05, the battery stopped when the 300 s passive mode expired
+	if trade.DurationS != 600 {
+		t.Errorf("duration = %d s, want 600 s up to the passive mode timeout", trade.DurationS)
+	}
+	if trade.EnergySource != EnergySourceCounters || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.25)) {
+		t.Errorf("energy = %s kWh (%s), want 0.25 kWh from counters", trade.EnergyKWh, trade.EnergySource)
+	}
+	if !after.solarCooldownUntil.Equal(baseTime.Add(time.Hour)) || after.solarConsecutiveShortSessions != 2 {
+		t.Errorf("solar cooldown %s after %d short sessions, want the saved backoff", after.solarCooldownUntil, after.solarConsecutiveShortSessions)
+	}
+	if len(battery.ChargeCalls) != 0 || battery.IdleCalls != 0 {
+		t.Errorf("recovery sent battery commands: %d charge, %d idle", len(battery.ChargeCalls), battery.IdleCalls)
+	}
+}
+
+func TestRecoverSession_CleanShutdownEndsTradeAtShutdown(t *testing.T) {
+	dir := t.TempDir()
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+
+	before := newTestService(testConfigSmallBattery(), NewMockBattery(50), prices, baseTime.Add(2*time.Minute))
+	before.sessions = NewSessionStore(dir)
+	before.state = StateDischarging
+	before.currentTradeStart = baseTime
+	before.currentTradeSOC = 50
+	before.saveStoppedSession()
+
+	after := newTestService(testConfigSmallBattery(), NewMockBattery(45), prices, baseTime.Add(3*time.Hour))
+	after.sessions = NewSessionStore(dir)
+	snap, err := after.sessions.Load()
+	if err != nil || snap == nil || snap.StoppedAt.IsZero() {
+		t.Fatalf("Load() = %+v, %v; want a session stopped at shutdown", snap, err)
+	}
+	if !after.recoverSession(context.Background(), snap) {
+		t.Fatal("recoverSession() = false, want the discharge session closed")
+	}
+	trades := after.recorder.ExportTrades(ExportQuery{})
+	if len(trades) != 1 || trades[0].DurationS != 120 {
+		t.Fatalf("trades = %+v, want one discharge lasting until the shutdown", trades)
+	}
+}
+
+func TestSaveSession_ThrottlesAccumulatorUpdates(t *testing.T) {
+	dir := t.TempDir()
+	baseTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := baseTime
+	svc := newTestService(testConfigSmallBattery(), NewMockBattery(50), nil, baseTime)
+	svc.nowFunc = func() time.Time { return now }
+	svc.sessions = NewSessionStore(dir)
+	svc.state = StateSolarCharging
+	svc.currentTradeStart = baseTime
+	svc.saveSession(false)
+
+	saved := func() float64 {
+		t.Helper()
+		snap, err := svc.sessions.Load()
+		if err != nil || snap == nil {
+			t.Fatalf("Load() = %v, %v", snap, err)
+		}
+		return snap.SolarEnergyWs
+	}
+
+	now = baseTime.Add(10 * time.Second)
+	svc.solarEnergyWs = 5000
+	svc.saveSession(false)
+	if got := saved(); got != 0 {
+		t.Errorf("saved energy = %v Ws, want the accumulator update throttled", got)
+	}
+
+	now = baseTime.Add(sessionSaveInterval)
+	svc.solarEnergyWs = 30000
+	svc.saveSession(false)
+	if got := saved(); got != 30000 {
+		t.Errorf("saved energy = %v Ws, want 30000 after the save interval", got)
+	}
+
+	// A state change is saved immediately
+	now = now.Add(time.Second)
+	svc.state = StateIdle
+	svc.solarEnergyWs = 0
+	svc.saveSession(false)
+	if snap, _ := svc.sessions.Load(); snap.State != StateIdle {
+		t.Errorf("saved state = %s, want idle", snap.State)
+	}
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	telemetryDir           = "telemetry"
+	telemetryFlushInterval = 10 * time.Second
+	telemetryRawKeepDays   = 1           // raw samples are downsampled once they are older than this
+	telemetryDownsample    = time.Minute // resolution of downsampled days
+	telemetryMemoryMax     = 86400       // samples kept in memory without a data dir
+	telemetryMaxPoints     = 20000       // max points returned by one query
+)
+
+// ErrTooManyPoints is returned by Query when the result would exceed telemetryMaxPoints.
+var ErrTooManyPoints = errors.New("too many points, use a shorter range or a larger step")
+
+// TelemetrySample is one reading of the control loop. Optional fields are nil
+// when the value was not measured, e.g. battery power on the minute tick.
+// Downsampled samples hold averages over N readings and the last state.
+type TelemetrySample struct {
+	Time            time.Time `json:"t"`
+	SOC             float64   `json:"soc"`
+	BatteryPowerW   *float64  `json:"bat_w,omitempty"`   // positive charging, negative discharging
+	MeterPowerW     *float64  `json:"meter_w,omitempty"` // grid: positive imports, negative exports
+	PriceEUR        *float64  `json:"price,omitempty"`   // EUR/kWh
+	State           State     `json:"state"`
+	SolarEMAW       float64   `json:"ema_w"` // smoothed solar surplus
+	CommandedPowerW float64   `json:"cmd_w"` // positive charging, negative discharging
+	N               int       `json:"n,omitempty"`
+}
+
+// TelemetryStore records control loop samples in daily JSON Lines files:
+// DATA_DIR/telemetry/YYYY-MM-DD.jsonl at full resolution, downsampled to
+// YYYY-MM-DD.1m.jsonl after a day and deleted after the retention period.
+// Samples are buffered in memory and written by Flush; telemetry is
+// diagnostic, so the files are not fsync'd.
+type TelemetryStore struct {
+	fileMu          sync.Mutex // serializes file access (Flush, Query)
+	mu              sync.Mutex // guards pending
+	dir             string     // "" keeps samples in memory only
+	loc             *time.Location
+	retentionDays   int
+	pending         []TelemetrySample
+	lastMaintenance string // day of the last downsampling/retention pass
+}
+
+// NewTelemetryStore creates a telemetry store in dataDir/telemetry that keeps
+// retentionDays days. An empty dataDir keeps the most recent samples in memory only.
+func NewTelemetryStore(dataDir string, retentionDays int, loc *time.Location) *TelemetryStore {
+	if loc == nil {
+		loc = time.UTC
+	}
+	dir := ""
+	if dataDir != "" {
+		dir = filepath.Join(dataDir, telemetryDir)
+	}
+	return &TelemetryStore{
+		dir:           dir,
+		loc:           loc,
+		retentionDays: retentionDays,
+	}
+}
+
+// Record buffers a sample until the next Flush. It does no I/O.
+func (ts *TelemetryStore) Record(sample TelemetrySample) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.pending = append(ts.pending, sample)
+	if ts.dir == "" && len(ts.pending) > telemetryMemoryMax {
+		ts.pending = append(ts.pending[:0], ts.pending[len(ts.pending)-telemetryMemoryMax:]...)
+	}
+}
+
+// Flush appends the buffered samples to their daily files and, once a day,
+// downsamples old raw files and removes files past the retention period.
+func (ts *TelemetryStore) Flush(now time.Time) error {
+	if ts.dir == "" {
+		return nil // No persistence configured
+	}
+
+	ts.fileMu.Lock()
+	defer ts.fileMu.Unlock()
+
+	ts.mu.Lock()
+	samples := ts.pending
+	ts.pending = nil
+	ts.mu.Unlock()
+
+	if err := ts.appendSamples(samples); err != nil {
+		return err
+	}
+	if today := now.In(ts.loc).Format("2006-01-02"); today != ts.lastMaintenance {
+		ts.lastMaintenance = today
+		ts.maintain(now)
+	}
+	return nil
+}
+
+// appendSamples writes samples to the raw file of their day. Caller must hold ts.fileMu.
+func (ts *TelemetryStore) appendSamples(samples []TelemetrySample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(ts.dir, 0755); err != nil {
+		return fmt.Errorf("create telemetry dir: %w", err)
+	}
+
+	byDay := make(map[string]*bytes.Buffer)
+	for _, sample := range samples {
+		data, err := json.Marshal(sample)
+		if err != nil {
+			return fmt.Errorf("marshal telemetry sample: %w", err)
+		}
+		day := sample.Time.In(ts.loc).Format("2006-01-02")
+		if byDay[day] == nil {
+			byDay[day] = &bytes.Buffer{}
+		}
+		byDay[day].Write(data)
+		byDay[day].WriteByte('\n')
+	}
+
+	for day, buf := range byDay {
+		f, err := os.OpenFile(ts.rawPath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("open telemetry file: %w", err)
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			f.Close()
+			return fmt.Errorf("write telemetry file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("close telemetry file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (ts *TelemetryStore) rawPath(day string) string {
+	return filepath.Join(ts.dir, day+".jsonl")
+}
+
+func (ts *TelemetryStore) downsampledPath(day string) string {
+	return filepath.Join(ts.dir, day+".1m.jsonl")
+}
+
+// maintain downsamples raw files of days that ended more than a day ago and
+// deletes days older than the retention period. Caller must hold ts.fileMu.
+func (ts *TelemetryStore) maintain(now time.Time) {
+	today := now.In(ts.loc)
+	rawBefore := today.AddDate(0, 0, -telemetryRawKeepDays).Format("2006-01-02")
+	expiredBefore := ""
+	if ts.retentionDays > 0 {
+		expiredBefore = today.AddDate(0, 0, -ts.retentionDays).Format("2006-01-02")
+	}
+
+	entries, err := os.ReadDir(ts.dir)
+	if err != nil {
+		slog.Warn("failed to list telemetry files", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		day, kind, ok := strings.Cut(strings.TrimSuffix(name, ".jsonl"), ".")
+		if !strings.HasSuffix(name, ".jsonl") || len(day) != len("2006-01-02") {
+			continue
+		}
+		path := filepath.Join(ts.dir, name)
+		switch {
+		case day < expiredBefore:
+			if err := os.Remove(path); err != nil {
+				slog.Warn("failed to remove expired telemetry file", "file", name, "error", err)
+			}
+		case !ok && day < rawBefore:
+			if err := ts.downsampleDay(day); err != nil {
+				slog.Warn("failed to downsample telemetry", "day", day, "error", err)
+			}
+		case ok && kind != "1m":
+			slog.Debug("ignoring unknown telemetry file", "file", name)
+		}
+	}
+}
+
+// downsampleDay replaces a day's raw file with one-minute averages. Samples
+// already downsampled for that day are kept. Caller must hold ts.fileMu.
+func (ts *TelemetryStore) downsampleDay(day string) error {
+	raw, err := readTelemetryFile(ts.rawPath(day))
+	if err != nil {
+		return err
+	}
+	existing, err := readTelemetryFile(ts.downsampledPath(day))
+	if err != nil {
+		return err
+	}
+	samples := append(existing, downsample(raw, telemetryDownsample)...)
+	sort.SliceStable(samples, func(a, b int) bool { return samples[a].Time.Before(samples[b].Time) })
+
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		data, err := json.Marshal(sample)
+		if err != nil {
+			return fmt.Errorf("marshal telemetry sample: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	path := ts.downsampledPath(day)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("rename downsampled telemetry: %w", err)
+	}
+	slog.Info("downsampled telemetry", "day", day, "samples", len(raw), "points", len(samples))
+	return os.Remove(ts.rawPath(day))
+}
+
+// Query returns the samples in [from, to), averaged into buckets of step when
+// step is positive. Days still at full resolution are read from their raw
+// files, older days from their downsampled files.
+func (ts *TelemetryStore) Query(from, to time.Time, step time.Duration) ([]TelemetrySample, error) {
+	ts.fileMu.Lock()
+	defer ts.fileMu.Unlock()
+
+	var samples []TelemetrySample
+	inRange := func(s TelemetrySample) bool { return !s.Time.Before(from) && s.Time.Before(to) }
+	if ts.dir != "" {
+		for day := from.In(ts.loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+			key := day.Format("2006-01-02")
+			path := ts.rawPath(key)
+			if _, err := os.Stat(path); err != nil {
+				path = ts.downsampledPath(key)
+			}
+			daySamples, err := readTelemetryFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range daySamples {
+				if inRange(s) {
+					samples = append(samples, s)
+				}
+			}
+			day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, ts.loc)
+		}
+	}
+
+	ts.mu.Lock()
+	for _, s := range ts.pending {
+		if inRange(s) {
+			samples = append(samples, s)
+		}
+	}
+	ts.mu.Unlock()
+
+	if step > 0 {
+		samples = downsample(samples, step)
+	}
+	if len(samples) > telemetryMaxPoints {
+		return nil, ErrTooManyPoints
+	}
+	if samples == nil {
+		samples = []TelemetrySample{}
+	}
+	return samples, nil
+}
+
+// readTelemetryFile reads a JSON Lines telemetry file. A missing file holds no
+// samples; malformed lines, such as one cut short by a crash, are skipped.
+func readTelemetryFile(path string) ([]TelemetrySample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open telemetry file: %w", err)
+	}
+	defer f.Close()
+
+	var samples []TelemetrySample
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s TelemetrySample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read telemetry file: %w", err)
+	}
+	return samples, nil
+}
+
+// downsample averages time-ordered samples into buckets of step. Optional
+// fields are averaged over the samples that have them; the state is the last one.
+func downsample(samples []TelemetrySample, step time.Duration) []TelemetrySample {
+	var out []TelemetrySample
+	for i := 0; i < len(samples); {
+		bucket := samples[i].Time.Truncate(step)
+		j := i
+		for j < len(samples) && samples[j].Time.Truncate(step).Equal(bucket) {
+			j++
+		}
+		out = append(out, average(bucket, samples[i:j]))
+		i = j
+	}
+	return out
+}
+
+// average combines samples into one, weighting already averaged samples by N.
+func average(t time.Time, samples []TelemetrySample) TelemetrySample {
+	var n, soc, ema, cmd float64
+	var bat, meter, price optionalMean
+	for _, s := range samples {
+		w := float64(max(s.N, 1))
+		n += w
+		soc += s.SOC * w
+		ema += s.SolarEMAW * w
+		cmd += s.CommandedPowerW * w
+		bat.add(s.BatteryPowerW, w)
+		meter.add(s.MeterPowerW, w)
+		price.add(s.PriceEUR, w)
+	}
+	return TelemetrySample{
+		Time:            t,
+		SOC:             round1(soc / n),
+		BatteryPowerW:   bat.mean(),
+		MeterPowerW:     meter.mean(),
+		PriceEUR:        price.mean(),
+		State:           samples[len(samples)-1].State,
+		SolarEMAW:       round1(ema / n),
+		CommandedPowerW: round1(cmd / n),
+		N:               int(n),
+	}
+}
+
+// optionalMean is the weighted mean of a field that is not always measured.
+type optionalMean struct{ sum, weight float64 }
+
+func (m *optionalMean) add(v *float64, w float64) {
+	if v != nil {
+		m.sum += *v * w
+		m.weight += w
+	}
+}
+
+func (m *optionalMean) mean() *float64 {
+	if m.weight == 0 {
+		return nil
+	}
+	v := round1(m.sum / m.weight)
+	return &v
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// commandedPowerLocked returns the power the battery was last commanded to:
+// positive charging, negative discharging. Caller must hold s.mu.
+func (s *Service) commandedPowerLocked() int {
+	switch s.state {
+	case StateCharging:
+		return s.sessionPowerLocked()
+	case StateDischarging:
+		return -s.sessionPowerLocked()
+	case StateSolarCharging:
+		return s.solarChargePower
+	}
+	return 0
+}
+
+// recordTelemetryLocked keeps and publishes the latest control loop sample and
+// buffers it for the telemetry store. batteryPowerW and meterPowerW may be nil when not
+// measured. Caller must hold s.mu.
+func (s *Service) recordTelemetryLocked(soc int, batteryPowerW, meterPowerW *float64) {
+	now := s.now()
+	sample := TelemetrySample{
+		Time:            now,
+		SOC:             float64(soc),
+		BatteryPowerW:   batteryPowerW,
+		MeterPowerW:     meterPowerW,
+		State:           s.state,
+		SolarEMAW:       round1(s.solarSurplusEMA),
+		CommandedPowerW: float64(s.commandedPowerLocked()),
+	}
+	if price, ok := GetCurrentPrice(s.todayPrices, now); ok {
+		p := price.InexactFloat64()
+		sample.PriceEUR = &p
+	}
+	s.lastSample = &sample
+	s.publish(EventTelemetry, sample)
+	if s.telemetry != nil {
+		s.telemetry.Record(sample)
+	}
+}
+
+// flushTelemetry writes buffered telemetry to disk. Must be called without holding s.mu.
+func (s *Service) flushTelemetry() {
+	if s.telemetry == nil {
+		return
+	}
+	if err := s.telemetry.Flush(s.now()); err != nil {
+		slog.Warn("failed to write telemetry", "error", err)
+	}
+}
+
+// GetTelemetry returns the telemetry store, or nil when telemetry is disabled.
+func (s *Service) GetTelemetry() *TelemetryStore {
+	return s.telemetry
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTelemetryStore_FlushAndQuery(t *testing.T) {
+	store := NewTelemetryStore(t.TempDir(), 30, time.UTC)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 120 {
+		bat := float64(i)
+		store.Record(TelemetrySample{Time: start.Add(time.Duration(i) * time.Second), SOC: 50, BatteryPowerW: &bat, State: StateSolarCharging})
+	}
+	if err := store.Flush(start.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	// Not yet flushed samples are returned too.
+	store.Record(TelemetrySample{Time: start.Add(2 * time.Minute), SOC: 51, State: StateIdle})
+
+	samples, err := store.Query(start, start.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(samples) != 121 {
+		t.Fatalf("Query() returned %d samples, want 121", len(samples))
+	}
+
+	samples, err = store.Query(start, start.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatalf("Query(step) error = %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Query(step=1m) returned %d points, want 3", len(samples))
+	}
+	if first := samples[0]; first.N != 60 || first.BatteryPowerW == nil || *first.BatteryPowerW != 29.5 {
+		t.Errorf("first minute = %+v, want the average of 60 samples (29.5 W)", first)
+	}
+	if last := samples[2]; last.BatteryPowerW != nil || last.State != StateIdle {
+		t.Errorf("last minute = %+v, want no battery power and idle state", last)
+	}
+
+	if _, err := store.Query(start, start.Add(time.Hour), time.Millisecond); err != nil {
+		t.Fatalf("Query(step=1ms) error = %v", err)
+	}
+	for i := range telemetryMaxPoints {
+		store.Record(TelemetrySample{Time: start.Add(time.Hour + time.Duration(i)*time.Second)})
+	}
+	if _, err := store.Query(start, start.Add(24*time.Hour), 0); !errors.Is(err, ErrTooManyPoints) {
+		t.Errorf("Query() error = %v, want ErrTooManyPoints", err)
+	}
+}
+
+func TestTelemetryStore_DownsamplesAndExpires(t *testing.T) {
+	dataDir := t.TempDir()
+	store := NewTelemetryStore(dataDir, 30, time.UTC)
+	now := time.Date(2024, 6, 10, 0, 5, 0, 0, time.UTC)
+
+	record := func(day time.Time) {
+		for i := range 180 {
+			store.Record(TelemetrySample{Time: day.Add(10*time.Hour + time.Duration(i)*time.Second), SOC: 60})
+		}
+	}
+	expired := now.AddDate(0, 0, -31)
+	old := now.AddDate(0, 0, -2)
+	yesterday := now.AddDate(0, 0, -1)
+	record(expired)
+	record(old)
+	record(yesterday)
+	if err := store.Flush(now); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	dir := filepath.Join(dataDir, telemetryDir)
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	if exists(expired.Format("2006-01-02")+".jsonl") || exists(expired.Format("2006-01-02")+".1m.jsonl") {
+		t.Error("expired day still on disk")
+	}
+	if exists(old.Format("2006-01-02")+".jsonl") || !exists(old.Format("2006-01-02")+".1m.jsonl") {
+		t.Error("day before yesterday not downsampled")
+	}
+	if !exists(yesterday.Format("2006-01-02") + ".jsonl") {
+		t.Error("yesterday's raw samples removed, want them kept for a day")
+	}
+
+	samples, err := store.Query(old, old.AddDate(0, 0, 1), 0)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(samples) != 3 || samples[0].N != 60 || samples[0].SOC != 60 {
+		t.Errorf("downsampled day = %+v, want 3 one-minute points of 60 samples", samples)
+	}
+}
+
+func TestSolarTick_RecordsTelemetry(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.10, 0.10, 0.10)
+	meter := NewMockMeter(true, -450)
+	svc := newTestServiceWithMeter(testConfigSmallBattery(), NewMockBattery(40), meter, prices, baseTime)
+	svc.telemetry = NewTelemetryStore("", 0, time.UTC)
+
+	svc.solarTick(context.Background())
+	svc.tick(context.Background())
+
+	samples, err := svc.telemetry.Query(baseTime, baseTime.Add(time.Second), 0)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("recorded %d samples, want one per tick", len(samples))
+	}
+	solar := samples[0]
+	if solar.SOC != 40 || solar.MeterPowerW == nil || *solar.MeterPowerW != -450 || solar.BatteryPowerW == nil {
+		t.Errorf("solarTick sample = %+v, want SOC 40 with meter and battery power", solar)
+	}
+	if solar.PriceEUR == nil || *solar.PriceEUR != 0.10 {
+		t.Errorf("solarTick sample price = %v, want 0.10", solar.PriceEUR)
+	}
+	if minute := samples[1]; minute.MeterPowerW != nil || minute.BatteryPowerW != nil {
+		t.Errorf("tick sample = %+v, want no meter or battery power", minute)
+	}
+}
+
+func TestGetLiveStatus_LatestSampleWithoutTelemetry(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.10, 0.10, 0.10, 0.10)
+	meter := NewMockMeter(true, -450)
+	svc := newTestServiceWithMeter(testConfigSmallBattery(), NewMockBattery(40), meter, prices, baseTime)
+
+	if live := svc.GetLiveStatus(); live.Sample != nil || live.State != StateIdle {
+		t.Fatalf("live status before the first tick = %+v, want idle without a sample", live)
+	}
+
+	svc.solarTick(context.Background())
+	live := svc.GetLiveStatus()
+	if live.Sample == nil || live.Sample.SOC != 40 || live.Sample.MeterPowerW == nil || *live.Sample.MeterPowerW != -450 {
+		t.Errorf("live sample = %+v, want the solarTick reading", live.Sample)
+	}
+	if live.CurrentPrice == nil || *live.CurrentPrice != 0.10 || live.NextAction == "" {
+		t.Errorf("live status = %+v, want the current price and next action", live)
+	}
+}
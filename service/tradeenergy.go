@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// EnergySource says how a trade's EnergyKWh was determined.
+type EnergySource string
+
+const (
+	EnergySourceCounters   EnergySource = "counters"   // lifetime energy counters at start and end
+	EnergySourceIntegrated EnergySource = "integrated" // measured battery power integrated over the session
+	EnergySourceNominal    EnergySource = "nominal"    // SOC change times the configured capacity
+)
+
+// minIntegratedCoverage is the share of a session that battery power samples
+// must span before their integral is trusted over the nominal energy.
+const minIntegratedCoverage = 0.5
+
+// minCounterShare is the share of the integrated energy a counter delta must
+// reach; a counter that lags further behind was not refreshed in time.
+const minCounterShare = 0.5
+
+// energyCounters are the battery's lifetime charge and discharge counters.
+type energyCounters struct {
+	ChargedWh    float64 `json:"charged_wh"`
+	DischargedWh float64 `json:"discharged_wh"`
+}
+
+// readEnergyCounters reads the battery's lifetime energy counters, or nil when
+// the battery does not report them. Must be called without holding s.mu.
+func (s *Service) readEnergyCounters(ctx context.Context) *energyCounters {
+	reader, ok := s.battery.(EnergyCounterReader)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, statusBatteryTimeout)
+	defer cancel()
+	charged, discharged, err := reader.GetEnergyCounters(ctx)
+	if err != nil || (charged <= 0 && discharged <= 0) {
+		return nil
+	}
+	return &energyCounters{ChargedWh: charged, DischargedWh: discharged}
+}
+
+// startTradeEnergyLocked resets the energy measurement for a new grid session.
+// Caller must hold s.mu.
+func (s *Service) startTradeEnergyLocked(counters *energyCounters) {
+	s.tradeStartCounters = counters
+	s.tradeEnergyWs = 0
+	s.tradePowerW = 0
+	s.tradeFirstSample = time.Time{}
+	s.tradeLastSample = time.Time{}
+}
+
+// accumulateTradeEnergyLocked integrates a battery power sample (positive
+// charging) into the running grid session. Caller must hold s.mu.
+func (s *Service) accumulateTradeEnergyLocked(batteryPowerW float64) {
+	var powerW float64
+	switch s.state {
+	case StateCharging:
+		powerW = max(batteryPowerW, 0)
+	case StateDischarging:
+		powerW = max(-batteryPowerW, 0)
+	default:
+		return
+	}
+	now := s.now()
+	if s.tradeFirstSample.IsZero() {
+		s.tradeFirstSample = now
+	} else if elapsed := now.Sub(s.tradeLastSample).Seconds(); elapsed > 0 {
+		s.tradeEnergyWs += s.tradePowerW * elapsed
+	}
+	s.tradePowerW = powerW
+	s.tradeLastSample = now
+}
+
+// measuredTradeEnergyLocked returns the energy of the grid session ending at
+// stopTime from the counters, else from integrated battery power. Counters
+// that did not advance, or lag far behind the integrated power, are stuck or
+// stale and not used. ok is false when no measurement is usable. Caller must
+// hold s.mu.
+func (s *Service) measuredTradeEnergyLocked(action TradeAction, end *energyCounters, stopTime time.Time) (kwh decimal.Decimal, source EnergySource, ok bool) {
+	integrated, integratedOK := s.integratedTradeEnergyLocked(stopTime)
+	if start := s.tradeStartCounters; start != nil && end != nil {
+		deltaWh := end.ChargedWh - start.ChargedWh
+		if action == ActionDischarge {
+			deltaWh = end.DischargedWh - start.DischargedWh
+		}
+		counted := decimal.NewFromFloat(deltaWh / 1000).Round(4)
+		switch {
+		case deltaWh <= 0:
+			slog.Warn("ignoring battery energy counters that did not advance", "action", action, "delta_wh", deltaWh)
+		case integratedOK && counted.LessThan(integrated.Mul(decimal.NewFromFloat(minCounterShare))):
+			slog.Warn("ignoring battery energy counters behind the measured power", "action", action,
+				"counter_kwh", counted.InexactFloat64(), "integrated_kwh", integrated.InexactFloat64())
+		default:
+			return counted, EnergySourceCounters, true
+		}
+	}
+	if integratedOK {
+		return integrated, EnergySourceIntegrated, true
+	}
+	return decimal.Zero, EnergySourceNominal, false
+}
+
+// integratedTradeEnergyLocked returns the battery power integrated over the
+// grid session ending at stopTime. ok is false when the samples cover too
+// little of the session. Caller must hold s.mu.
+func (s *Service) integratedTradeEnergyLocked(stopTime time.Time) (decimal.Decimal, bool) {
+	duration := stopTime.Sub(s.currentTradeStart)
+	if s.tradeFirstSample.IsZero() || duration <= 0 {
+		return decimal.Zero, false
+	}
+	// Hold the last sample until the stop, as the solar path does
+	energyWs := s.tradeEnergyWs
+	if elapsed := stopTime.Sub(s.tradeLastSample).Seconds(); elapsed > 0 {
+		energyWs += s.tradePowerW * elapsed
+	}
+	if s.tradeLastSample.Sub(s.tradeFirstSample).Seconds() < duration.Seconds()*minIntegratedCoverage {
+		return decimal.Zero, false
+	}
+	return decimal.NewFromFloat(energyWs / 3_600_000.0).Round(4), true
+}
+
+// tradeEnergyLocked sets the energy of a finished grid trade: the measured
+// value when available, with the SOC-based nominal value kept alongside.
+// Caller must hold s.mu.
+func (s *Service) tradeEnergyLocked(trade *Trade, nominal decimal.Decimal, end *energyCounters, stopTime time.Time) {
+	trade.EnergyKWh = nominal
+	trade.NominalKWh = &nominal
+	trade.EnergySource = EnergySourceNominal
+	if measured, source, ok := s.measuredTradeEnergyLocked(trade.Action, end, stopTime); ok {
+		trade.EnergyKWh = measured
+		trade.EnergySource = source
+	}
+}
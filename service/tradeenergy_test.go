@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// chargingService returns a service in a charge session started at 50% SOC
+// at baseTime, ticking 15 minutes later outside the charge window.
+func chargingService(battery *MockBattery, baseTime time.Time) *Service {
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	svc := newTestService(testConfigSmallBattery(), battery, prices, baseTime.Add(15*time.Minute))
+	svc.state = StateCharging
+	svc.currentTradeStart = baseTime
+	svc.currentTradePrice = decimal.NewFromFloat(0.05)
+	svc.currentTradeSOC = 50
+	return svc
+}
+
+func lastTrade(t *testing.T, svc *Service) Trade {
+	t.Helper()
+	trade := svc.recorder.GetLastChargeTrade()
+	if trade == nil {
+		t.Fatal("expected the charge trade to be recorded")
+	}
+	return *trade
+}
+
+func TestStopCharging_EnergyFromCounters(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(70)
+	battery.ChargedWh = 10_380
+	svc := chargingService(battery, baseTime)
+	svc.startTradeEnergyLocked(&energyCounters{ChargedWh: 10_000, DischargedWh: 8_000})
+
+	svc.tick(context.Background())
+
+	trade := lastTrade(t, svc)
+	if trade.EnergySource != EnergySourceCounters || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.38)) {
+		t.Errorf("energy = %s kWh (%s), want 0.38 kWh from counters", trade.EnergyKWh, trade.EnergySource)
+	}
+	// 20% of 0.5 kWh
+	if trade.NominalKWh == nil || !trade.NominalKWh.Equal(decimal.NewFromFloat(0.1)) {
+		t.Errorf("NominalKWh = %v, want 0.1", trade.NominalKWh)
+	}
+}
+
+func TestStopCharging_EnergyIntegratedWithoutCounters(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(70)
+	svc := chargingService(battery, baseTime)
+	svc.startTradeEnergyLocked(nil)
+
+	// 1600 W for the first 9 minutes, then 800 W until the stop
+	svc.nowFunc = func() time.Time { return baseTime.Add(time.Minute) }
+	svc.accumulateTradeEnergyLocked(1600)
+	svc.nowFunc = func() time.Time { return baseTime.Add(10 * time.Minute) }
+	svc.accumulateTradeEnergyLocked(800)
+	svc.nowFunc = func() time.Time { return baseTime.Add(15 * time.Minute) }
+
+	svc.tick(context.Background())
+
+	// The first minute is not covered: 1600 W × 9 min + 800 W × 5 min
+	trade := lastTrade(t, svc)
+	if trade.EnergySource != EnergySourceIntegrated || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.3067)) {
+		t.Errorf("energy = %s kWh (%s), want 0.3067 kWh integrated", trade.EnergyKWh, trade.EnergySource)
+	}
+}
+
+func TestStopCharging_StuckCounterFallsBack(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	// The SOC went from 50% to 70% but the counter did not move.
+	battery := NewMockBattery(70)
+	battery.ChargedWh = 10_000
+	svc := chargingService(battery, baseTime)
+	svc.startTradeEnergyLocked(&energyCounters{ChargedWh: 10_000, DischargedWh: 8_000})
+	svc.tick(context.Background())
+
+	trade := lastTrade(t, svc)
+	if trade.EnergySource != EnergySourceNominal || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.1)) {
+		t.Errorf("energy = %s kWh (%s), want the 0.1 kWh nominal estimate", trade.EnergyKWh, trade.EnergySource)
+	}
+
+	// A counter far behind the integrated battery power is not trusted either.
+	battery = NewMockBattery(70)
+	battery.ChargedWh = 10_050
+	svc = chargingService(battery, baseTime)
+	svc.startTradeEnergyLocked(&energyCounters{ChargedWh: 10_000, DischargedWh: 8_000})
+	svc.nowFunc = func() time.Time { return baseTime }
+	svc.accumulateTradeEnergyLocked(1600)
+	svc.nowFunc = func() time.Time { return baseTime.Add(10 * time.Minute) }
+	svc.accumulateTradeEnergyLocked(1600)
+	svc.nowFunc = func() time.Time { return baseTime.Add(15 * time.Minute) }
+	svc.tick(context.Background())
+
+	// 1600 W × 15 min
+	trade = lastTrade(t, svc)
+	if trade.EnergySource != EnergySourceIntegrated || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.4)) {
+		t.Errorf("energy = %s kWh (%s), want 0.4 kWh integrated", trade.EnergyKWh, trade.EnergySource)
+	}
+}
+
+func TestStopCharging_NominalEnergyWithoutMeasurements(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(70)
+	svc := chargingService(battery, baseTime)
+	svc.startTradeEnergyLocked(nil)
+
+	// A single sample near the end covers too little of the session
+	svc.nowFunc = func() time.Time { return baseTime.Add(14 * time.Minute) }
+	svc.accumulateTradeEnergyLocked(2000)
+	svc.nowFunc = func() time.Time { return baseTime.Add(15 * time.Minute) }
+
+	svc.tick(context.Background())
+
+	trade := lastTrade(t, svc)
+	if trade.EnergySource != EnergySourceNominal || !trade.EnergyKWh.Equal(decimal.NewFromFloat(0.1)) {
+		t.Errorf("energy = %s kWh (%s), want the 0.1 kWh nominal estimate", trade.EnergyKWh, trade.EnergySource)
+	}
+}
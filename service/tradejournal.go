@@ -0,0 +1,474 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tradesFile           = "trades.jsonl" // journal of the current month
+	tradesLegacyFile     = "trades.json"  // whole-file format before the journal, migrated on load
+	tradesArchiveDir     = "trades"       // completed months: trades/YYYY-MM.jsonl
+	tradesCheckpointFile = "ledger.json"  // trades/ledger.json: ledger after the archived trades
+)
+
+// tradeJournal persists trades as JSON Lines. New trades are appended to
+// trades.jsonl and fsync'd one by one; once a month is over its trades are
+// compacted into an archive file so the journal stays small.
+type tradeJournal struct {
+	mu      sync.Mutex // serializes file access, independent of the recorder's lock
+	dataDir string
+	loc     *time.Location
+	oldest  string // month (YYYY-MM) of the oldest trade in the journal, "" when empty
+}
+
+func newTradeJournal(dataDir string, loc *time.Location) *tradeJournal {
+	return &tradeJournal{dataDir: dataDir, loc: loc}
+}
+
+// month returns the archive month of a trade in the configured timezone.
+func (j *tradeJournal) month(t Trade) string {
+	return t.Timestamp.In(j.loc).Format("2006-01")
+}
+
+// append writes one trade to the journal and syncs it to disk. record is
+// called first, under the journal lock, to add the trade in memory: the
+// journal then keeps the order of the trades in memory. It returns an empty
+// ledger with the recorder's settings, from which the ledger checkpoint is
+// rebuilt when the first trade of a new month compacts the previous months
+// into their archives.
+func (j *tradeJournal) append(trade Trade, record func() *ledger) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	empty := record()
+
+	if err := os.MkdirAll(j.dataDir, 0755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	data, err := json.Marshal(trade)
+	if err != nil {
+		return fmt.Errorf("marshal trade: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(j.dataDir, tradesFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open trade journal: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write trade journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync trade journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close trade journal: %w", err)
+	}
+
+	month := j.month(trade)
+	if j.oldest == "" || month < j.oldest {
+		j.oldest = month
+	}
+	if j.oldest < month {
+		archived, err := j.compactLocked(month)
+		if err != nil {
+			slog.Warn("failed to compact trade journal", "error", err)
+		}
+		if archived {
+			j.saveCheckpointLocked(empty)
+		}
+	}
+	return nil
+}
+
+// saveCheckpointLocked saves the ledger after the archives for the next
+// startup, starting from the empty ledger. Caller must hold j.mu.
+func (j *tradeJournal) saveCheckpointLocked(empty *ledger) {
+	archived, fingerprint, err := j.readArchivesLocked()
+	if err == nil {
+		err = j.writeCheckpoint(newLedgerCheckpoint(empty, archived, fingerprint))
+	}
+	if err != nil {
+		slog.Warn("failed to save ledger checkpoint", "error", err)
+	}
+}
+
+// load migrates a legacy trades.json, compacts completed months and returns
+// the trades of the journal only; the archives are read by readArchives.
+func (j *tradeJournal) load(now time.Time) ([]Trade, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.migrateLocked(); err != nil {
+		return nil, err
+	}
+	if _, err := j.compactLocked(now.In(j.loc).Format("2006-01")); err != nil {
+		slog.Warn("failed to compact trade journal", "error", err)
+	}
+	journal, err := readTradeLines(filepath.Join(j.dataDir, tradesFile))
+	if err != nil {
+		return nil, err
+	}
+	j.updateOldestLocked(journal)
+	return journal, nil
+}
+
+// readArchives returns the trades of all monthly archives, oldest month first,
+// and the fingerprint of the archive files read.
+func (j *tradeJournal) readArchives() ([]Trade, string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.readArchivesLocked()
+}
+
+// readArchivesLocked is readArchives for callers holding j.mu.
+func (j *tradeJournal) readArchivesLocked() ([]Trade, string, error) {
+	archives, err := j.archivePathsLocked()
+	if err != nil {
+		return nil, "", err
+	}
+	var trades []Trade
+	for _, path := range archives {
+		archived, err := peekTradeLines(path)
+		if err != nil {
+			return nil, "", err
+		}
+		trades = append(trades, archived...)
+	}
+	fingerprint, err := archiveFingerprint(archives)
+	if err != nil {
+		return nil, "", err
+	}
+	return trades, fingerprint, nil
+}
+
+// archivesFingerprint identifies the current archive files without reading them.
+func (j *tradeJournal) archivesFingerprint() (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	archives, err := j.archivePathsLocked()
+	if err != nil {
+		return "", err
+	}
+	return archiveFingerprint(archives)
+}
+
+// archivePathsLocked lists the monthly archives, oldest first. Caller must hold j.mu.
+func (j *tradeJournal) archivePathsLocked() ([]string, error) {
+	archives, err := filepath.Glob(filepath.Join(j.dataDir, tradesArchiveDir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("list trade archives: %w", err)
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// archiveFingerprint lists the archive files with their sizes. Archives only
+// change by compaction, which always adds trades, so a changed archive has a
+// different fingerprint.
+func archiveFingerprint(archives []string) (string, error) {
+	parts := make([]string, 0, len(archives))
+	for _, path := range archives {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("stat trade archive: %w", err)
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d", filepath.Base(path), info.Size()))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// readCheckpoint returns the saved ledger checkpoint, or nil when there is none.
+func (j *tradeJournal) readCheckpoint() (*ledgerCheckpoint, error) {
+	data, err := os.ReadFile(filepath.Join(j.dataDir, tradesArchiveDir, tradesCheckpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read ledger checkpoint: %w", err)
+	}
+	var cp ledgerCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("unmarshal ledger checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint atomically replaces the saved ledger checkpoint.
+func (j *tradeJournal) writeCheckpoint(cp *ledgerCheckpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal ledger checkpoint: %w", err)
+	}
+	dir := filepath.Join(j.dataDir, tradesArchiveDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create trade archive dir: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dir, tradesCheckpointFile), data)
+}
+
+// read returns all trades without changing any file: no migration, no
+// compaction and no truncation of a final line another process is still
+// appending, which is skipped instead. It is safe while the service runs.
+func (j *tradeJournal) read() ([]Trade, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	archives, err := j.archivePathsLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	var trades []Trade
+	for _, path := range archives {
+		archived, err := peekTradeLines(path)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, archived...)
+	}
+	journal, err := peekTradeLines(filepath.Join(j.dataDir, tradesFile))
+	if err != nil {
+		return nil, err
+	}
+	return append(trades, journal...), nil
+}
+
+// updateOldestLocked sets the oldest month from the journal's trades. Caller must hold j.mu.
+func (j *tradeJournal) updateOldestLocked(journal []Trade) {
+	j.oldest = ""
+	for _, t := range journal {
+		if month := j.month(t); j.oldest == "" || month < j.oldest {
+			j.oldest = month
+		}
+	}
+}
+
+// migrateLocked moves the trades of a legacy trades.json into the journal and
+// renames the old file to trades.json.migrated. Caller must hold j.mu.
+func (j *tradeJournal) migrateLocked() error {
+	legacyPath := filepath.Join(j.dataDir, tradesLegacyFile)
+	data, err := os.ReadFile(legacyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read trades file: %w", err)
+	}
+	var legacy []Trade
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return fmt.Errorf("unmarshal trades: %w", err)
+	}
+
+	journalPath := filepath.Join(j.dataDir, tradesFile)
+	existing, err := readTradeLines(journalPath)
+	if err != nil {
+		return err
+	}
+	// Trades already in the journal come from an interrupted migration or were recorded after it
+	merged := legacy
+	for _, t := range existing {
+		if !slices.ContainsFunc(legacy, func(l Trade) bool { return sameTrade(l, t) }) {
+			merged = append(merged, t)
+		}
+	}
+	if err := writeTradeLines(journalPath, merged); err != nil {
+		return err
+	}
+	if err := os.Rename(legacyPath, legacyPath+".migrated"); err != nil {
+		return fmt.Errorf("rename migrated trades file: %w", err)
+	}
+	slog.Info("migrated trades to the trade journal", "trades", len(legacy), "journal", journalPath)
+	return nil
+}
+
+// compactLocked moves journal trades from months before currentMonth into
+// their archive files, then rewrites the journal with the rest. It is safe to
+// repeat after a crash: trades already in an archive are not added twice.
+// It reports whether any trade was archived. Caller must hold j.mu.
+func (j *tradeJournal) compactLocked(currentMonth string) (bool, error) {
+	journalPath := filepath.Join(j.dataDir, tradesFile)
+	trades, err := readTradeLines(journalPath)
+	if err != nil {
+		return false, err
+	}
+
+	byMonth := make(map[string][]Trade)
+	var keep []Trade
+	for _, t := range trades {
+		if month := j.month(t); month < currentMonth {
+			byMonth[month] = append(byMonth[month], t)
+		} else {
+			keep = append(keep, t)
+		}
+	}
+	if len(byMonth) == 0 {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(j.dataDir, tradesArchiveDir), 0755); err != nil {
+		return false, fmt.Errorf("create trade archive dir: %w", err)
+	}
+	for month, monthTrades := range byMonth {
+		archivePath := filepath.Join(j.dataDir, tradesArchiveDir, month+".jsonl")
+		archived, err := readTradeLines(archivePath)
+		if err != nil {
+			return false, err
+		}
+		merged := archived
+		for _, t := range monthTrades {
+			if !slices.ContainsFunc(archived, func(a Trade) bool { return sameTrade(a, t) }) {
+				merged = append(merged, t)
+			}
+		}
+		sort.SliceStable(merged, func(a, b int) bool { return merged[a].Timestamp.Before(merged[b].Timestamp) })
+		if err := writeTradeLines(archivePath, merged); err != nil {
+			return false, err
+		}
+		slog.Info("archived trades", "month", month, "trades", len(monthTrades), "archive", archivePath)
+	}
+
+	if err := writeTradeLines(journalPath, keep); err != nil {
+		return true, err
+	}
+	j.oldest = ""
+	if len(keep) > 0 {
+		j.oldest = currentMonth
+	}
+	return true, nil
+}
+
+// sameTrade reports whether a and b are the same recorded trade.
+func sameTrade(a, b Trade) bool {
+	return a.Timestamp.Equal(b.Timestamp) && a.Action == b.Action
+}
+
+// readTradeLines reads a JSON Lines trade file. A missing file holds no trades.
+// A final line cut short by a crash is dropped and truncated off the file so
+// later appends start on a fresh line; other malformed lines are skipped.
+// Only the service that owns the journal may call it.
+func readTradeLines(path string) ([]Trade, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read trade journal: %w", err)
+	}
+
+	if n := len(data); n > 0 && data[n-1] != '\n' {
+		complete := bytes.LastIndexByte(data, '\n') + 1
+		slog.Warn("dropping truncated trade journal line", "path", path, "bytes", n-complete)
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return nil, fmt.Errorf("truncate trade journal: %w", err)
+		}
+		data = data[:complete]
+	}
+	return parseTradeLines(path, data), nil
+}
+
+// peekTradeLines reads a JSON Lines trade file like readTradeLines but never
+// modifies it: a final line without a newline may still be being written, so
+// it is skipped and left in place.
+func peekTradeLines(path string) ([]Trade, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read trade journal: %w", err)
+	}
+	if n := len(data); n > 0 && data[n-1] != '\n' {
+		complete := bytes.LastIndexByte(data, '\n') + 1
+		slog.Debug("skipping incomplete trade journal line", "path", path, "bytes", n-complete)
+		data = data[:complete]
+	}
+	return parseTradeLines(path, data), nil
+}
+
+// parseTradeLines decodes complete JSON Lines, skipping malformed ones.
+func parseTradeLines(path string, data []byte) []Trade {
+	var trades []Trade
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var t Trade
+		if err := json.Unmarshal(line, &t); err != nil {
+			slog.Warn("skipping malformed trade journal line", "path", path, "error", err)
+			continue
+		}
+		trades = append(trades, t)
+	}
+	return trades
+}
+
+// writeTradeLines atomically replaces path with the trades as JSON Lines.
+func writeTradeLines(path string, trades []Trade) error {
+	var buf bytes.Buffer
+	for _, t := range trades {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("marshal trade: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// writeFileAtomic replaces path with data through a synced temp file, then
+// syncs the directory so the rename itself survives a power loss.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	// Atomic rename (on POSIX systems)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("rename %s: %w", filepath.Base(path), err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory's entries to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
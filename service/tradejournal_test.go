@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func journalTrade(ts time.Time, action TradeAction) Trade {
+	return Trade{Timestamp: ts, Action: action, PriceEUR: decimal.NewFromFloat(0.10), EnergyKWh: decimal.NewFromFloat(1.5)}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	trades, err := readTradeLines(path)
+	if err != nil {
+		t.Fatalf("readTradeLines(%s) error = %v", path, err)
+	}
+	return len(trades)
+}
+
+func TestTradeJournal_CompactsCompletedMonths(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRecorder(dir, 0.90, time.UTC)
+
+	jan := time.Date(2024, 1, 31, 22, 0, 0, 0, time.UTC)
+	r.RecordTrade(journalTrade(jan, ActionCharge))
+	r.RecordTrade(journalTrade(jan.Add(time.Hour), ActionDischarge))
+	if n := countLines(t, filepath.Join(dir, tradesFile)); n != 2 {
+		t.Fatalf("journal has %d trades, want 2 before the month ends", n)
+	}
+
+	// The first trade of February moves January into its archive.
+	r.RecordTrade(journalTrade(jan.Add(3*time.Hour), ActionCharge))
+	if n := countLines(t, filepath.Join(dir, tradesArchiveDir, "2024-01.jsonl")); n != 2 {
+		t.Errorf("January archive has %d trades, want 2", n)
+	}
+	if n := countLines(t, filepath.Join(dir, tradesFile)); n != 1 {
+		t.Errorf("journal has %d trades after compaction, want 1", n)
+	}
+
+	r2 := NewRecorder(dir, 0.90, time.UTC)
+	if err := r2.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if len(r2.trades) != 3 || !r2.trades[0].Timestamp.Equal(jan) || !r2.trades[2].Timestamp.Equal(jan.Add(3*time.Hour)) {
+		t.Errorf("loaded trades = %+v, want the 3 trades in order", r2.trades)
+	}
+}
+
+func TestRecorder_ConcurrentTradesKeepJournalOrder(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRecorder(dir, 0.90, time.UTC)
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			if err := r.RecordTrade(journalTrade(base.Add(time.Duration(i)*time.Minute), ActionCharge)); err != nil {
+				t.Errorf("RecordTrade() error = %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	journal, err := peekTradeLines(filepath.Join(dir, tradesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal) != len(r.trades) {
+		t.Fatalf("journal has %d trades, memory %d", len(journal), len(r.trades))
+	}
+	for i := range journal {
+		if !journal[i].Timestamp.Equal(r.trades[i].Timestamp) {
+			t.Fatalf("trade %d: journal %s, memory %s; want the same order", i, journal[i].Timestamp, r.trades[i].Timestamp)
+		}
+	}
+}
+
+func TestTradeJournal_CompactionIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	trade := journalTrade(time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC), ActionCharge)
+
+	// A crash between writing the archive and rewriting the journal leaves the trade in both.
+	os.MkdirAll(filepath.Join(dir, tradesArchiveDir), 0755)
+	writeTradeLines(filepath.Join(dir, tradesArchiveDir, "2024-03.jsonl"), []Trade{trade})
+	writeTradeLines(filepath.Join(dir, tradesFile), []Trade{trade})
+
+	r := NewRecorder(dir, 0.90, time.UTC)
+	if err := r.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if len(r.trades) != 1 {
+		t.Errorf("loaded %d trades, want 1", len(r.trades))
+	}
+}
+
+func TestTradeJournal_RecoversTruncatedLine(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	r := NewRecorder(dir, 0.90, time.UTC)
+	r.RecordTrade(journalTrade(now.Add(-time.Minute), ActionSolarCharge))
+
+	// Power loss in the middle of the next append.
+	path := filepath.Join(dir, tradesFile)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"timestamp":"2024-06-01T12:00:00Z","act`)
+	f.Close()
+
+	r2 := NewRecorder(dir, 0.90, time.UTC)
+	if err := r2.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if len(r2.trades) != 1 {
+		t.Fatalf("loaded %d trades, want 1 (truncated line dropped)", len(r2.trades))
+	}
+
+	// Appends after recovery start on a fresh line.
+	r2.RecordTrade(journalTrade(now, ActionCharge))
+	r3 := NewRecorder(dir, 0.90, time.UTC)
+	if err := r3.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if len(r3.trades) != 2 {
+		t.Errorf("loaded %d trades after recovery, want 2", len(r3.trades))
+	}
+}
+
+func TestRecorder_LoadTradesReadOnlyLeavesJournalUntouched(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRecorder(dir, 0.90, time.UTC)
+	r.RecordTrade(journalTrade(time.Now().UTC().Add(-time.Minute), ActionCharge))
+
+	// The service is in the middle of appending the next trade.
+	path := filepath.Join(dir, tradesFile)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"timestamp":"2024-06-01T12:00:00Z","act`)
+	f.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := NewRecorder(dir, 0.90, time.UTC)
+	if err := export.LoadTradesReadOnly(); err != nil {
+		t.Fatalf("LoadTradesReadOnly() error = %v", err)
+	}
+	if len(export.trades) != 1 {
+		t.Errorf("loaded %d trades, want 1 (incomplete line skipped)", len(export.trades))
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("journal changed by a read-only load:\nbefore %q\nafter  %q", before, after)
+	}
+}
+
+func TestTradeJournal_MigratesLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := []Trade{
+		journalTrade(time.Date(2023, 7, 1, 13, 0, 0, 0, time.UTC), ActionSolarCharge),
+		journalTrade(time.Date(2023, 7, 1, 19, 0, 0, 0, time.UTC), ActionDischarge),
+		journalTrade(time.Date(2023, 8, 2, 3, 0, 0, 0, time.UTC), ActionCharge),
+	}
+	data, _ := json.MarshalIndent(legacy, "", "  ")
+	os.WriteFile(filepath.Join(dir, tradesLegacyFile), data, 0644)
+
+	r := NewRecorder(dir, 0.90, time.UTC)
+	if err := r.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if len(r.trades) != 3 {
+		t.Fatalf("loaded %d trades, want 3", len(r.trades))
+	}
+	if _, err := os.Stat(filepath.Join(dir, tradesLegacyFile)); !os.IsNotExist(err) {
+		t.Errorf("trades.json still present after migration (stat error %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, tradesLegacyFile+".migrated")); err != nil {
+		t.Errorf("migrated backup missing: %v", err)
+	}
+	if n := countLines(t, filepath.Join(dir, tradesArchiveDir, "2023-07.jsonl")); n != 2 {
+		t.Errorf("July archive has %d trades, want 2", n)
+	}
+
+	// A second start does not migrate again.
+	r2 := NewRecorder(dir, 0.90, time.UTC)
+	if err := r2.LoadTrades(); err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	if n := len(r2.ExportTrades(ExportQuery{})); n != 3 {
+		t.Errorf("reload: %d trades, want 3", n)
+	}
+}
+
+func TestRecorder_LoadTradesUsesLedgerCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+
+	r := NewRecorder(dir, 0.90, time.UTC)
+	charge := journalTrade(lastMonth.Add(2*time.Hour), ActionCharge)
+	discharge := journalTrade(lastMonth.Add(20*time.Hour), ActionDischarge)
+	discharge.EnergyKWh = decimal.NewFromFloat(0.9)
+	discharge.PriceEUR = decimal.NewFromFloat(0.30)
+	r.RecordTrade(charge)
+	r.RecordTrade(discharge)
+	r.RecordTrade(journalTrade(now.Add(-time.Hour), ActionCharge)) // archives last month
+	wantPnL, wantInventory := r.GetTotalPnL(), r.GetInventory()
+
+	r2 := NewRecorder(dir, 0.90, time.UTC)
+	if err := r2.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if r2.archived == nil || len(r2.trades) != 1 {
+		t.Fatalf("loaded %d trades with checkpoint %v, want only this month's", len(r2.trades), r2.archived != nil)
+	}
+	if got := r2.GetTotalPnL(); !got.Equal(wantPnL) {
+		t.Errorf("GetTotalPnL() = %s from the checkpoint, want %s", got, wantPnL)
+	}
+	if got := r2.GetInventory(); !got.EnergyKWh.Equal(wantInventory.EnergyKWh) || !got.CostBasisEUR.Equal(wantInventory.CostBasisEUR) {
+		t.Errorf("GetInventory() = %+v from the checkpoint, want %+v", got, wantInventory)
+	}
+	if last := r2.GetLastChargeTrade(); last == nil || !last.Timestamp.Equal(now.Add(-time.Hour)) {
+		t.Errorf("GetLastChargeTrade() = %+v, want this month's charge", last)
+	}
+
+	// The history loads the archives on first use.
+	if history := r2.GetHistory(); len(history.Days) < 2 || !history.TotalPnL.Equal(wantPnL) {
+		t.Errorf("history = %d days, P&L %s; want all days and %s", len(history.Days), history.TotalPnL, wantPnL)
+	}
+	if r2.archived != nil || len(r2.trades) != 3 {
+		t.Errorf("after GetHistory: %d trades, checkpoint %v; want all 3 loaded", len(r2.trades), r2.archived != nil)
+	}
+
+	// Other cost settings invalidate the checkpoint.
+	r3 := NewRecorder(dir, 0.90, time.UTC)
+	r3.SetCostBasis(CostBasisAverage, SolarValuationZero)
+	if err := r3.LoadTrades(); err != nil {
+		t.Fatalf("LoadTrades() error = %v", err)
+	}
+	if r3.archived != nil || len(r3.trades) != 3 {
+		t.Errorf("with other settings: %d trades, checkpoint %v; want the archives read", len(r3.trades), r3.archived != nil)
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/marstek"
+)
+
+// Watchdog actions taken when the battery's actual mode drifts from the service state.
+const (
+	WatchdogActionReassert = "reassert" // re-send the intended command, idle after repeated failures
+	WatchdogActionIdle     = "idle"     // end the session and return to idle
+)
+
+const (
+	watchdogSettleTime        = 30 * time.Second // ignore readings this soon after a command
+	watchdogDriftConfirmCount = 2                // consecutive drifted readings before acting
+	watchdogMaxReasserts      = 2                // re-asserts per session before giving up
+	watchdogStatusTimeout     = 10 * time.Second
+)
+
+// DriftEvent records a detected mismatch between the service state and the battery.
+type DriftEvent struct {
+	Time          time.Time                `json:"time"`
+	State         State                    `json:"state"`
+	Expected      marstek.ControlDirection `json:"expected"`
+	Actual        marstek.ControlDirection `json:"actual"`
+	Mode          string                   `json:"mode"`
+	BatteryPowerW float64                  `json:"battery_power_w"`
+	Action        string                   `json:"action"`
+	Err           string                   `json:"error,omitempty"`
+}
+
+// expectedDirectionLocked returns the control direction the current state implies. Caller must hold s.mu.
+func (s *Service) expectedDirectionLocked() (marstek.ControlDirection, bool) {
+	switch s.state {
+	case StateIdle:
+		return marstek.ControlIdle, true
+	case StateCharging, StateDischarging:
+		// A session throttled to (near) zero by fuse protection looks idle.
+		if float64(s.sessionPowerLocked()) < batteryActivePowerThresholdW {
+			return "", false
+		}
+		if s.state == StateDischarging {
+			return marstek.ControlDischarge, true
+		}
+		return marstek.ControlCharge, true
+	case StateSolarCharging:
+		return marstek.ControlCharge, true
+	default:
+		return "", false
+	}
+}
+
+// isDrift reports whether the measured control status contradicts the expected direction.
+func isDrift(expected marstek.ControlDirection, status *marstek.ControlStatus) bool {
+	if status.Direction != expected {
+		return true
+	}
+	// Forced in the right mode but measurably moving energy the wrong way.
+	switch expected {
+	case marstek.ControlCharge:
+		return status.BatteryPower <= -batteryActivePowerThresholdW
+	case marstek.ControlDischarge:
+		return status.BatteryPower >= batteryActivePowerThresholdW
+	}
+	return false
+}
+
+// watchdogTick compares the battery's actual control mode with the service state
+// and reconciles drift caused by the Marstek app, a device reboot or an expired
+// passive countdown.
+func (s *Service) watchdogTick(ctx context.Context) {
+	if s.retryStopping(ctx) {
+		return
+	}
+
+	// Read control status OUTSIDE lock (network I/O)
+	statusCtx, cancel := context.WithTimeout(ctx, watchdogStatusTimeout)
+	status, err := s.battery.GetControlStatus(statusCtx)
+	cancel()
+	if err != nil {
+		slog.Debug("watchdog: failed to read battery control status", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accumulateTradeEnergyLocked(status.BatteryPower)
+
+	expected, ok := s.expectedDirectionLocked()
+	if !ok || s.now().Sub(s.lastPassiveRefresh) < watchdogSettleTime || s.now().Sub(s.lastIdleTransition) < watchdogSettleTime {
+		s.watchdogDriftCount = 0
+		return
+	}
+	if !isDrift(expected, status) {
+		s.watchdogDriftCount = 0
+		return
+	}
+	s.watchdogDriftCount++
+	if s.watchdogDriftCount < watchdogDriftConfirmCount {
+		slog.Debug("watchdog: possible battery mode drift", "state", s.state, "expected", expected, "actual", status.Direction)
+		return
+	}
+	s.watchdogDriftCount = 0
+
+	event := DriftEvent{
+		Time:          s.now(),
+		State:         s.state,
+		Expected:      expected,
+		Actual:        status.Direction,
+		Mode:          status.Mode,
+		BatteryPowerW: status.BatteryPower,
+	}
+	l := slog.With("state", s.state, "expected", expected, "actual", status.Direction,
+		"mode", status.Mode, "battery_power_w", status.BatteryPower)
+	l.Warn("watchdog: battery mode drifted from service state")
+
+	reassert := s.cfg.WatchdogAction != WatchdogActionIdle && s.watchdogReasserts < watchdogMaxReasserts
+	if s.state == StateIdle || reassert {
+		event.Action = WatchdogActionReassert
+		if err := s.reassertLocked(ctx); err != nil {
+			event.Err = err.Error()
+			l.Error("watchdog: failed to re-assert battery command", "error", err)
+		} else {
+			l.Info("watchdog: re-asserted battery command", "reasserts", s.watchdogReasserts)
+		}
+	} else {
+		event.Action = WatchdogActionIdle
+		s.stopForDriftLocked(ctx)
+		if s.state != StateIdle {
+			event.Err = "battery stop not confirmed"
+		}
+	}
+
+	s.lastDrift = &event
+	msg := fmt.Sprintf("Battery mode drift: expected %s while %s, battery reports %s (%s, %.0f W); action: %s",
+		expected, event.State, status.Direction, status.Mode, status.BatteryPower, event.Action)
+	if event.Err != "" {
+		msg += " failed: " + event.Err
+	}
+	s.mu.Unlock()
+	s.notifyError(ctx, msg)
+	s.mu.Lock()
+}
+
+// reassertLocked re-sends the command matching the current state. Caller must hold s.mu.
+func (s *Service) reassertLocked(ctx context.Context) error {
+	state := s.state
+	var power int
+	switch state {
+	case StateCharging:
+		power = -s.sessionPowerLocked()
+	case StateSolarCharging:
+		power = -s.solarChargePower
+	case StateDischarging:
+		power = s.sessionPowerLocked()
+	}
+	if state != StateIdle {
+		s.watchdogReasserts++
+	}
+
+	// Release lock during network I/O
+	s.mu.Unlock()
+	var cmd CommandRecord
+	var err error
+	if state == StateIdle {
+		cmd, err = s.sendCommand(ctx, CommandRecord{Command: CommandIdle, Reason: "watchdog", StateBefore: state, StateAfter: state}, s.idleBattery)
+	} else {
+		cmd, err = s.sendCommand(ctx, CommandRecord{Command: CommandPassiveRefresh, Reason: "watchdog", PowerW: -power, StateBefore: state, StateAfter: state},
+			func(ctx context.Context) error {
+				return s.battery.SetPassiveModeContext(ctx, power, s.cfg.PassiveModeTimeoutS)
+			})
+	}
+	s.journalCommands(cmd)
+	s.mu.Lock()
+
+	if err != nil {
+		return err
+	}
+	s.lastPassiveRefresh = s.now()
+	return nil
+}
+
+// stopForDriftLocked ends the active session after drift. Caller must hold s.mu.
+func (s *Service) stopForDriftLocked(ctx context.Context) {
+	endSOC := s.currentTradeSOC
+	s.mu.Unlock()
+	statusCtx, cancel := context.WithTimeout(ctx, statusBatteryTimeout)
+	if status, err := s.battery.GetESStatus(statusCtx); err == nil {
+		endSOC = status.BatterySOC
+	}
+	cancel()
+	s.mu.Lock()
+
+	switch s.state {
+	case StateCharging:
+		s.stopChargingLocked(ctx, endSOC)
+	case StateDischarging:
+		s.stopDischargingLocked(ctx, endSOC)
+	case StateSolarCharging:
+		s.stopSolarChargingLocked(ctx, endSOC, solarStopReasonTelemetryFailure)
+	}
+	if s.state == StateIdle {
+		s.batteryCooldownUntil = s.now().Add(batteryControlFailureCooldown)
+	}
+}
+
+// LastDrift returns the most recent drift event, or nil if none was detected.
+func (s *Service) LastDrift() *DriftEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.lastDrift == nil {
+		return nil
+	}
+	event := *s.lastDrift
+	return &event
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/foae/marstek-energy-trading/clients/marstek"
+)
+
+// startChargingForWatchdog returns a service in StateCharging and a clock it can advance.
+func startChargingForWatchdog(t *testing.T) (*Service, *MockBattery, *time.Time) {
+	t.Helper()
+	baseTime := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	prices := makePrices(baseTime, 0.05, 0.15, 0.25, 0.10)
+	battery := NewMockBattery(50)
+	svc := newTestService(testConfigSmallBattery(), battery, prices, baseTime)
+	now := baseTime
+	svc.nowFunc = func() time.Time { return now }
+
+	svc.tick(context.Background())
+	if svc.state != StateCharging {
+		t.Fatalf("state = %s, want charging", svc.state)
+	}
+	return svc, battery, &now
+}
+
+// revertToAuto simulates the Marstek app or a reboot taking the battery out of passive mode.
+func revertToAuto(battery *MockBattery) {
+	battery.mu.Lock()
+	defer battery.mu.Unlock()
+	battery.CurrentMode = "Auto"
+	battery.CurrentPower = 0
+}
+
+func TestWatchdog_ReassertsDriftedCharge(t *testing.T) {
+	svc, battery, now := startChargingForWatchdog(t)
+	revertToAuto(battery)
+	*now = now.Add(watchdogSettleTime)
+
+	svc.watchdogTick(context.Background())
+	if svc.lastDrift != nil {
+		t.Fatal("watchdog acted on a single drifted reading")
+	}
+	svc.watchdogTick(context.Background())
+
+	if svc.lastDrift == nil || svc.lastDrift.Action != "reassert" {
+		t.Fatalf("lastDrift = %+v, want reassert", svc.lastDrift)
+	}
+	if svc.lastDrift.Expected != marstek.ControlCharge || svc.lastDrift.Actual != marstek.ControlIdle {
+		t.Errorf("drift = %s -> %s, want charge -> idle", svc.lastDrift.Expected, svc.lastDrift.Actual)
+	}
+	if svc.state != StateCharging {
+		t.Errorf("state = %s, want charging after reassert", svc.state)
+	}
+	if battery.CurrentMode != "Passive" || battery.CurrentPower != svc.cfg.ChargePowerW {
+		t.Errorf("battery = %s %d W, want passive charge re-asserted", battery.CurrentMode, battery.CurrentPower)
+	}
+}
+
+func TestWatchdog_IgnoresReadingsDuringSettleTime(t *testing.T) {
+	svc, battery, now := startChargingForWatchdog(t)
+	revertToAuto(battery)
+	*now = now.Add(watchdogSettleTime - time.Second)
+
+	for range watchdogDriftConfirmCount + 1 {
+		svc.watchdogTick(context.Background())
+	}
+	if svc.lastDrift != nil {
+		t.Errorf("lastDrift = %+v, want none within settle time", svc.lastDrift)
+	}
+}
+
+func TestWatchdog_IdlesAfterMaxReasserts(t *testing.T) {
+	svc, battery, now := startChargingForWatchdog(t)
+
+	for i := 0; i <= watchdogMaxReasserts; i++ {
+		revertToAuto(battery)
+		*now = now.Add(watchdogSettleTime)
+		svc.watchdogTick(context.Background())
+		svc.watchdogTick(context.Background())
+	}
+
+	if svc.lastDrift == nil || svc.lastDrift.Action != "idle" {
+		t.Fatalf("lastDrift = %+v, want idle after %d reasserts", svc.lastDrift, watchdogMaxReasserts)
+	}
+	if svc.state != StateIdle {
+		t.Errorf("state = %s, want idle", svc.state)
+	}
+	if trades := svc.recorder.GetHistory(); trades.TotalDays != 1 || trades.Days[0].ChargeCycles != 1 {
+		t.Errorf("history = %+v, want the interrupted charge recorded", trades)
+	}
+	if !svc.now().Before(svc.batteryCooldownUntil) {
+		t.Error("expected battery cooldown after drift stop")
+	}
+}
+
+func TestWatchdog_IdleActionStopsSession(t *testing.T) {
+	svc, battery, now := startChargingForWatchdog(t)
+	svc.cfg.WatchdogAction = WatchdogActionIdle
+	revertToAuto(battery)
+	*now = now.Add(watchdogSettleTime)
+
+	svc.watchdogTick(context.Background())
+	svc.watchdogTick(context.Background())
+
+	if svc.state != StateIdle {
+		t.Errorf("state = %s, want idle", svc.state)
+	}
+	if svc.lastDrift == nil || svc.lastDrift.Action != "idle" {
+		t.Errorf("lastDrift = %+v, want idle action", svc.lastDrift)
+	}
+}
+
+func TestWatchdog_IdleStateReassertsIdle(t *testing.T) {
+	baseTime := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	battery := NewMockBattery(50)
+	svc := newTestService(testConfigSmallBattery(), battery, nil, baseTime)
+	// Something else put the battery into forced discharge.
+	battery.CurrentMode = "Passive"
+	battery.CurrentPower = -800
+
+	svc.watchdogTick(context.Background())
+	svc.watchdogTick(context.Background())
+
+	if battery.IdleCalls != 1 || battery.CurrentMode != "Auto" {
+		t.Errorf("idle calls = %d, mode = %s; want battery returned to idle", battery.IdleCalls, battery.CurrentMode)
+	}
+	if svc.lastDrift == nil || svc.lastDrift.Actual != marstek.ControlDischarge {
+		t.Errorf("lastDrift = %+v, want discharge drift", svc.lastDrift)
+	}
+}
+
+func TestWatchdog_NoDriftWhenModeMatches(t *testing.T) {
+	svc, _, now := startChargingForWatchdog(t)
+	*now = now.Add(time.Hour)
+
+	for range watchdogDriftConfirmCount + 1 {
+		svc.watchdogTick(context.Background())
+	}
+	if svc.lastDrift != nil {
+		t.Errorf("lastDrift = %+v, want none", svc.lastDrift)
+	}
+}